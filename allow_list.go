@@ -352,7 +352,12 @@ func (al *RemoteAllowList) AllowIpV6(vpnIp iputil.VpnIp, hi, lo uint64) bool {
 
 func (al *RemoteAllowList) getInsideAllowList(vpnIp iputil.VpnIp) *AllowList {
 	if al.insideAllowLists != nil {
-		inside := al.insideAllowLists.MostSpecificContainsIpV4(vpnIp)
+		var inside interface{}
+		if vpnIp.Is4() {
+			inside = al.insideAllowLists.MostSpecificContainsIpV4(vpnIp)
+		} else {
+			inside = al.insideAllowLists.MostSpecificContainsIpV6(vpnIp.HiLo())
+		}
 		if inside != nil {
 			return inside.(*AllowList)
 		}
@@ -21,8 +21,15 @@ type CertState struct {
 }
 
 func NewCertState(certificate *cert.NebulaCertificate, privateKey []byte) (*CertState, error) {
+	// newConnectionState only knows how to build a cipher suite for these curves
+	switch certificate.Details.Curve {
+	case cert.Curve_CURVE25519, cert.Curve_P256:
+	default:
+		return nil, fmt.Errorf("invalid nebula certificate on interface: unsupported curve %s", certificate.Details.Curve)
+	}
+
 	// Marshal the certificate to ensure it is valid
-	rawCertificate, err := certificate.Marshal()
+	rawCertificate, err := certificate.MarshalWithChain()
 	if err != nil {
 		return nil, fmt.Errorf("invalid nebula certificate on interface: %s", err)
 	}
@@ -36,7 +43,7 @@ func NewCertState(certificate *cert.NebulaCertificate, privateKey []byte) (*Cert
 	}
 
 	cs.certificate.Details.PublicKey = nil
-	rawCertNoKey, err := cs.certificate.Marshal()
+	rawCertNoKey, err := cs.certificate.MarshalWithChain()
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling certificate no key: %s", err)
 	}
@@ -66,12 +73,39 @@ func NewCertStateFromConfig(c *config.C) (*CertState, error) {
 		}
 	}
 
-	rawKey, _, err := cert.UnmarshalX25519PrivateKey(pemPrivateKey)
+	rawKey, _, curve, err := cert.UnmarshalPrivateKey(pemPrivateKey)
 	if err != nil {
 		return nil, fmt.Errorf("error while unmarshaling pki.key %s: %s", privPathOrPEM, err)
 	}
 
+	nebulaCert, err := loadCertFromConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if nebulaCert.Expired(time.Now()) {
+		return nil, fmt.Errorf("nebula certificate for this host is expired")
+	}
+
+	if len(nebulaCert.Details.Ips) == 0 {
+		return nil, fmt.Errorf("no IPs encoded in certificate")
+	}
+
+	if nebulaCert.Details.Curve != curve {
+		return nil, fmt.Errorf("pki.key curve %s does not match the pki.cert curve %s", curve, nebulaCert.Details.Curve)
+	}
+
+	if err = nebulaCert.VerifyPrivateKey(rawKey); err != nil {
+		return nil, fmt.Errorf("private key is not a pair with public key in nebula cert")
+	}
+
+	return NewCertState(nebulaCert, rawKey)
+}
+
+// loadCertFromConfig reads the certificate in pki.cert without checking it against pki.key
+func loadCertFromConfig(c *config.C) (*cert.NebulaCertificate, error) {
 	var rawCert []byte
+	var err error
 
 	pubPathOrPEM := c.GetString("pki.cert", "")
 
@@ -89,24 +123,12 @@ func NewCertStateFromConfig(c *config.C) (*CertState, error) {
 		}
 	}
 
-	nebulaCert, _, err := cert.UnmarshalNebulaCertificateFromPEM(rawCert)
+	nebulaCert, err := cert.UnmarshalNebulaCertificateWithChainFromPEM(rawCert)
 	if err != nil {
 		return nil, fmt.Errorf("error while unmarshaling pki.cert %s: %s", pubPathOrPEM, err)
 	}
 
-	if nebulaCert.Expired(time.Now()) {
-		return nil, fmt.Errorf("nebula certificate for this host is expired")
-	}
-
-	if len(nebulaCert.Details.Ips) == 0 {
-		return nil, fmt.Errorf("no IPs encoded in certificate")
-	}
-
-	if err = nebulaCert.VerifyPrivateKey(rawKey); err != nil {
-		return nil, fmt.Errorf("private key is not a pair with public key in nebula cert")
-	}
-
-	return NewCertState(nebulaCert, rawKey)
+	return nebulaCert, nil
 }
 
 func loadCAFromConfig(l *logrus.Logger, c *config.C) (*cert.NebulaCAPool, error) {
@@ -151,6 +173,20 @@ func loadCAFromConfig(l *logrus.Logger, c *config.C) (*cert.NebulaCAPool, error)
 		CAs.BlocklistFingerprint(fp)
 	}
 
+	for _, fp := range c.GetStringSlice("pki.ca_deprecated", []string{}) {
+		l.WithField("fingerprint", fp).Info("Deprecating CA")
+		CAs.DeprecateCA(fp)
+	}
+
+	clockSkew := c.GetDuration("pki.clock_skew", 0)
+	if clockSkew < 0 {
+		return nil, fmt.Errorf("pki.clock_skew can not be negative: %s", clockSkew)
+	}
+	if clockSkew > 0 {
+		l.WithField("clockSkew", clockSkew).Info("Tolerating clock skew when verifying certificates")
+		CAs.SetClockSkew(clockSkew)
+	}
+
 	// Support deprecated config for at least one minor release to allow for migrations
 	//TODO: remove in 2022 or later
 	for _, fp := range c.GetStringSlice("pki.blacklist", []string{}) {
@@ -159,5 +195,31 @@ func loadCAFromConfig(l *logrus.Logger, c *config.C) (*cert.NebulaCAPool, error)
 		CAs.BlocklistFingerprint(fp)
 	}
 
+	crlPathOrPEM := c.GetString("pki.crl", "")
+	if crlPathOrPEM != "" {
+		var rawCRL []byte
+		if strings.Contains(crlPathOrPEM, "-----BEGIN") {
+			rawCRL = []byte(crlPathOrPEM)
+
+		} else {
+			rawCRL, err = ioutil.ReadFile(crlPathOrPEM)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read pki.crl file %s: %s", crlPathOrPEM, err)
+			}
+		}
+
+		err = CAs.AddRevocationListsFromPEM(rawCRL)
+		if err != nil {
+			return nil, fmt.Errorf("error while adding revocation list to CA trust store: %s", err)
+		}
+
+		for _, crl := range CAs.GetRevocationLists() {
+			l.WithField("issuer", crl.Details.Issuer).
+				WithField("issuedAt", crl.Details.IssuedAt).
+				WithField("revoked", len(crl.Details.Fingerprints)).
+				Info("Loaded certificate revocation list")
+		}
+	}
+
 	return CAs, nil
 }
@@ -4,12 +4,23 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 )
 
 type NebulaCAPool struct {
 	CAs           map[string]*NebulaCertificate
 	certBlocklist map[string]struct{}
+
+	// Fingerprints of CAs that are still trusted but are being rotated out
+	deprecatedCAs map[string]struct{}
+
+	// How far outside of its validity period a certificate may be and still verify, to tolerate clock drift
+	clockSkew time.Duration
+
+	// Revocation lists keyed by the fingerprint of the issuing CA, only the newest list for each CA is kept
+	crlLock sync.RWMutex
+	crls    map[string]*NebulaRevocationList
 }
 
 // NewCAPool creates a CAPool
@@ -17,6 +28,8 @@ func NewCAPool() *NebulaCAPool {
 	ca := NebulaCAPool{
 		CAs:           make(map[string]*NebulaCertificate),
 		certBlocklist: make(map[string]struct{}),
+		deprecatedCAs: make(map[string]struct{}),
+		crls:          make(map[string]*NebulaRevocationList),
 	}
 
 	return &ca
@@ -105,6 +118,120 @@ func (ncp *NebulaCAPool) IsBlocklisted(c *NebulaCertificate) bool {
 	return false
 }
 
+// DeprecateCA marks a CA fingerprint as deprecated, certificates it issued are still trusted
+func (ncp *NebulaCAPool) DeprecateCA(f string) {
+	ncp.deprecatedCAs[f] = struct{}{}
+}
+
+// SetClockSkew sets how far outside of its validity period a certificate may be and still pass Verify
+func (ncp *NebulaCAPool) SetClockSkew(d time.Duration) {
+	ncp.clockSkew = d
+}
+
+// GetDeprecatedCA returns the fingerprint of the first deprecated CA in the chain of the provided certificate, or an
+// empty string if no CA in the chain is deprecated. No signature validation is performed
+func (ncp *NebulaCAPool) GetDeprecatedCA(c *NebulaCertificate) string {
+	if len(ncp.deprecatedCAs) == 0 {
+		return ""
+	}
+
+	if _, ok := ncp.deprecatedCAs[c.Details.Issuer]; ok {
+		return c.Details.Issuer
+	}
+
+	signers, err := ncp.GetCAChainForCert(c)
+	if err != nil {
+		return ""
+	}
+
+	for _, signer := range signers {
+		if _, ok := ncp.deprecatedCAs[signer.Details.Issuer]; ok {
+			return signer.Details.Issuer
+		}
+	}
+
+	return ""
+}
+
+// AddRevocationList verifies a revocation list was signed by a CA in the pool and installs it. If a list from the same
+// CA is already present it is only replaced if the new list was issued later. Returns true if the list was installed.
+func (ncp *NebulaCAPool) AddRevocationList(crl *NebulaRevocationList) (bool, error) {
+	signer, ok := ncp.CAs[crl.Details.Issuer]
+	if !ok {
+		return false, fmt.Errorf("could not find ca for the revocation list")
+	}
+
+	if crl.Details.Curve != signer.Details.Curve {
+		return false, fmt.Errorf("revocation list curve %s does not match the ca curve %s", crl.Details.Curve, signer.Details.Curve)
+	}
+
+	if !crl.CheckSignature(signer.Details.PublicKey) {
+		return false, fmt.Errorf("revocation list signature did not match")
+	}
+
+	ncp.crlLock.Lock()
+	defer ncp.crlLock.Unlock()
+
+	if ncp.crls == nil {
+		ncp.crls = make(map[string]*NebulaRevocationList)
+	}
+
+	if existing, ok := ncp.crls[crl.Details.Issuer]; ok && !crl.Details.IssuedAt.After(existing.Details.IssuedAt) {
+		return false, nil
+	}
+
+	ncp.crls[crl.Details.Issuer] = crl
+	return true, nil
+}
+
+// AddRevocationListsFromPEM adds every pem encoded revocation list in the provided bytes to the pool
+func (ncp *NebulaCAPool) AddRevocationListsFromPEM(pemBytes []byte) error {
+	for len(pemBytes) > 0 && strings.TrimSpace(string(pemBytes)) != "" {
+		var crl *NebulaRevocationList
+		var err error
+		crl, pemBytes, err = UnmarshalNebulaRevocationListFromPEM(pemBytes)
+		if err != nil {
+			return err
+		}
+
+		if _, err = ncp.AddRevocationList(crl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsRevoked returns true if the certificate is present in the revocation list of its issuer
+func (ncp *NebulaCAPool) IsRevoked(c *NebulaCertificate) bool {
+	ncp.crlLock.RLock()
+	crl, ok := ncp.crls[c.Details.Issuer]
+	ncp.crlLock.RUnlock()
+	if !ok {
+		return false
+	}
+
+	h, err := c.Sha256Sum()
+	if err != nil {
+		return true
+	}
+
+	return crl.IsRevoked(h)
+}
+
+// GetRevocationLists returns all revocation lists currently installed in the pool
+func (ncp *NebulaCAPool) GetRevocationLists() []*NebulaRevocationList {
+	ncp.crlLock.RLock()
+	defer ncp.crlLock.RUnlock()
+
+	crls := make([]*NebulaRevocationList, 0, len(ncp.crls))
+	for _, crl := range ncp.crls {
+		crls = append(crls, crl)
+	}
+
+	return crls
+}
+
 // GetCAForCert attempts to return the signing certificate for the provided certificate.
 // No signature validation is performed
 func (ncp *NebulaCAPool) GetCAForCert(c *NebulaCertificate) (*NebulaCertificate, error) {
@@ -117,6 +244,43 @@ func (ncp *NebulaCAPool) GetCAForCert(c *NebulaCertificate) (*NebulaCertificate,
 		return signer, nil
 	}
 
+	if signer := c.chainCertificate(c.Details.Issuer); signer != nil {
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("could not find ca for the certificate")
+}
+
+// GetCAChainForCert returns every signing certificate for the provided certificate, starting with the issuer and
+// ending with a root from the pool. Intermediates are taken from the certificate chain.
+// No signature validation is performed
+func (ncp *NebulaCAPool) GetCAChainForCert(c *NebulaCertificate) ([]*NebulaCertificate, error) {
+	var signers []*NebulaCertificate
+	cur := c
+	for len(signers) <= maxChainLength {
+		if cur.Details.Issuer == "" {
+			if cur != c {
+				// A self signed certificate in the chain is an untrusted root
+				break
+			}
+			return nil, fmt.Errorf("no issuer in certificate")
+		}
+
+		if root, ok := ncp.CAs[cur.Details.Issuer]; ok {
+			return append(signers, root), nil
+		}
+
+		cur = c.chainCertificate(cur.Details.Issuer)
+		if cur == nil {
+			break
+		}
+		signers = append(signers, cur)
+	}
+
+	if len(signers) > maxChainLength {
+		return nil, fmt.Errorf("certificate chain is too long")
+	}
+
 	return nil, fmt.Errorf("could not find ca for the certificate")
 }
 
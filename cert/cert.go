@@ -2,8 +2,6 @@ package cert
 
 import (
 	"bytes"
-	"crypto"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
@@ -11,26 +9,42 @@ import (
 	"encoding/pem"
 	"fmt"
 	"net"
+	"sort"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/ed25519"
 	"google.golang.org/protobuf/proto"
 )
 
 const publicKeyLen = 32
 
+// maxChainLength is the most intermediate CA certificates allowed between a certificate and its root
+const maxChainLength = 8
+
 const (
 	CertBanner              = "NEBULA CERTIFICATE"
 	X25519PrivateKeyBanner  = "NEBULA X25519 PRIVATE KEY"
 	X25519PublicKeyBanner   = "NEBULA X25519 PUBLIC KEY"
 	Ed25519PrivateKeyBanner = "NEBULA ED25519 PRIVATE KEY"
 	Ed25519PublicKeyBanner  = "NEBULA ED25519 PUBLIC KEY"
+
+	EncryptedEd25519PrivateKeyBanner = "NEBULA ED25519 ENCRYPTED PRIVATE KEY"
+
+	P256PrivateKeyBanner      = "NEBULA P256 PRIVATE KEY"
+	P256PublicKeyBanner       = "NEBULA P256 PUBLIC KEY"
+	ECDSAP256PrivateKeyBanner = "NEBULA ECDSA P256 PRIVATE KEY"
+
+	EncryptedECDSAP256PrivateKeyBanner = "NEBULA ECDSA P256 ENCRYPTED PRIVATE KEY"
 )
 
 type NebulaCertificate struct {
 	Details   NebulaCertificateDetails
 	Signature []byte
+
+	// Chain holds the intermediate CA certificates between this certificate and a root, nearest first.
+	// It is not covered by the signature or the fingerprint, Verify checks every link.
+	Chain []*NebulaCertificate
 }
 
 type NebulaCertificateDetails struct {
@@ -43,6 +57,10 @@ type NebulaCertificateDetails struct {
 	PublicKey []byte
 	IsCA      bool
 	Issuer    string
+	Curve     Curve
+
+	// Extensions hold arbitrary signed key/value metadata, such as an owner or environment
+	Extensions map[string]string
 
 	// Map of groups for faster lookup
 	InvertedGroups map[string]struct{}
@@ -61,63 +79,132 @@ func UnmarshalNebulaCertificate(b []byte) (*NebulaCertificate, error) {
 		return nil, err
 	}
 
-	if rc.Details == nil {
-		return nil, fmt.Errorf("encoded Details was nil")
+	nc, err := unmarshalRawCertificate(&rc)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(rc.Details.Ips)%2 != 0 {
-		return nil, fmt.Errorf("encoded IPs should be in pairs, an odd number was found")
+	if len(rc.Chain) > maxChainLength {
+		return nil, fmt.Errorf("certificate chain is too long, have %v intermediates", len(rc.Chain))
 	}
 
-	if len(rc.Details.Subnets)%2 != 0 {
-		return nil, fmt.Errorf("encoded Subnets should be in pairs, an odd number was found")
+	for i, raw := range rc.Chain {
+		ic, err := unmarshalRawCertificate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("chain certificate %v: %s", i, err)
+		}
+		nc.Chain = append(nc.Chain, ic)
+	}
+
+	return nc, nil
+}
+
+func unmarshalRawCertificate(rc *RawNebulaCertificate) (*NebulaCertificate, error) {
+	if rc.Details == nil {
+		return nil, fmt.Errorf("encoded Details was nil")
+	}
+
+	details, err := unmarshalRawDetails(rc.Details)
+	if err != nil {
+		return nil, err
 	}
 
 	nc := NebulaCertificate{
-		Details: NebulaCertificateDetails{
-			Name:           rc.Details.Name,
-			Groups:         make([]string, len(rc.Details.Groups)),
-			Ips:            make([]*net.IPNet, len(rc.Details.Ips)/2),
-			Subnets:        make([]*net.IPNet, len(rc.Details.Subnets)/2),
-			NotBefore:      time.Unix(rc.Details.NotBefore, 0),
-			NotAfter:       time.Unix(rc.Details.NotAfter, 0),
-			PublicKey:      make([]byte, len(rc.Details.PublicKey)),
-			IsCA:           rc.Details.IsCA,
-			InvertedGroups: make(map[string]struct{}),
-		},
+		Details:   details,
 		Signature: make([]byte, len(rc.Signature)),
 	}
 
 	copy(nc.Signature, rc.Signature)
-	copy(nc.Details.Groups, rc.Details.Groups)
-	nc.Details.Issuer = hex.EncodeToString(rc.Details.Issuer)
+	return &nc, nil
+}
+
+// unmarshalRawDetails converts protobuf details into NebulaCertificateDetails, validating the encoded networks
+func unmarshalRawDetails(rd *RawNebulaCertificateDetails) (NebulaCertificateDetails, error) {
+	if len(rd.Ips)%2 != 0 {
+		return NebulaCertificateDetails{}, fmt.Errorf("encoded IPs should be in pairs, an odd number was found")
+	}
 
-	if len(rc.Details.PublicKey) < publicKeyLen {
-		return nil, fmt.Errorf("Public key was fewer than 32 bytes; %v", len(rc.Details.PublicKey))
+	if len(rd.Subnets)%2 != 0 {
+		return NebulaCertificateDetails{}, fmt.Errorf("encoded Subnets should be in pairs, an odd number was found")
 	}
-	copy(nc.Details.PublicKey, rc.Details.PublicKey)
 
-	for i, rawIp := range rc.Details.Ips {
+	if len(rd.Ips6)%8 != 0 {
+		return NebulaCertificateDetails{}, fmt.Errorf("encoded IPv6 IPs should be in groups of 8, an invalid number was found")
+	}
+
+	if len(rd.Subnets6)%8 != 0 {
+		return NebulaCertificateDetails{}, fmt.Errorf("encoded IPv6 Subnets should be in groups of 8, an invalid number was found")
+	}
+
+	d := NebulaCertificateDetails{
+		Name:           rd.Name,
+		Groups:         make([]string, len(rd.Groups)),
+		Ips:            make([]*net.IPNet, len(rd.Ips)/2, len(rd.Ips)/2+len(rd.Ips6)/8),
+		Subnets:        make([]*net.IPNet, len(rd.Subnets)/2, len(rd.Subnets)/2+len(rd.Subnets6)/8),
+		NotBefore:      time.Unix(rd.NotBefore, 0),
+		NotAfter:       time.Unix(rd.NotAfter, 0),
+		PublicKey:      make([]byte, len(rd.PublicKey)),
+		IsCA:           rd.IsCA,
+		Curve:          rd.Curve,
+		InvertedGroups: make(map[string]struct{}),
+	}
+
+	copy(d.Groups, rd.Groups)
+	d.Issuer = hex.EncodeToString(rd.Issuer)
+
+	if len(rd.PublicKey) < publicKeyLen {
+		return NebulaCertificateDetails{}, fmt.Errorf("Public key was fewer than 32 bytes; %v", len(rd.PublicKey))
+	}
+	copy(d.PublicKey, rd.PublicKey)
+
+	for i, rawIp := range rd.Ips {
 		if i%2 == 0 {
-			nc.Details.Ips[i/2] = &net.IPNet{IP: int2ip(rawIp)}
+			d.Ips[i/2] = &net.IPNet{IP: int2ip(rawIp)}
 		} else {
-			nc.Details.Ips[i/2].Mask = net.IPMask(int2ip(rawIp))
+			d.Ips[i/2].Mask = net.IPMask(int2ip(rawIp))
 		}
 	}
 
-	for i, rawIp := range rc.Details.Subnets {
+	for i, rawIp := range rd.Subnets {
 		if i%2 == 0 {
-			nc.Details.Subnets[i/2] = &net.IPNet{IP: int2ip(rawIp)}
+			d.Subnets[i/2] = &net.IPNet{IP: int2ip(rawIp)}
 		} else {
-			nc.Details.Subnets[i/2].Mask = net.IPMask(int2ip(rawIp))
+			d.Subnets[i/2].Mask = net.IPMask(int2ip(rawIp))
 		}
 	}
 
-	for _, g := range rc.Details.Groups {
-		nc.Details.InvertedGroups[g] = struct{}{}
+	for i := 0; i < len(rd.Ips6); i += 8 {
+		d.Ips = append(d.Ips, &net.IPNet{
+			IP:   ints2ip6(rd.Ips6[i : i+4]),
+			Mask: net.IPMask(ints2ip6(rd.Ips6[i+4 : i+8])),
+		})
 	}
 
-	return &nc, nil
+	for i := 0; i < len(rd.Subnets6); i += 8 {
+		d.Subnets = append(d.Subnets, &net.IPNet{
+			IP:   ints2ip6(rd.Subnets6[i : i+4]),
+			Mask: net.IPMask(ints2ip6(rd.Subnets6[i+4 : i+8])),
+		})
+	}
+
+	for _, g := range rd.Groups {
+		d.InvertedGroups[g] = struct{}{}
+	}
+
+	if len(rd.Extensions) > 0 {
+		d.Extensions = make(map[string]string, len(rd.Extensions))
+		for _, e := range rd.Extensions {
+			if e.Key == "" {
+				return NebulaCertificateDetails{}, fmt.Errorf("encoded Extensions contained an empty key")
+			}
+			if _, ok := d.Extensions[e.Key]; ok {
+				return NebulaCertificateDetails{}, fmt.Errorf("encoded Extensions contained a duplicate key: %s", e.Key)
+			}
+			d.Extensions[e.Key] = e.Value
+		}
+	}
+
+	return d, nil
 }
 
 // UnmarshalNebulaCertificateFromPEM will unmarshal the first pem block in a byte array, returning any non consumed data
@@ -134,6 +221,34 @@ func UnmarshalNebulaCertificateFromPEM(b []byte) (*NebulaCertificate, []byte, er
 	return nc, r, err
 }
 
+// UnmarshalNebulaCertificateWithChainFromPEM will unmarshal a pem encoded certificate followed by the pem encoded
+// intermediate CA certificates that lead to its root, as written by MarshalToPEMWithChain. All input is consumed.
+func UnmarshalNebulaCertificateWithChainFromPEM(b []byte) (*NebulaCertificate, error) {
+	nc, r, err := UnmarshalNebulaCertificateFromPEM(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(r) > 0 && strings.TrimSpace(string(r)) != "" {
+		var ic *NebulaCertificate
+		ic, r, err = UnmarshalNebulaCertificateFromPEM(r)
+		if err != nil {
+			return nil, fmt.Errorf("chain certificate %v: %s", len(nc.Chain), err)
+		}
+
+		if !ic.Details.IsCA {
+			return nil, fmt.Errorf("chain certificate %v: %s: %w", len(nc.Chain), ic.Details.Name, ErrNotCA)
+		}
+
+		nc.Chain = append(nc.Chain, ic)
+		if len(nc.Chain) > maxChainLength {
+			return nil, fmt.Errorf("certificate chain is too long, have more than %v intermediates", maxChainLength)
+		}
+	}
+
+	return nc, nil
+}
+
 // MarshalX25519PrivateKey is a simple helper to PEM encode an X25519 private key
 func MarshalX25519PrivateKey(b []byte) []byte {
 	return pem.EncodeToMemory(&pem.Block{Type: X25519PrivateKeyBanner, Bytes: b})
@@ -168,6 +283,9 @@ func UnmarshalEd25519PrivateKey(b []byte) (ed25519.PrivateKey, []byte, error) {
 	if k == nil {
 		return nil, r, fmt.Errorf("input did not contain a valid PEM encoded block")
 	}
+	if k.Type == EncryptedEd25519PrivateKeyBanner {
+		return nil, r, ErrPrivateKeyEncrypted
+	}
 	if k.Type != Ed25519PrivateKeyBanner {
 		return nil, r, fmt.Errorf("bytes did not contain a proper nebula Ed25519 private key banner")
 	}
@@ -205,6 +323,123 @@ func UnmarshalX25519PublicKey(b []byte) ([]byte, []byte, error) {
 	return k.Bytes, r, nil
 }
 
+// MarshalPrivateKey is a simple helper to PEM encode an X25519 or P256 host private key
+func MarshalPrivateKey(curve Curve, b []byte) []byte {
+	switch curve {
+	case Curve_CURVE25519:
+		return pem.EncodeToMemory(&pem.Block{Type: X25519PrivateKeyBanner, Bytes: b})
+	case Curve_P256:
+		return pem.EncodeToMemory(&pem.Block{Type: P256PrivateKeyBanner, Bytes: b})
+	default:
+		return nil
+	}
+}
+
+// MarshalPublicKey is a simple helper to PEM encode an X25519 or P256 host public key
+func MarshalPublicKey(curve Curve, b []byte) []byte {
+	switch curve {
+	case Curve_CURVE25519:
+		return pem.EncodeToMemory(&pem.Block{Type: X25519PublicKeyBanner, Bytes: b})
+	case Curve_P256:
+		return pem.EncodeToMemory(&pem.Block{Type: P256PublicKeyBanner, Bytes: b})
+	default:
+		return nil
+	}
+}
+
+// MarshalSigningPrivateKey is a simple helper to PEM encode an Ed25519 or ECDSA P256 CA private key
+func MarshalSigningPrivateKey(curve Curve, b []byte) []byte {
+	switch curve {
+	case Curve_CURVE25519:
+		return pem.EncodeToMemory(&pem.Block{Type: Ed25519PrivateKeyBanner, Bytes: b})
+	case Curve_P256:
+		return pem.EncodeToMemory(&pem.Block{Type: ECDSAP256PrivateKeyBanner, Bytes: b})
+	default:
+		return nil
+	}
+}
+
+// UnmarshalPrivateKey will try to pem decode an X25519 or P256 host private key, returning the key, any other bytes b
+// and the curve of the key or an error on failure
+func UnmarshalPrivateKey(b []byte) ([]byte, []byte, Curve, error) {
+	k, r := pem.Decode(b)
+	if k == nil {
+		return nil, r, 0, fmt.Errorf("input did not contain a valid PEM encoded block")
+	}
+
+	var curve Curve
+	switch k.Type {
+	case X25519PrivateKeyBanner:
+		curve = Curve_CURVE25519
+	case P256PrivateKeyBanner:
+		curve = Curve_P256
+	default:
+		return nil, r, 0, fmt.Errorf("bytes did not contain a proper nebula private key banner")
+	}
+
+	if len(k.Bytes) != 32 {
+		return nil, r, 0, fmt.Errorf("key was not 32 bytes, is invalid %s private key", curve)
+	}
+
+	return k.Bytes, r, curve, nil
+}
+
+// UnmarshalPublicKey will try to pem decode an X25519 or P256 host public key, returning the key, any other bytes b
+// and the curve of the key or an error on failure
+func UnmarshalPublicKey(b []byte) ([]byte, []byte, Curve, error) {
+	k, r := pem.Decode(b)
+	if k == nil {
+		return nil, r, 0, fmt.Errorf("input did not contain a valid PEM encoded block")
+	}
+
+	var curve Curve
+	var expectedLen int
+	switch k.Type {
+	case X25519PublicKeyBanner:
+		curve, expectedLen = Curve_CURVE25519, publicKeyLen
+	case P256PublicKeyBanner:
+		// Uncompressed points are 65 bytes
+		curve, expectedLen = Curve_P256, 65
+	default:
+		return nil, r, 0, fmt.Errorf("bytes did not contain a proper nebula public key banner")
+	}
+
+	if len(k.Bytes) != expectedLen {
+		return nil, r, 0, fmt.Errorf("key was not %v bytes, is invalid %s public key", expectedLen, curve)
+	}
+
+	return k.Bytes, r, curve, nil
+}
+
+// UnmarshalSigningPrivateKey will try to pem decode an Ed25519 or ECDSA P256 CA private key, returning the key, any
+// other bytes b and the curve of the key or an error on failure
+func UnmarshalSigningPrivateKey(b []byte) ([]byte, []byte, Curve, error) {
+	k, r := pem.Decode(b)
+	if k == nil {
+		return nil, r, 0, fmt.Errorf("input did not contain a valid PEM encoded block")
+	}
+
+	switch k.Type {
+	case EncryptedEd25519PrivateKeyBanner, EncryptedECDSAP256PrivateKeyBanner:
+		return nil, r, 0, ErrPrivateKeyEncrypted
+
+	case Ed25519PrivateKeyBanner:
+		if len(k.Bytes) != ed25519.PrivateKeySize {
+			return nil, r, 0, fmt.Errorf("key was not 64 bytes, is invalid ed25519 private key")
+		}
+		return k.Bytes, r, Curve_CURVE25519, nil
+
+	case ECDSAP256PrivateKeyBanner:
+		if len(k.Bytes) != p256PrivateKeyLen {
+			return nil, r, 0, fmt.Errorf("key was not 32 bytes, is invalid ECDSA P256 private key")
+		}
+		return k.Bytes, r, Curve_P256, nil
+
+	default:
+		return nil, r, 0, fmt.Errorf("bytes did not contain a proper nebula signing private key banner")
+	}
+}
+
 // UnmarshalEd25519PublicKey will try to pem decode an Ed25519 public key, returning any other bytes b
 // or an error on failure
 func UnmarshalEd25519PublicKey(b []byte) (ed25519.PublicKey, []byte, error) {
@@ -222,14 +457,15 @@ func UnmarshalEd25519PublicKey(b []byte) (ed25519.PublicKey, []byte, error) {
 	return k.Bytes, r, nil
 }
 
-// Sign signs a nebula cert with the provided private key
-func (nc *NebulaCertificate) Sign(key ed25519.PrivateKey) error {
+// Sign signs a nebula cert with the provided private key, which must be an Ed25519 key for a CURVE25519 certificate
+// or an ECDSA P256 key for a P256 certificate
+func (nc *NebulaCertificate) Sign(key []byte) error {
 	b, err := proto.Marshal(nc.getRawDetails())
 	if err != nil {
 		return err
 	}
 
-	sig, err := key.Sign(rand.Reader, b, crypto.Hash(0))
+	sig, err := signBytes(nc.Details.Curve, key, b)
 	if err != nil {
 		return err
 	}
@@ -238,12 +474,22 @@ func (nc *NebulaCertificate) Sign(key ed25519.PrivateKey) error {
 }
 
 // CheckSignature verifies the signature against the provided public key
-func (nc *NebulaCertificate) CheckSignature(key ed25519.PublicKey) bool {
+func (nc *NebulaCertificate) CheckSignature(key []byte) bool {
 	b, err := proto.Marshal(nc.getRawDetails())
 	if err != nil {
 		return false
 	}
-	return ed25519.Verify(key, b, nc.Signature)
+	return verifySignature(nc.Details.Curve, key, b, nc.Signature)
+}
+
+// SignData signs arbitrary data with the private key of a ca certificate, using the signature scheme of its curve
+func (nc *NebulaCertificate) SignData(key, b []byte) ([]byte, error) {
+	return signBytes(nc.Details.Curve, key, b)
+}
+
+// CheckDataSignature verifies a signature made by SignData against the public key of the certificate
+func (nc *NebulaCertificate) CheckDataSignature(b, sig []byte) bool {
+	return verifySignature(nc.Details.Curve, nc.Details.PublicKey, b, sig)
 }
 
 // Expired will return true if the nebula cert is too young or too old compared to the provided time, otherwise false
@@ -251,34 +497,102 @@ func (nc *NebulaCertificate) Expired(t time.Time) bool {
 	return nc.Details.NotBefore.After(t) || nc.Details.NotAfter.Before(t)
 }
 
+// expiredWithSkew is like Expired but allows the validity period to be exceeded by up to skew in either direction
+func (nc *NebulaCertificate) expiredWithSkew(t time.Time, skew time.Duration) bool {
+	return nc.Details.NotBefore.After(t.Add(skew)) || nc.Details.NotAfter.Before(t.Add(-skew))
+}
+
 // Verify will ensure a certificate is good in all respects (expiry, group membership, signature, cert blocklist, etc)
 func (nc *NebulaCertificate) Verify(t time.Time, ncp *NebulaCAPool) (bool, error) {
 	if ncp.IsBlocklisted(nc) {
 		return false, fmt.Errorf("certificate has been blocked")
 	}
 
-	signer, err := ncp.GetCAForCert(nc)
+	if ncp.IsRevoked(nc) {
+		return false, fmt.Errorf("certificate has been revoked")
+	}
+
+	signers, err := ncp.GetCAChainForCert(nc)
 	if err != nil {
 		return false, err
 	}
 
-	if signer.Expired(t) {
-		return false, fmt.Errorf("root certificate is expired")
+	// Walk up the chain, the last signer is a root from the pool
+	c := nc
+	for i, signer := range signers {
+		root := i == len(signers)-1
+		if err := c.checkIssuedBy(t, signer, root, ncp.clockSkew); err != nil {
+			if c != nc {
+				return false, fmt.Errorf("intermediate certificate %s: %s", c.Details.Name, err)
+			}
+			return false, err
+		}
+
+		if !root {
+			if ncp.IsBlocklisted(signer) {
+				return false, fmt.Errorf("intermediate certificate %s: certificate has been blocked", signer.Details.Name)
+			}
+
+			if ncp.IsRevoked(signer) {
+				return false, fmt.Errorf("intermediate certificate %s: certificate has been revoked", signer.Details.Name)
+			}
+		}
+
+		c = signer
 	}
 
-	if nc.Expired(t) {
-		return false, fmt.Errorf("certificate is expired")
+	// Limits are inherited, a signer without any can not hand out what a ca further up the chain does not allow
+	c = nc
+	for i := range signers {
+		if err := c.CheckInheritedConstrains(signers[i+1:]); err != nil {
+			if c != nc {
+				return false, fmt.Errorf("intermediate certificate %s: %s", c.Details.Name, err)
+			}
+			return false, err
+		}
+		c = signers[i]
+	}
+
+	return true, nil
+}
+
+// checkIssuedBy verifies a single link of a certificate chain
+func (nc *NebulaCertificate) checkIssuedBy(t time.Time, signer *NebulaCertificate, root bool, skew time.Duration) error {
+	if !signer.Details.IsCA {
+		return fmt.Errorf("%s: %w", signer.Details.Name, ErrNotCA)
+	}
+
+	if signer.expiredWithSkew(t, skew) {
+		if root {
+			return fmt.Errorf("root certificate is expired")
+		}
+		return fmt.Errorf("intermediate certificate is expired")
+	}
+
+	if nc.expiredWithSkew(t, skew) {
+		return fmt.Errorf("certificate is expired")
+	}
+
+	if nc.Details.Curve != signer.Details.Curve {
+		return fmt.Errorf("certificate curve %s does not match the signing ca curve %s", nc.Details.Curve, signer.Details.Curve)
 	}
 
 	if !nc.CheckSignature(signer.Details.PublicKey) {
-		return false, fmt.Errorf("certificate signature did not match")
+		return fmt.Errorf("certificate signature did not match")
 	}
 
-	if err := nc.CheckRootConstrains(signer); err != nil {
-		return false, err
+	return nc.CheckRootConstrains(signer)
+}
+
+// chainCertificate returns the certificate from the chain with the provided fingerprint
+func (nc *NebulaCertificate) chainCertificate(fingerprint string) *NebulaCertificate {
+	for _, c := range nc.Chain {
+		if fp, err := c.Sha256Sum(); err == nil && fp == fingerprint {
+			return c
+		}
 	}
 
-	return true, nil
+	return nil
 }
 
 // CheckRootConstrains returns an error if the certificate violates constraints set on the root (groups, ips, subnets)
@@ -293,6 +607,42 @@ func (nc *NebulaCertificate) CheckRootConstrains(signer *NebulaCertificate) erro
 		return fmt.Errorf("certificate is valid before the signing certificate")
 	}
 
+	return nc.checkLimits(signer)
+}
+
+// CheckInheritedConstrains returns an error if the certificate violates the groups, ips or subnets of any of the cas
+// above its direct signer. ancestors is ordered nearest first, the same as the chain of the signer.
+func (nc *NebulaCertificate) CheckInheritedConstrains(ancestors []*NebulaCertificate) error {
+	for _, ca := range ancestors {
+		if err := nc.checkLimits(ca); err != nil {
+			return fmt.Errorf("%s, inherited from %s", err, ca.Details.Name)
+		}
+	}
+
+	return nil
+}
+
+// CheckIntermediateConstrains returns an error if the ca certificate would be able to issue certificates the signer
+// could not, because it drops a groups, ips or subnets limit the signer has
+func (nc *NebulaCertificate) CheckIntermediateConstrains(signer *NebulaCertificate) error {
+	if len(signer.Details.Groups) > 0 && len(nc.Details.Groups) == 0 {
+		return fmt.Errorf("intermediate certificate has no groups limit but the signing ca is limited to: %s", strings.Join(signer.Details.Groups, ", "))
+	}
+
+	if len(signer.Details.Ips) > 0 && len(nc.Details.Ips) == 0 {
+		return fmt.Errorf("intermediate certificate has no ips limit but the signing ca is limited to: %s", joinIPNets(signer.Details.Ips))
+	}
+
+	if len(signer.Details.Subnets) > 0 && len(nc.Details.Subnets) == 0 {
+		return fmt.Errorf("intermediate certificate has no subnets limit but the signing ca is limited to: %s", joinIPNets(signer.Details.Subnets))
+	}
+
+	return nil
+}
+
+// checkLimits returns an error if the certificate contains a group, ip or subnet outside of the ones the signer is
+// limited to
+func (nc *NebulaCertificate) checkLimits(signer *NebulaCertificate) error {
 	// If the signer has a limited set of groups make sure the cert only contains a subset
 	if len(signer.Details.InvertedGroups) > 0 {
 		for _, g := range nc.Details.Groups {
@@ -325,22 +675,17 @@ func (nc *NebulaCertificate) CheckRootConstrains(signer *NebulaCertificate) erro
 
 // VerifyPrivateKey checks that the public key in the Nebula certificate and a supplied private key match
 func (nc *NebulaCertificate) VerifyPrivateKey(key []byte) error {
+	var pub []byte
+	var err error
 	if nc.Details.IsCA {
-		// the call to PublicKey below will panic slice bounds out of range otherwise
-		if len(key) != ed25519.PrivateKeySize {
-			return fmt.Errorf("key was not 64 bytes, is invalid ed25519 private key")
-		}
-
-		if !ed25519.PublicKey(nc.Details.PublicKey).Equal(ed25519.PrivateKey(key).Public()) {
-			return fmt.Errorf("public key in cert and private key supplied don't match")
-		}
-		return nil
+		pub, err = signingPublicKey(nc.Details.Curve, key)
+	} else {
+		pub, err = dhPublicKey(nc.Details.Curve, key)
 	}
-
-	pub, err := curve25519.X25519(key, curve25519.Basepoint)
 	if err != nil {
 		return err
 	}
+
 	if !bytes.Equal(pub, nc.Details.PublicKey) {
 		return fmt.Errorf("public key in cert and private key supplied don't match")
 	}
@@ -388,11 +733,20 @@ func (nc *NebulaCertificate) String() string {
 		s += "\t\tGroups: []\n"
 	}
 
+	if len(nc.Details.Extensions) > 0 {
+		s += "\t\tExtensions: [\n"
+		for _, k := range nc.Details.extensionKeys() {
+			s += fmt.Sprintf("\t\t\t\"%v\": \"%v\"\n", k, nc.Details.Extensions[k])
+		}
+		s += "\t\t]\n"
+	}
+
 	s += fmt.Sprintf("\t\tNot before: %v\n", nc.Details.NotBefore)
 	s += fmt.Sprintf("\t\tNot After: %v\n", nc.Details.NotAfter)
 	s += fmt.Sprintf("\t\tIs CA: %v\n", nc.Details.IsCA)
 	s += fmt.Sprintf("\t\tIssuer: %s\n", nc.Details.Issuer)
 	s += fmt.Sprintf("\t\tPublic key: %x\n", nc.Details.PublicKey)
+	s += fmt.Sprintf("\t\tCurve: %s\n", nc.Details.Curve)
 	s += "\t}\n"
 	fp, err := nc.Sha256Sum()
 	if err == nil {
@@ -413,14 +767,29 @@ func (nc *NebulaCertificate) getRawDetails() *RawNebulaCertificateDetails {
 		NotAfter:  nc.Details.NotAfter.Unix(),
 		PublicKey: make([]byte, len(nc.Details.PublicKey)),
 		IsCA:      nc.Details.IsCA,
+		Curve:     nc.Details.Curve,
 	}
 
 	for _, ipNet := range nc.Details.Ips {
-		rd.Ips = append(rd.Ips, ip2int(ipNet.IP), ip2int(ipNet.Mask))
+		if ipNet.IP.To4() != nil {
+			rd.Ips = append(rd.Ips, ip2int(ipNet.IP), ip2int(ipNet.Mask))
+		} else {
+			rd.Ips6 = append(rd.Ips6, ip62ints(ipNet.IP)...)
+			rd.Ips6 = append(rd.Ips6, ip62ints(ipNet.Mask)...)
+		}
 	}
 
 	for _, ipNet := range nc.Details.Subnets {
-		rd.Subnets = append(rd.Subnets, ip2int(ipNet.IP), ip2int(ipNet.Mask))
+		if ipNet.IP.To4() != nil {
+			rd.Subnets = append(rd.Subnets, ip2int(ipNet.IP), ip2int(ipNet.Mask))
+		} else {
+			rd.Subnets6 = append(rd.Subnets6, ip62ints(ipNet.IP)...)
+			rd.Subnets6 = append(rd.Subnets6, ip62ints(ipNet.Mask)...)
+		}
+	}
+
+	for _, k := range nc.Details.extensionKeys() {
+		rd.Extensions = append(rd.Extensions, &RawNebulaCertificateExtension{Key: k, Value: nc.Details.Extensions[k]})
 	}
 
 	copy(rd.PublicKey, nc.Details.PublicKey[:])
@@ -431,6 +800,16 @@ func (nc *NebulaCertificate) getRawDetails() *RawNebulaCertificateDetails {
 	return rd
 }
 
+// extensionKeys returns the extension keys in the order they are marshaled
+func (d *NebulaCertificateDetails) extensionKeys() []string {
+	keys := make([]string, 0, len(d.Extensions))
+	for k := range d.Extensions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Marshal will marshal a nebula cert into a protobuf byte array
 func (nc *NebulaCertificate) Marshal() ([]byte, error) {
 	rc := RawNebulaCertificate{
@@ -441,6 +820,23 @@ func (nc *NebulaCertificate) Marshal() ([]byte, error) {
 	return proto.Marshal(&rc)
 }
 
+// MarshalWithChain will marshal a nebula cert into a protobuf byte array that also carries the intermediate CA chain
+func (nc *NebulaCertificate) MarshalWithChain() ([]byte, error) {
+	rc := RawNebulaCertificate{
+		Details:   nc.getRawDetails(),
+		Signature: nc.Signature,
+	}
+
+	for _, c := range nc.Chain {
+		rc.Chain = append(rc.Chain, &RawNebulaCertificate{
+			Details:   c.getRawDetails(),
+			Signature: c.Signature,
+		})
+	}
+
+	return proto.Marshal(&rc)
+}
+
 // MarshalToPEM will marshal a nebula cert into a protobuf byte array and pem encode the result
 func (nc *NebulaCertificate) MarshalToPEM() ([]byte, error) {
 	b, err := nc.Marshal()
@@ -450,6 +846,24 @@ func (nc *NebulaCertificate) MarshalToPEM() ([]byte, error) {
 	return pem.EncodeToMemory(&pem.Block{Type: CertBanner, Bytes: b}), nil
 }
 
+// MarshalToPEMWithChain will pem encode the certificate followed by each certificate in the chain
+func (nc *NebulaCertificate) MarshalToPEMWithChain() ([]byte, error) {
+	b, err := nc.MarshalToPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range nc.Chain {
+		cb, err := c.MarshalToPEM()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, cb...)
+	}
+
+	return b, nil
+}
+
 // Sha256Sum calculates a sha-256 sum of the marshaled certificate
 func (nc *NebulaCertificate) Sha256Sum() (string, error) {
 	b, err := nc.Marshal()
@@ -471,18 +885,24 @@ func (nc *NebulaCertificate) MarshalJSON() ([]byte, error) {
 	}
 
 	fp, _ := nc.Sha256Sum()
+	details := m{
+		"name":      nc.Details.Name,
+		"ips":       toString(nc.Details.Ips),
+		"subnets":   toString(nc.Details.Subnets),
+		"groups":    nc.Details.Groups,
+		"notBefore": nc.Details.NotBefore,
+		"notAfter":  nc.Details.NotAfter,
+		"publicKey": fmt.Sprintf("%x", nc.Details.PublicKey),
+		"isCa":      nc.Details.IsCA,
+		"issuer":    nc.Details.Issuer,
+		"curve":     nc.Details.Curve.String(),
+	}
+	if len(nc.Details.Extensions) > 0 {
+		details["extensions"] = nc.Details.Extensions
+	}
+
 	jc := m{
-		"details": m{
-			"name":      nc.Details.Name,
-			"ips":       toString(nc.Details.Ips),
-			"subnets":   toString(nc.Details.Subnets),
-			"groups":    nc.Details.Groups,
-			"notBefore": nc.Details.NotBefore,
-			"notAfter":  nc.Details.NotAfter,
-			"publicKey": fmt.Sprintf("%x", nc.Details.PublicKey),
-			"isCa":      nc.Details.IsCA,
-			"issuer":    nc.Details.Issuer,
-		},
+		"details":     details,
 		"fingerprint": fp,
 		"signature":   fmt.Sprintf("%x", nc.Signature),
 	}
@@ -512,6 +932,7 @@ func (nc *NebulaCertificate) Copy() *NebulaCertificate {
 			PublicKey:      make([]byte, len(nc.Details.PublicKey)),
 			IsCA:           nc.Details.IsCA,
 			Issuer:         nc.Details.Issuer,
+			Curve:          nc.Details.Curve,
 			InvertedGroups: make(map[string]struct{}, len(nc.Details.InvertedGroups)),
 		},
 		Signature: make([]byte, len(nc.Signature)),
@@ -543,9 +964,28 @@ func (nc *NebulaCertificate) Copy() *NebulaCertificate {
 		c.Details.InvertedGroups[g] = struct{}{}
 	}
 
+	if nc.Details.Extensions != nil {
+		c.Details.Extensions = make(map[string]string, len(nc.Details.Extensions))
+		for k, v := range nc.Details.Extensions {
+			c.Details.Extensions[k] = v
+		}
+	}
+
+	for _, ic := range nc.Chain {
+		c.Chain = append(c.Chain, ic.Copy())
+	}
+
 	return c
 }
 
+func joinIPNets(nets []*net.IPNet) string {
+	s := make([]string, len(nets))
+	for i, n := range nets {
+		s[i] = n.String()
+	}
+	return strings.Join(s, ", ")
+}
+
 func netMatch(certIp *net.IPNet, rootIps []*net.IPNet) bool {
 	for _, net := range rootIps {
 		if net.Contains(certIp.IP) && maskContains(net.Mask, certIp.Mask) {
@@ -559,13 +999,19 @@ func netMatch(certIp *net.IPNet, rootIps []*net.IPNet) bool {
 func maskContains(caMask, certMask net.IPMask) bool {
 	caM := maskTo4(caMask)
 	cM := maskTo4(certMask)
+	if caM == nil && cM == nil && len(caMask) == net.IPv6len && len(certMask) == net.IPv6len {
+		// Both are ipv6 masks
+		caM = caMask
+		cM = certMask
+	}
+
 	// Make sure forcing to ipv4 didn't nuke us
 	if caM == nil || cM == nil {
 		return false
 	}
 
 	// Make sure the cert mask is not greater than the ca mask
-	for i := 0; i < len(caMask); i++ {
+	for i := 0; i < len(caM); i++ {
 		if caM[i] > cM[i] {
 			return false
 		}
@@ -607,3 +1053,21 @@ func int2ip(nn uint32) net.IP {
 	binary.BigEndian.PutUint32(ip, nn)
 	return ip
 }
+
+func ip62ints(ip []byte) []uint32 {
+	ip = net.IP(ip).To16()
+	return []uint32{
+		binary.BigEndian.Uint32(ip[0:4]),
+		binary.BigEndian.Uint32(ip[4:8]),
+		binary.BigEndian.Uint32(ip[8:12]),
+		binary.BigEndian.Uint32(ip[12:16]),
+	}
+}
+
+func ints2ip6(nn []uint32) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	for i, n := range nn {
+		binary.BigEndian.PutUint32(ip[i*4:], n)
+	}
+	return ip
+}
@@ -1,6 +1,8 @@
 package cert
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -71,6 +73,117 @@ func TestMarshalingNebulaCertificate(t *testing.T) {
 	assert.EqualValues(t, nc.Details.Groups, nc2.Details.Groups)
 }
 
+func TestMarshalingNebulaCertificate_Extensions(t *testing.T) {
+	time.Local = time.UTC
+	ca, _, caKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	c, _, _, err := newTestCert(ca, caKey, time.Now(), time.Now().Add(5*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	assert.Nil(t, c.Details.Extensions)
+
+	c.Details.Extensions = map[string]string{"team": "ops", "env": "prod", "asset": ""}
+	assert.Nil(t, c.Sign(caKey))
+
+	b, err := c.Marshal()
+	assert.Nil(t, err)
+	c2, err := UnmarshalNebulaCertificate(b)
+	assert.Nil(t, err)
+	assert.Equal(t, c.Details.Extensions, c2.Details.Extensions)
+
+	// Extensions are covered by the signature and marshal in a stable order
+	assert.True(t, c2.CheckSignature(ca.Details.PublicKey))
+	for i := 0; i < 10; i++ {
+		b2, err := c2.Marshal()
+		assert.Nil(t, err)
+		assert.Equal(t, b, b2)
+	}
+
+	c2.Details.Extensions["team"] = "dev"
+	assert.False(t, c2.CheckSignature(ca.Details.PublicKey))
+
+	// Extensions are only printed when present
+	assert.Contains(t, c.String(), "\t\tExtensions: [\n\t\t\t\"asset\": \"\"\n\t\t\t\"env\": \"prod\"\n\t\t\t\"team\": \"ops\"\n\t\t]\n")
+	assert.NotContains(t, ca.String(), "Extensions")
+
+	jb, err := c.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Contains(t, string(jb), "\"extensions\":{\"asset\":\"\",\"env\":\"prod\",\"team\":\"ops\"}")
+	jb, err = ca.MarshalJSON()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(jb), "extensions")
+
+	// Bad encodings
+	rd := c.getRawDetails()
+	rd.Extensions = append(rd.Extensions, &RawNebulaCertificateExtension{Key: "team", Value: "dev"})
+	_, err = unmarshalRawDetails(rd)
+	assert.EqualError(t, err, "encoded Extensions contained a duplicate key: team")
+
+	rd = c.getRawDetails()
+	rd.Extensions = append(rd.Extensions, &RawNebulaCertificateExtension{Value: "dev"})
+	_, err = unmarshalRawDetails(rd)
+	assert.EqualError(t, err, "encoded Extensions contained an empty key")
+}
+
+func TestMarshalingNebulaCertificate_IPv6(t *testing.T) {
+	before := time.Now().Add(time.Second * -60).Round(time.Second)
+	after := time.Now().Add(time.Second * 60).Round(time.Second)
+	pubKey := []byte("1234567890abcedfghij1234567890ab")
+
+	_, ip6, _ := net.ParseCIDR("fd00::1/64")
+	ip6.IP = net.ParseIP("fd00::1")
+	_, subnet6, _ := net.ParseCIDR("fd01:2::/32")
+
+	nc := NebulaCertificate{
+		Details: NebulaCertificateDetails{
+			Name: "testing",
+			Ips: []*net.IPNet{
+				{IP: net.ParseIP("10.1.1.1"), Mask: net.IPMask(net.ParseIP("255.255.255.0"))},
+				ip6,
+			},
+			Subnets: []*net.IPNet{
+				subnet6,
+				{IP: net.ParseIP("9.1.1.0"), Mask: net.IPMask(net.ParseIP("255.255.255.0"))},
+			},
+			Groups:    []string{"test-group1"},
+			NotBefore: before,
+			NotAfter:  after,
+			PublicKey: pubKey,
+			IsCA:      false,
+			Issuer:    "1234567890abcedfghij1234567890ab",
+		},
+		Signature: []byte("1234567890abcedfghij1234567890ab"),
+	}
+
+	b, err := nc.Marshal()
+	assert.Nil(t, err)
+
+	nc2, err := UnmarshalNebulaCertificate(b)
+	assert.Nil(t, err)
+
+	// ipv4 entries are always first
+	assert.Len(t, nc2.Details.Ips, 2)
+	assert.Equal(t, "10.1.1.1/24", nc2.Details.Ips[0].String())
+	assert.Equal(t, "fd00::1/64", nc2.Details.Ips[1].String())
+
+	assert.Len(t, nc2.Details.Subnets, 2)
+	assert.Equal(t, "9.1.1.0/24", nc2.Details.Subnets[0].String())
+	assert.Equal(t, "fd01:2::/32", nc2.Details.Subnets[1].String())
+
+	// Re-marshaling must produce the same bytes so signatures remain valid
+	b2, err := nc2.Marshal()
+	assert.Nil(t, err)
+	assert.Equal(t, b, b2)
+
+	// Bad encodings are rejected
+	rc := RawNebulaCertificate{Details: nc.getRawDetails()}
+	rc.Details.Ips6 = rc.Details.Ips6[:7]
+	b, err = proto.Marshal(&rc)
+	assert.Nil(t, err)
+	_, err = UnmarshalNebulaCertificate(b)
+	assert.EqualError(t, err, "encoded IPv6 IPs should be in groups of 8, an invalid number was found")
+}
+
 func TestNebulaCertificate_Sign(t *testing.T) {
 	before := time.Now().Add(time.Second * -60).Round(time.Second)
 	after := time.Now().Add(time.Second * 60).Round(time.Second)
@@ -153,7 +266,7 @@ func TestNebulaCertificate_MarshalJSON(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(
 		t,
-		"{\"details\":{\"groups\":[\"test-group1\",\"test-group2\",\"test-group3\"],\"ips\":[\"10.1.1.1/24\",\"10.1.1.2/16\",\"10.1.1.3/ff00ff00\"],\"isCa\":false,\"issuer\":\"1234567890abcedfghij1234567890ab\",\"name\":\"testing\",\"notAfter\":\"0000-11-30T02:00:00Z\",\"notBefore\":\"0000-11-30T01:00:00Z\",\"publicKey\":\"313233343536373839306162636564666768696a313233343536373839306162\",\"subnets\":[\"9.1.1.1/ff00ff00\",\"9.1.1.2/24\",\"9.1.1.3/16\"]},\"fingerprint\":\"26cb1c30ad7872c804c166b5150fa372f437aa3856b04edb4334b4470ec728e4\",\"signature\":\"313233343536373839306162636564666768696a313233343536373839306162\"}",
+		"{\"details\":{\"curve\":\"CURVE25519\",\"groups\":[\"test-group1\",\"test-group2\",\"test-group3\"],\"ips\":[\"10.1.1.1/24\",\"10.1.1.2/16\",\"10.1.1.3/ff00ff00\"],\"isCa\":false,\"issuer\":\"1234567890abcedfghij1234567890ab\",\"name\":\"testing\",\"notAfter\":\"0000-11-30T02:00:00Z\",\"notBefore\":\"0000-11-30T01:00:00Z\",\"publicKey\":\"313233343536373839306162636564666768696a313233343536373839306162\",\"subnets\":[\"9.1.1.1/ff00ff00\",\"9.1.1.2/24\",\"9.1.1.3/16\"]},\"fingerprint\":\"26cb1c30ad7872c804c166b5150fa372f437aa3856b04edb4334b4470ec728e4\",\"signature\":\"313233343536373839306162636564666768696a313233343536373839306162\"}",
 		string(b),
 	)
 }
@@ -217,6 +330,60 @@ func TestNebulaCertificate_Verify(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestNebulaCertificate_VerifyClockSkew(t *testing.T) {
+	now := time.Now()
+	ca, _, caKey, err := newTestCaCert(now.Add(-time.Hour), now.Add(time.Hour), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	c, _, _, err := newTestCert(ca, caKey, now, now.Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	caPool := NewCAPool()
+	h, err := ca.Sha256Sum()
+	assert.Nil(t, err)
+	caPool.CAs[h] = ca
+
+	// A peer whose clock is behind ours sees our fresh cert as not yet valid
+	v, err := c.Verify(now.Add(-time.Minute), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate is expired")
+
+	v, err = c.Verify(now.Add(11*time.Minute), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate is expired")
+
+	caPool.SetClockSkew(2 * time.Minute)
+	v, err = c.Verify(now.Add(-time.Minute), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+
+	v, err = c.Verify(now.Add(11*time.Minute), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+
+	// The tolerance is not unlimited
+	v, err = c.Verify(now.Add(-3*time.Minute), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate is expired")
+
+	// It applies to the signing ca as well
+	ca, _, caKey, err = newTestCaCert(now.Add(-time.Hour), now.Add(5*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	c, _, _, err = newTestCert(ca, caKey, now, now.Add(5*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	h, err = ca.Sha256Sum()
+	assert.Nil(t, err)
+	caPool.CAs[h] = ca
+
+	v, err = c.Verify(now.Add(6*time.Minute), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+
+	v, err = c.Verify(now.Add(8*time.Minute), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "root certificate is expired")
+}
+
 func TestNebulaCertificate_Verify_IPs(t *testing.T) {
 	_, caIp1, _ := net.ParseCIDR("10.0.0.0/16")
 	_, caIp2, _ := net.ParseCIDR("192.168.0.0/24")
@@ -296,6 +463,51 @@ func TestNebulaCertificate_Verify_IPs(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestNebulaCertificate_Verify_IPv6(t *testing.T) {
+	_, caIp1, _ := net.ParseCIDR("10.0.0.0/16")
+	_, caIp2, _ := net.ParseCIDR("fd00::/48")
+	ca, _, caKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{caIp1, caIp2}, []*net.IPNet{caIp2}, []string{"test"})
+	assert.Nil(t, err)
+
+	caPem, err := ca.MarshalToPEM()
+	assert.Nil(t, err)
+
+	caPool := NewCAPool()
+	caPool.AddCACertificate(caPem)
+
+	// ip is outside the network
+	cIp1 := &net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: []byte{255, 255, 255, 0}}
+	_, cIp2, _ := net.ParseCIDR("fd01::/64")
+	c, _, _, err := newTestCert(ca, caKey, time.Now(), time.Now().Add(5*time.Minute), []*net.IPNet{cIp1, cIp2}, []*net.IPNet{}, []string{"test"})
+	assert.Nil(t, err)
+	v, err := c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate contained an ip assignment outside the limitations of the signing ca: fd01::/64")
+
+	// ip is within the network but mask is outside
+	_, cIp2, _ = net.ParseCIDR("fd00::/32")
+	c, _, _, err = newTestCert(ca, caKey, time.Now(), time.Now().Add(5*time.Minute), []*net.IPNet{cIp1, cIp2}, []*net.IPNet{}, []string{"test"})
+	assert.Nil(t, err)
+	v, err = c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate contained an ip assignment outside the limitations of the signing ca: fd00::/32")
+
+	// ip and mask are within the network
+	_, cIp2, _ = net.ParseCIDR("fd00:0:0:1::/64")
+	c, _, _, err = newTestCert(ca, caKey, time.Now(), time.Now().Add(5*time.Minute), []*net.IPNet{cIp1, cIp2}, []*net.IPNet{cIp2}, []string{"test"})
+	assert.Nil(t, err)
+	v, err = c.Verify(time.Now(), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+
+	// an ipv4 subnet is not allowed by an ipv6 only constraint
+	c, _, _, err = newTestCert(ca, caKey, time.Now(), time.Now().Add(5*time.Minute), []*net.IPNet{cIp1, cIp2}, []*net.IPNet{cIp1}, []string{"test"})
+	assert.Nil(t, err)
+	v, err = c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate contained a subnet assignment outside the limitations of the signing ca: 10.0.0.1/24")
+}
+
 func TestNebulaCertificate_Verify_Subnets(t *testing.T) {
 	_, caIp1, _ := net.ParseCIDR("10.0.0.0/16")
 	_, caIp2, _ := net.ParseCIDR("192.168.0.0/24")
@@ -375,6 +587,277 @@ func TestNebulaCertificate_Verify_Subnets(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestNebulaCertificate_Verify_Chain(t *testing.T) {
+	root, _, rootKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"test1", "test2"})
+	assert.Nil(t, err)
+
+	inter, _, interKey, err := newTestIntermediateCert(root, rootKey, time.Now(), time.Now().Add(5*time.Minute), []string{"test1"})
+	assert.Nil(t, err)
+
+	c, _, _, err := newTestCert(inter, interKey, time.Now(), time.Now().Add(4*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"test1"})
+	assert.Nil(t, err)
+
+	caPem, err := root.MarshalToPEM()
+	assert.Nil(t, err)
+	caPool := NewCAPool()
+	_, err = caPool.AddCACertificate(caPem)
+	assert.Nil(t, err)
+
+	// The intermediate is not a trust anchor
+	interPem, err := inter.MarshalToPEM()
+	assert.Nil(t, err)
+	_, err = NewCAPool().AddCACertificate(interPem)
+	assert.EqualError(t, err, "test intermediate: certificate is not self-signed")
+
+	// Without the chain the issuer is unknown
+	v, err := c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "could not find ca for the certificate")
+
+	c.Chain = []*NebulaCertificate{inter}
+	v, err = c.Verify(time.Now(), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+
+	signers, err := caPool.GetCAChainForCert(c)
+	assert.Nil(t, err)
+	rootFp, err := root.Sha256Sum()
+	assert.Nil(t, err)
+	assert.Equal(t, []*NebulaCertificate{inter, caPool.CAs[rootFp]}, signers)
+
+	signer, err := caPool.GetCAForCert(c)
+	assert.Nil(t, err)
+	assert.Equal(t, inter, signer)
+
+	// The intermediate expires first
+	v, err = c.Verify(time.Now().Add(6*time.Minute), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "intermediate certificate is expired")
+
+	// Blocking or revoking the intermediate takes out everything below it
+	interFp, err := inter.Sha256Sum()
+	assert.Nil(t, err)
+	caPool.BlocklistFingerprint(interFp)
+	v, err = c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "intermediate certificate test intermediate: certificate has been blocked")
+	caPool.ResetCertBlocklist()
+
+	// Intermediate constraints apply to the certificate
+	bad, _, _, err := newTestCert(inter, interKey, time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"test2"})
+	assert.Nil(t, err)
+	bad.Chain = []*NebulaCertificate{inter}
+	v, err = bad.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate contained a group not present on the signing ca: test2")
+
+	// Root constraints apply to the intermediate
+	badInter, _, badInterKey, err := newTestIntermediateCert(root, rootKey, time.Now(), time.Now().Add(5*time.Minute), []string{"test1", "bad"})
+	assert.Nil(t, err)
+	bad, _, _, err = newTestCert(badInter, badInterKey, time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"test1"})
+	assert.Nil(t, err)
+	bad.Chain = []*NebulaCertificate{badInter}
+	v, err = bad.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "intermediate certificate test intermediate: certificate contained a group not present on the signing ca: bad")
+
+	// A chain leading to an untrusted root
+	otherRoot, _, otherRootKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	otherInter, _, otherInterKey, err := newTestIntermediateCert(otherRoot, otherRootKey, time.Now(), time.Now().Add(5*time.Minute), []string{})
+	assert.Nil(t, err)
+	bad, _, _, err = newTestCert(otherInter, otherInterKey, time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	bad.Chain = []*NebulaCertificate{otherInter, otherRoot}
+	v, err = bad.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "could not find ca for the certificate")
+
+	// A chain certificate that is not a CA can not sign
+	notCA, _, _, err := newTestCert(root, rootKey, time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"test1"})
+	assert.Nil(t, err)
+	bad = &NebulaCertificate{Details: c.Details, Chain: []*NebulaCertificate{notCA}}
+	bad.Details.Issuer, err = notCA.Sha256Sum()
+	assert.Nil(t, err)
+	v, err = bad.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "testing: certificate is not a CA")
+}
+
+func TestNebulaCAPool_GetDeprecatedCA(t *testing.T) {
+	root, _, rootKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	inter, _, interKey, err := newTestIntermediateCert(root, rootKey, time.Now(), time.Now().Add(5*time.Minute), []string{})
+	assert.Nil(t, err)
+
+	direct, _, _, err := newTestCert(root, rootKey, time.Now(), time.Now().Add(4*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	chained, _, _, err := newTestCert(inter, interKey, time.Now(), time.Now().Add(4*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	chained.Chain = []*NebulaCertificate{inter}
+
+	caPem, err := root.MarshalToPEM()
+	assert.Nil(t, err)
+	caPool := NewCAPool()
+	_, err = caPool.AddCACertificate(caPem)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "", caPool.GetDeprecatedCA(direct))
+	assert.Equal(t, "", caPool.GetDeprecatedCA(chained))
+
+	// Deprecating the root covers everything below it
+	rootFp, err := root.Sha256Sum()
+	assert.Nil(t, err)
+	caPool.DeprecateCA(rootFp)
+	assert.Equal(t, rootFp, caPool.GetDeprecatedCA(direct))
+	assert.Equal(t, rootFp, caPool.GetDeprecatedCA(chained))
+
+	// Deprecating an intermediate only covers what it issued
+	interFp, err := inter.Sha256Sum()
+	assert.Nil(t, err)
+	caPool = NewCAPool()
+	_, err = caPool.AddCACertificate(caPem)
+	assert.Nil(t, err)
+	caPool.DeprecateCA(interFp)
+	assert.Equal(t, "", caPool.GetDeprecatedCA(direct))
+	assert.Equal(t, interFp, caPool.GetDeprecatedCA(chained))
+
+	// Deprecated certs still verify
+	v, err := chained.Verify(time.Now(), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+}
+
+func TestNebulaCertificate_Verify_ChainInheritsLimits(t *testing.T) {
+	root, _, rootKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"only"})
+	assert.Nil(t, err)
+
+	caPem, err := root.MarshalToPEM()
+	assert.Nil(t, err)
+	caPool := NewCAPool()
+	_, err = caPool.AddCACertificate(caPem)
+	assert.Nil(t, err)
+
+	// An intermediate without a groups limit does not lift the one on the root
+	inter, _, interKey, err := newTestIntermediateCert(root, rootKey, time.Now(), time.Now().Add(5*time.Minute), []string{})
+	assert.Nil(t, err)
+
+	c, _, _, err := newTestCert(inter, interKey, time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"admin"})
+	assert.Nil(t, err)
+	c.Chain = []*NebulaCertificate{inter}
+	v, err := c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate contained a group not present on the signing ca: admin, inherited from test ca")
+
+	c, _, _, err = newTestCert(inter, interKey, time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"only"})
+	assert.Nil(t, err)
+	c.Chain = []*NebulaCertificate{inter}
+	v, err = c.Verify(time.Now(), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+
+	// The same holds for an intermediate further down the chain
+	inter2, _, inter2Key, err := newTestIntermediateCert(inter, interKey, time.Now(), time.Now().Add(4*time.Minute), []string{"only", "admin"})
+	assert.Nil(t, err)
+	c, _, _, err = newTestCert(inter2, inter2Key, time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{"only"})
+	assert.Nil(t, err)
+	c.Chain = []*NebulaCertificate{inter2, inter}
+	v, err = c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "intermediate certificate test intermediate: certificate contained a group not present on the signing ca: admin, inherited from test ca")
+
+	// Signing refuses intermediates that drop a limit
+	assert.EqualError(t, inter.CheckIntermediateConstrains(root), "intermediate certificate has no groups limit but the signing ca is limited to: only")
+	assert.Nil(t, inter2.CheckIntermediateConstrains(inter))
+}
+
+func TestNebulaCertificate_MarshalWithChain(t *testing.T) {
+	root, _, rootKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	inter, _, interKey, err := newTestIntermediateCert(root, rootKey, time.Now(), time.Now().Add(5*time.Minute), []string{})
+	assert.Nil(t, err)
+	c, _, _, err := newTestCert(inter, interKey, time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	c.Chain = []*NebulaCertificate{inter}
+
+	// The chain does not change the fingerprint and is only carried when asked for
+	b, err := c.Marshal()
+	assert.Nil(t, err)
+	nc, err := UnmarshalNebulaCertificate(b)
+	assert.Nil(t, err)
+	assert.Empty(t, nc.Chain)
+
+	b, err = c.MarshalWithChain()
+	assert.Nil(t, err)
+	nc, err = UnmarshalNebulaCertificate(b)
+	assert.Nil(t, err)
+	assert.Len(t, nc.Chain, 1)
+	assert.Equal(t, inter.Signature, nc.Chain[0].Signature)
+	assert.Equal(t, c.Signature, nc.Signature)
+
+	fp1, _ := c.Sha256Sum()
+	fp2, _ := nc.Sha256Sum()
+	assert.Equal(t, fp1, fp2)
+
+	// Pem with the chain following the certificate
+	pb, err := c.MarshalToPEMWithChain()
+	assert.Nil(t, err)
+	nc, err = UnmarshalNebulaCertificateWithChainFromPEM(append(pb, []byte("\n")...))
+	assert.Nil(t, err)
+	assert.Len(t, nc.Chain, 1)
+	assert.Equal(t, inter.Signature, nc.Chain[0].Signature)
+
+	// The plain unmarshal stops after the first certificate
+	_, rest, err := UnmarshalNebulaCertificateFromPEM(pb)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, rest)
+
+	// Only CA certificates may follow
+	cPem, err := c.MarshalToPEM()
+	assert.Nil(t, err)
+	_, err = UnmarshalNebulaCertificateWithChainFromPEM(append(cPem, cPem...))
+	assert.EqualError(t, err, "chain certificate 0: testing: certificate is not a CA")
+
+	_, err = UnmarshalNebulaCertificateWithChainFromPEM(append(cPem, []byte("nope")...))
+	assert.EqualError(t, err, "chain certificate 0: input did not contain a valid PEM encoded block")
+
+	// Chains are bounded
+	interPem, err := inter.MarshalToPEM()
+	assert.Nil(t, err)
+	pb = cPem
+	for i := 0; i <= maxChainLength; i++ {
+		pb = append(pb, interPem...)
+	}
+	_, err = UnmarshalNebulaCertificateWithChainFromPEM(pb)
+	assert.EqualError(t, err, "certificate chain is too long, have more than 8 intermediates")
+
+	for i := 0; i < maxChainLength; i++ {
+		c.Chain = append(c.Chain, inter)
+	}
+	b, err = c.MarshalWithChain()
+	assert.Nil(t, err)
+	_, err = UnmarshalNebulaCertificate(b)
+	assert.EqualError(t, err, "certificate chain is too long, have 9 intermediates")
+}
+
+func TestNebulaCertificate_SignData(t *testing.T) {
+	ca, _, caKey, err := newTestCaCert(time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	other, _, _, err := newTestCaCert(time.Now(), time.Now().Add(time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	sig, err := ca.SignData(caKey, []byte("data"))
+	assert.Nil(t, err)
+	assert.True(t, ca.CheckDataSignature([]byte("data"), sig))
+	assert.False(t, ca.CheckDataSignature([]byte("date"), sig))
+	assert.False(t, other.CheckDataSignature([]byte("data"), sig))
+
+	_, err = ca.SignData([]byte("nope"), []byte("data"))
+	assert.EqualError(t, err, "key was not 64 bytes, is invalid ed25519 private key")
+}
+
 func TestNebulaCertificate_VerifyPrivateKey(t *testing.T) {
 	ca, _, caKey, err := newTestCaCert(time.Time{}, time.Time{}, []*net.IPNet{}, []*net.IPNet{}, []string{})
 	assert.Nil(t, err)
@@ -395,6 +878,69 @@ func TestNebulaCertificate_VerifyPrivateKey(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestNebulaCertificate_P256(t *testing.T) {
+	ca, _, caKey, err := newTestCaCertP256(time.Now().Add(-time.Minute), time.Now().Add(10*time.Minute))
+	assert.Nil(t, err)
+	assert.Len(t, ca.Details.PublicKey, 65)
+	assert.True(t, ca.CheckSignature(ca.Details.PublicKey))
+	assert.Nil(t, ca.VerifyPrivateKey(caKey))
+
+	// Ed25519 keys do not fit a P256 CA
+	_, _, edKey, err := newTestCaCert(time.Time{}, time.Time{}, []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	assert.EqualError(t, ca.VerifyPrivateKey(edKey), "key was not 32 bytes, is invalid P256 private key")
+
+	b, err := ca.Marshal()
+	assert.Nil(t, err)
+	ca2, err := UnmarshalNebulaCertificate(b)
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_P256, ca2.Details.Curve)
+	assert.True(t, ca2.CheckSignature(ca.Details.PublicKey))
+
+	// Changing the curve invalidates the signature
+	ca2.Details.Curve = Curve_CURVE25519
+	assert.False(t, ca2.CheckSignature(ca.Details.PublicKey))
+
+	caPem, err := ca.MarshalToPEM()
+	assert.Nil(t, err)
+	caPool, err := NewCAPoolFromBytes(caPem)
+	assert.Nil(t, err)
+
+	issuer, err := ca.Sha256Sum()
+	assert.Nil(t, err)
+
+	pub, priv := p256Keypair()
+	c := &NebulaCertificate{
+		Details: NebulaCertificateDetails{
+			Name:      "testing",
+			Ips:       []*net.IPNet{{IP: net.ParseIP("10.1.1.1").To4(), Mask: net.IPMask(net.ParseIP("255.255.255.0").To4())}},
+			NotBefore: time.Now().Add(-time.Minute),
+			NotAfter:  time.Now().Add(5 * time.Minute),
+			PublicKey: pub,
+			Issuer:    issuer,
+			Curve:     Curve_P256,
+		},
+	}
+	assert.Nil(t, c.Sign(caKey))
+	assert.Nil(t, c.VerifyPrivateKey(priv))
+
+	v, err := c.Verify(time.Now(), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+
+	_, priv2 := p256Keypair()
+	assert.EqualError(t, c.VerifyPrivateKey(priv2), "public key in cert and private key supplied don't match")
+
+	// A 25519 certificate can not chain to a P256 CA
+	c.Details.Curve = Curve_CURVE25519
+	c.Details.PublicKey, _ = x25519Keypair()
+	assert.EqualError(t, c.Sign(caKey), "key was not 64 bytes, is invalid ed25519 private key")
+	assert.Nil(t, c.Sign(edKey))
+	v, err = c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate curve CURVE25519 does not match the signing ca curve P256")
+}
+
 func TestNewCAPoolFromBytes(t *testing.T) {
 	noNewLines := `
 # Current provisional, Remove once everything moves over to the real root.
@@ -627,6 +1173,82 @@ AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
 	assert.EqualError(t, err, "input did not contain a valid PEM encoded block")
 }
 
+func TestUnmarshalPrivateKey(t *testing.T) {
+	_, x := x25519Keypair()
+	_, p := p256Keypair()
+
+	keyBundle := appendByteSlices(MarshalPrivateKey(Curve_CURVE25519, x), MarshalPrivateKey(Curve_P256, p), MarshalPublicKey(Curve_P256, p[:31]), []byte("nope"))
+
+	k, rest, curve, err := UnmarshalPrivateKey(keyBundle)
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_CURVE25519, curve)
+	assert.Equal(t, x, k)
+
+	k, rest, curve, err = UnmarshalPrivateKey(rest)
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_P256, curve)
+	assert.Equal(t, p, k)
+
+	_, rest, _, err = UnmarshalPrivateKey(rest)
+	assert.EqualError(t, err, "bytes did not contain a proper nebula private key banner")
+
+	_, _, _, err = UnmarshalPrivateKey(rest)
+	assert.EqualError(t, err, "input did not contain a valid PEM encoded block")
+
+	_, _, _, err = UnmarshalPrivateKey(MarshalPrivateKey(Curve_P256, p[:31]))
+	assert.EqualError(t, err, "key was not 32 bytes, is invalid P256 private key")
+}
+
+func TestUnmarshalPublicKey(t *testing.T) {
+	x, _ := x25519Keypair()
+	p, _ := p256Keypair()
+
+	k, rest, curve, err := UnmarshalPublicKey(appendByteSlices(MarshalPublicKey(Curve_CURVE25519, x), MarshalPublicKey(Curve_P256, p)))
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_CURVE25519, curve)
+	assert.Equal(t, x, k)
+
+	k, rest, curve, err = UnmarshalPublicKey(rest)
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_P256, curve)
+	assert.Equal(t, p, k)
+	assert.Empty(t, rest)
+
+	_, _, _, err = UnmarshalPublicKey(MarshalPublicKey(Curve_P256, x))
+	assert.EqualError(t, err, "key was not 65 bytes, is invalid P256 public key")
+
+	_, _, _, err = UnmarshalPublicKey(MarshalPrivateKey(Curve_P256, x))
+	assert.EqualError(t, err, "bytes did not contain a proper nebula public key banner")
+}
+
+func TestUnmarshalSigningPrivateKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	_, _, p256Key, err := newTestCaCertP256(time.Time{}, time.Time{})
+	assert.Nil(t, err)
+
+	k, rest, curve, err := UnmarshalSigningPrivateKey(appendByteSlices(MarshalSigningPrivateKey(Curve_CURVE25519, edKey), MarshalSigningPrivateKey(Curve_P256, p256Key)))
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_CURVE25519, curve)
+	assert.Equal(t, []byte(edKey), k)
+
+	k, _, curve, err = UnmarshalSigningPrivateKey(rest)
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_P256, curve)
+	assert.Equal(t, p256Key, k)
+
+	_, _, _, err = UnmarshalSigningPrivateKey(MarshalSigningPrivateKey(Curve_P256, edKey))
+	assert.EqualError(t, err, "key was not 32 bytes, is invalid ECDSA P256 private key")
+
+	_, _, _, err = UnmarshalSigningPrivateKey(MarshalPrivateKey(Curve_P256, p256Key))
+	assert.EqualError(t, err, "bytes did not contain a proper nebula signing private key banner")
+
+	b, err := EncryptAndMarshalSigningPrivateKey(Curve_P256, p256Key, []byte("pass"), NewArgon2Parameters(64*1024, 4, 1))
+	assert.Nil(t, err)
+	_, _, _, err = UnmarshalSigningPrivateKey(b)
+	assert.Equal(t, ErrPrivateKeyEncrypted, err)
+}
+
 func TestUnmarshalEd25519PublicKey(t *testing.T) {
 	pubKey := []byte(`# A good key
 -----BEGIN NEBULA ED25519 PUBLIC KEY-----
@@ -775,6 +1397,10 @@ func TestNebulaCertificate_Copy(t *testing.T) {
 	cc := c.Copy()
 
 	test.AssertDeepCopyEqual(t, c, cc)
+
+	c.Details.Extensions = map[string]string{"team": "ops"}
+	cc = c.Copy()
+	test.AssertDeepCopyEqual(t, c, cc)
 }
 
 func TestUnmarshalNebulaCertificate(t *testing.T) {
@@ -881,6 +1507,86 @@ func newTestCert(ca *NebulaCertificate, key []byte, before, after time.Time, ips
 	return nc, pub, rawPriv, nil
 }
 
+// newTestIntermediateCert creates a CA certificate signed by parent
+func newTestIntermediateCert(parent *NebulaCertificate, key []byte, before, after time.Time, groups []string) (*NebulaCertificate, []byte, []byte, error) {
+	issuer, err := parent.Sha256Sum()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	nc := &NebulaCertificate{
+		Details: NebulaCertificateDetails{
+			Name:           "test intermediate",
+			Groups:         groups,
+			NotBefore:      time.Unix(before.Unix(), 0),
+			NotAfter:       time.Unix(after.Unix(), 0),
+			PublicKey:      pub,
+			IsCA:           true,
+			Issuer:         issuer,
+			InvertedGroups: make(map[string]struct{}),
+		},
+	}
+
+	for _, g := range groups {
+		nc.Details.InvertedGroups[g] = struct{}{}
+	}
+
+	err = nc.Sign(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return nc, pub, priv, nil
+}
+
+func newTestCaCertP256(before, after time.Time) (*NebulaCertificate, []byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pub := elliptic.Marshal(key.Curve, key.X, key.Y)
+	priv := key.D.FillBytes(make([]byte, 32))
+
+	if before.IsZero() {
+		before = time.Now().Add(time.Second * -60).Round(time.Second)
+	}
+	if after.IsZero() {
+		after = time.Now().Add(time.Second * 60).Round(time.Second)
+	}
+
+	nc := &NebulaCertificate{
+		Details: NebulaCertificateDetails{
+			Name:           "test ca p256",
+			NotBefore:      time.Unix(before.Unix(), 0),
+			NotAfter:       time.Unix(after.Unix(), 0),
+			PublicKey:      pub,
+			IsCA:           true,
+			Curve:          Curve_P256,
+			InvertedGroups: make(map[string]struct{}),
+		},
+	}
+
+	err = nc.Sign(priv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return nc, pub, priv, nil
+}
+
+func p256Keypair() ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return elliptic.Marshal(key.Curve, key.X, key.Y), key.D.FillBytes(make([]byte, 32))
+}
+
 func x25519Keypair() ([]byte, []byte) {
 	privkey := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, privkey); err != nil {
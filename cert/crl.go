@@ -0,0 +1,224 @@
+package cert
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const RevocationListBanner = "NEBULA REVOCATION LIST"
+
+// NebulaRevocationList is a list of certificate fingerprints that have been revoked by the signing CA
+type NebulaRevocationList struct {
+	Details   NebulaRevocationListDetails
+	Signature []byte
+}
+
+type NebulaRevocationListDetails struct {
+	Issuer       string
+	IssuedAt     time.Time
+	Fingerprints []string
+	Curve        Curve
+}
+
+// UnmarshalNebulaRevocationList will unmarshal a protobuf byte representation of a nebula revocation list
+func UnmarshalNebulaRevocationList(b []byte) (*NebulaRevocationList, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("nil byte array")
+	}
+	var rl RawNebulaRevocationList
+	err := proto.Unmarshal(b, &rl)
+	if err != nil {
+		return nil, err
+	}
+
+	if rl.Details == nil {
+		return nil, fmt.Errorf("encoded Details was nil")
+	}
+
+	crl := NebulaRevocationList{
+		Details: NebulaRevocationListDetails{
+			Issuer:       hex.EncodeToString(rl.Details.Issuer),
+			IssuedAt:     time.Unix(rl.Details.IssuedAt, 0),
+			Fingerprints: make([]string, len(rl.Details.Fingerprints)),
+			Curve:        rl.Details.Curve,
+		},
+		Signature: make([]byte, len(rl.Signature)),
+	}
+
+	copy(crl.Signature, rl.Signature)
+	for i, fp := range rl.Details.Fingerprints {
+		crl.Details.Fingerprints[i] = hex.EncodeToString(fp)
+	}
+
+	return &crl, nil
+}
+
+// UnmarshalNebulaRevocationListFromPEM will unmarshal the first pem block in a byte array, returning any non consumed
+// data or an error on failure
+func UnmarshalNebulaRevocationListFromPEM(b []byte) (*NebulaRevocationList, []byte, error) {
+	p, r := pem.Decode(b)
+	if p == nil {
+		return nil, r, fmt.Errorf("input did not contain a valid PEM encoded block")
+	}
+	if p.Type != RevocationListBanner {
+		return nil, r, fmt.Errorf("bytes did not contain a proper nebula revocation list banner")
+	}
+	crl, err := UnmarshalNebulaRevocationList(p.Bytes)
+	return crl, r, err
+}
+
+// Sign signs a nebula revocation list with the provided private key
+func (crl *NebulaRevocationList) Sign(key []byte) error {
+	rd, err := crl.getRawDetails()
+	if err != nil {
+		return err
+	}
+
+	b, err := proto.Marshal(rd)
+	if err != nil {
+		return err
+	}
+
+	sig, err := signBytes(crl.Details.Curve, key, b)
+	if err != nil {
+		return err
+	}
+	crl.Signature = sig
+	return nil
+}
+
+// CheckSignature verifies the signature against the provided public key
+func (crl *NebulaRevocationList) CheckSignature(key []byte) bool {
+	rd, err := crl.getRawDetails()
+	if err != nil {
+		return false
+	}
+
+	b, err := proto.Marshal(rd)
+	if err != nil {
+		return false
+	}
+	return verifySignature(crl.Details.Curve, key, b, crl.Signature)
+}
+
+// IsRevoked returns true if the provided fingerprint is present in the list
+func (crl *NebulaRevocationList) IsRevoked(fingerprint string) bool {
+	for _, fp := range crl.Details.Fingerprints {
+		if fp == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoke adds a fingerprint to the list, the list must be signed again afterwards
+func (crl *NebulaRevocationList) Revoke(fingerprint string) error {
+	if _, err := hex.DecodeString(fingerprint); err != nil {
+		return fmt.Errorf("fingerprint was not valid hex: %s", fingerprint)
+	}
+
+	if crl.IsRevoked(fingerprint) {
+		return nil
+	}
+
+	crl.Details.Fingerprints = append(crl.Details.Fingerprints, fingerprint)
+	sort.Strings(crl.Details.Fingerprints)
+	crl.Signature = nil
+	return nil
+}
+
+// getRawDetails marshals the raw details into protobuf ready struct
+func (crl *NebulaRevocationList) getRawDetails() (*RawNebulaRevocationListDetails, error) {
+	issuer, err := hex.DecodeString(crl.Details.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("issuer was not valid hex: %s", crl.Details.Issuer)
+	}
+
+	rd := &RawNebulaRevocationListDetails{
+		Issuer:       issuer,
+		IssuedAt:     crl.Details.IssuedAt.Unix(),
+		Fingerprints: make([][]byte, len(crl.Details.Fingerprints)),
+		Curve:        crl.Details.Curve,
+	}
+
+	for i, fp := range crl.Details.Fingerprints {
+		rd.Fingerprints[i], err = hex.DecodeString(fp)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint was not valid hex: %s", fp)
+		}
+	}
+
+	return rd, nil
+}
+
+// Marshal will marshal a nebula revocation list into a protobuf byte array
+func (crl *NebulaRevocationList) Marshal() ([]byte, error) {
+	rd, err := crl.getRawDetails()
+	if err != nil {
+		return nil, err
+	}
+
+	rl := RawNebulaRevocationList{
+		Details:   rd,
+		Signature: crl.Signature,
+	}
+
+	return proto.Marshal(&rl)
+}
+
+// MarshalToPEM will marshal a nebula revocation list into a protobuf byte array and pem encode the result
+func (crl *NebulaRevocationList) MarshalToPEM() ([]byte, error) {
+	b, err := crl.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: RevocationListBanner, Bytes: b}), nil
+}
+
+// String will return a pretty printed representation of a nebula revocation list
+func (crl *NebulaRevocationList) String() string {
+	if crl == nil {
+		return "NebulaRevocationList {}\n"
+	}
+
+	s := "NebulaRevocationList {\n"
+	s += "\tDetails {\n"
+	s += fmt.Sprintf("\t\tIssuer: %s\n", crl.Details.Issuer)
+	s += fmt.Sprintf("\t\tIssued at: %v\n", crl.Details.IssuedAt)
+	s += fmt.Sprintf("\t\tCurve: %s\n", crl.Details.Curve)
+
+	if len(crl.Details.Fingerprints) > 0 {
+		s += "\t\tFingerprints: [\n"
+		for _, fp := range crl.Details.Fingerprints {
+			s += fmt.Sprintf("\t\t\t%s\n", fp)
+		}
+		s += "\t\t]\n"
+	} else {
+		s += "\t\tFingerprints: []\n"
+	}
+
+	s += "\t}\n"
+	s += fmt.Sprintf("\tSignature: %x\n", crl.Signature)
+	s += "}"
+
+	return s
+}
+
+func (crl *NebulaRevocationList) MarshalJSON() ([]byte, error) {
+	jc := m{
+		"details": m{
+			"issuer":       crl.Details.Issuer,
+			"issuedAt":     crl.Details.IssuedAt,
+			"fingerprints": crl.Details.Fingerprints,
+			"curve":        crl.Details.Curve.String(),
+		},
+		"signature": fmt.Sprintf("%x", crl.Signature),
+	}
+	return json.Marshal(jc)
+}
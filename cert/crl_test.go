@@ -0,0 +1,188 @@
+package cert
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalingNebulaRevocationList(t *testing.T) {
+	ca, _, caKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	issuer, err := ca.Sha256Sum()
+	assert.Nil(t, err)
+
+	crl := NebulaRevocationList{
+		Details: NebulaRevocationListDetails{
+			Issuer:   issuer,
+			IssuedAt: time.Now().Round(time.Second),
+		},
+	}
+	assert.Nil(t, crl.Revoke("1234"))
+	assert.Nil(t, crl.Revoke("abcd"))
+	assert.Nil(t, crl.Revoke("1234"))
+	assert.EqualError(t, crl.Revoke("nope"), "fingerprint was not valid hex: nope")
+	assert.Equal(t, []string{"1234", "abcd"}, crl.Details.Fingerprints)
+
+	assert.Nil(t, crl.Sign(caKey))
+	assert.True(t, crl.CheckSignature(ca.Details.PublicKey))
+
+	b, err := crl.MarshalToPEM()
+	assert.Nil(t, err)
+
+	crl2, rest, err := UnmarshalNebulaRevocationListFromPEM(append(b, []byte("rest")...))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("rest"), rest)
+	assert.Equal(t, crl.Signature, crl2.Signature)
+	assert.Equal(t, crl.Details.Issuer, crl2.Details.Issuer)
+	assert.Equal(t, crl.Details.IssuedAt, crl2.Details.IssuedAt)
+	assert.Equal(t, crl.Details.Fingerprints, crl2.Details.Fingerprints)
+	assert.True(t, crl2.CheckSignature(ca.Details.PublicKey))
+	assert.True(t, crl2.IsRevoked("abcd"))
+	assert.False(t, crl2.IsRevoked("ef01"))
+
+	// A tampered list should not pass
+	crl2.Details.Fingerprints = crl2.Details.Fingerprints[1:]
+	assert.False(t, crl2.CheckSignature(ca.Details.PublicKey))
+
+	// Wrong banner
+	caPem, err := ca.MarshalToPEM()
+	assert.Nil(t, err)
+	_, _, err = UnmarshalNebulaRevocationListFromPEM(caPem)
+	assert.EqualError(t, err, "bytes did not contain a proper nebula revocation list banner")
+}
+
+func TestNebulaCAPool_AddRevocationList(t *testing.T) {
+	ca, _, caKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	ca2, _, ca2Key, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	c, _, _, err := newTestCert(ca, caKey, time.Now(), time.Now().Add(5*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	caPem, err := ca.MarshalToPEM()
+	assert.Nil(t, err)
+
+	caPool, err := NewCAPoolFromBytes(caPem)
+	assert.Nil(t, err)
+
+	v, err := c.Verify(time.Now(), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+
+	issuer, err := ca.Sha256Sum()
+	assert.Nil(t, err)
+	fp, err := c.Sha256Sum()
+	assert.Nil(t, err)
+
+	// A list signed by an untrusted CA is refused
+	crl := &NebulaRevocationList{Details: NebulaRevocationListDetails{Issuer: issuer, IssuedAt: time.Now()}}
+	assert.Nil(t, crl.Revoke(fp))
+	assert.Nil(t, crl.Sign(ca2Key))
+	ok, err := caPool.AddRevocationList(crl)
+	assert.False(t, ok)
+	assert.EqualError(t, err, "revocation list signature did not match")
+
+	issuer2, err := ca2.Sha256Sum()
+	assert.Nil(t, err)
+	crl.Details.Issuer = issuer2
+	ok, err = caPool.AddRevocationList(crl)
+	assert.False(t, ok)
+	assert.EqualError(t, err, "could not find ca for the revocation list")
+
+	// A properly signed list is installed and the cert is now revoked
+	crl.Details.Issuer = issuer
+	assert.Nil(t, crl.Sign(caKey))
+	ok, err = caPool.AddRevocationList(crl)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.True(t, caPool.IsRevoked(c))
+	assert.Len(t, caPool.GetRevocationLists(), 1)
+
+	v, err = c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate has been revoked")
+
+	// An older list does not replace a newer one
+	older := &NebulaRevocationList{Details: NebulaRevocationListDetails{Issuer: issuer, IssuedAt: time.Now().Add(-time.Hour)}}
+	assert.Nil(t, older.Sign(caKey))
+	ok, err = caPool.AddRevocationList(older)
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	assert.True(t, caPool.IsRevoked(c))
+
+	// A newer list does
+	newer := &NebulaRevocationList{Details: NebulaRevocationListDetails{Issuer: issuer, IssuedAt: time.Now().Add(time.Hour)}}
+	assert.Nil(t, newer.Sign(caKey))
+	b, err := newer.MarshalToPEM()
+	assert.Nil(t, err)
+	assert.Nil(t, caPool.AddRevocationListsFromPEM(b))
+	assert.False(t, caPool.IsRevoked(c))
+}
+
+func TestNebulaCAPool_IsRevoked_P256HighS(t *testing.T) {
+	ca, _, caKey, err := newTestCaCertP256(time.Now().Add(-time.Minute), time.Now().Add(10*time.Minute))
+	assert.Nil(t, err)
+	caPem, err := ca.MarshalToPEM()
+	assert.Nil(t, err)
+	caPool, err := NewCAPoolFromBytes(caPem)
+	assert.Nil(t, err)
+	issuer, err := ca.Sha256Sum()
+	assert.Nil(t, err)
+
+	pub, _ := p256Keypair()
+	c := &NebulaCertificate{
+		Details: NebulaCertificateDetails{
+			Name:      "testing",
+			Ips:       []*net.IPNet{{IP: net.IP{10, 1, 1, 1}, Mask: net.IPMask{255, 255, 255, 0}}},
+			NotBefore: time.Now().Add(-time.Minute),
+			NotAfter:  time.Now().Add(5 * time.Minute),
+			PublicKey: pub,
+			Issuer:    issuer,
+			Curve:     Curve_P256,
+		},
+	}
+	assert.Nil(t, c.Sign(caKey))
+
+	// Signatures are always produced in the low s form
+	n := elliptic.P256().Params().N
+	var es ecdsaSignature
+	_, err = asn1.Unmarshal(c.Signature, &es)
+	assert.Nil(t, err)
+	assert.True(t, es.S.Cmp(new(big.Int).Rsh(n, 1)) <= 0)
+
+	fp, err := c.Sha256Sum()
+	assert.Nil(t, err)
+	crl := &NebulaRevocationList{Details: NebulaRevocationListDetails{Issuer: issuer, IssuedAt: time.Now(), Curve: Curve_P256}}
+	assert.Nil(t, crl.Revoke(fp))
+	assert.Nil(t, crl.Sign(caKey))
+	ok, err := caPool.AddRevocationList(crl)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	v, err := c.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate has been revoked")
+
+	// Rewriting the signature as (r, n-s) changes the fingerprint but must not verify
+	flipped := c.Copy()
+	flipped.Signature, err = asn1.Marshal(ecdsaSignature{R: es.R, S: new(big.Int).Sub(n, es.S)})
+	assert.Nil(t, err)
+	flippedFp, err := flipped.Sha256Sum()
+	assert.Nil(t, err)
+	assert.NotEqual(t, fp, flippedFp)
+	assert.False(t, flipped.CheckSignature(ca.Details.PublicKey))
+
+	v, err = flipped.Verify(time.Now(), caPool)
+	assert.False(t, v)
+	assert.EqualError(t, err, "certificate signature did not match")
+	assert.True(t, caPool.IsRevoked(c))
+}
@@ -0,0 +1,227 @@
+package cert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ed25519"
+	"google.golang.org/protobuf/proto"
+)
+
+const encryptionAlgorithmAES256GCM = "AES-256-GCM"
+
+// Argon2Parameters are the KDF parameters used to derive a key from a passphrase
+type Argon2Parameters struct {
+	version     rune
+	Memory      uint32 // KiB
+	Parallelism uint8
+	Iterations  uint32
+	salt        []byte
+}
+
+// NewArgon2Parameters returns argon2id parameters with the provided costs, a random salt is generated on use
+func NewArgon2Parameters(memory uint32, parallelism uint8, iterations uint32) *Argon2Parameters {
+	return &Argon2Parameters{
+		version:     argon2.Version,
+		Memory:      memory,
+		Parallelism: parallelism,
+		Iterations:  iterations,
+	}
+}
+
+// EncryptAndMarshalEd25519PrivateKey encrypts an ed25519 private key with a key derived from the passphrase and PEM
+// encodes the result with the Ed25519 encrypted private key banner
+func EncryptAndMarshalEd25519PrivateKey(b ed25519.PrivateKey, passphrase []byte, kdfParams *Argon2Parameters) ([]byte, error) {
+	return EncryptAndMarshalSigningPrivateKey(Curve_CURVE25519, b, passphrase, kdfParams)
+}
+
+// EncryptAndMarshalSigningPrivateKey encrypts an Ed25519 or ECDSA P256 CA private key with a key derived from the
+// passphrase and PEM encodes the result with the encrypted private key banner of the curve
+func EncryptAndMarshalSigningPrivateKey(curve Curve, b []byte, passphrase []byte, kdfParams *Argon2Parameters) ([]byte, error) {
+	var banner string
+	switch curve {
+	case Curve_CURVE25519:
+		banner = EncryptedEd25519PrivateKeyBanner
+	case Curve_P256:
+		banner = EncryptedECDSAP256PrivateKeyBanner
+	default:
+		return nil, fmt.Errorf("invalid curve: %s", curve)
+	}
+
+	b, err := EncryptAndMarshalData(b, passphrase, kdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: banner, Bytes: b}), nil
+}
+
+// DecryptAndUnmarshalEd25519PrivateKey will try to pem decode and decrypt an Ed25519 private key, returning any other
+// bytes b or an error on failure
+func DecryptAndUnmarshalEd25519PrivateKey(passphrase, b []byte) (ed25519.PrivateKey, []byte, error) {
+	k, _ := pem.Decode(b)
+	if k != nil && k.Type != EncryptedEd25519PrivateKeyBanner {
+		return nil, b, fmt.Errorf("bytes did not contain a proper nebula encrypted Ed25519 private key banner")
+	}
+
+	_, key, r, err := DecryptAndUnmarshalSigningPrivateKey(passphrase, b)
+	return key, r, err
+}
+
+// DecryptAndUnmarshalSigningPrivateKey will try to pem decode and decrypt an Ed25519 or ECDSA P256 CA private key,
+// returning the curve of the key, the key, any other bytes b or an error on failure
+func DecryptAndUnmarshalSigningPrivateKey(passphrase, b []byte) (Curve, []byte, []byte, error) {
+	k, r := pem.Decode(b)
+	if k == nil {
+		return 0, nil, r, fmt.Errorf("input did not contain a valid PEM encoded block")
+	}
+
+	var curve Curve
+	switch k.Type {
+	case EncryptedEd25519PrivateKeyBanner:
+		curve = Curve_CURVE25519
+	case EncryptedECDSAP256PrivateKeyBanner:
+		curve = Curve_P256
+	default:
+		return 0, nil, r, fmt.Errorf("bytes did not contain a proper nebula encrypted signing private key banner")
+	}
+
+	key, err := UnmarshalAndDecryptData(passphrase, k.Bytes)
+	if err != nil {
+		return 0, nil, r, err
+	}
+
+	switch curve {
+	case Curve_CURVE25519:
+		if len(key) != ed25519.PrivateKeySize {
+			return 0, nil, r, fmt.Errorf("key was not 64 bytes, is invalid ed25519 private key")
+		}
+	case Curve_P256:
+		if len(key) != p256PrivateKeyLen {
+			return 0, nil, r, fmt.Errorf("key was not 32 bytes, is invalid ECDSA P256 private key")
+		}
+	}
+
+	return curve, key, r, nil
+}
+
+// EncryptAndMarshalData encrypts b with a key derived from the passphrase and marshals the ciphertext along with the
+// parameters needed to decrypt it
+func EncryptAndMarshalData(b []byte, passphrase []byte, kdfParams *Argon2Parameters) ([]byte, error) {
+	ciphertext, err := aes256Encrypt(passphrase, kdfParams, b)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(&RawNebulaEncryptedData{
+		EncryptionMetadata: &RawNebulaEncryptionMetadata{
+			EncryptionAlgorithm: encryptionAlgorithmAES256GCM,
+			Argon2Parameters: &RawNebulaArgon2Parameters{
+				Version:     kdfParams.version,
+				Memory:      kdfParams.Memory,
+				Parallelism: uint32(kdfParams.Parallelism),
+				Iterations:  kdfParams.Iterations,
+				Salt:        kdfParams.salt,
+			},
+		},
+		Ciphertext: ciphertext,
+	})
+}
+
+// UnmarshalAndDecryptData reverses EncryptAndMarshalData, ErrInvalidPassphrase is returned if the passphrase is wrong
+func UnmarshalAndDecryptData(passphrase, b []byte) ([]byte, error) {
+	var rd RawNebulaEncryptedData
+	if err := proto.Unmarshal(b, &rd); err != nil {
+		return nil, err
+	}
+
+	if rd.EncryptionMetadata == nil || rd.EncryptionMetadata.Argon2Parameters == nil {
+		return nil, fmt.Errorf("encoded EncryptionMetadata was nil")
+	}
+
+	if rd.EncryptionMetadata.EncryptionAlgorithm != encryptionAlgorithmAES256GCM {
+		return nil, fmt.Errorf("unsupported encryption algorithm: %s", rd.EncryptionMetadata.EncryptionAlgorithm)
+	}
+
+	rp := rd.EncryptionMetadata.Argon2Parameters
+	if rp.Parallelism > 255 {
+		return nil, fmt.Errorf("argon2 parallelism was greater than 255: %v", rp.Parallelism)
+	}
+
+	params := &Argon2Parameters{
+		version:     rp.Version,
+		Memory:      rp.Memory,
+		Parallelism: uint8(rp.Parallelism),
+		Iterations:  rp.Iterations,
+		salt:        rp.Salt,
+	}
+
+	return aes256Decrypt(passphrase, params, rd.Ciphertext)
+}
+
+// aes256Encrypt seals data with AES-256-GCM, a fresh salt is written to params
+func aes256Encrypt(passphrase []byte, params *Argon2Parameters, data []byte) ([]byte, error) {
+	params.salt = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, params.salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %s", err)
+	}
+
+	gcm, err := aes256GCM(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %s", err)
+	}
+
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func aes256Decrypt(passphrase []byte, params *Argon2Parameters, data []byte) ([]byte, error) {
+	gcm, err := aes256GCM(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext was too short")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+
+	return plaintext, nil
+}
+
+func aes256GCM(passphrase []byte, params *Argon2Parameters) (cipher.AEAD, error) {
+	if params.version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version: %v", params.version)
+	}
+
+	if len(params.salt) == 0 {
+		return nil, fmt.Errorf("argon2 salt was empty")
+	}
+
+	if params.Parallelism == 0 || params.Iterations == 0 {
+		return nil, fmt.Errorf("argon2 parallelism and iterations must be greater than 0")
+	}
+
+	key := argon2.IDKey(passphrase, params.salt, params.Iterations, params.Memory, params.Parallelism, 32)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
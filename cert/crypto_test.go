@@ -0,0 +1,109 @@
+package cert
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestEncryptAndMarshalEd25519PrivateKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	passphrase := []byte("DO NOT USE THIS KEY")
+	params := NewArgon2Parameters(64*1024, 4, 3)
+
+	b, err := EncryptAndMarshalEd25519PrivateKey(priv, passphrase, params)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), string(MarshalEd25519PrivateKey(priv)))
+
+	// The plain unmarshal should point callers at the encrypted path
+	_, rest, err := UnmarshalEd25519PrivateKey(append(b, []byte("rest")...))
+	assert.Equal(t, ErrPrivateKeyEncrypted, err)
+	assert.Equal(t, []byte("rest"), rest)
+
+	k, rest, err := DecryptAndUnmarshalEd25519PrivateKey(passphrase, append(b, []byte("rest")...))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("rest"), rest)
+	assert.Equal(t, priv, k)
+
+	// Wrong passphrase
+	_, _, err = DecryptAndUnmarshalEd25519PrivateKey([]byte("nope"), b)
+	assert.Equal(t, ErrInvalidPassphrase, err)
+
+	// Each encryption uses a fresh salt and nonce
+	b2, err := EncryptAndMarshalEd25519PrivateKey(priv, passphrase, params)
+	assert.Nil(t, err)
+	assert.NotEqual(t, b, b2)
+
+	// Wrong banner
+	_, _, err = DecryptAndUnmarshalEd25519PrivateKey(passphrase, MarshalEd25519PrivateKey(priv))
+	assert.EqualError(t, err, "bytes did not contain a proper nebula encrypted Ed25519 private key banner")
+
+	// Not a pem
+	_, _, err = DecryptAndUnmarshalEd25519PrivateKey(passphrase, []byte("nope"))
+	assert.EqualError(t, err, "input did not contain a valid PEM encoded block")
+
+	// Tampered parameters change the derived key
+	p, _ := pem.Decode(b)
+	var rd RawNebulaEncryptedData
+	assert.Nil(t, proto.Unmarshal(p.Bytes, &rd))
+	rd.EncryptionMetadata.Argon2Parameters.Iterations = 1
+	tb, err := proto.Marshal(&rd)
+	assert.Nil(t, err)
+	_, _, err = DecryptAndUnmarshalEd25519PrivateKey(passphrase, pem.EncodeToMemory(&pem.Block{Type: EncryptedEd25519PrivateKeyBanner, Bytes: tb}))
+	assert.Equal(t, ErrInvalidPassphrase, err)
+
+	// Unknown algorithm
+	rd.EncryptionMetadata.EncryptionAlgorithm = "ROT13"
+	tb, err = proto.Marshal(&rd)
+	assert.Nil(t, err)
+	_, _, err = DecryptAndUnmarshalEd25519PrivateKey(passphrase, pem.EncodeToMemory(&pem.Block{Type: EncryptedEd25519PrivateKeyBanner, Bytes: tb}))
+	assert.EqualError(t, err, "unsupported encryption algorithm: ROT13")
+}
+
+func TestEncryptAndMarshalSigningPrivateKey_P256(t *testing.T) {
+	_, _, priv, err := newTestCaCertP256(time.Time{}, time.Time{})
+	assert.Nil(t, err)
+
+	passphrase := []byte("DO NOT USE THIS KEY")
+	b, err := EncryptAndMarshalSigningPrivateKey(Curve_P256, priv, passphrase, NewArgon2Parameters(64*1024, 4, 1))
+	assert.Nil(t, err)
+
+	curve, k, rest, err := DecryptAndUnmarshalSigningPrivateKey(passphrase, append(b, []byte("rest")...))
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_P256, curve)
+	assert.Equal(t, priv, k)
+	assert.Equal(t, []byte("rest"), rest)
+
+	// The Ed25519 helper only accepts Ed25519 keys
+	_, _, err = DecryptAndUnmarshalEd25519PrivateKey(passphrase, b)
+	assert.EqualError(t, err, "bytes did not contain a proper nebula encrypted Ed25519 private key banner")
+
+	_, err = EncryptAndMarshalSigningPrivateKey(Curve(5), priv, passphrase, NewArgon2Parameters(64*1024, 4, 1))
+	assert.EqualError(t, err, "invalid curve: 5")
+}
+
+func TestEncryptAndMarshalData(t *testing.T) {
+	passphrase := []byte("DO NOT USE THIS PASSPHRASE")
+	params := NewArgon2Parameters(64*1024, 4, 3)
+
+	b, err := EncryptAndMarshalData([]byte("some secret data"), passphrase, params)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(b), "some secret data")
+
+	d, err := UnmarshalAndDecryptData(passphrase, b)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("some secret data"), d)
+
+	_, err = UnmarshalAndDecryptData([]byte("nope"), b)
+	assert.Equal(t, ErrInvalidPassphrase, err)
+
+	_, err = UnmarshalAndDecryptData(passphrase, []byte("garbage"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,220 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+	"google.golang.org/protobuf/proto"
+)
+
+const CertificateRequestBanner = "NEBULA CERTIFICATE REQUEST"
+
+// NebulaCertificateRequest asks a CA to sign the contained details. Host keys can not sign, so possession of the
+// private key is proven with an HMAC keyed by the X25519 or P256 ECDH shared secret between the requested key and the
+// CA key. Only the CA named in Details.Issuer can check the proof.
+type NebulaCertificateRequest struct {
+	Details NebulaCertificateDetails
+	Proof   []byte
+}
+
+// curve25519P is the field prime 2^255 - 19
+var curve25519P, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// UnmarshalNebulaCertificateRequest will unmarshal a protobuf byte representation of a nebula certificate request
+func UnmarshalNebulaCertificateRequest(b []byte) (*NebulaCertificateRequest, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("nil byte array")
+	}
+	var rr RawNebulaCertificateRequest
+	err := proto.Unmarshal(b, &rr)
+	if err != nil {
+		return nil, err
+	}
+
+	if rr.Details == nil {
+		return nil, fmt.Errorf("encoded Details was nil")
+	}
+
+	details, err := unmarshalRawDetails(rr.Details)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NebulaCertificateRequest{
+		Details: details,
+		Proof:   make([]byte, len(rr.Proof)),
+	}
+
+	copy(r.Proof, rr.Proof)
+	return &r, nil
+}
+
+// UnmarshalNebulaCertificateRequestFromPEM will unmarshal the first pem block in a byte array, returning any non
+// consumed data or an error on failure
+func UnmarshalNebulaCertificateRequestFromPEM(b []byte) (*NebulaCertificateRequest, []byte, error) {
+	p, r := pem.Decode(b)
+	if p == nil {
+		return nil, r, fmt.Errorf("input did not contain a valid PEM encoded block")
+	}
+	if p.Type != CertificateRequestBanner {
+		return nil, r, fmt.Errorf("bytes did not contain a proper nebula certificate request banner")
+	}
+	csr, err := UnmarshalNebulaCertificateRequest(p.Bytes)
+	return csr, r, err
+}
+
+// Prove addresses the request to caCert and computes the proof of possession for the private key, which must be on
+// the same curve as caCert
+func (r *NebulaCertificateRequest) Prove(priv []byte, caCert *NebulaCertificate) error {
+	curve := caCert.Details.Curve
+	pub, err := dhPublicKey(curve, priv)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(pub, r.Details.PublicKey) {
+		return fmt.Errorf("private key does not match the requested public key")
+	}
+
+	r.Details.Curve = curve
+	r.Details.Issuer, err = caCert.Sha256Sum()
+	if err != nil {
+		return err
+	}
+
+	var shared []byte
+	switch curve {
+	case Curve_CURVE25519:
+		var caPub []byte
+		caPub, err = ed25519PublicToX25519(caCert.Details.PublicKey)
+		if err != nil {
+			return err
+		}
+		shared, err = curve25519.X25519(priv, caPub)
+	case Curve_P256:
+		shared, err = p256ECDH(priv, caCert.Details.PublicKey)
+	default:
+		err = fmt.Errorf("invalid curve: %s", curve)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.Proof, err = r.proof(shared)
+	return err
+}
+
+// CheckProof returns true if the proof was made with the private key of the requested public key for the CA key
+func (r *NebulaCertificateRequest) CheckProof(caKey []byte) bool {
+	var shared []byte
+	var err error
+	switch r.Details.Curve {
+	case Curve_CURVE25519:
+		if len(caKey) != ed25519.PrivateKeySize {
+			return false
+		}
+		shared, err = curve25519.X25519(ed25519PrivateToX25519(caKey), r.Details.PublicKey)
+	case Curve_P256:
+		shared, err = p256ECDH(caKey, r.Details.PublicKey)
+	default:
+		return false
+	}
+	if err != nil {
+		return false
+	}
+
+	expected, err := r.proof(shared)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(expected, r.Proof)
+}
+
+func (r *NebulaCertificateRequest) proof(shared []byte) ([]byte, error) {
+	b, err := proto.Marshal(r.getRawDetails())
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, shared)
+	mac.Write(b)
+	return mac.Sum(nil), nil
+}
+
+// Marshal will marshal a nebula certificate request into a protobuf byte array
+func (r *NebulaCertificateRequest) Marshal() ([]byte, error) {
+	rr := RawNebulaCertificateRequest{
+		Details: r.getRawDetails(),
+		Proof:   r.Proof,
+	}
+
+	return proto.Marshal(&rr)
+}
+
+// MarshalToPEM will marshal a nebula certificate request into a protobuf byte array and pem encode the result
+func (r *NebulaCertificateRequest) MarshalToPEM() ([]byte, error) {
+	b, err := r.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: CertificateRequestBanner, Bytes: b}), nil
+}
+
+func (r *NebulaCertificateRequest) getRawDetails() *RawNebulaCertificateDetails {
+	return (&NebulaCertificate{Details: r.Details}).getRawDetails()
+}
+
+// ed25519PrivateToX25519 derives the X25519 scalar of an ed25519 private key, as defined in RFC 8032
+func ed25519PrivateToX25519(priv ed25519.PrivateKey) []byte {
+	h := sha512.Sum512(priv.Seed())
+	s := h[:32]
+	s[0] &= 248
+	s[31] &= 127
+	s[31] |= 64
+	return s
+}
+
+// ed25519PublicToX25519 maps an ed25519 public key to the montgomery form, u = (1 + y) / (1 - y)
+func ed25519PublicToX25519(pub []byte) ([]byte, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("ca public key was %v bytes, expected %v", len(pub), ed25519.PublicKeySize)
+	}
+
+	// The key is little endian, the top bit holds the sign of x which is not needed
+	be := make([]byte, len(pub))
+	for i := range pub {
+		be[i] = pub[len(pub)-1-i]
+	}
+	be[0] &= 0x7f
+
+	y := new(big.Int).SetBytes(be)
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, fmt.Errorf("ca public key is not a valid ed25519 key")
+	}
+
+	num := new(big.Int).Add(big.NewInt(1), y)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("ca public key is not a valid ed25519 key")
+	}
+
+	u := num.Mul(num, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+
+	out := make([]byte, 32)
+	ub := u.Bytes()
+	for i := range ub {
+		out[i] = ub[len(ub)-1-i]
+	}
+
+	return out, nil
+}
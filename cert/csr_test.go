@@ -0,0 +1,136 @@
+package cert
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_ed25519ToX25519(t *testing.T) {
+	// Both sides of the proof must arrive at the same shared secret
+	for i := 0; i < 16; i++ {
+		edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+		assert.Nil(t, err)
+		pub, priv := x25519Keypair()
+
+		montPub, err := ed25519PublicToX25519(edPub)
+		assert.Nil(t, err)
+
+		// The converted private key must produce the converted public key
+		derived, err := curve25519.X25519(ed25519PrivateToX25519(edPriv), curve25519.Basepoint)
+		assert.Nil(t, err)
+		assert.Equal(t, montPub, derived)
+
+		nodeShared, err := curve25519.X25519(priv, montPub)
+		assert.Nil(t, err)
+		caShared, err := curve25519.X25519(ed25519PrivateToX25519(edPriv), pub)
+		assert.Nil(t, err)
+		assert.Equal(t, nodeShared, caShared)
+	}
+
+	_, err := ed25519PublicToX25519([]byte("short"))
+	assert.EqualError(t, err, "ca public key was 5 bytes, expected 32")
+}
+
+func TestNebulaCertificateRequest(t *testing.T) {
+	ca, _, caKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+	otherCa, _, otherCaKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	pub, priv := x25519Keypair()
+	_, wrongPriv := x25519Keypair()
+
+	r := NebulaCertificateRequest{
+		Details: NebulaCertificateDetails{
+			Name:   "testing",
+			Ips:    []*net.IPNet{{IP: net.ParseIP("10.1.1.1").To4(), Mask: net.IPMask(net.ParseIP("255.255.255.0").To4())}},
+			Groups: []string{"test-group1", "test-group2"},
+			Subnets: []*net.IPNet{
+				{IP: net.ParseIP("9.1.1.0").To4(), Mask: net.IPMask(net.ParseIP("255.255.255.0").To4())},
+			},
+			PublicKey: pub,
+		},
+	}
+
+	assert.EqualError(t, r.Prove(wrongPriv, ca), "private key does not match the requested public key")
+	assert.Nil(t, r.Prove(priv, ca))
+
+	fp, err := ca.Sha256Sum()
+	assert.Nil(t, err)
+	assert.Equal(t, fp, r.Details.Issuer)
+	assert.True(t, r.CheckProof(caKey))
+	assert.False(t, r.CheckProof(otherCaKey))
+
+	b, err := r.MarshalToPEM()
+	assert.Nil(t, err)
+
+	r2, rest, err := UnmarshalNebulaCertificateRequestFromPEM(append(b, []byte("rest")...))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("rest"), rest)
+	assert.Equal(t, r.Details.Name, r2.Details.Name)
+	assert.Equal(t, r.Details.Issuer, r2.Details.Issuer)
+	assert.Equal(t, r.Details.PublicKey, r2.Details.PublicKey)
+	assert.Equal(t, r.Details.Groups, r2.Details.Groups)
+	assert.Equal(t, r.Details.Ips[0].String(), r2.Details.Ips[0].String())
+	assert.Equal(t, r.Details.Subnets[0].String(), r2.Details.Subnets[0].String())
+	assert.Equal(t, r.Proof, r2.Proof)
+	assert.True(t, r2.CheckProof(caKey))
+
+	// A tampered request should not pass
+	r2.Details.Groups = append(r2.Details.Groups, "admin")
+	assert.False(t, r2.CheckProof(caKey))
+
+	// Proving for another CA addresses the request there
+	assert.Nil(t, r.Prove(priv, otherCa))
+	assert.False(t, r.CheckProof(caKey))
+	assert.True(t, r.CheckProof(otherCaKey))
+
+	// Wrong banner
+	caPem, err := ca.MarshalToPEM()
+	assert.Nil(t, err)
+	_, _, err = UnmarshalNebulaCertificateRequestFromPEM(caPem)
+	assert.EqualError(t, err, "bytes did not contain a proper nebula certificate request banner")
+
+	_, err = UnmarshalNebulaCertificateRequest(nil)
+	assert.EqualError(t, err, "nil byte array")
+}
+
+func TestNebulaCertificateRequest_P256(t *testing.T) {
+	ca, _, caKey, err := newTestCaCertP256(time.Now(), time.Now().Add(10*time.Minute))
+	assert.Nil(t, err)
+	edCa, _, edCaKey, err := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	assert.Nil(t, err)
+
+	pub, priv := p256Keypair()
+	r := NebulaCertificateRequest{
+		Details: NebulaCertificateDetails{
+			Name:      "testing",
+			Ips:       []*net.IPNet{{IP: net.ParseIP("10.1.1.1").To4(), Mask: net.IPMask(net.ParseIP("255.255.255.0").To4())}},
+			PublicKey: pub,
+		},
+	}
+
+	// The key must be on the curve of the CA
+	assert.EqualError(t, r.Prove(priv, edCa), "private key does not match the requested public key")
+
+	assert.Nil(t, r.Prove(priv, ca))
+	assert.Equal(t, Curve_P256, r.Details.Curve)
+	assert.True(t, r.CheckProof(caKey))
+	assert.False(t, r.CheckProof(edCaKey))
+
+	_, otherKey := p256Keypair()
+	assert.False(t, r.CheckProof(otherKey))
+
+	b, err := r.MarshalToPEM()
+	assert.Nil(t, err)
+	r2, _, err := UnmarshalNebulaCertificateRequestFromPEM(b)
+	assert.Nil(t, err)
+	assert.Equal(t, Curve_P256, r2.Details.Curve)
+	assert.True(t, r2.CheckProof(caKey))
+}
@@ -0,0 +1,182 @@
+package cert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+const p256PrivateKeyLen = 32
+
+// signBytes signs b with key using the signature scheme of the curve, Ed25519 or ECDSA over a sha-256 of b
+func signBytes(curve Curve, key, b []byte) ([]byte, error) {
+	switch curve {
+	case Curve_CURVE25519:
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("key was not 64 bytes, is invalid ed25519 private key")
+		}
+		return ed25519.Sign(key, b), nil
+
+	case Curve_P256:
+		k, err := p256PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.Sum256(b)
+		r, s, err := ecdsa.Sign(rand.Reader, k, h[:])
+		if err != nil {
+			return nil, err
+		}
+
+		// (r, n-s) is an equally valid signature, always produce the low s form so verifySignature can demand it
+		n := k.Curve.Params().N
+		if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+			s.Sub(n, s)
+		}
+		return asn1.Marshal(ecdsaSignature{R: r, S: s})
+
+	default:
+		return nil, fmt.Errorf("invalid curve: %s", curve)
+	}
+}
+
+// verifySignature checks sig over b against key using the signature scheme of the curve
+func verifySignature(curve Curve, key, b, sig []byte) bool {
+	switch curve {
+	case Curve_CURVE25519:
+		if len(key) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(key, b, sig)
+
+	case Curve_P256:
+		k, err := p256PublicKey(key)
+		if err != nil {
+			return false
+		}
+		r, s, ok := parseLowSSignature(k, sig)
+		if !ok {
+			return false
+		}
+		h := sha256.Sum256(b)
+		return ecdsa.Verify(k, h[:], r, s)
+
+	default:
+		return false
+	}
+}
+
+// ecdsaSignature is the ASN.1 form of an ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// parseLowSSignature decodes an ECDSA signature, refusing any that is not in its single canonical form. A signature is
+// part of the bytes a certificate fingerprint is taken over, if (r, n-s) or a different encoding of (r, s) were accepted
+// the holder of a certificate could mint a new fingerprint for it and slip past revocation and the blocklist.
+func parseLowSSignature(k *ecdsa.PublicKey, sig []byte) (*big.Int, *big.Int, bool) {
+	var es ecdsaSignature
+	rest, err := asn1.Unmarshal(sig, &es)
+	if err != nil || len(rest) != 0 || es.R == nil || es.S == nil {
+		return nil, nil, false
+	}
+
+	if es.S.Cmp(new(big.Int).Rsh(k.Curve.Params().N, 1)) > 0 {
+		return nil, nil, false
+	}
+
+	// Re-encoding must reproduce the exact bytes
+	b, err := asn1.Marshal(es)
+	if err != nil || string(b) != string(sig) {
+		return nil, nil, false
+	}
+
+	return es.R, es.S, true
+}
+
+// signingPublicKey derives the public key of a CA signing key
+func signingPublicKey(curve Curve, key []byte) ([]byte, error) {
+	switch curve {
+	case Curve_CURVE25519:
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("key was not 64 bytes, is invalid ed25519 private key")
+		}
+		return ed25519.PrivateKey(key).Public().(ed25519.PublicKey), nil
+
+	case Curve_P256:
+		return P256PublicKey(key)
+
+	default:
+		return nil, fmt.Errorf("invalid curve: %s", curve)
+	}
+}
+
+// dhPublicKey derives the public key of a host key
+func dhPublicKey(curve Curve, key []byte) ([]byte, error) {
+	switch curve {
+	case Curve_CURVE25519:
+		return curve25519.X25519(key, curve25519.Basepoint)
+
+	case Curve_P256:
+		return P256PublicKey(key)
+
+	default:
+		return nil, fmt.Errorf("invalid curve: %s", curve)
+	}
+}
+
+// P256PublicKey returns the uncompressed public key for a raw P256 private key
+func P256PublicKey(key []byte) ([]byte, error) {
+	k, err := p256PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return elliptic.Marshal(k.Curve, k.X, k.Y), nil
+}
+
+// p256ECDH returns the x coordinate of the shared point of a raw P256 private key and an uncompressed public key
+func p256ECDH(priv, pub []byte) ([]byte, error) {
+	if _, err := p256PrivateKey(priv); err != nil {
+		return nil, err
+	}
+
+	p, err := p256PublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	x, _ := p.Curve.ScalarMult(p.X, p.Y, priv)
+	return x.FillBytes(make([]byte, p256PrivateKeyLen)), nil
+}
+
+func p256PrivateKey(key []byte) (*ecdsa.PrivateKey, error) {
+	if len(key) != p256PrivateKeyLen {
+		return nil, fmt.Errorf("key was not 32 bytes, is invalid P256 private key")
+	}
+
+	c := elliptic.P256()
+	d := new(big.Int).SetBytes(key)
+	if d.Sign() == 0 || d.Cmp(c.Params().N) >= 0 {
+		return nil, fmt.Errorf("key is out of range, is invalid P256 private key")
+	}
+
+	x, y := c.ScalarBaseMult(key)
+	return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: c, X: x, Y: y}, D: d}, nil
+}
+
+func p256PublicKey(key []byte) (*ecdsa.PublicKey, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), key)
+	if x == nil {
+		return nil, fmt.Errorf("key was not an uncompressed point, is invalid P256 public key")
+	}
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
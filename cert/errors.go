@@ -6,4 +6,7 @@ var (
 	ErrExpired       = errors.New("certificate is expired")
 	ErrNotCA         = errors.New("certificate is not a CA")
 	ErrNotSelfSigned = errors.New("certificate is not self-signed")
+
+	ErrPrivateKeyEncrypted = errors.New("private key must be decrypted")
+	ErrInvalidPassphrase   = errors.New("invalid passphrase or corrupt private key")
 )
@@ -0,0 +1,321 @@
+package nebula
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/header"
+	"github.com/slackhq/nebula/iputil"
+	"github.com/slackhq/nebula/udp"
+)
+
+// CertSigner holds a CA key and renews the certificates of hosts in the mesh. Hosts are authenticated by the
+// certificate they presented during the handshake, a renewed certificate only ever differs in its validity period.
+type CertSigner struct {
+	caCert        *cert.NebulaCertificate
+	caKey         []byte
+	caFingerprint string
+	duration      time.Duration
+	l             *logrus.Logger
+}
+
+// NewCertSignerFromConfig returns nil if `pki.signer.enabled` is not set
+func NewCertSignerFromConfig(l *logrus.Logger, c *config.C) (*CertSigner, error) {
+	if !c.GetBool("pki.signer.enabled", false) {
+		return nil, nil
+	}
+
+	caCertPath := c.GetString("pki.signer.ca_cert", "")
+	if caCertPath == "" {
+		return nil, errors.New("no pki.signer.ca_cert path provided")
+	}
+
+	caKeyPath := c.GetString("pki.signer.ca_key", "")
+	if caKeyPath == "" {
+		return nil, errors.New("no pki.signer.ca_key path provided")
+	}
+
+	rawCAKey, err := ioutil.ReadFile(caKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read pki.signer.ca_key file %s: %s", caKeyPath, err)
+	}
+
+	caKey, _, curve, err := cert.UnmarshalSigningPrivateKey(rawCAKey)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshaling pki.signer.ca_key %s: %s", caKeyPath, err)
+	}
+
+	rawCACert, err := ioutil.ReadFile(caCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read pki.signer.ca_cert file %s: %s", caCertPath, err)
+	}
+
+	caCert, _, err := cert.UnmarshalNebulaCertificateFromPEM(rawCACert)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshaling pki.signer.ca_cert %s: %s", caCertPath, err)
+	}
+
+	if caCert.Details.Curve != curve {
+		return nil, errors.New("pki.signer.ca_cert curve does not match pki.signer.ca_key")
+	}
+
+	if err := caCert.VerifyPrivateKey(caKey); err != nil {
+		return nil, errors.New("pki.signer.ca_cert does not match pki.signer.ca_key")
+	}
+
+	if caCert.Expired(time.Now()) {
+		return nil, errors.New("pki.signer.ca_cert is expired")
+	}
+
+	fp, err := caCert.Sha256Sum()
+	if err != nil {
+		return nil, fmt.Errorf("error while getting pki.signer.ca_cert fingerprint: %s", err)
+	}
+
+	duration := c.GetDuration("pki.signer.duration", 0)
+	if duration < 0 {
+		return nil, errors.New("pki.signer.duration must not be negative")
+	}
+
+	l.WithField("ca", caCert.Details.Name).WithField("fingerprint", fp).WithField("duration", duration).
+		Info("Certificate renewal signer enabled")
+
+	return &CertSigner{
+		caCert:        caCert,
+		caKey:         caKey,
+		caFingerprint: fp,
+		duration:      duration,
+		l:             l,
+	}, nil
+}
+
+// Renew returns a freshly signed copy of a still valid certificate that was issued by our CA.
+// The lifetime is pki.signer.duration or the lifetime of the current certificate, capped by the CA expiration.
+func (s *CertSigner) Renew(c *cert.NebulaCertificate, caPool *cert.NebulaCAPool, now time.Time) (*cert.NebulaCertificate, error) {
+	if c.Details.IsCA {
+		return nil, errors.New("refusing to renew a CA certificate")
+	}
+
+	if c.Details.Issuer != s.caFingerprint {
+		return nil, errors.New("certificate was not issued by this signer")
+	}
+
+	if _, err := c.Verify(now, caPool); err != nil {
+		return nil, fmt.Errorf("certificate is not valid: %s", err)
+	}
+
+	lifetime := s.duration
+	if lifetime == 0 {
+		lifetime = c.Details.NotAfter.Sub(c.Details.NotBefore)
+	}
+
+	nc := c.Copy()
+	nc.Details.NotBefore = now
+	nc.Details.NotAfter = now.Add(lifetime)
+	if nc.Details.NotAfter.After(s.caCert.Details.NotAfter) {
+		nc.Details.NotAfter = s.caCert.Details.NotAfter
+	}
+
+	if !nc.Details.NotAfter.After(c.Details.NotAfter) {
+		return nil, errors.New("renewed certificate would not outlive the current certificate, the CA may be expiring")
+	}
+
+	if err := nc.Sign(s.caKey); err != nil {
+		return nil, fmt.Errorf("error while signing: %s", err)
+	}
+
+	return nc, nil
+}
+
+// CertRenewer asks a CertSigner in the mesh for a fresh certificate once enough of the current certificates
+// lifetime has passed. The renewed certificate is written over pki.cert and loaded with the normal reload path.
+type CertRenewer struct {
+	signer   iputil.VpnIp
+	at       float64
+	interval time.Duration
+	certPath string
+	c        *config.C
+	f        *Interface
+	l        *logrus.Logger
+}
+
+// NewCertRenewerFromConfig returns nil if `pki.renew.signer` is not set. The signer must be within one of myVpnNets,
+// the networks of the ips in our certificate.
+func NewCertRenewerFromConfig(l *logrus.Logger, c *config.C, myVpnNets []*net.IPNet) (*CertRenewer, error) {
+	rawSigner := c.GetString("pki.renew.signer", "")
+	if rawSigner == "" {
+		return nil, nil
+	}
+
+	signer := iputil.Ip2VpnIp(net.ParseIP(rawSigner))
+	if !signer.IsValid() {
+		return nil, fmt.Errorf("pki.renew.signer is not a valid vpn ip: %s", rawSigner)
+	}
+
+	inVpnNets := false
+	for _, n := range myVpnNets {
+		if n.Contains(signer.ToIP()) {
+			inVpnNets = true
+			break
+		}
+	}
+	if !inVpnNets {
+		return nil, fmt.Errorf("pki.renew.signer is not within our vpn networks: %s", rawSigner)
+	}
+
+	certPath := c.GetString("pki.cert", "")
+	if strings.Contains(certPath, "-----BEGIN") {
+		return nil, errors.New("pki.renew requires pki.cert to be a file path, the renewed certificate can not be saved otherwise")
+	}
+
+	at := c.GetFloat("pki.renew.at", 0.5)
+	if at <= 0 || at >= 1 {
+		return nil, fmt.Errorf("pki.renew.at must be between 0 and 1, have %v", at)
+	}
+
+	interval := c.GetDuration("pki.renew.interval", 10*time.Minute)
+	if interval <= 0 {
+		return nil, errors.New("pki.renew.interval must be greater than 0")
+	}
+
+	return &CertRenewer{
+		signer:   signer,
+		at:       at,
+		interval: interval,
+		certPath: certPath,
+		c:        c,
+		l:        l,
+	}, nil
+}
+
+// Run checks if our certificate is due for renewal every interval until the context is done
+func (r *CertRenewer) Run(ctx context.Context) {
+	nb := make([]byte, 12, 12)
+	out := make([]byte, mtu)
+
+	clockSource := time.NewTicker(r.interval)
+	defer clockSource.Stop()
+
+	for {
+		r.check(time.Now(), r.f, nb, out)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-clockSource.C:
+		}
+	}
+}
+
+func (r *CertRenewer) check(now time.Time, w udp.EncWriter, nb, out []byte) {
+	if !r.due(r.f.certState.certificate, now) {
+		return
+	}
+
+	query, err := (&NebulaMeta{Type: NebulaMeta_CertRenewalRequest, Details: &NebulaMetaDetails{}}).Marshal()
+	if err != nil {
+		r.l.WithError(err).Error("Failed to marshal certificate renewal request")
+		return
+	}
+
+	r.l.WithField("signer", r.signer).WithField("notAfter", r.f.certState.certificate.Details.NotAfter).
+		Info("Requesting certificate renewal")
+	r.f.lightHouse.metricTx(NebulaMeta_CertRenewalRequest, 1)
+	w.SendMessageToVpnIp(header.LightHouse, 0, r.signer, query, nb, out)
+}
+
+// due returns true once `at` of the certificates lifetime has passed
+func (r *CertRenewer) due(c *cert.NebulaCertificate, now time.Time) bool {
+	lifetime := c.Details.NotAfter.Sub(c.Details.NotBefore)
+	renewAt := c.Details.NotBefore.Add(time.Duration(float64(lifetime) * r.at))
+	return !now.Before(renewAt)
+}
+
+// handleReply validates a renewed certificate from the signer and swaps it in
+func (r *CertRenewer) handleReply(vpnIp iputil.VpnIp, rawCert []byte) {
+	if vpnIp != r.signer {
+		r.l.WithField("vpnIp", vpnIp).Warn("Ignoring certificate renewal reply from a host that is not our signer")
+		return
+	}
+
+	nc, err := cert.UnmarshalNebulaCertificate(rawCert)
+	if err != nil {
+		r.l.WithError(err).WithField("signer", vpnIp).Error("Failed to unmarshal renewed certificate")
+		return
+	}
+
+	if err := checkRenewedCert(r.f.certState.certificate, nc, r.f.caPool, time.Now()); err != nil {
+		r.l.WithError(err).WithField("signer", vpnIp).WithField("cert", nc).Error("Refusing renewed certificate")
+		return
+	}
+
+	b, err := nc.MarshalToPEMWithChain()
+	if err != nil {
+		r.l.WithError(err).Error("Failed to marshal renewed certificate")
+		return
+	}
+
+	if err := ioutil.WriteFile(r.certPath, b, 0600); err != nil {
+		r.l.WithError(err).WithField("path", r.certPath).Error("Failed to write renewed certificate")
+		return
+	}
+
+	r.l.WithField("path", r.certPath).WithField("notAfter", nc.Details.NotAfter).Info("Certificate renewed")
+	r.f.reloadCertKey(r.c)
+}
+
+// checkRenewedCert makes sure a renewed certificate only extends the validity of the current one
+func checkRenewedCert(current, renewed *cert.NebulaCertificate, caPool *cert.NebulaCAPool, now time.Time) error {
+	if !bytes.Equal(current.Details.PublicKey, renewed.Details.PublicKey) {
+		return errors.New("public key does not match")
+	}
+
+	if renewed.Details.Name != current.Details.Name || renewed.Details.IsCA != current.Details.IsCA {
+		return errors.New("name does not match")
+	}
+
+	if !ipNetsEqual(current.Details.Ips, renewed.Details.Ips) {
+		return errors.New("ips do not match")
+	}
+
+	if !ipNetsEqual(current.Details.Subnets, renewed.Details.Subnets) {
+		return errors.New("subnets do not match")
+	}
+
+	if strings.Join(current.Details.Groups, ",") != strings.Join(renewed.Details.Groups, ",") {
+		return errors.New("groups do not match")
+	}
+
+	if !renewed.Details.NotAfter.After(current.Details.NotAfter) {
+		return errors.New("renewed certificate does not expire after the current certificate")
+	}
+
+	if _, err := renewed.Verify(now, caPool); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func ipNetsEqual(a, b []*net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return false
+		}
+	}
+
+	return true
+}
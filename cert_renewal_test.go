@@ -0,0 +1,308 @@
+package nebula
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/iputil"
+	"github.com/slackhq/nebula/test"
+	"github.com/slackhq/nebula/udp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+func newRenewalTestCA(t *testing.T, notAfter time.Time) (*cert.NebulaCertificate, ed25519.PrivateKey, *cert.NebulaCAPool) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	ca := &cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      "ca",
+			NotBefore: time.Now().Add(-time.Hour),
+			NotAfter:  notAfter,
+			IsCA:      true,
+			PublicKey: pub,
+		},
+	}
+	assert.NoError(t, ca.Sign(priv))
+
+	pool := cert.NewCAPool()
+	b, _ := ca.MarshalToPEM()
+	_, err := pool.AddCACertificate(b)
+	assert.NoError(t, err)
+
+	return ca, priv, pool
+}
+
+func newRenewalTestCert(t *testing.T, ca *cert.NebulaCertificate, caKey ed25519.PrivateKey, notBefore, notAfter time.Time) (*cert.NebulaCertificate, []byte) {
+	priv := make([]byte, 32)
+	_, _ = rand.Read(priv)
+	pub, _ := curve25519.X25519(priv, curve25519.Basepoint)
+	issuer, _ := ca.Sha256Sum()
+
+	c := &cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      "host",
+			Ips:       []*net.IPNet{{IP: net.IP{10, 1, 1, 2}, Mask: net.IPMask{255, 255, 255, 0}}},
+			Groups:    []string{"a", "b"},
+			NotBefore: notBefore,
+			NotAfter:  notAfter,
+			PublicKey: pub,
+			Issuer:    issuer,
+		},
+	}
+	assert.NoError(t, c.Sign(caKey))
+	return c, priv
+}
+
+func TestCertSigner_Renew(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	ca, caKey, pool := newRenewalTestCA(t, now.Add(time.Hour*24))
+	fp, _ := ca.Sha256Sum()
+	s := &CertSigner{caCert: ca, caKey: caKey, caFingerprint: fp, l: test.NewLogger()}
+
+	c, _ := newRenewalTestCert(t, ca, caKey, now.Add(-time.Hour), now.Add(time.Hour))
+
+	// Keeps the lifetime of the current certificate
+	nc, err := s.Renew(c, pool, now)
+	assert.NoError(t, err)
+	assert.Equal(t, now, nc.Details.NotBefore)
+	assert.Equal(t, now.Add(time.Hour*2), nc.Details.NotAfter)
+	assert.NoError(t, checkRenewedCert(c, nc, pool, now))
+
+	// Uses the configured duration but never outlives the ca
+	s.duration = time.Hour * 48
+	nc, err = s.Renew(c, pool, now)
+	assert.NoError(t, err)
+	assert.Equal(t, ca.Details.NotAfter, nc.Details.NotAfter)
+
+	// Refuses when the ca would not allow the certificate to live any longer
+	ca3, caKey3, pool3 := newRenewalTestCA(t, now.Add(time.Hour))
+	fp3, _ := ca3.Sha256Sum()
+	s3 := &CertSigner{caCert: ca3, caKey: caKey3, caFingerprint: fp3, l: test.NewLogger()}
+	c3, _ := newRenewalTestCert(t, ca3, caKey3, now.Add(-time.Hour), now.Add(time.Hour))
+	nc, err = s3.Renew(c3, pool3, now)
+	assert.Nil(t, nc)
+	assert.EqualError(t, err, "renewed certificate would not outlive the current certificate, the CA may be expiring")
+
+	// Refuses expired certificates
+	nc, err = s.Renew(c, pool, now.Add(time.Hour*2))
+	assert.Nil(t, nc)
+	assert.EqualError(t, err, "certificate is not valid: certificate is expired")
+
+	// Refuses certificates from other cas
+	ca2, caKey2, pool2 := newRenewalTestCA(t, now.Add(time.Hour*24))
+	c2, _ := newRenewalTestCert(t, ca2, caKey2, now.Add(-time.Hour), now.Add(time.Hour))
+	nc, err = s.Renew(c2, pool2, now)
+	assert.Nil(t, nc)
+	assert.EqualError(t, err, "certificate was not issued by this signer")
+
+	// Refuses ca certificates
+	nc, err = s.Renew(ca, pool, now)
+	assert.Nil(t, nc)
+	assert.EqualError(t, err, "refusing to renew a CA certificate")
+}
+
+func TestCertRenewer_due(t *testing.T) {
+	now := time.Now()
+	r := &CertRenewer{at: 0.75}
+	c := &cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			NotBefore: now,
+			NotAfter:  now.Add(time.Hour * 4),
+		},
+	}
+
+	assert.False(t, r.due(c, now))
+	assert.False(t, r.due(c, now.Add(time.Hour*2)))
+	assert.True(t, r.due(c, now.Add(time.Hour*3)))
+	assert.True(t, r.due(c, now.Add(time.Hour*5)))
+}
+
+func Test_checkRenewedCert(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	ca, caKey, pool := newRenewalTestCA(t, now.Add(time.Hour*24))
+	c, _ := newRenewalTestCert(t, ca, caKey, now.Add(-time.Hour), now.Add(time.Hour))
+
+	resign := func(f func(nc *cert.NebulaCertificate)) *cert.NebulaCertificate {
+		nc := c.Copy()
+		nc.Details.NotAfter = now.Add(time.Hour * 2)
+		f(nc)
+		assert.NoError(t, nc.Sign(caKey))
+		return nc
+	}
+
+	assert.NoError(t, checkRenewedCert(c, resign(func(nc *cert.NebulaCertificate) {}), pool, now))
+	assert.EqualError(t, checkRenewedCert(c, resign(func(nc *cert.NebulaCertificate) { nc.Details.PublicKey = []byte{1} }), pool, now), "public key does not match")
+	assert.EqualError(t, checkRenewedCert(c, resign(func(nc *cert.NebulaCertificate) { nc.Details.Name = "nope" }), pool, now), "name does not match")
+	assert.EqualError(t, checkRenewedCert(c, resign(func(nc *cert.NebulaCertificate) { nc.Details.Ips[0].IP = net.IP{10, 1, 1, 3} }), pool, now), "ips do not match")
+	assert.EqualError(t, checkRenewedCert(c, resign(func(nc *cert.NebulaCertificate) {
+		nc.Details.Subnets = []*net.IPNet{{IP: net.IP{10, 2, 0, 0}, Mask: net.IPMask{255, 255, 0, 0}}}
+	}), pool, now), "subnets do not match")
+	assert.EqualError(t, checkRenewedCert(c, resign(func(nc *cert.NebulaCertificate) { nc.Details.Groups = []string{"a"} }), pool, now), "groups do not match")
+	assert.EqualError(t, checkRenewedCert(c, resign(func(nc *cert.NebulaCertificate) { nc.Details.NotAfter = c.Details.NotAfter }), pool, now), "renewed certificate does not expire after the current certificate")
+
+	// Must be signed by a ca we trust
+	_, caKey2, _ := newRenewalTestCA(t, now.Add(time.Hour*24))
+	nc := c.Copy()
+	nc.Details.NotAfter = now.Add(time.Hour * 2)
+	assert.NoError(t, nc.Sign(caKey2))
+	assert.EqualError(t, checkRenewedCert(c, nc, pool, now), "certificate signature did not match")
+}
+
+func TestCertRenewal(t *testing.T) {
+	l := test.NewLogger()
+	now := time.Now().Truncate(time.Second)
+	ca, caKey, pool := newRenewalTestCA(t, now.Add(time.Hour*24))
+	c, priv := newRenewalTestCert(t, ca, caKey, now.Add(-time.Hour), now.Add(time.Minute))
+
+	dir, err := ioutil.TempDir("", "cert-renewal")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, _ := ca.MarshalToPEM()
+	assert.NoError(t, ioutil.WriteFile(dir+"/ca.crt", b, 0600))
+	assert.NoError(t, ioutil.WriteFile(dir+"/ca.key", cert.MarshalEd25519PrivateKey(caKey), 0600))
+	b, _ = c.MarshalToPEM()
+	assert.NoError(t, ioutil.WriteFile(dir+"/host.crt", b, 0600))
+	assert.NoError(t, ioutil.WriteFile(dir+"/host.key", cert.MarshalX25519PrivateKey(priv), 0600))
+
+	// The signer side
+	signerVpnIp := iputil.Ip2VpnIp(net.ParseIP("10.1.1.1"))
+	myVpnIp := iputil.Ip2VpnIp(net.ParseIP("10.1.1.2"))
+
+	sc := config.NewC(l)
+	sc.Settings["pki"] = map[interface{}]interface{}{
+		"signer": map[interface{}]interface{}{"enabled": true, "ca_cert": dir + "/ca.crt", "ca_key": dir + "/nope"},
+	}
+	_, err = NewCertSignerFromConfig(l, sc)
+	assert.EqualError(t, err, "unable to read pki.signer.ca_key file "+dir+"/nope: open "+dir+"/nope: no such file or directory")
+
+	sc.Settings["pki"] = map[interface{}]interface{}{
+		"signer": map[interface{}]interface{}{"enabled": true, "ca_cert": dir + "/ca.crt", "ca_key": dir + "/ca.key"},
+	}
+	signer, err := NewCertSignerFromConfig(l, sc)
+	assert.NoError(t, err)
+
+	sc.Settings["lighthouse"] = map[interface{}]interface{}{"am_lighthouse": true}
+	sc.Settings["listen"] = map[interface{}]interface{}{"port": 4242}
+	signerLh, err := NewLightHouseFromConfig(l, sc, &net.IPNet{IP: net.IP{10, 1, 1, 1}, Mask: net.IPMask{255, 255, 255, 0}}, nil, nil)
+	assert.NoError(t, err)
+	signerLh.caPool = func() *cert.NebulaCAPool { return pool }
+	signerLh.certSigner = signer
+	signerLh.hostMap = NewHostMap(l, "main", &net.IPNet{IP: net.IP{10, 1, 1, 1}, Mask: net.IPMask{255, 255, 255, 0}}, nil)
+	signerLh.hostMap.Hosts[myVpnIp] = &HostInfo{vpnIp: myVpnIp, ConnectionState: &ConnectionState{peerCert: c}}
+	signerLhh := signerLh.NewRequestHandler()
+
+	// The renewing side
+	mc := config.NewC(l)
+	mc.Settings["pki"] = map[interface{}]interface{}{
+		"cert":  dir + "/host.crt",
+		"key":   dir + "/host.key",
+		"renew": map[interface{}]interface{}{"signer": "10.1.1.1", "at": 0.9},
+	}
+	renewer, err := NewCertRenewerFromConfig(l, mc, []*net.IPNet{{IP: net.IP{10, 1, 1, 2}, Mask: net.IPMask{255, 255, 255, 0}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.9, renewer.at)
+
+	cs, err := NewCertStateFromConfig(mc)
+	assert.NoError(t, err)
+	mc.Settings["lighthouse"] = map[interface{}]interface{}{"hosts": []interface{}{"10.1.1.1"}}
+	mc.Settings["static_host_map"] = map[interface{}]interface{}{"10.1.1.1": []interface{}{"10.0.0.1:4242"}}
+	myLh, err := NewLightHouseFromConfig(l, mc, &net.IPNet{IP: net.IP{10, 1, 1, 2}, Mask: net.IPMask{255, 255, 255, 0}}, nil, nil)
+	assert.NoError(t, err)
+	myLh.certRenewer = renewer
+	renewer.f = &Interface{certState: cs, caPool: pool, lightHouse: myLh, l: l}
+	myLhh := myLh.NewRequestHandler()
+
+	// Ask for a renewal, our certificate is well past the renewal point
+	w := &testEncWriter{}
+	renewer.check(now, w, nil, nil)
+	assert.Equal(t, signerVpnIp, w.lastReply.vpnIp)
+	assert.Equal(t, NebulaMeta_CertRenewalRequest, w.lastReply.msg.Type)
+
+	// Nothing to do if we are not due
+	w = &testEncWriter{}
+	renewer.check(now.Add(-time.Hour), w, nil, nil)
+	assert.Nil(t, w.lastReply.msg)
+
+	// Non signers don't answer
+	req, _ := (&NebulaMeta{Type: NebulaMeta_CertRenewalRequest, Details: &NebulaMetaDetails{}}).Marshal()
+	w = &testEncWriter{}
+	myLhh.HandleRequest(&udp.Addr{}, signerVpnIp, req, w)
+	assert.Nil(t, w.lastReply.msg)
+
+	// Unknown hosts are not answered
+	w = &testEncWriter{}
+	signerLhh.HandleRequest(&udp.Addr{}, iputil.Ip2VpnIp(net.ParseIP("10.1.1.3")), req, w)
+	assert.Nil(t, w.lastReply.msg)
+
+	w = &testEncWriter{}
+	signerLhh.HandleRequest(&udp.Addr{}, myVpnIp, req, w)
+	assert.Equal(t, myVpnIp, w.lastReply.vpnIp)
+	assert.Equal(t, NebulaMeta_CertRenewalReply, w.lastReply.msg.Type)
+
+	renewed, err := cert.UnmarshalNebulaCertificate(w.lastReply.msg.Details.Certificate)
+	assert.NoError(t, err)
+	assert.True(t, renewed.Details.NotAfter.After(c.Details.NotAfter))
+
+	reply, _ := w.lastReply.msg.Marshal()
+
+	// Replies from anyone but our signer are ignored
+	myLhh.HandleRequest(&udp.Addr{}, iputil.Ip2VpnIp(net.ParseIP("10.1.1.3")), reply, &testEncWriter{})
+	assert.Equal(t, c.Details.NotAfter, renewer.f.certState.certificate.Details.NotAfter)
+
+	// The renewed certificate is written to disk and loaded
+	myLhh.HandleRequest(&udp.Addr{}, signerVpnIp, reply, &testEncWriter{})
+	assert.Equal(t, renewed.Details.NotAfter, renewer.f.certState.certificate.Details.NotAfter)
+
+	b, err = ioutil.ReadFile(dir + "/host.crt")
+	assert.NoError(t, err)
+	onDisk, _, err := cert.UnmarshalNebulaCertificateFromPEM(b)
+	assert.NoError(t, err)
+	assert.Equal(t, renewed.Signature, onDisk.Signature)
+
+	// Inline certificates can not be renewed
+	mc.Settings["pki"] = map[interface{}]interface{}{
+		"cert":  string(b),
+		"renew": map[interface{}]interface{}{"signer": "10.1.1.1"},
+	}
+	_, err = NewCertRenewerFromConfig(l, mc, []*net.IPNet{{IP: net.IP{10, 1, 1, 2}, Mask: net.IPMask{255, 255, 255, 0}}})
+	assert.EqualError(t, err, "pki.renew requires pki.cert to be a file path, the renewed certificate can not be saved otherwise")
+}
+
+func TestNewCertRenewerFromConfig_Signer(t *testing.T) {
+	l := test.NewLogger()
+	_, ip6, _ := net.ParseCIDR("fd00::2/64")
+	ip6.IP = net.ParseIP("fd00::2")
+	myVpnNets := []*net.IPNet{ip6}
+
+	c := config.NewC(l)
+	c.Settings["pki"] = map[interface{}]interface{}{
+		"cert":  "/nope/host.crt",
+		"renew": map[interface{}]interface{}{"signer": "fd00::1"},
+	}
+
+	// An ipv6 signer works for an ipv6 only node
+	r, err := NewCertRenewerFromConfig(l, c, myVpnNets)
+	assert.NoError(t, err)
+	assert.Equal(t, iputil.Ip2VpnIp(net.ParseIP("fd00::1")), r.signer)
+
+	// The signer must be within one of our vpn networks
+	c.Settings["pki"].(map[interface{}]interface{})["renew"] = map[interface{}]interface{}{"signer": "10.1.1.1"}
+	_, err = NewCertRenewerFromConfig(l, c, myVpnNets)
+	assert.EqualError(t, err, "pki.renew.signer is not within our vpn networks: 10.1.1.1")
+
+	c.Settings["pki"].(map[interface{}]interface{})["renew"] = map[interface{}]interface{}{"signer": "fd01::1"}
+	_, err = NewCertRenewerFromConfig(l, c, myVpnNets)
+	assert.EqualError(t, err, "pki.renew.signer is not within our vpn networks: fd01::1")
+
+	c.Settings["pki"].(map[interface{}]interface{})["renew"] = map[interface{}]interface{}{"signer": "nope"}
+	_, err = NewCertRenewerFromConfig(l, c, myVpnNets)
+	assert.EqualError(t, err, "pki.renew.signer is not a valid vpn ip: nope")
+}
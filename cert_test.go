@@ -0,0 +1,19 @@
+package nebula
+
+import (
+	"testing"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCertState_curve(t *testing.T) {
+	for _, curve := range []cert.Curve{cert.Curve_CURVE25519, cert.Curve_P256} {
+		_, err := NewCertState(&cert.NebulaCertificate{Details: cert.NebulaCertificateDetails{Curve: curve}}, nil)
+		assert.Nil(t, err)
+	}
+
+	// newConnectionState can't build a handshake for any other curve
+	_, err := NewCertState(&cert.NebulaCertificate{Details: cert.NebulaCertificateDetails{Curve: cert.Curve(7)}}, nil)
+	assert.EqualError(t, err, "invalid nebula certificate on interface: unsupported curve 7")
+}
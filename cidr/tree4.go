@@ -1,6 +1,7 @@
 package cidr
 
 import (
+	"encoding/binary"
 	"net"
 
 	"github.com/slackhq/nebula/iputil"
@@ -18,9 +19,17 @@ type Tree4 struct {
 }
 
 const (
-	startbit = iputil.VpnIp(0x80000000)
+	startbit = uint32(0x80000000)
 )
 
+// ip4 returns a 4 byte ip or mask as a uint32, a 16 byte one uses its last 4 bytes
+func ip4(b []byte) uint32 {
+	if len(b) == 16 {
+		return binary.BigEndian.Uint32(b[12:16])
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
 func NewTree4() *Tree4 {
 	tree := new(Tree4)
 	tree.root = &Node{}
@@ -32,8 +41,8 @@ func (tree *Tree4) AddCIDR(cidr *net.IPNet, val interface{}) {
 	node := tree.root
 	next := tree.root
 
-	ip := iputil.Ip2VpnIp(cidr.IP)
-	mask := iputil.Ip2VpnIp(cidr.Mask)
+	ip := ip4(cidr.IP)
+	mask := ip4(cidr.Mask)
 
 	// Find our last ancestor in the tree
 	for bit&mask != 0 {
@@ -77,7 +86,12 @@ func (tree *Tree4) AddCIDR(cidr *net.IPNet, val interface{}) {
 }
 
 // Finds the first match, which may be the least specific
-func (tree *Tree4) Contains(ip iputil.VpnIp) (value interface{}) {
+func (tree *Tree4) Contains(vpnIp iputil.VpnIp) (value interface{}) {
+	if !vpnIp.Is4() {
+		return nil
+	}
+
+	ip := vpnIp.Uint32()
 	bit := startbit
 	node := tree.root
 
@@ -99,8 +113,41 @@ func (tree *Tree4) Contains(ip iputil.VpnIp) (value interface{}) {
 	return value
 }
 
+// EachContains calls f with the value of every cidr that contains ip, least specific first. It stops and returns true
+// as soon as f returns true.
+func (tree *Tree4) EachContains(vpnIp iputil.VpnIp, f func(value interface{}) bool) bool {
+	if !vpnIp.Is4() {
+		return false
+	}
+
+	ip := vpnIp.Uint32()
+	bit := startbit
+	node := tree.root
+
+	for node != nil {
+		if node.value != nil && f(node.value) {
+			return true
+		}
+
+		if ip&bit != 0 {
+			node = node.right
+		} else {
+			node = node.left
+		}
+
+		bit >>= 1
+	}
+
+	return false
+}
+
 // Finds the most specific match
-func (tree *Tree4) MostSpecificContains(ip iputil.VpnIp) (value interface{}) {
+func (tree *Tree4) MostSpecificContains(vpnIp iputil.VpnIp) (value interface{}) {
+	if !vpnIp.Is4() {
+		return nil
+	}
+
+	ip := vpnIp.Uint32()
 	bit := startbit
 	node := tree.root
 
@@ -122,7 +169,12 @@ func (tree *Tree4) MostSpecificContains(ip iputil.VpnIp) (value interface{}) {
 }
 
 // Finds the most specific match
-func (tree *Tree4) Match(ip iputil.VpnIp) (value interface{}) {
+func (tree *Tree4) Match(vpnIp iputil.VpnIp) (value interface{}) {
+	if !vpnIp.Is4() {
+		return nil
+	}
+
+	ip := vpnIp.Uint32()
 	bit := startbit
 	node := tree.root
 	lastNode := node
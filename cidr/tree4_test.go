@@ -85,6 +85,35 @@ func TestCIDRTree_MostSpecificContains(t *testing.T) {
 	assert.Equal(t, "cool", tree.MostSpecificContains(iputil.Ip2VpnIp(net.ParseIP("255.255.255.255"))))
 }
 
+func TestCIDRTree_EachContains(t *testing.T) {
+	tree := NewTree4()
+	tree.AddCIDR(Parse("4.0.0.0/8"), "4a")
+	tree.AddCIDR(Parse("4.1.1.0/24"), "4b")
+	tree.AddCIDR(Parse("4.1.1.1/32"), "4c")
+	tree.AddCIDR(Parse("5.0.0.0/8"), "5")
+
+	var seen []interface{}
+	each := func(value interface{}) bool {
+		seen = append(seen, value)
+		return false
+	}
+
+	assert.False(t, tree.EachContains(iputil.Ip2VpnIp(net.ParseIP("4.1.1.1")), each))
+	assert.Equal(t, []interface{}{"4a", "4b", "4c"}, seen)
+
+	seen = nil
+	assert.False(t, tree.EachContains(iputil.Ip2VpnIp(net.ParseIP("6.0.0.0")), each))
+	assert.Empty(t, seen)
+
+	// Stops once a value is accepted
+	seen = nil
+	assert.True(t, tree.EachContains(iputil.Ip2VpnIp(net.ParseIP("4.1.1.1")), func(value interface{}) bool {
+		seen = append(seen, value)
+		return value == "4b"
+	}))
+	assert.Equal(t, []interface{}{"4a", "4b"}, seen)
+}
+
 func TestCIDRTree_Match(t *testing.T) {
 	tree := NewTree4()
 	tree.AddCIDR(Parse("4.1.1.0/32"), "1a")
@@ -34,8 +34,8 @@ func (tree *Tree6) AddCIDR(cidr *net.IPNet, val interface{}) {
 	}
 
 	for i := 0; i < len(cidrIP); i += 4 {
-		ip := iputil.Ip2VpnIp(cidrIP[i : i+4])
-		mask := iputil.Ip2VpnIp(cidr.Mask[i : i+4])
+		ip := ip4(cidrIP[i : i+4])
+		mask := ip4(cidr.Mask[i : i+4])
 		bit := startbit
 
 		// Find our last ancestor in the tree
@@ -86,7 +86,7 @@ func (tree *Tree6) MostSpecificContains(ip net.IP) (value interface{}) {
 	}
 
 	for i := 0; i < len(wholeIP); i += 4 {
-		ip := iputil.Ip2VpnIp(wholeIP[i : i+4])
+		ip := ip4(wholeIP[i : i+4])
 		bit := startbit
 
 		for node != nil {
@@ -111,7 +111,12 @@ func (tree *Tree6) MostSpecificContains(ip net.IP) (value interface{}) {
 	return value
 }
 
-func (tree *Tree6) MostSpecificContainsIpV4(ip iputil.VpnIp) (value interface{}) {
+func (tree *Tree6) MostSpecificContainsIpV4(vpnIp iputil.VpnIp) (value interface{}) {
+	if !vpnIp.Is4() {
+		return nil
+	}
+
+	ip := vpnIp.Uint32()
 	bit := startbit
 	node := tree.root4
 
@@ -163,6 +168,40 @@ func (tree *Tree6) MostSpecificContainsIpV6(hi, lo uint64) (value interface{}) {
 	return value
 }
 
+// EachContainsIpV6 calls f with the value of every cidr that contains the ip, least specific first. It stops and
+// returns true as soon as f returns true.
+func (tree *Tree6) EachContainsIpV6(hi, lo uint64, f func(value interface{}) bool) bool {
+	ip := hi
+	node := tree.root6
+
+	for i := 0; i < 2; i++ {
+		bit := startbit6
+
+		for node != nil {
+			// The node on the 64 bit boundary was already seen at the end of the high bits
+			if node.value != nil && (i == 0 || bit != startbit6) && f(node.value) {
+				return true
+			}
+
+			if bit == 0 {
+				break
+			}
+
+			if ip&bit != 0 {
+				node = node.right
+			} else {
+				node = node.left
+			}
+
+			bit >>= 1
+		}
+
+		ip = lo
+	}
+
+	return false
+}
+
 func isIPV4(ip net.IP) (net.IP, bool) {
 	if len(ip) == net.IPv4len {
 		return ip, true
@@ -79,3 +79,25 @@ func TestCIDR6Tree_MostSpecificContainsIpV6(t *testing.T) {
 		assert.Equal(t, tt.Result, tree.MostSpecificContainsIpV6(hi, lo))
 	}
 }
+
+func TestCIDR6Tree_EachContainsIpV6(t *testing.T) {
+	tree := NewTree6()
+	tree.AddCIDR(Parse("1:2:0:4:5:0:0:0/64"), "6a")
+	tree.AddCIDR(Parse("1:2:0:4:5:0:0:0/80"), "6b")
+	tree.AddCIDR(Parse("1:2:0:4:5:0:0:0/96"), "6c")
+
+	var seen []interface{}
+	each := func(value interface{}) bool {
+		seen = append(seen, value)
+		return value == "6b"
+	}
+
+	ip := net.ParseIP("1:2:0:4:5:0:0:1")
+	assert.True(t, tree.EachContainsIpV6(binary.BigEndian.Uint64(ip[:8]), binary.BigEndian.Uint64(ip[8:]), each))
+	assert.Equal(t, []interface{}{"6a", "6b"}, seen)
+
+	seen = nil
+	ip = net.ParseIP("1:2:0:5::1")
+	assert.False(t, tree.EachContainsIpV6(binary.BigEndian.Uint64(ip[:8]), binary.BigEndian.Uint64(ip[8:]), each))
+	assert.Empty(t, seen)
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+)
+
+const (
+	auditValid       = "valid"
+	auditExpiring    = "expiring"
+	auditExpired     = "expired"
+	auditBlocklisted = "blocklisted"
+	auditRevoked     = "revoked"
+	auditOrphaned    = "orphaned"
+	auditInvalid     = "invalid"
+)
+
+type auditFlags struct {
+	set           *flag.FlagSet
+	path          *string
+	caPath        *string
+	crlPath       *string
+	blocklist     *string
+	expiresWithin *time.Duration
+	group         *string
+	json          *bool
+}
+
+func newAuditFlags() *auditFlags {
+	af := auditFlags{set: flag.NewFlagSet("audit", flag.ContinueOnError)}
+	af.set.Usage = func() {}
+	af.path = af.set.String("path", "", "Required: path to a directory of certificates, it is searched recursively")
+	af.caPath = af.set.String("ca", "", "Required: path to a file containing one or more ca certificates")
+	af.crlPath = af.set.String("crl", "", "Optional: path to a file containing revocation lists to check certificates against")
+	af.blocklist = af.set.String("blocklist", "", "Optional: comma separated list of blocklisted certificate fingerprints")
+	af.expiresWithin = af.set.Duration("expires-within", 30*24*time.Hour, "Optional: certificates expiring within this window are reported as expiring. Valid time units are seconds: \"s\", minutes: \"m\", hours: \"h\"")
+	af.group = af.set.String("group", "", "Optional: only report certificates that are a member of this group")
+	af.json = af.set.Bool("json", false, "Optional: outputs the report in json format")
+	return &af
+}
+
+type auditReport struct {
+	Certificates []*auditCert        `json:"certificates"`
+	IpCollisions []auditIpCollision  `json:"ipCollisions"`
+	Groups       map[string][]string `json:"groups"`
+}
+
+type auditCert struct {
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint"`
+	IsCA        bool      `json:"isCa"`
+	Ips         []string  `json:"ips"`
+	Groups      []string  `json:"groups"`
+	NotAfter    time.Time `json:"notAfter"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+
+	nc *cert.NebulaCertificate
+}
+
+type auditIpCollision struct {
+	Ip           string   `json:"ip"`
+	Certificates []string `json:"certificates"`
+}
+
+func audit(args []string, out io.Writer, errOut io.Writer) error {
+	af := newAuditFlags()
+	err := af.set.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := mustFlagString("path", af.path); err != nil {
+		return err
+	}
+	if err := mustFlagString("ca", af.caPath); err != nil {
+		return err
+	}
+
+	caPool, err := loadCAPool(*af.caPath)
+	if err != nil {
+		return err
+	}
+
+	if *af.crlPath != "" {
+		rawCRL, err := ioutil.ReadFile(*af.crlPath)
+		if err != nil {
+			return fmt.Errorf("error while reading crl: %s", err)
+		}
+
+		if err := caPool.AddRevocationListsFromPEM(rawCRL); err != nil {
+			return fmt.Errorf("error while adding crl to pool: %s", err)
+		}
+	}
+
+	for _, fp := range strings.Split(*af.blocklist, ",") {
+		if fp = strings.TrimSpace(fp); fp != "" {
+			caPool.BlocklistFingerprint(fp)
+		}
+	}
+
+	certs, err := readAuditCerts(*af.path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, c := range certs {
+		if c.nc != nil {
+			c.Status, err = auditStatus(c.nc, now, *af.expiresWithin, caPool)
+			if err != nil {
+				c.Error = err.Error()
+			}
+		}
+	}
+
+	// Soonest to expire first, unreadable certificates have no expiry and lead
+	sort.SliceStable(certs, func(i, j int) bool {
+		if !certs[i].NotAfter.Equal(certs[j].NotAfter) {
+			return certs[i].NotAfter.Before(certs[j].NotAfter)
+		}
+		return certs[i].Path < certs[j].Path
+	})
+
+	report := auditReport{
+		Certificates: []*auditCert{},
+		IpCollisions: auditIpCollisions(certs),
+		Groups:       auditGroups(certs),
+	}
+
+	for _, c := range certs {
+		if *af.group == "" || auditHasGroup(c, *af.group) {
+			report.Certificates = append(report.Certificates, c)
+		}
+	}
+
+	if *af.json {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error while marshalling report: %s", err)
+		}
+		out.Write(append(b, '\n'))
+		return nil
+	}
+
+	return writeAuditTable(out, report)
+}
+
+// readAuditCerts parses every certificate found below root. Files without a certificate are ignored. A file holding a
+// host certificate may be followed by its intermediates, a file starting with a ca certificate is treated as a bundle
+// where every certificate is reported on its own.
+func readAuditCerts(root string) ([]*auditCert, error) {
+	var certs []*auditCert
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Contains(b, []byte("-----BEGIN "+cert.CertBanner+"-----")) {
+			return nil
+		}
+
+		nc, r, err := cert.UnmarshalNebulaCertificateFromPEM(b)
+		if err != nil {
+			certs = append(certs, &auditCert{Path: path, Status: auditInvalid, Error: err.Error()})
+			return nil
+		}
+
+		if !nc.Details.IsCA {
+			nc, err = cert.UnmarshalNebulaCertificateWithChainFromPEM(b)
+			if err != nil {
+				certs = append(certs, &auditCert{Path: path, Status: auditInvalid, Error: err.Error()})
+				return nil
+			}
+			certs = append(certs, newAuditCert(path, nc))
+			return nil
+		}
+
+		for {
+			certs = append(certs, newAuditCert(path, nc))
+			if len(r) == 0 || strings.TrimSpace(string(r)) == "" {
+				return nil
+			}
+
+			nc, r, err = cert.UnmarshalNebulaCertificateFromPEM(r)
+			if err != nil {
+				certs = append(certs, &auditCert{Path: path, Status: auditInvalid, Error: err.Error()})
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while reading path: %s", err)
+	}
+
+	return certs, nil
+}
+
+func newAuditCert(path string, nc *cert.NebulaCertificate) *auditCert {
+	c := &auditCert{
+		Path:     path,
+		Name:     nc.Details.Name,
+		IsCA:     nc.Details.IsCA,
+		Ips:      []string{},
+		Groups:   nc.Details.Groups,
+		NotAfter: nc.Details.NotAfter,
+		nc:       nc,
+	}
+
+	// The fingerprint can only fail to compute for a certificate that could not have been parsed
+	c.Fingerprint, _ = nc.Sha256Sum()
+
+	if c.Groups == nil {
+		c.Groups = []string{}
+	}
+
+	for _, ip := range nc.Details.Ips {
+		c.Ips = append(c.Ips, ip.String())
+	}
+
+	return c
+}
+
+// auditStatus classifies a certificate, the returned error explains any status other than valid or expiring
+func auditStatus(nc *cert.NebulaCertificate, now time.Time, expiresWithin time.Duration, caPool *cert.NebulaCAPool) (string, error) {
+	if caPool.IsBlocklisted(nc) {
+		return auditBlocklisted, fmt.Errorf("certificate has been blocked")
+	}
+
+	if caPool.IsRevoked(nc) {
+		return auditRevoked, fmt.Errorf("certificate has been revoked")
+	}
+
+	if nc.Details.IsCA && nc.Details.Issuer == "" {
+		// Roots can not be verified against the pool, they either are a member of it or not
+		fp, _ := nc.Sha256Sum()
+		if _, ok := caPool.CAs[fp]; !ok {
+			return auditOrphaned, fmt.Errorf("root certificate is not in the ca pool")
+		}
+	} else if _, err := caPool.GetCAChainForCert(nc); err != nil {
+		return auditOrphaned, err
+	}
+
+	if nc.Expired(now) {
+		if nc.Details.NotBefore.After(now) {
+			return auditExpired, fmt.Errorf("certificate is not valid until %s", nc.Details.NotBefore)
+		}
+		return auditExpired, fmt.Errorf("certificate expired at %s", nc.Details.NotAfter)
+	}
+
+	if !nc.Details.IsCA || nc.Details.Issuer != "" {
+		if _, err := nc.Verify(now, caPool); err != nil {
+			return auditInvalid, err
+		}
+	}
+
+	if nc.Details.NotAfter.Before(now.Add(expiresWithin)) {
+		return auditExpiring, nil
+	}
+
+	return auditValid, nil
+}
+
+// auditUsable returns true if the certificate would be accepted by a nebula host today
+func auditUsable(c *auditCert) bool {
+	return c.Status == auditValid || c.Status == auditExpiring
+}
+
+func auditHasGroup(c *auditCert, group string) bool {
+	for _, g := range c.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// auditIpCollisions finds ips held by usable host certificates with different names. A renewed certificate still
+// lying next to its replacement carries the same name and is not a collision.
+func auditIpCollisions(certs []*auditCert) []auditIpCollision {
+	holders := map[string][]*auditCert{}
+	for _, c := range certs {
+		if c.IsCA || !auditUsable(c) {
+			continue
+		}
+
+		for _, ip := range c.nc.Details.Ips {
+			holders[ip.IP.String()] = append(holders[ip.IP.String()], c)
+		}
+	}
+
+	collisions := []auditIpCollision{}
+	for ip, cs := range holders {
+		names := map[string]struct{}{}
+		paths := make([]string, 0, len(cs))
+		for _, c := range cs {
+			names[c.Name] = struct{}{}
+			paths = append(paths, c.Path)
+		}
+
+		if len(names) > 1 {
+			sort.Strings(paths)
+			collisions = append(collisions, auditIpCollision{Ip: ip, Certificates: paths})
+		}
+	}
+
+	sort.Slice(collisions, func(i, j int) bool {
+		return collisions[i].Ip < collisions[j].Ip
+	})
+
+	return collisions
+}
+
+// auditGroups maps every group to the names of the usable host certificates that are a member of it
+func auditGroups(certs []*auditCert) map[string][]string {
+	members := map[string]map[string]struct{}{}
+	for _, c := range certs {
+		if c.IsCA || !auditUsable(c) {
+			continue
+		}
+
+		for _, g := range c.Groups {
+			if members[g] == nil {
+				members[g] = map[string]struct{}{}
+			}
+			members[g][c.Name] = struct{}{}
+		}
+	}
+
+	groups := make(map[string][]string, len(members))
+	for g, names := range members {
+		for name := range names {
+			groups[g] = append(groups[g], name)
+		}
+		sort.Strings(groups[g])
+	}
+
+	return groups
+}
+
+func writeAuditTable(out io.Writer, report auditReport) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tNOT AFTER\tNAME\tIPS\tGROUPS\tPATH\tERROR")
+	for _, c := range report.Certificates {
+		notAfter := "-"
+		if c.nc != nil {
+			notAfter = c.NotAfter.Format(time.RFC3339)
+		}
+
+		name := c.Name
+		if c.IsCA {
+			name += " (ca)"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Status, notAfter, name, strings.Join(c.Ips, ","), strings.Join(c.Groups, ","), c.Path, c.Error)
+	}
+
+	if len(report.IpCollisions) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "IP COLLISION\tCERTIFICATES")
+		for _, ic := range report.IpCollisions {
+			fmt.Fprintf(tw, "%s\t%s\n", ic.Ip, strings.Join(ic.Certificates, ","))
+		}
+	}
+
+	return tw.Flush()
+}
+
+func auditSummary() string {
+	return "audit <flags>: reports on the expiry, validity, ip collisions and groups of a directory of certificates"
+}
+
+func auditHelp(out io.Writer) {
+	af := newAuditFlags()
+	out.Write([]byte("Usage of " + os.Args[0] + " " + auditSummary() + "\n"))
+	af.set.SetOutput(out)
+	af.set.PrintDefaults()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_auditSummary(t *testing.T) {
+	assert.Equal(t, "audit <flags>: reports on the expiry, validity, ip collisions and groups of a directory of certificates", auditSummary())
+}
+
+func Test_auditHelp(t *testing.T) {
+	ob := &bytes.Buffer{}
+	auditHelp(ob)
+	assert.Equal(
+		t,
+		"Usage of "+os.Args[0]+" audit <flags>: reports on the expiry, validity, ip collisions and groups of a directory of certificates\n"+
+			"  -blocklist string\n"+
+			"    \tOptional: comma separated list of blocklisted certificate fingerprints\n"+
+			"  -ca string\n"+
+			"    \tRequired: path to a file containing one or more ca certificates\n"+
+			"  -crl string\n"+
+			"    \tOptional: path to a file containing revocation lists to check certificates against\n"+
+			"  -expires-within duration\n"+
+			"    \tOptional: certificates expiring within this window are reported as expiring. Valid time units are seconds: \"s\", minutes: \"m\", hours: \"h\" (default 720h0m0s)\n"+
+			"  -group string\n"+
+			"    \tOptional: only report certificates that are a member of this group\n"+
+			"  -json\n"+
+			"    \tOptional: outputs the report in json format\n"+
+			"  -path string\n"+
+			"    \tRequired: path to a directory of certificates, it is searched recursively\n",
+		ob.String(),
+	)
+}
+
+func Test_audit(t *testing.T) {
+	nopw := &StubPasswordReader{}
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	dir, err := ioutil.TempDir("", "nebula-cert-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// required args
+	assertHelpError(t, audit([]string{"-ca", p("ca.crt")}, ob, eb), "-path is required")
+	assertHelpError(t, audit([]string{"-path", p("certs")}, ob, eb), "-ca is required")
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	// bad ca
+	assert.EqualError(t, audit([]string{"-path", p("certs"), "-ca", p("nope.crt")}, ob, eb), "error while reading ca: open "+p("nope.crt")+": "+NoSuchFileError)
+
+	assert.Nil(t, os.MkdirAll(p("certs/old"), 0700))
+	assert.Nil(t, ca([]string{"-name", "ca", "-duration", "10000h", "-out-crt", p("ca.crt"), "-out-key", p("ca.key")}, ob, eb, nopw))
+	assert.Nil(t, ca([]string{"-name", "other", "-duration", "10000h", "-out-crt", p("other.crt"), "-out-key", p("other.key")}, ob, eb, nopw))
+
+	// bad path
+	assert.EqualError(t, audit([]string{"-path", p("nope"), "-ca", p("ca.crt")}, ob, eb), "error while reading path: lstat "+p("nope")+": "+NoSuchFileError)
+
+	sign := func(caName, name, ip, groups, duration string) {
+		args := []string{"-ca-crt", p(caName + ".crt"), "-ca-key", p(caName + ".key"), "-name", name, "-ip", ip, "-groups", groups, "-duration", duration, "-out-crt", p("certs/" + name + ".crt"), "-out-key", p("certs/" + name + ".key")}
+		assert.Nil(t, signCert(args, ob, eb, nopw))
+	}
+	sign("ca", "a", "10.0.0.1/24", "web,ops", "9000h")
+	sign("ca", "b", "10.0.0.2/24", "web", "100h")
+	sign("ca", "c", "10.0.0.1/24", "db", "9000h")
+	sign("ca", "blocked", "10.0.0.4/24", "web", "9000h")
+	sign("other", "orphan", "10.0.0.5/24", "web", "9000h")
+
+	// an expired copy of a sits in a sub directory and does not collide with anyone
+	rb, _ := ioutil.ReadFile(p("ca.key"))
+	caKey, _, _ := cert.UnmarshalEd25519PrivateKey(rb)
+	rb, _ = ioutil.ReadFile(p("certs/a.crt"))
+	expired, _, _ := cert.UnmarshalNebulaCertificateFromPEM(rb)
+	expired.Details.Name = "d"
+	expired.Details.NotBefore = time.Now().Add(-2 * time.Hour)
+	expired.Details.NotAfter = time.Now().Add(-time.Hour)
+	assert.Nil(t, expired.Sign(caKey))
+	b, _ := expired.MarshalToPEM()
+	assert.Nil(t, ioutil.WriteFile(p("certs/old/d.crt"), b, 0600))
+
+	// a broken cert and a file that is not a cert at all
+	assert.Nil(t, ioutil.WriteFile(p("certs/broken.crt"), []byte("-----BEGIN NEBULA CERTIFICATE-----\nAAAA\n-----END NEBULA CERTIFICATE-----\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(p("certs/README"), []byte("hello"), 0600))
+
+	rb, _ = ioutil.ReadFile(p("certs/blocked.crt"))
+	blocked, _, _ := cert.UnmarshalNebulaCertificateFromPEM(rb)
+	blockedFp, _ := blocked.Sha256Sum()
+
+	ob.Reset()
+	assert.Nil(t, audit([]string{"-path", p("certs"), "-ca", p("ca.crt"), "-blocklist", blockedFp, "-json"}, ob, eb))
+	assert.Empty(t, eb.String())
+
+	var report auditReport
+	assert.Nil(t, json.Unmarshal(ob.Bytes(), &report))
+	status := map[string]string{}
+	for _, c := range report.Certificates {
+		status[c.Path] = c.Status
+	}
+	assert.Equal(t, map[string]string{
+		p("certs/broken.crt"):  auditInvalid,
+		p("certs/old/d.crt"):   auditExpired,
+		p("certs/b.crt"):       auditExpiring,
+		p("certs/a.crt"):       auditValid,
+		p("certs/c.crt"):       auditValid,
+		p("certs/blocked.crt"): auditBlocklisted,
+		p("certs/orphan.crt"):  auditOrphaned,
+	}, status)
+
+	// soonest to expire first
+	assert.Equal(t, p("certs/broken.crt"), report.Certificates[0].Path)
+	assert.Equal(t, p("certs/old/d.crt"), report.Certificates[1].Path)
+	assert.Equal(t, p("certs/b.crt"), report.Certificates[2].Path)
+	assert.Equal(t, "could not find ca for the certificate", report.Certificates[6].Error)
+
+	assert.Equal(t, []auditIpCollision{{Ip: "10.0.0.1", Certificates: []string{p("certs/a.crt"), p("certs/c.crt")}}}, report.IpCollisions)
+	assert.Equal(t, map[string][]string{"web": {"a", "b"}, "ops": {"a"}, "db": {"c"}}, report.Groups)
+
+	// filtering by group
+	ob.Reset()
+	assert.Nil(t, audit([]string{"-path", p("certs"), "-ca", p("ca.crt"), "-group", "ops", "-json"}, ob, eb))
+	report = auditReport{}
+	assert.Nil(t, json.Unmarshal(ob.Bytes(), &report))
+	assert.Len(t, report.Certificates, 2)
+	assert.Equal(t, "d", report.Certificates[0].Name)
+	assert.Equal(t, "a", report.Certificates[1].Name)
+	assert.Equal(t, []string{"10.0.0.1/24"}, report.Certificates[1].Ips)
+
+	// a wider window catches more certs
+	ob.Reset()
+	assert.Nil(t, audit([]string{"-path", p("certs"), "-ca", p("ca.crt"), "-expires-within", "9500h", "-group", "db", "-json"}, ob, eb))
+	report = auditReport{}
+	assert.Nil(t, json.Unmarshal(ob.Bytes(), &report))
+	assert.Equal(t, auditExpiring, report.Certificates[0].Status)
+
+	// revoked certs
+	assert.Nil(t, crl([]string{"-ca-crt", p("ca.crt"), "-ca-key", p("ca.key"), "-crt", p("certs/c.crt"), "-out-crl", p("ca.crl")}, ob, eb, nopw))
+	ob.Reset()
+	assert.Nil(t, audit([]string{"-path", p("certs"), "-ca", p("ca.crt"), "-crl", p("ca.crl"), "-group", "db", "-json"}, ob, eb))
+	report = auditReport{}
+	assert.Nil(t, json.Unmarshal(ob.Bytes(), &report))
+	assert.Equal(t, auditRevoked, report.Certificates[0].Status)
+	assert.Empty(t, report.IpCollisions)
+
+	// the table lists every cert and the collisions
+	ob.Reset()
+	assert.Nil(t, audit([]string{"-path", p("certs"), "-ca", p("ca.crt")}, ob, eb))
+	lines := strings.Split(strings.TrimSpace(ob.String()), "\n")
+	assert.Len(t, lines, 11)
+	assert.True(t, strings.HasPrefix(lines[0], "STATUS"))
+	assert.True(t, strings.HasPrefix(lines[1], "invalid"))
+	assert.True(t, strings.HasPrefix(lines[9], "IP COLLISION"))
+	assert.True(t, strings.HasPrefix(lines[10], "10.0.0.1 "))
+	assert.Empty(t, eb.String())
+
+	// a ca bundle in the tree reports every ca on its own, roots outside the pool are orphaned
+	caPEM, _ := ioutil.ReadFile(p("ca.crt"))
+	otherPEM, _ := ioutil.ReadFile(p("other.crt"))
+	bundle := append(caPEM, otherPEM...)
+	assert.Nil(t, ioutil.WriteFile(p("certs/old/bundle.crt"), bundle, 0600))
+	ob.Reset()
+	assert.Nil(t, audit([]string{"-path", p("certs/old"), "-ca", p("ca.crt"), "-json"}, ob, eb))
+	report = auditReport{}
+	assert.Nil(t, json.Unmarshal(ob.Bytes(), &report))
+	assert.Len(t, report.Certificates, 3)
+	status = map[string]string{}
+	for _, c := range report.Certificates {
+		status[c.Name] = c.Status
+	}
+	assert.Equal(t, map[string]string{"d": auditExpired, "ca": auditValid, "other": auditOrphaned}, status)
+}
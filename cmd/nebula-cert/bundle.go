@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/slackhq/nebula/cert"
+	"gopkg.in/yaml.v2"
+)
+
+// bundleVersion is bumped whenever the bundle layout changes in a way readers must know about
+const bundleVersion = 2
+
+// bundleQRChunkSize keeps each qr code well below the capacity of a medium recovery level code so phones can scan it
+const bundleQRChunkSize = 1024
+
+type bundleFlags struct {
+	set              *flag.FlagSet
+	caKeyPath        *string
+	caCertPath       *string
+	caPassphrasePath *string
+	caPoolPath       *string
+	name             *string
+	ip               *string
+	groups           *string
+	subnets          *string
+	duration         *time.Duration
+	policyPath       *string
+	configPath       *string
+	lighthouses      *string
+	encrypt          *bool
+	passphrasePath   *string
+	outJSONPath      *string
+	outQRPath        *string
+}
+
+func newBundleFlags() *bundleFlags {
+	bf := bundleFlags{set: flag.NewFlagSet("bundle", flag.ContinueOnError)}
+	bf.set.Usage = func() {}
+	bf.caKeyPath = bf.set.String("ca-key", "ca.key", "Optional: path to the signing CA key")
+	bf.caCertPath = bf.set.String("ca-crt", "ca.crt", "Optional: path to the signing CA cert")
+	bf.caPassphrasePath = bf.set.String("ca-passphrase-file", "", "Optional: path to a file holding the passphrase of an encrypted ca-key. The "+caPassphraseEnv+" environment variable is used if not set, otherwise the passphrase is prompted for")
+	bf.caPoolPath = bf.set.String("ca", "", "Optional: path to the ca certificates the device should trust. The default is ca-crt, set this to the root when signing with an intermediate")
+	bf.name = bf.set.String("name", "", "Required: name of the cert, usually a hostname")
+	bf.ip = bf.set.String("ip", "", "Required: ipv4 and/or ipv6 address and network in CIDR notation to assign the cert, separated by a comma")
+	bf.groups = bf.set.String("groups", "", "Optional: comma separated list of groups")
+	bf.subnets = bf.set.String("subnets", "", "Optional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. Subnets this cert can serve for")
+	bf.duration = bf.set.Duration("duration", 0, "Optional: how long the cert should be valid for. The default is 1 second before the signing cert expires. Valid time units are seconds: \"s\", minutes: \"m\", hours: \"h\"")
+	bf.policyPath = bf.set.String("policy", "", "Optional: path to a yaml policy file the certificate must satisfy, see examples/policy.yml")
+	bf.configPath = bf.set.String("config", "", "Optional: path to a yaml config to use as the config template")
+	bf.lighthouses = bf.set.String("lighthouses", "", "Optional: comma separated list of lighthouses as nebula ip=public ip:port, added to the static_host_map and lighthouse.hosts of the config template")
+	bf.encrypt = bf.set.Bool("encrypt", false, "Optional: encrypt the bundle with a passphrase, read from -passphrase-file or prompted for")
+	bf.passphrasePath = bf.set.String("passphrase-file", "", "Optional: path to a file holding the passphrase to encrypt the bundle with")
+	bf.outJSONPath = bf.set.String("out-json", "", "Required: path to write the bundle to")
+	bf.outQRPath = bf.set.String("out-qr", "", "Optional: output the bundle as qr code images (png). The bundle is split in chunks, chunk N is written to the path with -N inserted before the extension")
+	return &bf
+}
+
+// bundleFile is the json written to out-json, exactly one of Bundle or Encrypted is set. Signature is made by the key of
+// the Signer ca over the exact bytes of whichever one is set, so a reader can check it before parsing or decrypting.
+type bundleFile struct {
+	Version   int             `json:"version"`
+	Signer    string          `json:"signer"`
+	Signature []byte          `json:"signature"`
+	Bundle    json.RawMessage `json:"bundle,omitempty"`
+	Encrypted []byte          `json:"encrypted,omitempty"`
+}
+
+// payload returns the bytes covered by the signature
+func (bf *bundleFile) payload() []byte {
+	if bf.Encrypted != nil {
+		return bf.Encrypted
+	}
+	return bf.Bundle
+}
+
+// bundleContents holds everything a device needs to join the network, Encrypted holds it marshalled to json
+type bundleContents struct {
+	Crt    string `json:"crt"`
+	Key    string `json:"key"`
+	CA     string `json:"ca"`
+	Config string `json:"config,omitempty"`
+}
+
+func bundle(args []string, out io.Writer, errOut io.Writer, pr PasswordReader) error {
+	bf := newBundleFlags()
+	err := bf.set.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := mustFlagString("ca-key", bf.caKeyPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("ca-crt", bf.caCertPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("name", bf.name); err != nil {
+		return err
+	}
+	if err := mustFlagString("ip", bf.ip); err != nil {
+		return err
+	}
+	if err := mustFlagString("out-json", bf.outJSONPath); err != nil {
+		return err
+	}
+
+	if !*bf.encrypt && *bf.passphrasePath != "" {
+		return newHelpErrorf("-passphrase-file requires -encrypt")
+	}
+
+	if _, err := os.Stat(*bf.outJSONPath); err == nil {
+		return fmt.Errorf("refusing to overwrite existing bundle: %s", *bf.outJSONPath)
+	}
+
+	config, err := bundleConfig(*bf.configPath, *bf.lighthouses)
+	if err != nil {
+		return err
+	}
+
+	var policy *signPolicy
+	if *bf.policyPath != "" {
+		policy, err = loadSignPolicy(*bf.policyPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	caPoolPath := *bf.caPoolPath
+	if caPoolPath == "" {
+		caPoolPath = *bf.caCertPath
+	}
+
+	caPool, err := loadCAPool(caPoolPath)
+	if err != nil {
+		return err
+	}
+
+	caCert, caKey, issuer, err := loadSigningCA(*bf.caKeyPath, *bf.caCertPath, *bf.caPassphrasePath, errOut, pr)
+	if err != nil {
+		return err
+	}
+
+	rh := rawManifestHost{
+		Name:    *bf.name,
+		Ip:      *bf.ip,
+		Groups:  strings.Split(*bf.groups, ","),
+		Subnets: strings.Split(*bf.subnets, ","),
+	}
+	h, err := newBatchHost(rh, "", caCert, issuer, *bf.duration)
+	if err != nil {
+		return err
+	}
+
+	if err := issueCert(h.nc, caCert, caKey, policy); err != nil {
+		return err
+	}
+
+	// A device that does not trust the issuer of its own cert can never complete a handshake
+	if _, err := h.nc.Verify(time.Now(), caPool); err != nil {
+		return fmt.Errorf("signed cert does not verify against the ca pool: %s", err)
+	}
+
+	rawCert, err := h.nc.MarshalToPEMWithChain()
+	if err != nil {
+		return fmt.Errorf("error while marshalling certificate: %s", err)
+	}
+
+	rawCAs, err := ioutil.ReadFile(caPoolPath)
+	if err != nil {
+		return fmt.Errorf("error while reading ca: %s", err)
+	}
+
+	contents := &bundleContents{
+		Crt:    string(rawCert),
+		Key:    string(cert.MarshalPrivateKey(caCert.Details.Curve, h.rawPriv)),
+		CA:     string(rawCAs),
+		Config: config,
+	}
+
+	rawSigner, err := caCert.MarshalToPEMWithChain()
+	if err != nil {
+		return fmt.Errorf("error while marshalling ca-crt: %s", err)
+	}
+
+	bundle := bundleFile{Version: bundleVersion, Signer: string(rawSigner)}
+	bundle.Bundle, err = json.Marshal(contents)
+	if err != nil {
+		return fmt.Errorf("error while marshalling bundle: %s", err)
+	}
+
+	if *bf.encrypt {
+		passphrase, err := readPassphrase(*bf.passphrasePath, true, errOut, pr)
+		if err != nil {
+			return err
+		}
+
+		// The bundle is decrypted on phones, keep the argon2 memory cost well within what they can spare
+		bundle.Encrypted, err = cert.EncryptAndMarshalData(bundle.Bundle, passphrase, cert.NewArgon2Parameters(64*1024, 4, 3))
+		if err != nil {
+			return fmt.Errorf("error while encrypting bundle: %s", err)
+		}
+		bundle.Bundle = nil
+	}
+
+	bundle.Signature, err = caCert.SignData(caKey, bundle.payload())
+	if err != nil {
+		return fmt.Errorf("error while signing bundle: %s", err)
+	}
+
+	b, err := json.Marshal(bundle)
+	if err != nil {
+		return fmt.Errorf("error while marshalling bundle: %s", err)
+	}
+
+	err = ioutil.WriteFile(*bf.outJSONPath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("error while writing out-json: %s", err)
+	}
+
+	if *bf.outQRPath != "" {
+		chunks := bundleQRChunks(b)
+		for i, chunk := range chunks {
+			qr, err := qrcode.Encode(chunk, qrcode.Medium, -5)
+			if err != nil {
+				return fmt.Errorf("error while generating qr code: %s", err)
+			}
+
+			err = ioutil.WriteFile(bundleQRPath(*bf.outQRPath, i+1), qr, 0600)
+			if err != nil {
+				return fmt.Errorf("error while writing out-qr: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// bundleConfig loads the config template, if any, and adds the lighthouses to it
+func bundleConfig(configPath string, lighthouses string) (string, error) {
+	config := map[interface{}]interface{}{}
+	if configPath != "" {
+		b, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			return "", fmt.Errorf("error while reading config: %s", err)
+		}
+
+		if err := yaml.Unmarshal(b, &config); err != nil {
+			return "", fmt.Errorf("error while parsing config: %s", err)
+		}
+	}
+
+	if strings.TrimSpace(lighthouses) != "" {
+		staticHostMap, ok := config["static_host_map"].(map[interface{}]interface{})
+		if !ok {
+			staticHostMap = map[interface{}]interface{}{}
+		}
+
+		lighthouse, ok := config["lighthouse"].(map[interface{}]interface{})
+		if !ok {
+			lighthouse = map[interface{}]interface{}{}
+		}
+
+		hosts, _ := lighthouse["hosts"].([]interface{})
+		for _, rl := range strings.Split(lighthouses, ",") {
+			rl = strings.TrimSpace(rl)
+			if rl == "" {
+				continue
+			}
+
+			vpnIp, addr, err := parseBundleLighthouse(rl)
+			if err != nil {
+				return "", err
+			}
+
+			addrs, _ := staticHostMap[vpnIp].([]interface{})
+			if addrs == nil {
+				hosts = append(hosts, vpnIp)
+			}
+			staticHostMap[vpnIp] = append(addrs, addr)
+		}
+
+		lighthouse["hosts"] = hosts
+		config["static_host_map"] = staticHostMap
+		config["lighthouse"] = lighthouse
+	}
+
+	if len(config) == 0 {
+		return "", nil
+	}
+
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("error while marshalling config: %s", err)
+	}
+
+	return string(b), nil
+}
+
+// parseBundleLighthouse splits a nebula ip=public ip:port lighthouse definition
+func parseBundleLighthouse(s string) (string, string, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return "", "", newHelpErrorf("invalid lighthouse definition: %s, should be nebula ip=public ip:port", s)
+	}
+
+	vpnIp := net.ParseIP(strings.TrimSpace(parts[0]))
+	if vpnIp == nil {
+		return "", "", newHelpErrorf("invalid lighthouse definition: %s, %s is not an ip", s, parts[0])
+	}
+
+	addr := strings.TrimSpace(parts[1])
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", newHelpErrorf("invalid lighthouse definition: %s, %s", s, err)
+	}
+
+	return vpnIp.String(), addr, nil
+}
+
+// bundleQRChunks splits the bundle into pieces that fit a qr code. Every piece is prefixed with its position so a
+// reader can scan them in any order: "NEBULA BUNDLE 1/3\n"
+func bundleQRChunks(b []byte) []string {
+	total := (len(b) + bundleQRChunkSize - 1) / bundleQRChunkSize
+	chunks := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * bundleQRChunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		chunks = append(chunks, fmt.Sprintf("NEBULA BUNDLE %d/%d\n%s", i+1, total, b[i*bundleQRChunkSize:end]))
+	}
+
+	return chunks
+}
+
+// bundleQRPath inserts the chunk number before the extension of path, bundle.png becomes bundle-1.png
+func bundleQRPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+func bundleSummary() string {
+	return "bundle <flags>: create and sign a certificate and package it with its key, the ca and a config template for onboarding a device"
+}
+
+func bundleHelp(out io.Writer) {
+	bf := newBundleFlags()
+	out.Write([]byte("Usage of " + os.Args[0] + " " + bundleSummary() + "\n"))
+	bf.set.SetOutput(out)
+	bf.set.PrintDefaults()
+}
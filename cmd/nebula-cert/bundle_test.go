@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_bundleSummary(t *testing.T) {
+	assert.Equal(t, "bundle <flags>: create and sign a certificate and package it with its key, the ca and a config template for onboarding a device", bundleSummary())
+}
+
+func Test_bundleHelp(t *testing.T) {
+	ob := &bytes.Buffer{}
+	bundleHelp(ob)
+	assert.Equal(
+		t,
+		"Usage of "+os.Args[0]+" bundle <flags>: create and sign a certificate and package it with its key, the ca and a config template for onboarding a device\n"+
+			"  -ca string\n"+
+			"    \tOptional: path to the ca certificates the device should trust. The default is ca-crt, set this to the root when signing with an intermediate\n"+
+			"  -ca-crt string\n"+
+			"    \tOptional: path to the signing CA cert (default \"ca.crt\")\n"+
+			"  -ca-key string\n"+
+			"    \tOptional: path to the signing CA key (default \"ca.key\")\n"+
+			"  -ca-passphrase-file string\n"+
+			"    \tOptional: path to a file holding the passphrase of an encrypted ca-key. The "+caPassphraseEnv+" environment variable is used if not set, otherwise the passphrase is prompted for\n"+
+			"  -config string\n"+
+			"    \tOptional: path to a yaml config to use as the config template\n"+
+			"  -duration duration\n"+
+			"    \tOptional: how long the cert should be valid for. The default is 1 second before the signing cert expires. Valid time units are seconds: \"s\", minutes: \"m\", hours: \"h\"\n"+
+			"  -encrypt\n"+
+			"    \tOptional: encrypt the bundle with a passphrase, read from -passphrase-file or prompted for\n"+
+			"  -groups string\n"+
+			"    \tOptional: comma separated list of groups\n"+
+			"  -ip string\n"+
+			"    \tRequired: ipv4 and/or ipv6 address and network in CIDR notation to assign the cert, separated by a comma\n"+
+			"  -lighthouses string\n"+
+			"    \tOptional: comma separated list of lighthouses as nebula ip=public ip:port, added to the static_host_map and lighthouse.hosts of the config template\n"+
+			"  -name string\n"+
+			"    \tRequired: name of the cert, usually a hostname\n"+
+			"  -out-json string\n"+
+			"    \tRequired: path to write the bundle to\n"+
+			"  -out-qr string\n"+
+			"    \tOptional: output the bundle as qr code images (png). The bundle is split in chunks, chunk N is written to the path with -N inserted before the extension\n"+
+			"  -passphrase-file string\n"+
+			"    \tOptional: path to a file holding the passphrase to encrypt the bundle with\n"+
+			"  -policy string\n"+
+			"    \tOptional: path to a yaml policy file the certificate must satisfy, see examples/policy.yml\n"+
+			"  -subnets string\n"+
+			"    \tOptional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. Subnets this cert can serve for\n",
+		ob.String(),
+	)
+}
+
+func Test_bundleQRChunks(t *testing.T) {
+	b := bytes.Repeat([]byte("a"), bundleQRChunkSize*2+1)
+	chunks := bundleQRChunks(b)
+	assert.Len(t, chunks, 3)
+	assert.Equal(t, "NEBULA BUNDLE 1/3\n"+string(b[:bundleQRChunkSize]), chunks[0])
+	assert.Equal(t, "NEBULA BUNDLE 3/3\na", chunks[2])
+
+	assert.Equal(t, "out/bundle-2.png", bundleQRPath("out/bundle.png", 2))
+	assert.Equal(t, "bundle-1", bundleQRPath("bundle", 1))
+}
+
+func Test_bundle(t *testing.T) {
+	nopw := &StubPasswordReader{}
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	dir, err := ioutil.TempDir("", "nebula-cert-bundle")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// required args
+	assertHelpError(t, bundle([]string{"-ip", "10.0.0.2/24", "-out-json", p("b.json")}, ob, eb, nopw), "-name is required")
+	assertHelpError(t, bundle([]string{"-name", "phone", "-out-json", p("b.json")}, ob, eb, nopw), "-ip is required")
+	assertHelpError(t, bundle([]string{"-name", "phone", "-ip", "10.0.0.2/24"}, ob, eb, nopw), "-out-json is required")
+	assertHelpError(t, bundle([]string{"-name", "phone", "-ip", "10.0.0.2/24", "-out-json", p("b.json"), "-passphrase-file", p("pw")}, ob, eb, nopw), "-passphrase-file requires -encrypt")
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	assert.Nil(t, ca([]string{"-name", "ca", "-duration", "10h", "-out-crt", p("ca.crt"), "-out-key", p("ca.key")}, ob, eb, nopw))
+
+	args := func(extra ...string) []string {
+		return append([]string{"-ca-crt", p("ca.crt"), "-ca-key", p("ca.key"), "-name", "phone", "-ip", "10.0.0.2/24", "-groups", "mobile"}, extra...)
+	}
+
+	// bad lighthouses and config
+	assertHelpError(t, bundle(args("-out-json", p("b.json"), "-lighthouses", "10.0.0.1"), ob, eb, nopw), "invalid lighthouse definition: 10.0.0.1, should be nebula ip=public ip:port")
+	assertHelpError(t, bundle(args("-out-json", p("b.json"), "-lighthouses", "nope=1.1.1.1:4242"), ob, eb, nopw), "invalid lighthouse definition: nope=1.1.1.1:4242, nope is not an ip")
+	assertHelpError(t, bundle(args("-out-json", p("b.json"), "-lighthouses", "10.0.0.1=1.1.1.1"), ob, eb, nopw), "invalid lighthouse definition: 10.0.0.1=1.1.1.1, address 1.1.1.1: missing port in address")
+	assert.EqualError(t, bundle(args("-out-json", p("b.json"), "-config", p("nope.yml")), ob, eb, nopw), "error while reading config: open "+p("nope.yml")+": "+NoSuchFileError)
+
+	// a plain bundle with a config template
+	assert.Nil(t, ioutil.WriteFile(p("config.yml"), []byte("listen:\n  port: 0\nlighthouse:\n  interval: 60\n"), 0600))
+	assert.Nil(t, bundle(args("-out-json", p("b.json"), "-config", p("config.yml"), "-lighthouses", "10.0.0.1=1.1.1.1:4242, 10.0.0.1=[::1]:4242,10.0.0.3=3.3.3.3:4242", "-out-qr", p("b.png")), ob, eb, nopw))
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	var bf bundleFile
+	rb, err := ioutil.ReadFile(p("b.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(rb, &bf))
+	assert.Equal(t, bundleVersion, bf.Version)
+	assert.Nil(t, bf.Encrypted)
+
+	// signed by the ca over the bundle as written
+	caPEM, _ := ioutil.ReadFile(p("ca.crt"))
+	assert.Equal(t, string(caPEM), bf.Signer)
+	signer, _, err := cert.UnmarshalNebulaCertificateFromPEM(caPEM)
+	assert.Nil(t, err)
+	assert.True(t, signer.CheckDataSignature(bf.Bundle, bf.Signature))
+
+	var contents bundleContents
+	assert.Nil(t, json.Unmarshal(bf.Bundle, &contents))
+	assert.Equal(t, string(caPEM), contents.CA)
+
+	caPool := cert.NewCAPool()
+	_, err = caPool.AddCACertificate([]byte(contents.CA))
+	assert.Nil(t, err)
+	c, err := cert.UnmarshalNebulaCertificateWithChainFromPEM([]byte(contents.Crt))
+	assert.Nil(t, err)
+	v, err := c.Verify(time.Now(), caPool)
+	assert.True(t, v)
+	assert.Nil(t, err)
+	assert.Equal(t, "phone", c.Details.Name)
+	assert.Equal(t, []string{"mobile"}, c.Details.Groups)
+
+	key, _, _, err := cert.UnmarshalPrivateKey([]byte(contents.Key))
+	assert.Nil(t, err)
+	assert.Nil(t, c.VerifyPrivateKey(key))
+
+	var config map[string]interface{}
+	assert.Nil(t, yaml.Unmarshal([]byte(contents.Config), &config))
+	assert.Equal(t, map[interface{}]interface{}{"port": 0}, config["listen"])
+	assert.Equal(t, map[interface{}]interface{}{"interval": 60, "hosts": []interface{}{"10.0.0.1", "10.0.0.3"}}, config["lighthouse"])
+	assert.Equal(t, map[interface{}]interface{}{"10.0.0.1": []interface{}{"1.1.1.1:4242", "[::1]:4242"}, "10.0.0.3": []interface{}{"3.3.3.3:4242"}}, config["static_host_map"])
+
+	// the qr codes hold the bundle in order
+	chunks := bundleQRChunks(rb)
+	assert.NotEmpty(t, chunks)
+	for i := range chunks {
+		_, err = os.Stat(p(fmt.Sprintf("b-%d.png", i+1)))
+		assert.Nil(t, err)
+	}
+	var joined string
+	for i, chunk := range chunks {
+		header := fmt.Sprintf("NEBULA BUNDLE %d/%d\n", i+1, len(chunks))
+		assert.True(t, strings.HasPrefix(chunk, header))
+		joined += strings.TrimPrefix(chunk, header)
+	}
+	assert.Equal(t, string(rb), joined)
+
+	// never overwrite a bundle
+	assert.EqualError(t, bundle(args("-out-json", p("b.json")), ob, eb, nopw), "refusing to overwrite existing bundle: "+p("b.json"))
+
+	// the device must trust the issuer of its cert
+	assert.Nil(t, ca([]string{"-name", "other", "-duration", "10h", "-out-crt", p("other.crt"), "-out-key", p("other.key")}, ob, eb, nopw))
+	assert.EqualError(t, bundle(args("-out-json", p("o.json"), "-ca", p("other.crt")), ob, eb, nopw), "signed cert does not verify against the ca pool: could not find ca for the certificate")
+
+	// an encrypted bundle without a config
+	assert.Nil(t, ioutil.WriteFile(p("pw"), []byte("DO NOT USE\n"), 0600))
+	assert.Nil(t, bundle(args("-out-json", p("e.json"), "-encrypt", "-passphrase-file", p("pw")), ob, eb, nopw))
+	bf = bundleFile{}
+	rb, _ = ioutil.ReadFile(p("e.json"))
+	assert.Nil(t, json.Unmarshal(rb, &bf))
+	assert.Nil(t, bf.Bundle)
+	assert.NotContains(t, string(rb), "PRIVATE KEY")
+	assert.True(t, signer.CheckDataSignature(bf.Encrypted, bf.Signature))
+
+	_, err = cert.UnmarshalAndDecryptData([]byte("nope"), bf.Encrypted)
+	assert.Equal(t, cert.ErrInvalidPassphrase, err)
+	b, err := cert.UnmarshalAndDecryptData([]byte("DO NOT USE"), bf.Encrypted)
+	assert.Nil(t, err)
+	contents = bundleContents{}
+	assert.Nil(t, json.Unmarshal(b, &contents))
+	assert.Equal(t, string(caPEM), contents.CA)
+	assert.Empty(t, contents.Config)
+
+	// the ca passphrase environment variable is never used for the bundle, prompted passphrases must be confirmed
+	os.Setenv(caPassphraseEnv, "ca secret")
+	defer os.Unsetenv(caPassphraseEnv)
+	pw := &StubPasswordReader{passwords: [][]byte{[]byte("a"), []byte("b")}}
+	assert.EqualError(t, bundle(args("-out-json", p("p.json"), "-encrypt"), ob, eb, pw), "passphrases did not match")
+	assert.Equal(t, "Enter passphrase: \nConfirm passphrase: \n", eb.String())
+	_, err = os.Stat(p("p.json"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net"
 	"os"
 	"strings"
@@ -17,15 +20,24 @@ import (
 )
 
 type caFlags struct {
-	set         *flag.FlagSet
-	name        *string
-	duration    *time.Duration
-	outKeyPath  *string
-	outCertPath *string
-	outQRPath   *string
-	groups      *string
-	ips         *string
-	subnets     *string
+	set              *flag.FlagSet
+	name             *string
+	duration         *time.Duration
+	outKeyPath       *string
+	outCertPath      *string
+	outQRPath        *string
+	groups           *string
+	ips              *string
+	subnets          *string
+	encrypt          *bool
+	passphrasePath   *string
+	argonMemory      *uint
+	argonParallelism *uint
+	argonIterations  *uint
+	caKeyPath        *string
+	caCertPath       *string
+	caPassphrasePath *string
+	curve            *string
 }
 
 func newCaFlags() *caFlags {
@@ -37,12 +49,21 @@ func newCaFlags() *caFlags {
 	cf.outCertPath = cf.set.String("out-crt", "ca.crt", "Optional: path to write the certificate to")
 	cf.outQRPath = cf.set.String("out-qr", "", "Optional: output a qr code image (png) of the certificate")
 	cf.groups = cf.set.String("groups", "", "Optional: comma separated list of groups. This will limit which groups subordinate certs can use")
-	cf.ips = cf.set.String("ips", "", "Optional: comma separated list of ipv4 address and network in CIDR notation. This will limit which ipv4 addresses and networks subordinate certs can use for ip addresses")
-	cf.subnets = cf.set.String("subnets", "", "Optional: comma separated list of ipv4 address and network in CIDR notation. This will limit which ipv4 addresses and networks subordinate certs can use in subnets")
+	cf.ips = cf.set.String("ips", "", "Optional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. This will limit which addresses and networks subordinate certs can use for ip addresses")
+	cf.subnets = cf.set.String("subnets", "", "Optional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. This will limit which addresses and networks subordinate certs can use in subnets")
+	cf.encrypt = cf.set.Bool("encrypt", false, "Optional: encrypt the private key with a passphrase. The passphrase is read from -passphrase-file, the "+caPassphraseEnv+" environment variable or prompted for")
+	cf.passphrasePath = cf.set.String("passphrase-file", "", "Optional: path to a file holding the passphrase to encrypt the private key with")
+	cf.argonMemory = cf.set.Uint("argon-memory", 2*1024*1024, "Optional: argon2 memory parameter (in KiB) used for encrypted private key passphrase")
+	cf.argonParallelism = cf.set.Uint("argon-parallelism", 4, "Optional: argon2 parallelism parameter used for encrypted private key passphrase")
+	cf.argonIterations = cf.set.Uint("argon-iterations", 1, "Optional: argon2 iterations parameter used for encrypted private key passphrase")
+	cf.caKeyPath = cf.set.String("ca-key", "", "Optional: path to the key of a CA to sign an intermediate CA with. The CA is self signed if not set")
+	cf.caCertPath = cf.set.String("ca-crt", "", "Optional (if ca-key is set): path to the cert of the CA to sign an intermediate CA with")
+	cf.caPassphrasePath = cf.set.String("ca-passphrase-file", "", "Optional: path to a file holding the passphrase of an encrypted ca-key")
+	cf.curve = cf.set.String("curve", "25519", "Optional: curve of the CA key, 25519 signs with Ed25519 and P256 signs with ECDSA. An intermediate CA uses the curve of ca-crt")
 	return &cf
 }
 
-func ca(args []string, out io.Writer, errOut io.Writer) error {
+func ca(args []string, out io.Writer, errOut io.Writer, pr PasswordReader) error {
 	cf := newCaFlags()
 	err := cf.set.Parse(args)
 	if err != nil {
@@ -63,6 +84,38 @@ func ca(args []string, out io.Writer, errOut io.Writer) error {
 		return &helpError{"-duration must be greater than 0"}
 	}
 
+	if *cf.caKeyPath != "" || *cf.caCertPath != "" {
+		if err := mustFlagString("ca-key", cf.caKeyPath); err != nil {
+			return err
+		}
+		if err := mustFlagString("ca-crt", cf.caCertPath); err != nil {
+			return err
+		}
+	}
+
+	curve, err := parseCurve(*cf.curve)
+	if err != nil {
+		return err
+	}
+
+	if !*cf.encrypt && *cf.passphrasePath != "" {
+		return newHelpErrorf("-passphrase-file requires -encrypt")
+	}
+
+	var kdfParams *cert.Argon2Parameters
+	if *cf.encrypt {
+		if *cf.argonMemory == 0 || *cf.argonMemory > math.MaxUint32 {
+			return newHelpErrorf("-argon-memory must be greater than 0 and no more than %d KiB", uint32(math.MaxUint32))
+		}
+		if *cf.argonParallelism == 0 || *cf.argonParallelism > math.MaxUint8 {
+			return newHelpErrorf("-argon-parallelism must be greater than 0 and no more than %d", math.MaxUint8)
+		}
+		if *cf.argonIterations == 0 || *cf.argonIterations > math.MaxUint32 {
+			return newHelpErrorf("-argon-iterations must be greater than 0 and no more than %d", uint32(math.MaxUint32))
+		}
+		kdfParams = cert.NewArgon2Parameters(uint32(*cf.argonMemory), uint8(*cf.argonParallelism), uint32(*cf.argonIterations))
+	}
+
 	var groups []string
 	if *cf.groups != "" {
 		for _, rg := range strings.Split(*cf.groups, ",") {
@@ -82,9 +135,6 @@ func ca(args []string, out io.Writer, errOut io.Writer) error {
 				if err != nil {
 					return newHelpErrorf("invalid ip definition: %s", err)
 				}
-				if ip.To4() == nil {
-					return newHelpErrorf("invalid ip definition: can only be ipv4, have %s", rs)
-				}
 
 				ipNet.IP = ip
 				ips = append(ips, ipNet)
@@ -101,32 +151,109 @@ func ca(args []string, out io.Writer, errOut io.Writer) error {
 				if err != nil {
 					return newHelpErrorf("invalid subnet definition: %s", err)
 				}
-				if s.IP.To4() == nil {
-					return newHelpErrorf("invalid subnet definition: can only be ipv4, have %s", rs)
-				}
 				subnets = append(subnets, s)
 			}
 		}
 	}
 
-	pub, rawPriv, err := ed25519.GenerateKey(rand.Reader)
-	if err != nil {
-		return fmt.Errorf("error while generating ed25519 keys: %s", err)
+	// When signing an intermediate the parent key signs, otherwise the new key signs itself
+	var signerCert *cert.NebulaCertificate
+	var signerKey []byte
+	if *cf.caKeyPath != "" {
+		signerKey, err = readCAKey(*cf.caKeyPath, *cf.caPassphrasePath, errOut, pr)
+		if err != nil {
+			return err
+		}
+
+		rawCACert, err := ioutil.ReadFile(*cf.caCertPath)
+		if err != nil {
+			return fmt.Errorf("error while reading ca-crt: %s", err)
+		}
+
+		signerCert, err = cert.UnmarshalNebulaCertificateWithChainFromPEM(rawCACert)
+		if err != nil {
+			return fmt.Errorf("error while parsing ca-crt: %s", err)
+		}
+
+		if !signerCert.Details.IsCA {
+			return fmt.Errorf("refusing to sign, ca-crt is not a CA")
+		}
+
+		if err := signerCert.VerifyPrivateKey(signerKey); err != nil {
+			return fmt.Errorf("refusing to sign, root certificate does not match private key")
+		}
+
+		if signerCert.Expired(time.Now()) {
+			return fmt.Errorf("ca certificate is expired")
+		}
+
+		if flagPassed(cf.set, "curve") && curve != signerCert.Details.Curve {
+			return fmt.Errorf("refusing to sign, -curve %s does not match the ca-crt curve %s", curve, signerCert.Details.Curve)
+		}
+		curve = signerCert.Details.Curve
 	}
 
+	var pub, rawPriv []byte
+	switch curve {
+	case cert.Curve_CURVE25519:
+		pub, rawPriv, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("error while generating ed25519 keys: %s", err)
+		}
+	case cert.Curve_P256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("error while generating ecdsa keys: %s", err)
+		}
+		pub = elliptic.Marshal(key.Curve, key.X, key.Y)
+		rawPriv = key.D.FillBytes(make([]byte, 32))
+	}
+
+	now := time.Now()
 	nc := cert.NebulaCertificate{
 		Details: cert.NebulaCertificateDetails{
 			Name:      *cf.name,
 			Groups:    groups,
 			Ips:       ips,
 			Subnets:   subnets,
-			NotBefore: time.Now(),
-			NotAfter:  time.Now().Add(*cf.duration),
+			NotBefore: now,
+			NotAfter:  now.Add(*cf.duration),
 			PublicKey: pub,
 			IsCA:      true,
+			Curve:     curve,
 		},
 	}
 
+	if signerCert != nil {
+		// Unless a duration was asked for, expire one second before the signing CA
+		if !flagPassed(cf.set, "duration") && nc.Details.NotAfter.After(signerCert.Details.NotAfter) {
+			nc.Details.NotAfter = signerCert.Details.NotAfter.Add(-time.Second)
+		}
+
+		nc.Details.Issuer, err = signerCert.Sha256Sum()
+		if err != nil {
+			return fmt.Errorf("error while getting -ca-crt fingerprint: %s", err)
+		}
+
+		if err := nc.CheckRootConstrains(signerCert); err != nil {
+			return fmt.Errorf("refusing to sign, root certificate constraints violated: %s", err)
+		}
+
+		if err := nc.CheckInheritedConstrains(signerCert.Chain); err != nil {
+			return fmt.Errorf("refusing to sign, root certificate constraints violated: %s", err)
+		}
+
+		// An intermediate is never looser than the ca that signs it, otherwise its certificates would carry groups,
+		// ips or subnets the rest of the chain does not allow
+		if err := nc.CheckIntermediateConstrains(signerCert); err != nil {
+			return fmt.Errorf("refusing to sign, %s", err)
+		}
+
+		nc.Chain = issuedChain(signerCert)
+	} else {
+		signerKey = rawPriv
+	}
+
 	if _, err := os.Stat(*cf.outKeyPath); err == nil {
 		return fmt.Errorf("refusing to overwrite existing CA key: %s", *cf.outKeyPath)
 	}
@@ -135,17 +262,30 @@ func ca(args []string, out io.Writer, errOut io.Writer) error {
 		return fmt.Errorf("refusing to overwrite existing CA cert: %s", *cf.outCertPath)
 	}
 
-	err = nc.Sign(rawPriv)
+	err = nc.Sign(signerKey)
 	if err != nil {
 		return fmt.Errorf("error while signing: %s", err)
 	}
 
-	err = ioutil.WriteFile(*cf.outKeyPath, cert.MarshalEd25519PrivateKey(rawPriv), 0600)
+	b := cert.MarshalSigningPrivateKey(curve, rawPriv)
+	if kdfParams != nil {
+		passphrase, err := readCAPassphrase(*cf.passphrasePath, true, errOut, pr)
+		if err != nil {
+			return err
+		}
+
+		b, err = cert.EncryptAndMarshalSigningPrivateKey(curve, rawPriv, passphrase, kdfParams)
+		if err != nil {
+			return fmt.Errorf("error while encrypting out-key: %s", err)
+		}
+	}
+
+	err = ioutil.WriteFile(*cf.outKeyPath, b, 0600)
 	if err != nil {
 		return fmt.Errorf("error while writing out-key: %s", err)
 	}
 
-	b, err := nc.MarshalToPEM()
+	b, err = nc.MarshalToPEMWithChain()
 	if err != nil {
 		return fmt.Errorf("error while marshalling certificate: %s", err)
 	}
@@ -171,7 +311,7 @@ func ca(args []string, out io.Writer, errOut io.Writer) error {
 }
 
 func caSummary() string {
-	return "ca <flags>: create a self signed certificate authority"
+	return "ca <flags>: create a self signed certificate authority, or an intermediate certificate authority signed by another"
 }
 
 func caHelp(out io.Writer) {
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -17,7 +18,7 @@ import (
 //TODO: test file permissions
 
 func Test_caSummary(t *testing.T) {
-	assert.Equal(t, "ca <flags>: create a self signed certificate authority", caSummary())
+	assert.Equal(t, "ca <flags>: create a self signed certificate authority, or an intermediate certificate authority signed by another", caSummary())
 }
 
 func Test_caHelp(t *testing.T) {
@@ -25,13 +26,29 @@ func Test_caHelp(t *testing.T) {
 	caHelp(ob)
 	assert.Equal(
 		t,
-		"Usage of "+os.Args[0]+" ca <flags>: create a self signed certificate authority\n"+
+		"Usage of "+os.Args[0]+" ca <flags>: create a self signed certificate authority, or an intermediate certificate authority signed by another\n"+
+			"  -argon-iterations uint\n"+
+			"    \tOptional: argon2 iterations parameter used for encrypted private key passphrase (default 1)\n"+
+			"  -argon-memory uint\n"+
+			"    \tOptional: argon2 memory parameter (in KiB) used for encrypted private key passphrase (default 2097152)\n"+
+			"  -argon-parallelism uint\n"+
+			"    \tOptional: argon2 parallelism parameter used for encrypted private key passphrase (default 4)\n"+
+			"  -ca-crt string\n"+
+			"    \tOptional (if ca-key is set): path to the cert of the CA to sign an intermediate CA with\n"+
+			"  -ca-key string\n"+
+			"    \tOptional: path to the key of a CA to sign an intermediate CA with. The CA is self signed if not set\n"+
+			"  -ca-passphrase-file string\n"+
+			"    \tOptional: path to a file holding the passphrase of an encrypted ca-key\n"+
+			"  -curve string\n"+
+			"    \tOptional: curve of the CA key, 25519 signs with Ed25519 and P256 signs with ECDSA. An intermediate CA uses the curve of ca-crt (default \"25519\")\n"+
 			"  -duration duration\n"+
 			"    \tOptional: amount of time the certificate should be valid for. Valid time units are seconds: \"s\", minutes: \"m\", hours: \"h\" (default 8760h0m0s)\n"+
+			"  -encrypt\n"+
+			"    \tOptional: encrypt the private key with a passphrase. The passphrase is read from -passphrase-file, the NEBULA_CA_PASSPHRASE environment variable or prompted for\n"+
 			"  -groups string\n"+
 			"    \tOptional: comma separated list of groups. This will limit which groups subordinate certs can use\n"+
 			"  -ips string\n"+
-			"    \tOptional: comma separated list of ipv4 address and network in CIDR notation. This will limit which ipv4 addresses and networks subordinate certs can use for ip addresses\n"+
+			"    \tOptional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. This will limit which addresses and networks subordinate certs can use for ip addresses\n"+
 			"  -name string\n"+
 			"    \tRequired: name of the certificate authority\n"+
 			"  -out-crt string\n"+
@@ -40,28 +57,32 @@ func Test_caHelp(t *testing.T) {
 			"    \tOptional: path to write the private key to (default \"ca.key\")\n"+
 			"  -out-qr string\n"+
 			"    \tOptional: output a qr code image (png) of the certificate\n"+
+			"  -passphrase-file string\n"+
+			"    \tOptional: path to a file holding the passphrase to encrypt the private key with\n"+
 			"  -subnets string\n"+
-			"    \tOptional: comma separated list of ipv4 address and network in CIDR notation. This will limit which ipv4 addresses and networks subordinate certs can use in subnets\n",
+			"    \tOptional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. This will limit which addresses and networks subordinate certs can use in subnets\n",
 		ob.String(),
 	)
 }
 
 func Test_ca(t *testing.T) {
+	nopw := &StubPasswordReader{}
+
 	ob := &bytes.Buffer{}
 	eb := &bytes.Buffer{}
 
 	// required args
-	assertHelpError(t, ca([]string{"-out-key", "nope", "-out-crt", "nope", "duration", "100m"}, ob, eb), "-name is required")
+	assertHelpError(t, ca([]string{"-out-key", "nope", "-out-crt", "nope", "duration", "100m"}, ob, eb, nopw), "-name is required")
 	assert.Equal(t, "", ob.String())
 	assert.Equal(t, "", eb.String())
 
-	// ipv4 only ips
-	assertHelpError(t, ca([]string{"-name", "ipv6", "-ips", "100::100/100"}, ob, eb), "invalid ip definition: can only be ipv4, have 100::100/100")
+	// bad ips
+	assertHelpError(t, ca([]string{"-name", "ipv6", "-ips", "100::100/129"}, ob, eb, nopw), "invalid ip definition: invalid CIDR address: 100::100/129")
 	assert.Equal(t, "", ob.String())
 	assert.Equal(t, "", eb.String())
 
-	// ipv4 only subnets
-	assertHelpError(t, ca([]string{"-name", "ipv6", "-subnets", "100::100/100"}, ob, eb), "invalid subnet definition: can only be ipv4, have 100::100/100")
+	// bad subnets
+	assertHelpError(t, ca([]string{"-name", "ipv6", "-subnets", "100::100/129"}, ob, eb, nopw), "invalid subnet definition: invalid CIDR address: 100::100/129")
 	assert.Equal(t, "", ob.String())
 	assert.Equal(t, "", eb.String())
 
@@ -69,7 +90,7 @@ func Test_ca(t *testing.T) {
 	ob.Reset()
 	eb.Reset()
 	args := []string{"-name", "test", "-duration", "100m", "-out-crt", "/do/not/write/pleasecrt", "-out-key", "/do/not/write/pleasekey"}
-	assert.EqualError(t, ca(args, ob, eb), "error while writing out-key: open /do/not/write/pleasekey: "+NoSuchDirError)
+	assert.EqualError(t, ca(args, ob, eb, nopw), "error while writing out-key: open /do/not/write/pleasekey: "+NoSuchDirError)
 	assert.Equal(t, "", ob.String())
 	assert.Equal(t, "", eb.String())
 
@@ -82,7 +103,7 @@ func Test_ca(t *testing.T) {
 	ob.Reset()
 	eb.Reset()
 	args = []string{"-name", "test", "-duration", "100m", "-out-crt", "/do/not/write/pleasecrt", "-out-key", keyF.Name()}
-	assert.EqualError(t, ca(args, ob, eb), "error while writing out-crt: open /do/not/write/pleasecrt: "+NoSuchDirError)
+	assert.EqualError(t, ca(args, ob, eb, nopw), "error while writing out-crt: open /do/not/write/pleasecrt: "+NoSuchDirError)
 	assert.Equal(t, "", ob.String())
 	assert.Equal(t, "", eb.String())
 
@@ -96,7 +117,7 @@ func Test_ca(t *testing.T) {
 	ob.Reset()
 	eb.Reset()
 	args = []string{"-name", "test", "-duration", "100m", "-groups", "1,,   2    ,        ,,,3,4,5", "-out-crt", crtF.Name(), "-out-key", keyF.Name()}
-	assert.Nil(t, ca(args, ob, eb))
+	assert.Nil(t, ca(args, ob, eb, nopw))
 	assert.Equal(t, "", ob.String())
 	assert.Equal(t, "", eb.String())
 
@@ -122,19 +143,40 @@ func Test_ca(t *testing.T) {
 	assert.Equal(t, "", lCrt.Details.Issuer)
 	assert.True(t, lCrt.CheckSignature(lCrt.Details.PublicKey))
 
+	// test proper cert with ipv4 and ipv6 constraints
+	os.Remove(keyF.Name())
+	os.Remove(crtF.Name())
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-name", "test", "-duration", "100m", "-ips", "10.0.0.0/8, fd00::/48", "-subnets", "fd01::/32", "-out-crt", crtF.Name(), "-out-key", keyF.Name()}
+	assert.Nil(t, ca(args, ob, eb, nopw))
+	assert.Equal(t, "", ob.String())
+	assert.Equal(t, "", eb.String())
+
+	rb, _ = ioutil.ReadFile(crtF.Name())
+	lCrt, b, err = cert.UnmarshalNebulaCertificateFromPEM(rb)
+	assert.Len(t, b, 0)
+	assert.Nil(t, err)
+	assert.Len(t, lCrt.Details.Ips, 2)
+	assert.Equal(t, "10.0.0.0/8", lCrt.Details.Ips[0].String())
+	assert.Equal(t, "fd00::/48", lCrt.Details.Ips[1].String())
+	assert.Len(t, lCrt.Details.Subnets, 1)
+	assert.Equal(t, "fd01::/32", lCrt.Details.Subnets[0].String())
+	assert.True(t, lCrt.CheckSignature(lCrt.Details.PublicKey))
+
 	// create valid cert/key for overwrite tests
 	os.Remove(keyF.Name())
 	os.Remove(crtF.Name())
 	ob.Reset()
 	eb.Reset()
 	args = []string{"-name", "test", "-duration", "100m", "-groups", "1,,   2    ,        ,,,3,4,5", "-out-crt", crtF.Name(), "-out-key", keyF.Name()}
-	assert.Nil(t, ca(args, ob, eb))
+	assert.Nil(t, ca(args, ob, eb, nopw))
 
 	// test that we won't overwrite existing certificate file
 	ob.Reset()
 	eb.Reset()
 	args = []string{"-name", "test", "-duration", "100m", "-groups", "1,,   2    ,        ,,,3,4,5", "-out-crt", crtF.Name(), "-out-key", keyF.Name()}
-	assert.EqualError(t, ca(args, ob, eb), "refusing to overwrite existing CA key: "+keyF.Name())
+	assert.EqualError(t, ca(args, ob, eb, nopw), "refusing to overwrite existing CA key: "+keyF.Name())
 	assert.Equal(t, "", ob.String())
 	assert.Equal(t, "", eb.String())
 
@@ -143,9 +185,260 @@ func Test_ca(t *testing.T) {
 	ob.Reset()
 	eb.Reset()
 	args = []string{"-name", "test", "-duration", "100m", "-groups", "1,,   2    ,        ,,,3,4,5", "-out-crt", crtF.Name(), "-out-key", keyF.Name()}
-	assert.EqualError(t, ca(args, ob, eb), "refusing to overwrite existing CA cert: "+crtF.Name())
+	assert.EqualError(t, ca(args, ob, eb, nopw), "refusing to overwrite existing CA cert: "+crtF.Name())
 	assert.Equal(t, "", ob.String())
 	assert.Equal(t, "", eb.String())
 	os.Remove(keyF.Name())
+	os.Remove(crtF.Name())
+
+	// encryption args
+	assertHelpError(t, ca([]string{"-name", "test", "-passphrase-file", "nope"}, ob, eb, nopw), "-passphrase-file requires -encrypt")
+	assertHelpError(t, ca([]string{"-name", "test", "-encrypt", "-argon-parallelism", "256"}, ob, eb, nopw), "-argon-parallelism must be greater than 0 and no more than 255")
+	assertHelpError(t, ca([]string{"-name", "test", "-encrypt", "-argon-iterations", "0"}, ob, eb, nopw), "-argon-iterations must be greater than 0 and no more than 4294967295")
+
+	// an empty passphrase is refused and nothing is written
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-name", "test", "-duration", "100m", "-encrypt", "-argon-memory", "1024", "-out-crt", crtF.Name(), "-out-key", keyF.Name()}
+	assert.EqualError(t, ca(args, ob, eb, nopw), "no passphrase specified")
+	_, err = os.Stat(keyF.Name())
+	assert.True(t, os.IsNotExist(err))
+
+	// test an encrypted key
+	ob.Reset()
+	eb.Reset()
+	pw := &StubPasswordReader{passwords: [][]byte{[]byte("secret"), []byte("secret")}}
+	assert.Nil(t, ca(args, ob, eb, pw))
+	assert.Equal(t, "", ob.String())
+	assert.Equal(t, "Enter passphrase: \nConfirm passphrase: \n", eb.String())
+
+	rb, _ = ioutil.ReadFile(keyF.Name())
+	_, _, err = cert.UnmarshalEd25519PrivateKey(rb)
+	assert.Equal(t, cert.ErrPrivateKeyEncrypted, err)
+	lKey, b, err = cert.DecryptAndUnmarshalEd25519PrivateKey([]byte("secret"), rb)
+	assert.Nil(t, err)
+	assert.Len(t, b, 0)
+
+	rb, _ = ioutil.ReadFile(crtF.Name())
+	lCrt, _, err = cert.UnmarshalNebulaCertificateFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Nil(t, lCrt.VerifyPrivateKey(lKey))
+
+	// sign with the encrypted key
+	hostCrt, err := ioutil.TempFile("", "test-host.crt")
+	assert.Nil(t, err)
+	os.Remove(hostCrt.Name())
+	defer os.Remove(hostCrt.Name())
+	hostKey, err := ioutil.TempFile("", "test-host.key")
+	assert.Nil(t, err)
+	os.Remove(hostKey.Name())
+	defer os.Remove(hostKey.Name())
+
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-ca-crt", crtF.Name(), "-ca-key", keyF.Name(), "-name", "host", "-ip", "1.1.1.1/24", "-out-crt", hostCrt.Name(), "-out-key", hostKey.Name()}
+	assert.EqualError(t, signCert(args, ob, eb, &StubPasswordReader{passwords: [][]byte{[]byte("wrong")}}), "error while decrypting ca-key: invalid passphrase or corrupt private key")
+	assert.Equal(t, "Enter passphrase: \n", eb.String())
+
+	eb.Reset()
+	assert.Nil(t, signCert(args, ob, eb, &StubPasswordReader{passwords: [][]byte{[]byte("secret")}}))
+	assert.Equal(t, "", ob.String())
+	assert.Equal(t, "Enter passphrase: \n", eb.String())
+
+	os.Remove(keyF.Name())
+	os.Remove(crtF.Name())
+}
+
+func Test_caIntermediate(t *testing.T) {
+	nopw := &StubPasswordReader{}
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	dir, err := ioutil.TempDir("", "nebula-cert-intermediate")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// both halves of the signing ca are required
+	assertHelpError(t, ca([]string{"-name", "inter", "-ca-key", p("root.key")}, ob, eb, nopw), "-ca-crt is required")
+	assertHelpError(t, ca([]string{"-name", "inter", "-ca-crt", p("root.crt")}, ob, eb, nopw), "-ca-key is required")
+
+	args := []string{"-name", "root", "-duration", "10h", "-ips", "10.0.0.0/8", "-groups", "a,b", "-out-crt", p("root.crt"), "-out-key", p("root.key")}
+	assert.Nil(t, ca(args, ob, eb, nopw))
+
+	// missing signing ca
+	args = []string{"-name", "inter", "-ca-crt", p("nope.crt"), "-ca-key", p("root.key"), "-out-crt", p("inter.crt"), "-out-key", p("inter.key")}
+	assert.EqualError(t, ca(args, ob, eb, nopw), "error while reading ca-crt: open "+p("nope.crt")+": "+NoSuchFileError)
+
+	// constraints of the signing ca are enforced
+	args = []string{"-name", "inter", "-ips", "192.168.0.0/16", "-ca-crt", p("root.crt"), "-ca-key", p("root.key"), "-out-crt", p("inter.crt"), "-out-key", p("inter.key")}
+	assert.EqualError(t, ca(args, ob, eb, nopw), "refusing to sign, root certificate constraints violated: certificate contained an ip assignment outside the limitations of the signing ca: 192.168.0.0/16")
 
+	// without a duration the intermediate expires before the root
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-name", "inter", "-ips", "10.1.0.0/16", "-groups", "a", "-ca-crt", p("root.crt"), "-ca-key", p("root.key"), "-out-crt", p("inter.crt"), "-out-key", p("inter.key")}
+	assert.Nil(t, ca(args, ob, eb, nopw))
+	assert.Equal(t, "", ob.String())
+	assert.Equal(t, "", eb.String())
+
+	rb, _ := ioutil.ReadFile(p("root.crt"))
+	root, _, err := cert.UnmarshalNebulaCertificateFromPEM(rb)
+	assert.Nil(t, err)
+	rootFp, err := root.Sha256Sum()
+	assert.Nil(t, err)
+
+	rb, _ = ioutil.ReadFile(p("inter.crt"))
+	inter, err := cert.UnmarshalNebulaCertificateWithChainFromPEM(rb)
+	assert.Nil(t, err)
+	assert.True(t, inter.Details.IsCA)
+	assert.Equal(t, rootFp, inter.Details.Issuer)
+	assert.Equal(t, root.Details.NotAfter.Add(-time.Second), inter.Details.NotAfter)
+	assert.True(t, inter.CheckSignature(root.Details.PublicKey))
+	assert.Empty(t, inter.Chain)
+
+	// a host cert signed by the intermediate carries it and verifies against the root alone
+	args = []string{"-ca-crt", p("inter.crt"), "-ca-key", p("inter.key"), "-name", "host", "-ip", "10.1.0.1/16", "-groups", "a", "-out-crt", p("host.crt"), "-out-key", p("host.key")}
+	assert.Nil(t, signCert(args, ob, eb, nopw))
+
+	rb, _ = ioutil.ReadFile(p("host.crt"))
+	host, err := cert.UnmarshalNebulaCertificateWithChainFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Len(t, host.Chain, 1)
+	assert.Equal(t, "inter", host.Chain[0].Details.Name)
+	assert.Nil(t, verify([]string{"-ca", p("root.crt"), "-crt", p("host.crt")}, ob, eb))
+
+	// intermediate constraints apply to the host cert too
+	args = []string{"-ca-crt", p("inter.crt"), "-ca-key", p("inter.key"), "-name", "host2", "-ip", "10.2.0.1/16", "-out-crt", p("host2.crt"), "-out-key", p("host2.key")}
+	assert.EqualError(t, signCert(args, ob, eb, nopw), "refusing to sign, root certificate constraints violated: certificate contained an ip assignment outside the limitations of the signing ca: 10.2.0.1/16")
+
+	// an intermediate can not drop the limits of its signing ca
+	args = []string{"-name", "inter2", "-ca-crt", p("inter.crt"), "-ca-key", p("inter.key"), "-out-crt", p("inter2.crt"), "-out-key", p("inter2.key")}
+	assert.EqualError(t, ca(args, ob, eb, nopw), "refusing to sign, intermediate certificate has no groups limit but the signing ca is limited to: a")
+	args = []string{"-name", "inter2", "-groups", "a", "-ca-crt", p("inter.crt"), "-ca-key", p("inter.key"), "-out-crt", p("inter2.crt"), "-out-key", p("inter2.key")}
+	assert.EqualError(t, ca(args, ob, eb, nopw), "refusing to sign, intermediate certificate has no ips limit but the signing ca is limited to: 10.1.0.0/16")
+
+	// a second level intermediate bundles the whole chain, nearest first
+	args = []string{"-name", "inter2", "-groups", "a", "-ips", "10.1.0.0/24", "-ca-crt", p("inter.crt"), "-ca-key", p("inter.key"), "-out-crt", p("inter2.crt"), "-out-key", p("inter2.key")}
+	assert.Nil(t, ca(args, ob, eb, nopw))
+	args = []string{"-ca-crt", p("inter2.crt"), "-ca-key", p("inter2.key"), "-name", "host3", "-ip", "10.1.0.3/24", "-out-crt", p("host3.crt"), "-out-key", p("host3.key")}
+	assert.Nil(t, signCert(args, ob, eb, nopw))
+
+	rb, _ = ioutil.ReadFile(p("host3.crt"))
+	host, err = cert.UnmarshalNebulaCertificateWithChainFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Len(t, host.Chain, 2)
+	assert.Equal(t, "inter2", host.Chain[0].Details.Name)
+	assert.Equal(t, "inter", host.Chain[1].Details.Name)
+	assert.Nil(t, verify([]string{"-ca", p("root.crt"), "-crt", p("host3.crt")}, ob, eb))
+
+	// the intermediate alone is not a trust anchor
+	assert.EqualError(t, verify([]string{"-ca", p("inter.crt"), "-crt", p("host.crt")}, ob, eb), "error while adding ca cert to pool: inter: certificate is not self-signed")
+}
+
+func Test_caP256(t *testing.T) {
+	nopw := &StubPasswordReader{}
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	dir, err := ioutil.TempDir("", "nebula-cert-p256")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	assertHelpError(t, ca([]string{"-name", "root", "-curve", "P384"}, ob, eb, nopw), "invalid curve: P384, should be 25519 or P256")
+
+	args := []string{"-name", "root", "-curve", "P256", "-duration", "10h", "-out-crt", p("root.crt"), "-out-key", p("root.key")}
+	assert.Nil(t, ca(args, ob, eb, nopw))
+	assert.Equal(t, "", ob.String())
+	assert.Equal(t, "", eb.String())
+
+	rb, _ := ioutil.ReadFile(p("root.key"))
+	rootKey, _, curve, err := cert.UnmarshalSigningPrivateKey(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, curve)
+
+	rb, _ = ioutil.ReadFile(p("root.crt"))
+	root, _, err := cert.UnmarshalNebulaCertificateFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, root.Details.Curve)
+	assert.Len(t, root.Details.PublicKey, 65)
+	assert.True(t, root.CheckSignature(root.Details.PublicKey))
+	assert.Nil(t, root.VerifyPrivateKey(rootKey))
+
+	// intermediates inherit the curve of the signing ca, asking for another one is an error
+	args = []string{"-name", "inter", "-curve", "25519", "-ca-crt", p("root.crt"), "-ca-key", p("root.key"), "-out-crt", p("inter.crt"), "-out-key", p("inter.key")}
+	assert.EqualError(t, ca(args, ob, eb, nopw), "refusing to sign, -curve CURVE25519 does not match the ca-crt curve P256")
+
+	args = []string{"-name", "inter", "-ca-crt", p("root.crt"), "-ca-key", p("root.key"), "-out-crt", p("inter.crt"), "-out-key", p("inter.key")}
+	assert.Nil(t, ca(args, ob, eb, nopw))
+	rb, _ = ioutil.ReadFile(p("inter.crt"))
+	inter, err := cert.UnmarshalNebulaCertificateWithChainFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, inter.Details.Curve)
+
+	// host keys are generated on the curve of the signing ca
+	args = []string{"-ca-crt", p("inter.crt"), "-ca-key", p("inter.key"), "-name", "host", "-ip", "10.1.0.1/16", "-out-crt", p("host.crt"), "-out-key", p("host.key")}
+	assert.Nil(t, signCert(args, ob, eb, nopw))
+
+	rb, _ = ioutil.ReadFile(p("host.key"))
+	hostKey, _, curve, err := cert.UnmarshalPrivateKey(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, curve)
+
+	rb, _ = ioutil.ReadFile(p("host.crt"))
+	host, err := cert.UnmarshalNebulaCertificateWithChainFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, host.Details.Curve)
+	assert.Nil(t, host.VerifyPrivateKey(hostKey))
+	assert.Nil(t, verify([]string{"-ca", p("root.crt"), "-crt", p("host.crt")}, ob, eb))
+
+	// public keys from keygen must be on the same curve
+	assert.Nil(t, keygen([]string{"-out-pub", p("x.pub"), "-out-key", p("x.key")}, ob, eb))
+	args = []string{"-ca-crt", p("root.crt"), "-ca-key", p("root.key"), "-name", "x", "-ip", "10.1.0.2/16", "-in-pub", p("x.pub"), "-out-crt", p("x.crt")}
+	assert.EqualError(t, signCert(args, ob, eb, nopw), "refusing to sign, in-pub curve CURVE25519 does not match the ca-crt curve P256")
+
+	assert.Nil(t, keygen([]string{"-curve", "P256", "-out-pub", p("y.pub"), "-out-key", p("y.key")}, ob, eb))
+	args = []string{"-ca-crt", p("root.crt"), "-ca-key", p("root.key"), "-name", "y", "-ip", "10.1.0.3/16", "-in-pub", p("y.pub"), "-out-crt", p("y.crt")}
+	assert.Nil(t, signCert(args, ob, eb, nopw))
+
+	// certificate requests prove possession with P256 ECDH
+	args = []string{"-ca-crt", p("root.crt"), "-name", "z", "-ip", "10.1.0.4/16", "-in-key", p("x.key"), "-out-csr", p("z.csr")}
+	assert.EqualError(t, csr(args, ob, eb), "in-key curve CURVE25519 does not match the ca-crt curve P256")
+
+	args = []string{"-ca-crt", p("root.crt"), "-name", "z", "-ip", "10.1.0.4/16", "-in-key", p("y.key"), "-out-csr", p("z.csr")}
+	assert.Nil(t, csr(args, ob, eb))
+	args = []string{"-ca-crt", p("root.crt"), "-ca-key", p("root.key"), "-in-csr", p("z.csr"), "-out-crt", p("z.crt")}
+	assert.Nil(t, signCert(args, ob, eb, nopw))
+	assert.Nil(t, verify([]string{"-ca", p("root.crt"), "-crt", p("z.crt")}, ob, eb))
+
+	// revocation lists are signed with ECDSA too
+	args = []string{"-ca-crt", p("root.crt"), "-ca-key", p("root.key"), "-crt", p("y.crt"), "-out-crl", p("root.crl")}
+	assert.Nil(t, crl(args, ob, eb, nopw))
+	rb, _ = ioutil.ReadFile(p("root.crl"))
+	rl, _, err := cert.UnmarshalNebulaRevocationListFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, rl.Details.Curve)
+	assert.True(t, rl.CheckSignature(root.Details.PublicKey))
+
+	// a 25519 ca key does not match a P256 ca cert
+	args = []string{"-name", "other", "-out-crt", p("other.crt"), "-out-key", p("other.key")}
+	assert.Nil(t, ca(args, ob, eb, nopw))
+	args = []string{"-ca-crt", p("root.crt"), "-ca-key", p("other.key"), "-name", "w", "-ip", "10.1.0.5/16", "-out-crt", p("w.crt"), "-out-key", p("w.key")}
+	assert.EqualError(t, signCert(args, ob, eb, nopw), "refusing to sign, root certificate does not match private key")
+
+	// encrypted P256 keys
+	passwd := []byte("pass")
+	pw := &StubPasswordReader{passwords: [][]byte{passwd, passwd}}
+	args = []string{"-name", "enc", "-curve", "P256", "-encrypt", "-argon-memory", "10", "-out-crt", p("enc.crt"), "-out-key", p("enc.key")}
+	assert.Nil(t, ca(args, ob, eb, pw))
+	rb, _ = ioutil.ReadFile(p("enc.key"))
+	curve, encKey, _, err := cert.DecryptAndUnmarshalSigningPrivateKey(passwd, rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, curve)
+	assert.Len(t, encKey, 32)
 }
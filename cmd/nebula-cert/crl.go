@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+)
+
+type crlFlags struct {
+	set              *flag.FlagSet
+	caKeyPath        *string
+	caCertPath       *string
+	caPassphrasePath *string
+	inCRLPath        *string
+	outCRLPath       *string
+	fingerprints     *string
+	certPath         *string
+}
+
+func newCrlFlags() *crlFlags {
+	cf := crlFlags{set: flag.NewFlagSet("crl", flag.ContinueOnError)}
+	cf.set.Usage = func() {}
+	cf.caKeyPath = cf.set.String("ca-key", "ca.key", "Optional: path to the signing CA key")
+	cf.caCertPath = cf.set.String("ca-crt", "ca.crt", "Optional: path to the signing CA cert")
+	cf.caPassphrasePath = cf.set.String("ca-passphrase-file", "", "Optional: path to a file holding the passphrase of an encrypted ca-key. The "+caPassphraseEnv+" environment variable is used if not set, otherwise the passphrase is prompted for")
+	cf.inCRLPath = cf.set.String("in-crl", "", "Optional: path to a previously generated revocation list to add to")
+	cf.outCRLPath = cf.set.String("out-crl", "ca.crl", "Optional: path to write the revocation list to")
+	cf.fingerprints = cf.set.String("fingerprints", "", "Optional: comma separated list of certificate fingerprints to revoke")
+	cf.certPath = cf.set.String("crt", "", "Optional: path to a file containing one or more certificates to revoke")
+	return &cf
+}
+
+func crl(args []string, out io.Writer, errOut io.Writer, pr PasswordReader) error {
+	cf := newCrlFlags()
+	err := cf.set.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := mustFlagString("ca-key", cf.caKeyPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("ca-crt", cf.caCertPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("out-crl", cf.outCRLPath); err != nil {
+		return err
+	}
+
+	caKey, err := readCAKey(*cf.caKeyPath, *cf.caPassphrasePath, errOut, pr)
+	if err != nil {
+		return err
+	}
+
+	rawCACert, err := ioutil.ReadFile(*cf.caCertPath)
+	if err != nil {
+		return fmt.Errorf("error while reading ca-crt: %s", err)
+	}
+
+	caCert, _, err := cert.UnmarshalNebulaCertificateFromPEM(rawCACert)
+	if err != nil {
+		return fmt.Errorf("error while parsing ca-crt: %s", err)
+	}
+
+	if err := caCert.VerifyPrivateKey(caKey); err != nil {
+		return fmt.Errorf("refusing to sign, root certificate does not match private key")
+	}
+
+	issuer, err := caCert.Sha256Sum()
+	if err != nil {
+		return fmt.Errorf("error while getting -ca-crt fingerprint: %s", err)
+	}
+
+	rl := &cert.NebulaRevocationList{
+		Details: cert.NebulaRevocationListDetails{
+			Issuer: issuer,
+			Curve:  caCert.Details.Curve,
+		},
+	}
+
+	if *cf.inCRLPath != "" {
+		rawCRL, err := ioutil.ReadFile(*cf.inCRLPath)
+		if err != nil {
+			return fmt.Errorf("error while reading in-crl: %s", err)
+		}
+
+		rl, _, err = cert.UnmarshalNebulaRevocationListFromPEM(rawCRL)
+		if err != nil {
+			return fmt.Errorf("error while parsing in-crl: %s", err)
+		}
+
+		if rl.Details.Issuer != issuer {
+			return fmt.Errorf("in-crl was not issued by the provided ca-crt")
+		}
+
+		if !rl.CheckSignature(caCert.Details.PublicKey) {
+			return fmt.Errorf("in-crl signature does not match ca-crt")
+		}
+
+	} else if _, err := os.Stat(*cf.outCRLPath); err == nil {
+		return fmt.Errorf("refusing to overwrite existing revocation list: %s", *cf.outCRLPath)
+	}
+
+	if *cf.fingerprints != "" {
+		for _, rf := range strings.Split(*cf.fingerprints, ",") {
+			fp := strings.TrimSpace(rf)
+			if fp == "" {
+				continue
+			}
+
+			if err := rl.Revoke(fp); err != nil {
+				return newHelpErrorf("invalid fingerprint: %s", err)
+			}
+		}
+	}
+
+	if *cf.certPath != "" {
+		rawCert, err := ioutil.ReadFile(*cf.certPath)
+		if err != nil {
+			return fmt.Errorf("error while reading crt: %s", err)
+		}
+
+		for len(rawCert) > 0 && strings.TrimSpace(string(rawCert)) != "" {
+			var c *cert.NebulaCertificate
+			c, rawCert, err = cert.UnmarshalNebulaCertificateFromPEM(rawCert)
+			if err != nil {
+				return fmt.Errorf("error while parsing crt: %s", err)
+			}
+
+			if c.Details.Issuer != issuer {
+				return fmt.Errorf("refusing to revoke %s, it was not issued by the provided ca-crt", c.Details.Name)
+			}
+
+			fp, err := c.Sha256Sum()
+			if err != nil {
+				return fmt.Errorf("error while getting crt fingerprint: %s", err)
+			}
+
+			if err := rl.Revoke(fp); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Make sure the new list always supersedes the previous one
+	now := time.Unix(time.Now().Unix(), 0)
+	if !now.After(rl.Details.IssuedAt) {
+		now = rl.Details.IssuedAt.Add(time.Second)
+	}
+	rl.Details.IssuedAt = now
+
+	err = rl.Sign(caKey)
+	if err != nil {
+		return fmt.Errorf("error while signing: %s", err)
+	}
+
+	b, err := rl.MarshalToPEM()
+	if err != nil {
+		return fmt.Errorf("error while marshalling revocation list: %s", err)
+	}
+
+	err = ioutil.WriteFile(*cf.outCRLPath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("error while writing out-crl: %s", err)
+	}
+
+	return nil
+}
+
+func crlSummary() string {
+	return "crl <flags>: create or update a certificate revocation list signed by a certificate authority"
+}
+
+func crlHelp(out io.Writer) {
+	cf := newCrlFlags()
+	out.Write([]byte("Usage of " + os.Args[0] + " " + crlSummary() + "\n"))
+	cf.set.SetOutput(out)
+	cf.set.PrintDefaults()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_crlSummary(t *testing.T) {
+	assert.Equal(t, "crl <flags>: create or update a certificate revocation list signed by a certificate authority", crlSummary())
+}
+
+func Test_crlHelp(t *testing.T) {
+	ob := &bytes.Buffer{}
+	crlHelp(ob)
+	assert.Equal(
+		t,
+		"Usage of "+os.Args[0]+" crl <flags>: create or update a certificate revocation list signed by a certificate authority\n"+
+			"  -ca-crt string\n"+
+			"    \tOptional: path to the signing CA cert (default \"ca.crt\")\n"+
+			"  -ca-key string\n"+
+			"    \tOptional: path to the signing CA key (default \"ca.key\")\n"+
+			"  -ca-passphrase-file string\n"+
+			"    \tOptional: path to a file holding the passphrase of an encrypted ca-key. The NEBULA_CA_PASSPHRASE environment variable is used if not set, otherwise the passphrase is prompted for\n"+
+			"  -crt string\n"+
+			"    \tOptional: path to a file containing one or more certificates to revoke\n"+
+			"  -fingerprints string\n"+
+			"    \tOptional: comma separated list of certificate fingerprints to revoke\n"+
+			"  -in-crl string\n"+
+			"    \tOptional: path to a previously generated revocation list to add to\n"+
+			"  -out-crl string\n"+
+			"    \tOptional: path to write the revocation list to (default \"ca.crl\")\n",
+		ob.String(),
+	)
+}
+
+func Test_crl(t *testing.T) {
+	nopw := &StubPasswordReader{}
+
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	// failed to read key
+	args := []string{"-ca-crt", "./nope", "-ca-key", "./nope", "-out-crl", "nope"}
+	assert.EqualError(t, crl(args, ob, eb, nopw), "error while reading ca-key: open ./nope: "+NoSuchFileError)
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	// write a proper ca key and cert for later
+	caPub, caPriv, _ := ed25519.GenerateKey(rand.Reader)
+	caKeyF, err := ioutil.TempFile("", "crl-ca.key")
+	assert.Nil(t, err)
+	defer os.Remove(caKeyF.Name())
+	caKeyF.Write(cert.MarshalEd25519PrivateKey(caPriv))
+
+	ca := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      "ca",
+			NotBefore: time.Now(),
+			NotAfter:  time.Now().Add(time.Minute * 200),
+			PublicKey: caPub,
+			IsCA:      true,
+		},
+	}
+	ca.Sign(caPriv)
+	caCrtF, err := ioutil.TempFile("", "crl-ca.crt")
+	assert.Nil(t, err)
+	defer os.Remove(caCrtF.Name())
+	b, _ := ca.MarshalToPEM()
+	caCrtF.Write(b)
+	issuer, _ := ca.Sha256Sum()
+
+	// mismatched ca key
+	_, caPriv2, _ := ed25519.GenerateKey(rand.Reader)
+	caKeyF2, err := ioutil.TempFile("", "crl-ca-2.key")
+	assert.Nil(t, err)
+	defer os.Remove(caKeyF2.Name())
+	caKeyF2.Write(cert.MarshalEd25519PrivateKey(caPriv2))
+
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-ca-crt", caCrtF.Name(), "-ca-key", caKeyF2.Name(), "-out-crl", "nope"}
+	assert.EqualError(t, crl(args, ob, eb, nopw), "refusing to sign, root certificate does not match private key")
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	// bad fingerprint
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-ca-crt", caCrtF.Name(), "-ca-key", caKeyF.Name(), "-out-crl", "nope", "-fingerprints", "nope"}
+	assertHelpError(t, crl(args, ob, eb, nopw), "invalid fingerprint: fingerprint was not valid hex: nope")
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	// make a cert signed by the ca to revoke
+	pub, _ := x25519Keypair()
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      "test",
+			NotBefore: time.Now(),
+			NotAfter:  time.Now().Add(time.Minute * 100),
+			PublicKey: pub,
+			Issuer:    issuer,
+		},
+	}
+	c.Sign(caPriv)
+	fp, _ := c.Sha256Sum()
+	crtF, err := ioutil.TempFile("", "crl-test.crt")
+	assert.Nil(t, err)
+	defer os.Remove(crtF.Name())
+	b, _ = c.MarshalToPEM()
+	crtF.Write(b)
+
+	// a cert from another ca is refused
+	otherCrtF, err := ioutil.TempFile("", "crl-other.crt")
+	assert.Nil(t, err)
+	defer os.Remove(otherCrtF.Name())
+	c.Details.Issuer = "1234"
+	b, _ = c.MarshalToPEM()
+	otherCrtF.Write(b)
+
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-ca-crt", caCrtF.Name(), "-ca-key", caKeyF.Name(), "-out-crl", "nope", "-crt", otherCrtF.Name()}
+	assert.EqualError(t, crl(args, ob, eb, nopw), "refusing to revoke test, it was not issued by the provided ca-crt")
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	// create temp crl file
+	crlF, err := ioutil.TempFile("", "test.crl")
+	assert.Nil(t, err)
+	os.Remove(crlF.Name())
+	defer os.Remove(crlF.Name())
+
+	// proper crl
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-ca-crt", caCrtF.Name(), "-ca-key", caKeyF.Name(), "-out-crl", crlF.Name(), "-crt", crtF.Name(), "-fingerprints", "abcd"}
+	assert.Nil(t, crl(args, ob, eb, nopw))
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	rb, _ := ioutil.ReadFile(crlF.Name())
+	rl, b, err := cert.UnmarshalNebulaRevocationListFromPEM(rb)
+	assert.Len(t, b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, issuer, rl.Details.Issuer)
+	assert.True(t, rl.CheckSignature(caPub))
+	assert.True(t, rl.IsRevoked(fp))
+	assert.True(t, rl.IsRevoked("abcd"))
+	assert.Len(t, rl.Details.Fingerprints, 2)
+
+	// test that we won't overwrite an existing crl
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-ca-crt", caCrtF.Name(), "-ca-key", caKeyF.Name(), "-out-crl", crlF.Name(), "-fingerprints", "ef01"}
+	assert.EqualError(t, crl(args, ob, eb, nopw), "refusing to overwrite existing revocation list: "+crlF.Name())
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	// update an existing crl in place
+	ob.Reset()
+	eb.Reset()
+	args = []string{"-ca-crt", caCrtF.Name(), "-ca-key", caKeyF.Name(), "-in-crl", crlF.Name(), "-out-crl", crlF.Name(), "-fingerprints", "ef01"}
+	assert.Nil(t, crl(args, ob, eb, nopw))
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	rb, _ = ioutil.ReadFile(crlF.Name())
+	rl2, _, err := cert.UnmarshalNebulaRevocationListFromPEM(rb)
+	assert.Nil(t, err)
+	assert.True(t, rl2.CheckSignature(caPub))
+	assert.True(t, rl2.IsRevoked(fp))
+	assert.True(t, rl2.IsRevoked("ef01"))
+	assert.Len(t, rl2.Details.Fingerprints, 3)
+	assert.True(t, rl2.Details.IssuedAt.After(rl.Details.IssuedAt))
+
+	// a crl signed by another ca is refused
+	ob.Reset()
+	eb.Reset()
+	ca.Details.PublicKey = caPriv2.Public().(ed25519.PublicKey)
+	ca.Sign(caPriv2)
+	caCrtF2, err := ioutil.TempFile("", "crl-ca-2.crt")
+	assert.Nil(t, err)
+	defer os.Remove(caCrtF2.Name())
+	b, _ = ca.MarshalToPEM()
+	caCrtF2.Write(b)
+
+	args = []string{"-ca-crt", caCrtF2.Name(), "-ca-key", caKeyF2.Name(), "-in-crl", crlF.Name(), "-out-crl", crlF.Name()}
+	assert.EqualError(t, crl(args, ob, eb, nopw), "in-crl was not issued by the provided ca-crt")
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+}
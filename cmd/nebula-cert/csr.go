@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/slackhq/nebula/cert"
+	"golang.org/x/crypto/curve25519"
+)
+
+type csrFlags struct {
+	set        *flag.FlagSet
+	caCertPath *string
+	name       *string
+	ip         *string
+	groups     *string
+	extensions *string
+	subnets    *string
+	inKeyPath  *string
+	outKeyPath *string
+	outCSRPath *string
+}
+
+func newCsrFlags() *csrFlags {
+	cf := csrFlags{set: flag.NewFlagSet("csr", flag.ContinueOnError)}
+	cf.set.Usage = func() {}
+	cf.caCertPath = cf.set.String("ca-crt", "ca.crt", "Optional: path to the CA cert the request is for")
+	cf.name = cf.set.String("name", "", "Required: name of the cert, usually a hostname")
+	cf.ip = cf.set.String("ip", "", "Required: ipv4 and/or ipv6 address and network in CIDR notation to request, separated by a comma")
+	cf.groups = cf.set.String("groups", "", "Optional: comma separated list of groups")
+	cf.extensions = cf.set.String("extensions", "", "Optional: comma separated list of key=value metadata to request, ie: team=ops,env=prod")
+	cf.subnets = cf.set.String("subnets", "", "Optional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. Subnets this cert can serve for")
+	cf.inKeyPath = cf.set.String("in-key", "", "Optional (if out-key not set): path to read a previously generated private key")
+	cf.outKeyPath = cf.set.String("out-key", "", "Optional (if in-key not set): path to write the private key to")
+	cf.outCSRPath = cf.set.String("out-csr", "", "Optional: path to write the certificate request to")
+	return &cf
+}
+
+func csr(args []string, out io.Writer, errOut io.Writer) error {
+	cf := newCsrFlags()
+	err := cf.set.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := mustFlagString("ca-crt", cf.caCertPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("name", cf.name); err != nil {
+		return err
+	}
+	if err := mustFlagString("ip", cf.ip); err != nil {
+		return err
+	}
+	if *cf.inKeyPath != "" && *cf.outKeyPath != "" {
+		return newHelpErrorf("cannot set both -in-key and -out-key")
+	}
+
+	rawCACert, err := ioutil.ReadFile(*cf.caCertPath)
+	if err != nil {
+		return fmt.Errorf("error while reading ca-crt: %s", err)
+	}
+
+	caCert, _, err := cert.UnmarshalNebulaCertificateFromPEM(rawCACert)
+	if err != nil {
+		return fmt.Errorf("error while parsing ca-crt: %s", err)
+	}
+
+	ips, err := parseIps(*cf.ip)
+	if err != nil {
+		return err
+	}
+
+	subnets, err := parseSubnets(*cf.subnets)
+	if err != nil {
+		return err
+	}
+
+	extensions, err := parseExtensions(*cf.extensions)
+	if err != nil {
+		return err
+	}
+
+	var pub, rawPriv []byte
+	if *cf.inKeyPath != "" {
+		rawKey, err := ioutil.ReadFile(*cf.inKeyPath)
+		if err != nil {
+			return fmt.Errorf("error while reading in-key: %s", err)
+		}
+		var curve cert.Curve
+		rawPriv, _, curve, err = cert.UnmarshalPrivateKey(rawKey)
+		if err != nil {
+			return fmt.Errorf("error while parsing in-key: %s", err)
+		}
+		if curve != caCert.Details.Curve {
+			return fmt.Errorf("in-key curve %s does not match the ca-crt curve %s", curve, caCert.Details.Curve)
+		}
+
+		if curve == cert.Curve_P256 {
+			pub, err = cert.P256PublicKey(rawPriv)
+		} else {
+			pub, err = curve25519.X25519(rawPriv, curve25519.Basepoint)
+		}
+		if err != nil {
+			return fmt.Errorf("error while deriving public key: %s", err)
+		}
+	} else {
+		pub, rawPriv = newKeypair(caCert.Details.Curve)
+	}
+
+	r := cert.NebulaCertificateRequest{
+		Details: cert.NebulaCertificateDetails{
+			Name:       *cf.name,
+			Ips:        ips,
+			Groups:     parseGroups(*cf.groups),
+			Subnets:    subnets,
+			PublicKey:  pub,
+			Extensions: extensions,
+		},
+	}
+
+	if err := r.Prove(rawPriv, caCert); err != nil {
+		return fmt.Errorf("error while creating proof of possession: %s", err)
+	}
+
+	if *cf.outKeyPath == "" {
+		*cf.outKeyPath = *cf.name + ".key"
+	}
+
+	if *cf.outCSRPath == "" {
+		*cf.outCSRPath = *cf.name + ".csr"
+	}
+
+	if _, err := os.Stat(*cf.outCSRPath); err == nil {
+		return fmt.Errorf("refusing to overwrite existing certificate request: %s", *cf.outCSRPath)
+	}
+
+	if *cf.inKeyPath == "" {
+		if _, err := os.Stat(*cf.outKeyPath); err == nil {
+			return fmt.Errorf("refusing to overwrite existing key: %s", *cf.outKeyPath)
+		}
+
+		err = ioutil.WriteFile(*cf.outKeyPath, cert.MarshalPrivateKey(caCert.Details.Curve, rawPriv), 0600)
+		if err != nil {
+			return fmt.Errorf("error while writing out-key: %s", err)
+		}
+	}
+
+	b, err := r.MarshalToPEM()
+	if err != nil {
+		return fmt.Errorf("error while marshalling certificate request: %s", err)
+	}
+
+	err = ioutil.WriteFile(*cf.outCSRPath, b, 0600)
+	if err != nil {
+		return fmt.Errorf("error while writing out-csr: %s", err)
+	}
+
+	return nil
+}
+
+func csrSummary() string {
+	return "csr <flags>: create a certificate request with proof of possession of the private key. the request can be passed to `nebula-cert sign -in-csr`"
+}
+
+func csrHelp(out io.Writer) {
+	cf := newCsrFlags()
+	out.Write([]byte("Usage of " + os.Args[0] + " " + csrSummary() + "\n"))
+	cf.set.SetOutput(out)
+	cf.set.PrintDefaults()
+}
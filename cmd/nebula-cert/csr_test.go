@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+func Test_csrSummary(t *testing.T) {
+	assert.Equal(t, "csr <flags>: create a certificate request with proof of possession of the private key. the request can be passed to `nebula-cert sign -in-csr`", csrSummary())
+}
+
+func Test_csrHelp(t *testing.T) {
+	ob := &bytes.Buffer{}
+	csrHelp(ob)
+	assert.Equal(
+		t,
+		"Usage of "+os.Args[0]+" csr <flags>: create a certificate request with proof of possession of the private key. the request can be passed to `nebula-cert sign -in-csr`\n"+
+			"  -ca-crt string\n"+
+			"    \tOptional: path to the CA cert the request is for (default \"ca.crt\")\n"+
+			"  -extensions string\n"+
+			"    \tOptional: comma separated list of key=value metadata to request, ie: team=ops,env=prod\n"+
+			"  -groups string\n"+
+			"    \tOptional: comma separated list of groups\n"+
+			"  -in-key string\n"+
+			"    \tOptional (if out-key not set): path to read a previously generated private key\n"+
+			"  -ip string\n"+
+			"    \tRequired: ipv4 and/or ipv6 address and network in CIDR notation to request, separated by a comma\n"+
+			"  -name string\n"+
+			"    \tRequired: name of the cert, usually a hostname\n"+
+			"  -out-csr string\n"+
+			"    \tOptional: path to write the certificate request to\n"+
+			"  -out-key string\n"+
+			"    \tOptional (if in-key not set): path to write the private key to\n"+
+			"  -subnets string\n"+
+			"    \tOptional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. Subnets this cert can serve for\n",
+		ob.String(),
+	)
+}
+
+func Test_csr(t *testing.T) {
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	// required args
+	assertHelpError(t, csr([]string{"-ca-crt", "./nope", "-ip", "1.1.1.1/24"}, ob, eb), "-name is required")
+	assertHelpError(t, csr([]string{"-ca-crt", "./nope", "-name", "test"}, ob, eb), "-ip is required")
+	assertHelpError(t, csr([]string{"-ca-crt", "./nope", "-name", "test", "-ip", "1.1.1.1/24", "-in-key", "nope", "-out-key", "nope"}, ob, eb), "cannot set both -in-key and -out-key")
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	// failed to read ca cert
+	args := []string{"-ca-crt", "./nope", "-name", "test", "-ip", "1.1.1.1/24"}
+	assert.EqualError(t, csr(args, ob, eb), "error while reading ca-crt: open ./nope: "+NoSuchFileError)
+
+	// write a proper ca key and cert for later
+	caPub, caPriv, _ := ed25519.GenerateKey(rand.Reader)
+	caKeyF, err := ioutil.TempFile("", "csr-ca.key")
+	assert.Nil(t, err)
+	defer os.Remove(caKeyF.Name())
+	caKeyF.Write(cert.MarshalEd25519PrivateKey(caPriv))
+
+	ca := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      "ca",
+			NotBefore: time.Now(),
+			NotAfter:  time.Now().Add(time.Minute * 200),
+			PublicKey: caPub,
+			IsCA:      true,
+		},
+	}
+	ca.Sign(caPriv)
+	caCrtF, err := ioutil.TempFile("", "csr-ca.crt")
+	assert.Nil(t, err)
+	defer os.Remove(caCrtF.Name())
+	b, _ := ca.MarshalToPEM()
+	caCrtF.Write(b)
+
+	// bad ip and subnet
+	args = []string{"-ca-crt", caCrtF.Name(), "-name", "test", "-ip", "a1.1.1.1/24"}
+	assertHelpError(t, csr(args, ob, eb), "invalid ip definition: invalid CIDR address: a1.1.1.1/24")
+	args = []string{"-ca-crt", caCrtF.Name(), "-name", "test", "-ip", "1.1.1.1/24", "-subnets", "a"}
+	assertHelpError(t, csr(args, ob, eb), "invalid subnet definition: invalid CIDR address: a")
+
+	// failed to read in-key
+	args = []string{"-ca-crt", caCrtF.Name(), "-name", "test", "-ip", "1.1.1.1/24", "-in-key", "./nope"}
+	assert.EqualError(t, csr(args, ob, eb), "error while reading in-key: open ./nope: "+NoSuchFileError)
+
+	// create a request with a new key
+	keyF, err := ioutil.TempFile("", "csr.key")
+	assert.Nil(t, err)
+	os.Remove(keyF.Name())
+	defer os.Remove(keyF.Name())
+	csrF, err := ioutil.TempFile("", "test.csr")
+	assert.Nil(t, err)
+	os.Remove(csrF.Name())
+	defer os.Remove(csrF.Name())
+
+	args = []string{"-ca-crt", caCrtF.Name(), "-name", "test", "-ip", "1.1.1.1/24,fd00::1/64", "-groups", "1, 2", "-subnets", "10.1.1.0/24", "-extensions", "env=prod", "-out-key", keyF.Name(), "-out-csr", csrF.Name()}
+	assert.Nil(t, csr(args, ob, eb))
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	rb, _ := ioutil.ReadFile(keyF.Name())
+	rawPriv, _, err := cert.UnmarshalX25519PrivateKey(rb)
+	assert.Nil(t, err)
+
+	rb, _ = ioutil.ReadFile(csrF.Name())
+	r, _, err := cert.UnmarshalNebulaCertificateRequestFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", r.Details.Name)
+	assert.Equal(t, "1.1.1.1/24", r.Details.Ips[0].String())
+	assert.Equal(t, "fd00::1/64", r.Details.Ips[1].String())
+	assert.Equal(t, []string{"1", "2"}, r.Details.Groups)
+	assert.Equal(t, "10.1.1.0/24", r.Details.Subnets[0].String())
+	assert.Equal(t, map[string]string{"env": "prod"}, r.Details.Extensions)
+	assert.True(t, r.CheckProof(caPriv))
+	pub, err := curve25519.X25519(rawPriv, curve25519.Basepoint)
+	assert.Nil(t, err)
+	assert.Equal(t, pub, r.Details.PublicKey)
+
+	// refuse to overwrite
+	args = []string{"-ca-crt", caCrtF.Name(), "-name", "test", "-ip", "1.1.1.1/24", "-out-key", keyF.Name() + ".2", "-out-csr", csrF.Name()}
+	assert.EqualError(t, csr(args, ob, eb), "refusing to overwrite existing certificate request: "+csrF.Name())
+	args = []string{"-ca-crt", caCrtF.Name(), "-name", "test", "-ip", "1.1.1.1/24", "-out-key", keyF.Name(), "-out-csr", csrF.Name() + ".2"}
+	assert.EqualError(t, csr(args, ob, eb), "refusing to overwrite existing key: "+keyF.Name())
+
+	// an existing key is reused
+	os.Remove(csrF.Name())
+	args = []string{"-ca-crt", caCrtF.Name(), "-name", "test", "-ip", "1.1.1.1/24", "-in-key", keyF.Name(), "-out-csr", csrF.Name()}
+	assert.Nil(t, csr(args, ob, eb))
+	rb, _ = ioutil.ReadFile(csrF.Name())
+	r2, _, err := cert.UnmarshalNebulaCertificateRequestFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, r.Details.PublicKey, r2.Details.PublicKey)
+	assert.True(t, r2.CheckProof(caPriv))
+}
+
+func Test_signCert_inCSR(t *testing.T) {
+	nopw := &StubPasswordReader{}
+
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	// flag conflicts
+	assertHelpError(t, signCert([]string{"-in-csr", "nope", "-in-pub", "nope"}, ob, eb, nopw), "cannot set -in-csr with -in-pub or -out-key")
+	assertHelpError(t, signCert([]string{"-in-csr", "nope", "-out-key", "nope"}, ob, eb, nopw), "cannot set -in-csr with -in-pub or -out-key")
+
+	newCA := func(name string) (string, string) {
+		caPub, caPriv, _ := ed25519.GenerateKey(rand.Reader)
+		caKeyF, err := ioutil.TempFile("", name+".key")
+		assert.Nil(t, err)
+		caKeyF.Write(cert.MarshalEd25519PrivateKey(caPriv))
+
+		ca := cert.NebulaCertificate{
+			Details: cert.NebulaCertificateDetails{
+				Name:      name,
+				NotBefore: time.Now(),
+				NotAfter:  time.Now().Add(time.Minute * 200),
+				PublicKey: caPub,
+				IsCA:      true,
+			},
+		}
+		ca.Sign(caPriv)
+		caCrtF, err := ioutil.TempFile("", name+".crt")
+		assert.Nil(t, err)
+		b, _ := ca.MarshalToPEM()
+		caCrtF.Write(b)
+		return caCrtF.Name(), caKeyF.Name()
+	}
+
+	caCrt, caKey := newCA("ca")
+	defer os.Remove(caCrt)
+	defer os.Remove(caKey)
+	otherCrt, otherKey := newCA("other-ca")
+	defer os.Remove(otherCrt)
+	defer os.Remove(otherKey)
+
+	tmpName := func(pattern string) string {
+		f, err := ioutil.TempFile("", pattern)
+		assert.Nil(t, err)
+		os.Remove(f.Name())
+		return f.Name()
+	}
+
+	keyPath := tmpName("sign-csr.key")
+	defer os.Remove(keyPath)
+	csrPath := tmpName("sign.csr")
+	defer os.Remove(csrPath)
+	crtPath := tmpName("sign-csr.crt")
+	defer os.Remove(crtPath)
+
+	args := []string{"-ca-crt", caCrt, "-name", "test", "-ip", "1.1.1.1/24,fd00::1/64", "-groups", "1,2", "-subnets", "10.1.1.0/24", "-extensions", "team=ops", "-out-key", keyPath, "-out-csr", csrPath}
+	assert.Nil(t, csr(args, ob, eb))
+
+	// failed to read the request
+	args = []string{"-ca-crt", caCrt, "-ca-key", caKey, "-in-csr", "./nope", "-out-crt", crtPath}
+	assert.EqualError(t, signCert(args, ob, eb, nopw), "error while reading in-csr: open ./nope: "+NoSuchFileError)
+
+	// not a request
+	args = []string{"-ca-crt", caCrt, "-ca-key", caKey, "-in-csr", caCrt, "-out-crt", crtPath}
+	assert.EqualError(t, signCert(args, ob, eb, nopw), "error while parsing in-csr: bytes did not contain a proper nebula certificate request banner")
+
+	// a request for another ca is refused
+	args = []string{"-ca-crt", otherCrt, "-ca-key", otherKey, "-in-csr", csrPath, "-out-crt", crtPath}
+	assert.EqualError(t, signCert(args, ob, eb, nopw), "refusing to sign, certificate request was made for a different ca")
+
+	// a tampered request is refused
+	rb, _ := ioutil.ReadFile(csrPath)
+	r, _, err := cert.UnmarshalNebulaCertificateRequestFromPEM(rb)
+	assert.Nil(t, err)
+	r.Details.Groups = append(r.Details.Groups, "admin")
+	tb, err := r.MarshalToPEM()
+	assert.Nil(t, err)
+	tamperedPath := tmpName("tampered.csr")
+	defer os.Remove(tamperedPath)
+	assert.Nil(t, ioutil.WriteFile(tamperedPath, tb, 0600))
+	args = []string{"-ca-crt", caCrt, "-ca-key", caKey, "-in-csr", tamperedPath, "-out-crt", crtPath}
+	assert.EqualError(t, signCert(args, ob, eb, nopw), "refusing to sign, certificate request proof of possession is invalid")
+
+	// sign the request as is
+	args = []string{"-ca-crt", caCrt, "-ca-key", caKey, "-in-csr", csrPath, "-out-crt", crtPath, "-duration", "100m"}
+	assert.Nil(t, signCert(args, ob, eb, nopw))
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	rb, _ = ioutil.ReadFile(crtPath)
+	nc, _, err := cert.UnmarshalNebulaCertificateFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", nc.Details.Name)
+	assert.Equal(t, "1.1.1.1/24", nc.Details.Ips[0].String())
+	assert.Equal(t, "fd00::1/64", nc.Details.Ips[1].String())
+	assert.Equal(t, []string{"1", "2"}, nc.Details.Groups)
+	assert.Equal(t, "10.1.1.0/24", nc.Details.Subnets[0].String())
+	assert.Equal(t, map[string]string{"team": "ops"}, nc.Details.Extensions)
+	assert.Equal(t, r.Details.PublicKey, nc.Details.PublicKey)
+
+	rb, _ = ioutil.ReadFile(keyPath)
+	rawPriv, _, err := cert.UnmarshalX25519PrivateKey(rb)
+	assert.Nil(t, err)
+	assert.Nil(t, nc.VerifyPrivateKey(rawPriv))
+
+	// the ca can override what was requested
+	os.Remove(crtPath)
+	args = []string{"-ca-crt", caCrt, "-ca-key", caKey, "-in-csr", csrPath, "-out-crt", crtPath, "-name", "renamed", "-ip", "2.2.2.2/24", "-groups", "3", "-subnets", "", "-extensions", "team=dev"}
+	assert.Nil(t, signCert(args, ob, eb, nopw))
+	rb, _ = ioutil.ReadFile(crtPath)
+	nc, _, err = cert.UnmarshalNebulaCertificateFromPEM(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, "renamed", nc.Details.Name)
+	assert.Len(t, nc.Details.Ips, 1)
+	assert.Equal(t, "2.2.2.2/24", nc.Details.Ips[0].String())
+	assert.Equal(t, []string{"3"}, nc.Details.Groups)
+	assert.Equal(t, "10.1.1.0/24", nc.Details.Subnets[0].String())
+	assert.Equal(t, map[string]string{"team": "dev"}, nc.Details.Extensions)
+}
@@ -22,6 +22,8 @@ func Test_keygenHelp(t *testing.T) {
 	assert.Equal(
 		t,
 		"Usage of "+os.Args[0]+" keygen <flags>: create a public/private key pair. the public key can be passed to `nebula-cert sign`\n"+
+			"  -curve string\n"+
+			"    \tOptional: curve of the key pair, must match the curve of the signing CA. Valid curves are 25519 and P256 (default \"25519\")\n"+
 			"  -out-key string\n"+
 			"    \tRequired: path to write the private key to\n"+
 			"  -out-pub string\n"+
@@ -89,4 +91,26 @@ func Test_keygen(t *testing.T) {
 	assert.Len(t, b, 0)
 	assert.Nil(t, err)
 	assert.Len(t, lPub, 32)
+
+	// P256 keys
+	assertHelpError(t, keygen([]string{"-curve", "nope", "-out-pub", pubF.Name(), "-out-key", keyF.Name()}, ob, eb), "invalid curve: nope, should be 25519 or P256")
+
+	args = []string{"-curve", "P256", "-out-pub", pubF.Name(), "-out-key", keyF.Name()}
+	assert.Nil(t, keygen(args, ob, eb))
+
+	rb, _ = ioutil.ReadFile(keyF.Name())
+	lKey, _, curve, err := cert.UnmarshalPrivateKey(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, curve)
+	assert.Len(t, lKey, 32)
+
+	rb, _ = ioutil.ReadFile(pubF.Name())
+	lPub, _, curve, err = cert.UnmarshalPublicKey(rb)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Curve_P256, curve)
+	assert.Len(t, lPub, 65)
+
+	p, err := cert.P256PublicKey(lKey)
+	assert.Nil(t, err)
+	assert.Equal(t, p, lPub)
 }
@@ -62,15 +62,27 @@ func main() {
 
 	switch args[0] {
 	case "ca":
-		err = ca(args[1:], os.Stdout, os.Stderr)
+		err = ca(args[1:], os.Stdout, os.Stderr, StdinPasswordReader{})
 	case "keygen":
 		err = keygen(args[1:], os.Stdout, os.Stderr)
 	case "sign":
-		err = signCert(args[1:], os.Stdout, os.Stderr)
+		err = signCert(args[1:], os.Stdout, os.Stderr, StdinPasswordReader{})
 	case "print":
 		err = printCert(args[1:], os.Stdout, os.Stderr)
 	case "verify":
 		err = verify(args[1:], os.Stdout, os.Stderr)
+	case "crl":
+		err = crl(args[1:], os.Stdout, os.Stderr, StdinPasswordReader{})
+	case "csr":
+		err = csr(args[1:], os.Stdout, os.Stderr)
+	case "sign-batch":
+		err = signBatch(args[1:], os.Stdout, os.Stderr, StdinPasswordReader{})
+	case "audit":
+		err = audit(args[1:], os.Stdout, os.Stderr)
+	case "bundle":
+		err = bundle(args[1:], os.Stdout, os.Stderr, StdinPasswordReader{})
+	case "unbundle":
+		err = unbundle(args[1:], os.Stdout, os.Stderr, StdinPasswordReader{})
 	default:
 		err = fmt.Errorf("unknown mode: %s", args[0])
 	}
@@ -104,6 +116,18 @@ func handleError(mode string, e error, out io.Writer) int {
 			printHelp(out)
 		case "verify":
 			verifyHelp(out)
+		case "crl":
+			crlHelp(out)
+		case "csr":
+			csrHelp(out)
+		case "sign-batch":
+			signBatchHelp(out)
+		case "audit":
+			auditHelp(out)
+		case "bundle":
+			bundleHelp(out)
+		case "unbundle":
+			unbundleHelp(out)
 		}
 	}
 
@@ -127,6 +151,23 @@ func help(err string, out io.Writer) {
 	fmt.Fprintln(out, "    "+signSummary())
 	fmt.Fprintln(out, "    "+printSummary())
 	fmt.Fprintln(out, "    "+verifySummary())
+	fmt.Fprintln(out, "    "+crlSummary())
+	fmt.Fprintln(out, "    "+csrSummary())
+	fmt.Fprintln(out, "    "+signBatchSummary())
+	fmt.Fprintln(out, "    "+auditSummary())
+	fmt.Fprintln(out, "    "+bundleSummary())
+	fmt.Fprintln(out, "    "+unbundleSummary())
+}
+
+// flagPassed returns true if the named flag was set on the command line
+func flagPassed(set *flag.FlagSet, name string) bool {
+	found := false
+	set.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
 }
 
 func mustFlagString(name string, val *string) error {
@@ -22,7 +22,13 @@ func Test_help(t *testing.T) {
 		"    " + keygenSummary() + "\n" +
 		"    " + signSummary() + "\n" +
 		"    " + printSummary() + "\n" +
-		"    " + verifySummary() + "\n"
+		"    " + verifySummary() + "\n" +
+		"    " + crlSummary() + "\n" +
+		"    " + csrSummary() + "\n" +
+		"    " + signBatchSummary() + "\n" +
+		"    " + auditSummary() + "\n" +
+		"    " + bundleSummary() + "\n" +
+		"    " + unbundleSummary() + "\n"
 
 	ob := &bytes.Buffer{}
 
@@ -57,7 +63,7 @@ func Test_handleError(t *testing.T) {
 	assert.Equal(t, "Error: test error\n", ob.String())
 
 	// test all modes with help error
-	modes := map[string]func(io.Writer){"ca": caHelp, "print": printHelp, "sign": signHelp, "verify": verifyHelp}
+	modes := map[string]func(io.Writer){"ca": caHelp, "print": printHelp, "sign": signHelp, "verify": verifyHelp, "crl": crlHelp, "csr": csrHelp, "sign-batch": signBatchHelp, "audit": auditHelp, "bundle": bundleHelp, "unbundle": unbundleHelp}
 	eb := &bytes.Buffer{}
 	for mode, fn := range modes {
 		ob.Reset()
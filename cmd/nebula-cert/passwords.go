@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/slackhq/nebula/cert"
+	"golang.org/x/term"
+)
+
+// caPassphraseEnv is checked for the CA passphrase when no passphrase file was provided
+const caPassphraseEnv = "NEBULA_CA_PASSPHRASE"
+
+var ErrNoTerminal = errors.New("cannot read password from nonexistent terminal")
+
+// PasswordReader reads a passphrase without echoing it
+type PasswordReader interface {
+	ReadPassword() ([]byte, error)
+}
+
+type StdinPasswordReader struct{}
+
+func (pr StdinPasswordReader) ReadPassword() ([]byte, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, ErrNoTerminal
+	}
+
+	return term.ReadPassword(int(os.Stdin.Fd()))
+}
+
+// readCAPassphrase returns the passphrase from path, the NEBULA_CA_PASSPHRASE environment variable or a prompt, in
+// that order. A prompted passphrase is asked for twice if confirm is set.
+func readCAPassphrase(path string, confirm bool, errOut io.Writer, pr PasswordReader) ([]byte, error) {
+	if env := os.Getenv(caPassphraseEnv); path == "" && env != "" {
+		return []byte(env), nil
+	}
+
+	return readPassphrase(path, confirm, errOut, pr)
+}
+
+// readPassphrase returns the passphrase from path or a prompt when path is empty. A prompted passphrase is asked for
+// twice if confirm is set.
+func readPassphrase(path string, confirm bool, errOut io.Writer, pr PasswordReader) ([]byte, error) {
+	var passphrase []byte
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading passphrase file: %s", err)
+		}
+		passphrase = bytes.TrimRight(b, "\r\n")
+
+	} else {
+		var err error
+		passphrase, err = promptPassphrase("Enter passphrase: ", errOut, pr)
+		if err != nil {
+			return nil, err
+		}
+
+		if confirm && len(passphrase) > 0 {
+			again, err := promptPassphrase("Confirm passphrase: ", errOut, pr)
+			if err != nil {
+				return nil, err
+			}
+
+			if !bytes.Equal(passphrase, again) {
+				return nil, fmt.Errorf("passphrases did not match")
+			}
+		}
+	}
+
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("no passphrase specified")
+	}
+
+	return passphrase, nil
+}
+
+func promptPassphrase(prompt string, errOut io.Writer, pr PasswordReader) ([]byte, error) {
+	errOut.Write([]byte(prompt))
+	passphrase, err := pr.ReadPassword()
+	errOut.Write([]byte("\n"))
+	if err != nil {
+		return nil, fmt.Errorf("error while reading passphrase: %s", err)
+	}
+
+	return passphrase, nil
+}
+
+// readCAKey reads and parses the ca key at path, asking for the passphrase if the key is encrypted
+func readCAKey(path, passphrasePath string, errOut io.Writer, pr PasswordReader) ([]byte, error) {
+	rawCAKey, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading ca-key: %s", err)
+	}
+
+	caKey, _, _, err := cert.UnmarshalSigningPrivateKey(rawCAKey)
+	if err == cert.ErrPrivateKeyEncrypted {
+		passphrase, err := readCAPassphrase(passphrasePath, false, errOut, pr)
+		if err != nil {
+			return nil, err
+		}
+
+		_, caKey, _, err = cert.DecryptAndUnmarshalSigningPrivateKey(passphrase, rawCAKey)
+		if err != nil {
+			return nil, fmt.Errorf("error while decrypting ca-key: %s", err)
+		}
+
+	} else if err != nil {
+		return nil, fmt.Errorf("error while parsing ca-key: %s", err)
+	}
+
+	return caKey, nil
+}
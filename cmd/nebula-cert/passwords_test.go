@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ed25519"
+)
+
+// StubPasswordReader returns each of passwords in turn, or err if set
+type StubPasswordReader struct {
+	passwords [][]byte
+	err       error
+}
+
+func (pr *StubPasswordReader) ReadPassword() ([]byte, error) {
+	if pr.err != nil || len(pr.passwords) == 0 {
+		return nil, pr.err
+	}
+
+	p := pr.passwords[0]
+	pr.passwords = pr.passwords[1:]
+	return p, nil
+}
+
+func Test_readCAPassphrase(t *testing.T) {
+	eb := &bytes.Buffer{}
+	os.Unsetenv(caPassphraseEnv)
+
+	// prompted
+	pr := &StubPasswordReader{passwords: [][]byte{[]byte("pass")}}
+	p, err := readCAPassphrase("", false, eb, pr)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("pass"), p)
+	assert.Equal(t, "Enter passphrase: \n", eb.String())
+
+	// prompted with confirmation
+	eb.Reset()
+	pr = &StubPasswordReader{passwords: [][]byte{[]byte("pass"), []byte("pass")}}
+	p, err = readCAPassphrase("", true, eb, pr)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("pass"), p)
+	assert.Equal(t, "Enter passphrase: \nConfirm passphrase: \n", eb.String())
+
+	pr = &StubPasswordReader{passwords: [][]byte{[]byte("pass"), []byte("nope")}}
+	_, err = readCAPassphrase("", true, eb, pr)
+	assert.EqualError(t, err, "passphrases did not match")
+
+	_, err = readCAPassphrase("", true, eb, &StubPasswordReader{})
+	assert.EqualError(t, err, "no passphrase specified")
+
+	_, err = readCAPassphrase("", false, eb, &StubPasswordReader{err: errors.New("test error")})
+	assert.EqualError(t, err, "error while reading passphrase: test error")
+
+	// environment
+	eb.Reset()
+	os.Setenv(caPassphraseEnv, "from env")
+	defer os.Unsetenv(caPassphraseEnv)
+	p, err = readCAPassphrase("", true, eb, &StubPasswordReader{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("from env"), p)
+	assert.Empty(t, eb.String())
+
+	// file wins over the environment, the trailing newline is dropped
+	_, err = readCAPassphrase("./nope", false, eb, &StubPasswordReader{})
+	assert.EqualError(t, err, "error while reading passphrase file: open ./nope: "+NoSuchFileError)
+
+	pf, err := ioutil.TempFile("", "passphrase")
+	assert.Nil(t, err)
+	defer os.Remove(pf.Name())
+	pf.Write([]byte("from file\n"))
+	p, err = readCAPassphrase(pf.Name(), false, eb, &StubPasswordReader{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("from file"), p)
+	assert.Empty(t, eb.String())
+}
+
+func Test_readCAKey(t *testing.T) {
+	eb := &bytes.Buffer{}
+	os.Unsetenv(caPassphraseEnv)
+
+	_, err := readCAKey("./nope", "", eb, &StubPasswordReader{})
+	assert.EqualError(t, err, "error while reading ca-key: open ./nope: "+NoSuchFileError)
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	keyF, err := ioutil.TempFile("", "test.key")
+	assert.Nil(t, err)
+	defer os.Remove(keyF.Name())
+
+	// garbage
+	keyF.Write([]byte("nope"))
+	_, err = readCAKey(keyF.Name(), "", eb, &StubPasswordReader{})
+	assert.EqualError(t, err, "error while parsing ca-key: input did not contain a valid PEM encoded block")
+
+	// plain keys never prompt
+	assert.Nil(t, ioutil.WriteFile(keyF.Name(), cert.MarshalEd25519PrivateKey(priv), 0600))
+	k, err := readCAKey(keyF.Name(), "", eb, &StubPasswordReader{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(priv), k)
+	assert.Empty(t, eb.String())
+
+	// encrypted keys do
+	b, err := cert.EncryptAndMarshalEd25519PrivateKey(priv, []byte("pass"), cert.NewArgon2Parameters(64*1024, 4, 1))
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(keyF.Name(), b, 0600))
+
+	k, err = readCAKey(keyF.Name(), "", eb, &StubPasswordReader{passwords: [][]byte{[]byte("pass")}})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(priv), k)
+	assert.Equal(t, "Enter passphrase: \n", eb.String())
+
+	_, err = readCAKey(keyF.Name(), "", eb, &StubPasswordReader{passwords: [][]byte{[]byte("nope")}})
+	assert.EqualError(t, err, "error while decrypting ca-key: invalid passphrase or corrupt private key")
+}
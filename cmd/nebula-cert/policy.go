@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"gopkg.in/yaml.v2"
+)
+
+// signPolicy holds issuance rules that are enforced on top of the CA constraints, see examples/policy.yml
+type signPolicy struct {
+	names       []*regexp.Regexp
+	maxDuration time.Duration
+	groups      map[string]*groupPolicy
+	forbidden   [][]string
+}
+
+type groupPolicy struct {
+	ips         []*net.IPNet
+	maxDuration time.Duration
+}
+
+type rawSignPolicy struct {
+	Names                      []string                  `yaml:"names"`
+	MaxDuration                string                    `yaml:"max_duration"`
+	Groups                     map[string]rawGroupPolicy `yaml:"groups"`
+	ForbiddenGroupCombinations [][]string                `yaml:"forbidden_group_combinations"`
+}
+
+type rawGroupPolicy struct {
+	Ips         []string `yaml:"ips"`
+	MaxDuration string   `yaml:"max_duration"`
+}
+
+func loadSignPolicy(path string) (*signPolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading policy: %s", err)
+	}
+
+	p, err := parseSignPolicy(b)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing policy: %s", err)
+	}
+
+	return p, nil
+}
+
+func parseSignPolicy(b []byte) (*signPolicy, error) {
+	var raw rawSignPolicy
+	// Be strict, a misspelled rule should not silently allow everything
+	if err := yaml.UnmarshalStrict(b, &raw); err != nil {
+		return nil, err
+	}
+
+	p := signPolicy{groups: map[string]*groupPolicy{}}
+	for _, n := range raw.Names {
+		// Patterns must match the whole name
+		re, err := regexp.Compile("^(?:" + n + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid names pattern %q: %s", n, err)
+		}
+		p.names = append(p.names, re)
+	}
+
+	var err error
+	p.maxDuration, err = parsePolicyDuration(raw.MaxDuration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max_duration: %s", err)
+	}
+
+	for name, rg := range raw.Groups {
+		g := groupPolicy{}
+		for _, rs := range rg.Ips {
+			_, n, err := net.ParseCIDR(rs)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ips for group %s: %s", name, err)
+			}
+			g.ips = append(g.ips, n)
+		}
+
+		g.maxDuration, err = parsePolicyDuration(rg.MaxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_duration for group %s: %s", name, err)
+		}
+
+		p.groups[name] = &g
+	}
+
+	for _, combo := range raw.ForbiddenGroupCombinations {
+		if len(combo) < 2 {
+			return nil, fmt.Errorf("forbidden_group_combinations entries need at least 2 groups, have %v", combo)
+		}
+		p.forbidden = append(p.forbidden, combo)
+	}
+
+	return &p, nil
+}
+
+func parsePolicyDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("must be greater than 0, have %s", s)
+	}
+
+	return d, nil
+}
+
+// check returns an error listing every rule the certificate violates
+func (p *signPolicy) check(nc *cert.NebulaCertificate) error {
+	var violations []string
+
+	if len(p.names) > 0 {
+		matched := false
+		for _, re := range p.names {
+			if re.MatchString(nc.Details.Name) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			violations = append(violations, fmt.Sprintf("names: %s does not match any allowed pattern", nc.Details.Name))
+		}
+	}
+
+	duration := nc.Details.NotAfter.Sub(nc.Details.NotBefore)
+	if p.maxDuration > 0 && duration > p.maxDuration {
+		violations = append(violations, fmt.Sprintf("max_duration: %s exceeds %s", duration, p.maxDuration))
+	}
+
+	// Walk groups in order so the error is stable
+	groups := append([]string{}, nc.Details.Groups...)
+	sort.Strings(groups)
+	hasGroup := map[string]struct{}{}
+	for _, name := range groups {
+		hasGroup[name] = struct{}{}
+	}
+
+	for _, name := range groups {
+		g, ok := p.groups[name]
+		if !ok {
+			continue
+		}
+
+		if g.maxDuration > 0 && duration > g.maxDuration {
+			violations = append(violations, fmt.Sprintf("groups.%s.max_duration: %s exceeds %s", name, duration, g.maxDuration))
+		}
+
+		if len(g.ips) > 0 {
+			for _, ip := range nc.Details.Ips {
+				if !policyIpsContain(g.ips, ip.IP) {
+					violations = append(violations, fmt.Sprintf("groups.%s.ips: %s is not in an allowed range", name, ip.IP))
+				}
+			}
+		}
+	}
+
+	for _, combo := range p.forbidden {
+		all := true
+		for _, g := range combo {
+			if _, ok := hasGroup[g]; !ok {
+				all = false
+				break
+			}
+		}
+
+		if all {
+			violations = append(violations, fmt.Sprintf("forbidden_group_combinations: %s may not be combined", strings.Join(combo, ", ")))
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%s", strings.Join(violations, "; "))
+	}
+
+	return nil
+}
+
+func policyIpsContain(ranges []*net.IPNet, ip net.IP) bool {
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
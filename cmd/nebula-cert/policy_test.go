@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseSignPolicy(t *testing.T) {
+	_, err := parseSignPolicy([]byte("name: [nope]"))
+	assert.EqualError(t, err, "yaml: unmarshal errors:\n  line 1: field name not found in type main.rawSignPolicy")
+
+	_, err = parseSignPolicy([]byte("names: [\"(\"]"))
+	assert.EqualError(t, err, "invalid names pattern \"(\": error parsing regexp: missing closing ): `^(?:()$`")
+
+	_, err = parseSignPolicy([]byte("max_duration: 1x"))
+	assert.EqualError(t, err, "invalid max_duration: time: unknown unit \"x\" in duration \"1x\"")
+
+	_, err = parseSignPolicy([]byte("max_duration: -1h"))
+	assert.EqualError(t, err, "invalid max_duration: must be greater than 0, have -1h")
+
+	_, err = parseSignPolicy([]byte("groups: {servers: {ips: [nope]}}"))
+	assert.EqualError(t, err, "invalid ips for group servers: invalid CIDR address: nope")
+
+	_, err = parseSignPolicy([]byte("groups: {servers: {max_duration: nope}}"))
+	assert.EqualError(t, err, "invalid max_duration for group servers: time: invalid duration \"nope\"")
+
+	_, err = parseSignPolicy([]byte("forbidden_group_combinations: [[servers]]"))
+	assert.EqualError(t, err, "forbidden_group_combinations entries need at least 2 groups, have [servers]")
+
+	// The example must stay valid
+	b, err := ioutil.ReadFile("../../examples/policy.yml")
+	assert.Nil(t, err)
+	p, err := parseSignPolicy(b)
+	assert.Nil(t, err)
+	assert.Len(t, p.names, 2)
+	assert.Equal(t, 8760*time.Hour, p.maxDuration)
+	assert.Len(t, p.groups, 2)
+	assert.Equal(t, [][]string{{"laptop", "servers"}}, p.forbidden)
+}
+
+func Test_signPolicy_check(t *testing.T) {
+	b, err := ioutil.ReadFile("../../examples/policy.yml")
+	assert.Nil(t, err)
+	p, err := parseSignPolicy(b)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	newCert := func(name string, d time.Duration, groups []string, ips ...string) *cert.NebulaCertificate {
+		nc := &cert.NebulaCertificate{
+			Details: cert.NebulaCertificateDetails{
+				Name:      name,
+				Groups:    groups,
+				NotBefore: now,
+				NotAfter:  now.Add(d),
+			},
+		}
+		for _, s := range ips {
+			ip, n, err := net.ParseCIDR(s)
+			assert.Nil(t, err)
+			n.IP = ip
+			nc.Details.Ips = append(nc.Details.Ips, n)
+		}
+		return nc
+	}
+
+	assert.Nil(t, p.check(newCert("web1.servers.example.com", 2160*time.Hour, []string{"servers"}, "192.168.100.1/24", "fd00:100::1/64")))
+	assert.Nil(t, p.check(newCert("laptop-alice", time.Hour, []string{"laptop", "home"}, "192.168.100.200/24")))
+
+	// Groups without rules only get the top level rules
+	assert.Nil(t, p.check(newCert("laptop-bob", 8760*time.Hour, []string{"home"}, "10.0.0.1/8")))
+
+	assert.EqualError(t,
+		p.check(newCert("web1.servers.example.com.evil", time.Hour, nil, "192.168.100.1/24")),
+		"names: web1.servers.example.com.evil does not match any allowed pattern",
+	)
+
+	assert.EqualError(t,
+		p.check(newCert("laptop-alice", 8761*time.Hour, nil, "192.168.100.200/24")),
+		"max_duration: 8761h0m0s exceeds 8760h0m0s",
+	)
+
+	assert.EqualError(t,
+		p.check(newCert("web1.servers.example.com", 2161*time.Hour, []string{"servers"}, "192.168.100.1/24", "fd00:200::1/64")),
+		"groups.servers.max_duration: 2161h0m0s exceeds 2160h0m0s; groups.servers.ips: fd00:200::1 is not in an allowed range",
+	)
+
+	// Every violation is listed
+	assert.EqualError(t,
+		p.check(newCert("nope", 721*time.Hour, []string{"servers", "laptop"}, "192.168.100.1/24")),
+		"names: nope does not match any allowed pattern; "+
+			"groups.laptop.max_duration: 721h0m0s exceeds 720h0m0s; "+
+			"groups.laptop.ips: 192.168.100.1 is not in an allowed range; "+
+			"forbidden_group_combinations: laptop, servers may not be combined",
+	)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"flag"
 	"fmt"
@@ -17,18 +19,22 @@ import (
 )
 
 type signFlags struct {
-	set         *flag.FlagSet
-	caKeyPath   *string
-	caCertPath  *string
-	name        *string
-	ip          *string
-	duration    *time.Duration
-	inPubPath   *string
-	outKeyPath  *string
-	outCertPath *string
-	outQRPath   *string
-	groups      *string
-	subnets     *string
+	set              *flag.FlagSet
+	caKeyPath        *string
+	caCertPath       *string
+	caPassphrasePath *string
+	name             *string
+	ip               *string
+	duration         *time.Duration
+	inPubPath        *string
+	inCSRPath        *string
+	outKeyPath       *string
+	outCertPath      *string
+	outQRPath        *string
+	groups           *string
+	subnets          *string
+	extensions       *string
+	policyPath       *string
 }
 
 func newSignFlags() *signFlags {
@@ -36,20 +42,24 @@ func newSignFlags() *signFlags {
 	sf.set.Usage = func() {}
 	sf.caKeyPath = sf.set.String("ca-key", "ca.key", "Optional: path to the signing CA key")
 	sf.caCertPath = sf.set.String("ca-crt", "ca.crt", "Optional: path to the signing CA cert")
-	sf.name = sf.set.String("name", "", "Required: name of the cert, usually a hostname")
-	sf.ip = sf.set.String("ip", "", "Required: ipv4 address and network in CIDR notation to assign the cert")
+	sf.caPassphrasePath = sf.set.String("ca-passphrase-file", "", "Optional: path to a file holding the passphrase of an encrypted ca-key. The "+caPassphraseEnv+" environment variable is used if not set, otherwise the passphrase is prompted for")
+	sf.name = sf.set.String("name", "", "Required (if in-csr not set): name of the cert, usually a hostname")
+	sf.ip = sf.set.String("ip", "", "Required (if in-csr not set): ipv4 and/or ipv6 address and network in CIDR notation to assign the cert, separated by a comma")
 	sf.duration = sf.set.Duration("duration", 0, "Optional: how long the cert should be valid for. The default is 1 second before the signing cert expires. Valid time units are seconds: \"s\", minutes: \"m\", hours: \"h\"")
 	sf.inPubPath = sf.set.String("in-pub", "", "Optional (if out-key not set): path to read a previously generated public key")
-	sf.outKeyPath = sf.set.String("out-key", "", "Optional (if in-pub not set): path to write the private key to")
+	sf.inCSRPath = sf.set.String("in-csr", "", "Optional: path to read a certificate request created with nebula-cert csr. Name, ip, groups, subnets and extensions are taken from the request unless set")
+	sf.outKeyPath = sf.set.String("out-key", "", "Optional (if in-pub or in-csr not set): path to write the private key to")
 	sf.outCertPath = sf.set.String("out-crt", "", "Optional: path to write the certificate to")
 	sf.outQRPath = sf.set.String("out-qr", "", "Optional: output a qr code image (png) of the certificate")
 	sf.groups = sf.set.String("groups", "", "Optional: comma separated list of groups")
-	sf.subnets = sf.set.String("subnets", "", "Optional: comma separated list of ipv4 address and network in CIDR notation. Subnets this cert can serve for")
+	sf.subnets = sf.set.String("subnets", "", "Optional: comma separated list of ipv4 or ipv6 address and network in CIDR notation. Subnets this cert can serve for")
+	sf.extensions = sf.set.String("extensions", "", "Optional: comma separated list of key=value metadata to sign into the cert, ie: team=ops,env=prod")
+	sf.policyPath = sf.set.String("policy", "", "Optional: path to a yaml policy file the certificate must satisfy, see examples/policy.yml")
 	return &sf
 
 }
 
-func signCert(args []string, out io.Writer, errOut io.Writer) error {
+func signCert(args []string, out io.Writer, errOut io.Writer, pr PasswordReader) error {
 	sf := newSignFlags()
 	err := sf.set.Parse(args)
 	if err != nil {
@@ -62,151 +72,164 @@ func signCert(args []string, out io.Writer, errOut io.Writer) error {
 	if err := mustFlagString("ca-crt", sf.caCertPath); err != nil {
 		return err
 	}
-	if err := mustFlagString("name", sf.name); err != nil {
-		return err
-	}
-	if err := mustFlagString("ip", sf.ip); err != nil {
-		return err
+	if *sf.inCSRPath == "" {
+		if err := mustFlagString("name", sf.name); err != nil {
+			return err
+		}
+		if err := mustFlagString("ip", sf.ip); err != nil {
+			return err
+		}
+	} else if *sf.inPubPath != "" || *sf.outKeyPath != "" {
+		return newHelpErrorf("cannot set -in-csr with -in-pub or -out-key")
 	}
 	if *sf.inPubPath != "" && *sf.outKeyPath != "" {
 		return newHelpErrorf("cannot set both -in-pub and -out-key")
 	}
 
-	rawCAKey, err := ioutil.ReadFile(*sf.caKeyPath)
+	caCert, caKey, issuer, err := loadSigningCA(*sf.caKeyPath, *sf.caCertPath, *sf.caPassphrasePath, errOut, pr)
 	if err != nil {
-		return fmt.Errorf("error while reading ca-key: %s", err)
+		return err
 	}
 
-	caKey, _, err := cert.UnmarshalEd25519PrivateKey(rawCAKey)
-	if err != nil {
-		return fmt.Errorf("error while parsing ca-key: %s", err)
+	// if no duration is given, expire one second before the root expires
+	if *sf.duration <= 0 {
+		*sf.duration = time.Until(caCert.Details.NotAfter) - time.Second*1
 	}
 
-	rawCACert, err := ioutil.ReadFile(*sf.caCertPath)
-	if err != nil {
-		return fmt.Errorf("error while reading ca-crt: %s", err)
-	}
+	var csr *cert.NebulaCertificateRequest
+	if *sf.inCSRPath != "" {
+		rawCSR, err := ioutil.ReadFile(*sf.inCSRPath)
+		if err != nil {
+			return fmt.Errorf("error while reading in-csr: %s", err)
+		}
 
-	caCert, _, err := cert.UnmarshalNebulaCertificateFromPEM(rawCACert)
-	if err != nil {
-		return fmt.Errorf("error while parsing ca-crt: %s", err)
-	}
+		csr, _, err = cert.UnmarshalNebulaCertificateRequestFromPEM(rawCSR)
+		if err != nil {
+			return fmt.Errorf("error while parsing in-csr: %s", err)
+		}
 
-	if err := caCert.VerifyPrivateKey(caKey); err != nil {
-		return fmt.Errorf("refusing to sign, root certificate does not match private key")
+		if csr.Details.Issuer != issuer {
+			return fmt.Errorf("refusing to sign, certificate request was made for a different ca")
+		}
+
+		if !csr.CheckProof(caKey) {
+			return fmt.Errorf("refusing to sign, certificate request proof of possession is invalid")
+		}
 	}
 
-	issuer, err := caCert.Sha256Sum()
-	if err != nil {
-		return fmt.Errorf("error while getting -ca-crt fingerprint: %s", err)
+	name := *sf.name
+	if name == "" {
+		name = csr.Details.Name
 	}
 
-	if caCert.Expired(time.Now()) {
-		return fmt.Errorf("ca certificate is expired")
+	var ips []*net.IPNet
+	if *sf.ip != "" {
+		ips, err = parseIps(*sf.ip)
+		if err != nil {
+			return err
+		}
+	} else {
+		ips = csr.Details.Ips
+		if err := checkRequestIps(ips); err != nil {
+			return fmt.Errorf("refusing to sign, %s", err)
+		}
 	}
 
-	// if no duration is given, expire one second before the root expires
-	if *sf.duration <= 0 {
-		*sf.duration = time.Until(caCert.Details.NotAfter) - time.Second*1
+	groups := parseGroups(*sf.groups)
+	if *sf.groups == "" && csr != nil {
+		groups = csr.Details.Groups
 	}
 
-	ip, ipNet, err := net.ParseCIDR(*sf.ip)
+	subnets, err := parseSubnets(*sf.subnets)
 	if err != nil {
-		return newHelpErrorf("invalid ip definition: %s", err)
+		return err
 	}
-	if ip.To4() == nil {
-		return newHelpErrorf("invalid ip definition: can only be ipv4, have %s", *sf.ip)
+	if *sf.subnets == "" && csr != nil {
+		subnets = csr.Details.Subnets
 	}
-	ipNet.IP = ip
 
-	groups := []string{}
-	if *sf.groups != "" {
-		for _, rg := range strings.Split(*sf.groups, ",") {
-			g := strings.TrimSpace(rg)
-			if g != "" {
-				groups = append(groups, g)
-			}
-		}
+	extensions, err := parseExtensions(*sf.extensions)
+	if err != nil {
+		return err
 	}
-
-	subnets := []*net.IPNet{}
-	if *sf.subnets != "" {
-		for _, rs := range strings.Split(*sf.subnets, ",") {
-			rs := strings.Trim(rs, " ")
-			if rs != "" {
-				_, s, err := net.ParseCIDR(rs)
-				if err != nil {
-					return newHelpErrorf("invalid subnet definition: %s", err)
-				}
-				if s.IP.To4() == nil {
-					return newHelpErrorf("invalid subnet definition: can only be ipv4, have %s", rs)
-				}
-				subnets = append(subnets, s)
-			}
-		}
+	if *sf.extensions == "" && csr != nil {
+		extensions = csr.Details.Extensions
 	}
 
 	var pub, rawPriv []byte
-	if *sf.inPubPath != "" {
+	if csr != nil {
+		pub = csr.Details.PublicKey
+	} else if *sf.inPubPath != "" {
 		rawPub, err := ioutil.ReadFile(*sf.inPubPath)
 		if err != nil {
 			return fmt.Errorf("error while reading in-pub: %s", err)
 		}
-		pub, _, err = cert.UnmarshalX25519PublicKey(rawPub)
+		var curve cert.Curve
+		pub, _, curve, err = cert.UnmarshalPublicKey(rawPub)
 		if err != nil {
 			return fmt.Errorf("error while parsing in-pub: %s", err)
 		}
+		if curve != caCert.Details.Curve {
+			return fmt.Errorf("refusing to sign, in-pub curve %s does not match the ca-crt curve %s", curve, caCert.Details.Curve)
+		}
 	} else {
-		pub, rawPriv = x25519Keypair()
+		pub, rawPriv = newKeypair(caCert.Details.Curve)
 	}
 
+	now := time.Now()
 	nc := cert.NebulaCertificate{
 		Details: cert.NebulaCertificateDetails{
-			Name:      *sf.name,
-			Ips:       []*net.IPNet{ipNet},
-			Groups:    groups,
-			Subnets:   subnets,
-			NotBefore: time.Now(),
-			NotAfter:  time.Now().Add(*sf.duration),
-			PublicKey: pub,
-			IsCA:      false,
-			Issuer:    issuer,
+			Name:       name,
+			Ips:        ips,
+			Groups:     groups,
+			Subnets:    subnets,
+			NotBefore:  now,
+			NotAfter:   now.Add(*sf.duration),
+			PublicKey:  pub,
+			IsCA:       false,
+			Issuer:     issuer,
+			Curve:      caCert.Details.Curve,
+			Extensions: extensions,
 		},
+		Chain: issuedChain(caCert),
 	}
 
-	if err := nc.CheckRootConstrains(caCert); err != nil {
-		return fmt.Errorf("refusing to sign, root certificate constraints violated: %s", err)
+	var policy *signPolicy
+	if *sf.policyPath != "" {
+		policy, err = loadSignPolicy(*sf.policyPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := issueCert(&nc, caCert, caKey, policy); err != nil {
+		return err
 	}
 
 	if *sf.outKeyPath == "" {
-		*sf.outKeyPath = *sf.name + ".key"
+		*sf.outKeyPath = name + ".key"
 	}
 
 	if *sf.outCertPath == "" {
-		*sf.outCertPath = *sf.name + ".crt"
+		*sf.outCertPath = name + ".crt"
 	}
 
 	if _, err := os.Stat(*sf.outCertPath); err == nil {
 		return fmt.Errorf("refusing to overwrite existing cert: %s", *sf.outCertPath)
 	}
 
-	err = nc.Sign(caKey)
-	if err != nil {
-		return fmt.Errorf("error while signing: %s", err)
-	}
-
-	if *sf.inPubPath == "" {
+	if *sf.inPubPath == "" && csr == nil {
 		if _, err := os.Stat(*sf.outKeyPath); err == nil {
 			return fmt.Errorf("refusing to overwrite existing key: %s", *sf.outKeyPath)
 		}
 
-		err = ioutil.WriteFile(*sf.outKeyPath, cert.MarshalX25519PrivateKey(rawPriv), 0600)
+		err = ioutil.WriteFile(*sf.outKeyPath, cert.MarshalPrivateKey(caCert.Details.Curve, rawPriv), 0600)
 		if err != nil {
 			return fmt.Errorf("error while writing out-key: %s", err)
 		}
 	}
 
-	b, err := nc.MarshalToPEM()
+	b, err := nc.MarshalToPEMWithChain()
 	if err != nil {
 		return fmt.Errorf("error while marshalling certificate: %s", err)
 	}
@@ -231,6 +254,71 @@ func signCert(args []string, out io.Writer, errOut io.Writer) error {
 	return nil
 }
 
+// loadSigningCA reads the CA key and cert used to sign certificates and makes sure they can be used together
+func loadSigningCA(caKeyPath, caCertPath, passphrasePath string, errOut io.Writer, pr PasswordReader) (*cert.NebulaCertificate, []byte, string, error) {
+	caKey, err := readCAKey(caKeyPath, passphrasePath, errOut, pr)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	rawCACert, err := ioutil.ReadFile(caCertPath)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error while reading ca-crt: %s", err)
+	}
+
+	caCert, err := cert.UnmarshalNebulaCertificateWithChainFromPEM(rawCACert)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error while parsing ca-crt: %s", err)
+	}
+
+	if err := caCert.VerifyPrivateKey(caKey); err != nil {
+		return nil, nil, "", fmt.Errorf("refusing to sign, root certificate does not match private key")
+	}
+
+	issuer, err := caCert.Sha256Sum()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error while getting -ca-crt fingerprint: %s", err)
+	}
+
+	if caCert.Expired(time.Now()) {
+		return nil, nil, "", fmt.Errorf("ca certificate is expired")
+	}
+
+	return caCert, caKey, issuer, nil
+}
+
+// issueCert checks nc against the constraints of caCert and the optional policy, then signs it with caKey
+func issueCert(nc *cert.NebulaCertificate, caCert *cert.NebulaCertificate, caKey []byte, policy *signPolicy) error {
+	if err := nc.CheckRootConstrains(caCert); err != nil {
+		return fmt.Errorf("refusing to sign, root certificate constraints violated: %s", err)
+	}
+
+	if err := nc.CheckInheritedConstrains(caCert.Chain); err != nil {
+		return fmt.Errorf("refusing to sign, root certificate constraints violated: %s", err)
+	}
+
+	if policy != nil {
+		if err := policy.check(nc); err != nil {
+			return fmt.Errorf("refusing to sign, policy violated: %s", err)
+		}
+	}
+
+	if err := nc.Sign(caKey); err != nil {
+		return fmt.Errorf("error while signing: %s", err)
+	}
+
+	return nil
+}
+
+// issuedChain returns the intermediate chain for certificates signed by caCert, empty when caCert is a root
+func issuedChain(caCert *cert.NebulaCertificate) []*cert.NebulaCertificate {
+	if caCert.Details.Issuer == "" {
+		return nil
+	}
+
+	return append([]*cert.NebulaCertificate{caCert}, caCert.Chain...)
+}
+
 func x25519Keypair() ([]byte, []byte) {
 	privkey := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, privkey); err != nil {
@@ -245,6 +333,156 @@ func x25519Keypair() ([]byte, []byte) {
 	return pubkey, privkey
 }
 
+func p256Keypair() ([]byte, []byte) {
+	privkey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	pubkey := elliptic.Marshal(privkey.Curve, privkey.X, privkey.Y)
+	return pubkey, privkey.D.FillBytes(make([]byte, 32))
+}
+
+// newKeypair generates a host keypair on the curve
+func newKeypair(curve cert.Curve) ([]byte, []byte) {
+	if curve == cert.Curve_P256 {
+		return p256Keypair()
+	}
+
+	return x25519Keypair()
+}
+
+// parseCurve parses the -curve flag
+func parseCurve(s string) (cert.Curve, error) {
+	switch strings.ToUpper(s) {
+	case "25519", "X25519", "CURVE25519":
+		return cert.Curve_CURVE25519, nil
+	case "P256":
+		return cert.Curve_P256, nil
+	default:
+		return 0, newHelpErrorf("invalid curve: %s, should be 25519 or P256", s)
+	}
+}
+
+// parseIps parses the -ip flag, at most one ipv4 and one ipv6 address in CIDR notation with the ipv4 address first
+func parseIps(s string) ([]*net.IPNet, error) {
+	var ipNet, ipNet6 *net.IPNet
+	for _, rs := range strings.Split(s, ",") {
+		rs := strings.Trim(rs, " ")
+		if rs == "" {
+			continue
+		}
+
+		ip, n, err := net.ParseCIDR(rs)
+		if err != nil {
+			return nil, newHelpErrorf("invalid ip definition: %s", err)
+		}
+		n.IP = ip
+
+		if ip.To4() != nil {
+			if ipNet != nil {
+				return nil, newHelpErrorf("invalid ip definition: only one ipv4 address is allowed, have %s", s)
+			}
+			ipNet = n
+		} else {
+			if ipNet6 != nil {
+				return nil, newHelpErrorf("invalid ip definition: only one ipv6 address is allowed, have %s", s)
+			}
+			ipNet6 = n
+		}
+	}
+
+	var ips []*net.IPNet
+	if ipNet != nil {
+		ips = append(ips, ipNet)
+	}
+	if ipNet6 != nil {
+		ips = append(ips, ipNet6)
+	}
+
+	if len(ips) == 0 {
+		return nil, newHelpErrorf("invalid ip definition: an ipv4 or ipv6 address is required, have %s", s)
+	}
+
+	return ips, nil
+}
+
+// checkRequestIps applies the -ip rules to the ips of a certificate request
+func checkRequestIps(ips []*net.IPNet) error {
+	var v4, v6 int
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4++
+		} else {
+			v6++
+		}
+	}
+
+	if len(ips) == 0 || v4 > 1 || v6 > 1 {
+		return fmt.Errorf("certificate request must have at most one ipv4 and one ipv6 address, have %v", ips)
+	}
+
+	return nil
+}
+
+// parseGroups parses the comma separated -groups flag
+func parseGroups(s string) []string {
+	groups := []string{}
+	for _, rg := range strings.Split(s, ",") {
+		g := strings.TrimSpace(rg)
+		if g != "" {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+// parseExtensions parses the comma separated key=value -extensions flag
+func parseExtensions(s string) (map[string]string, error) {
+	var extensions map[string]string
+	for _, re := range strings.Split(s, ",") {
+		re = strings.TrimSpace(re)
+		if re == "" {
+			continue
+		}
+
+		kv := strings.SplitN(re, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, newHelpErrorf("invalid extension definition: %s, should be key=value", re)
+		}
+
+		if extensions == nil {
+			extensions = map[string]string{}
+		}
+
+		k := strings.TrimSpace(kv[0])
+		if _, ok := extensions[k]; ok {
+			return nil, newHelpErrorf("invalid extension definition: %s was provided more than once", k)
+		}
+		extensions[k] = strings.TrimSpace(kv[1])
+	}
+
+	return extensions, nil
+}
+
+// parseSubnets parses the comma separated -subnets flag
+func parseSubnets(s string) ([]*net.IPNet, error) {
+	subnets := []*net.IPNet{}
+	for _, rs := range strings.Split(s, ",") {
+		rs := strings.Trim(rs, " ")
+		if rs != "" {
+			_, n, err := net.ParseCIDR(rs)
+			if err != nil {
+				return nil, newHelpErrorf("invalid subnet definition: %s", err)
+			}
+			subnets = append(subnets, n)
+		}
+	}
+
+	return subnets, nil
+}
+
 func signSummary() string {
 	return "sign <flags>: create and sign a certificate"
 }
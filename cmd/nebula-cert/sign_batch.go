@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"gopkg.in/yaml.v2"
+)
+
+type signBatchFlags struct {
+	set              *flag.FlagSet
+	caKeyPath        *string
+	caCertPath       *string
+	caPassphrasePath *string
+	manifestPath     *string
+	outDir           *string
+	duration         *time.Duration
+	policyPath       *string
+}
+
+func newSignBatchFlags() *signBatchFlags {
+	sf := signBatchFlags{set: flag.NewFlagSet("sign-batch", flag.ContinueOnError)}
+	sf.set.Usage = func() {}
+	sf.caKeyPath = sf.set.String("ca-key", "ca.key", "Optional: path to the signing CA key")
+	sf.caCertPath = sf.set.String("ca-crt", "ca.crt", "Optional: path to the signing CA cert")
+	sf.caPassphrasePath = sf.set.String("ca-passphrase-file", "", "Optional: path to a file holding the passphrase of an encrypted ca-key. The "+caPassphraseEnv+" environment variable is used if not set, otherwise the passphrase is prompted for")
+	sf.manifestPath = sf.set.String("manifest", "", "Required: path to a yaml file listing the hosts to sign, see examples/manifest.yml")
+	sf.outDir = sf.set.String("out-dir", "", "Required: directory to write the certificates, keys and summary.json to. It is created if it does not exist")
+	sf.duration = sf.set.Duration("duration", 0, "Optional: how long the certs should be valid for unless a host sets its own. The default is 1 second before the signing cert expires. Valid time units are seconds: \"s\", minutes: \"m\", hours: \"h\"")
+	sf.policyPath = sf.set.String("policy", "", "Optional: path to a yaml policy file every certificate must satisfy, see examples/policy.yml")
+	return &sf
+}
+
+type rawSignManifest struct {
+	Hosts []rawManifestHost `yaml:"hosts"`
+}
+
+type rawManifestHost struct {
+	Name       string            `yaml:"name"`
+	Ip         string            `yaml:"ip"`
+	Groups     []string          `yaml:"groups"`
+	Subnets    []string          `yaml:"subnets"`
+	Extensions map[string]string `yaml:"extensions"`
+	Duration   string            `yaml:"duration"`
+	Pub        string            `yaml:"pub"`
+}
+
+type batchSummary struct {
+	CA    batchSummaryCA     `json:"ca"`
+	Hosts []batchSummaryHost `json:"hosts"`
+}
+
+type batchSummaryCA struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type batchSummaryHost struct {
+	Name        string    `json:"name"`
+	Ips         []string  `json:"ips"`
+	Groups      []string  `json:"groups"`
+	NotAfter    time.Time `json:"notAfter"`
+	Fingerprint string    `json:"fingerprint"`
+	Crt         string    `json:"crt"`
+	Key         string    `json:"key,omitempty"`
+	Pub         string    `json:"pub,omitempty"`
+}
+
+// batchHost is a signed certificate waiting to be written to the output directory
+type batchHost struct {
+	nc      *cert.NebulaCertificate
+	rawPriv []byte
+	pubPath string
+}
+
+func signBatch(args []string, out io.Writer, errOut io.Writer, pr PasswordReader) error {
+	sf := newSignBatchFlags()
+	err := sf.set.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := mustFlagString("ca-key", sf.caKeyPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("ca-crt", sf.caCertPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("manifest", sf.manifestPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("out-dir", sf.outDir); err != nil {
+		return err
+	}
+
+	rawManifest, err := ioutil.ReadFile(*sf.manifestPath)
+	if err != nil {
+		return fmt.Errorf("error while reading manifest: %s", err)
+	}
+
+	var manifest rawSignManifest
+	// Be strict, a misspelled field should not silently be left out of a certificate
+	if err := yaml.UnmarshalStrict(rawManifest, &manifest); err != nil {
+		return fmt.Errorf("error while parsing manifest: %s", err)
+	}
+
+	if len(manifest.Hosts) == 0 {
+		return fmt.Errorf("manifest did not contain any hosts")
+	}
+
+	var policy *signPolicy
+	if *sf.policyPath != "" {
+		policy, err = loadSignPolicy(*sf.policyPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	caCert, caKey, issuer, err := loadSigningCA(*sf.caKeyPath, *sf.caCertPath, *sf.caPassphrasePath, errOut, pr)
+	if err != nil {
+		return err
+	}
+
+	// Sign everything before writing anything, a bad entry should not leave a partial batch behind
+	manifestDir := filepath.Dir(*sf.manifestPath)
+	names := map[string]struct{}{}
+	ipOwners := map[string]string{}
+	hosts := make([]*batchHost, 0, len(manifest.Hosts))
+	for i, rh := range manifest.Hosts {
+		h, err := newBatchHost(rh, manifestDir, caCert, issuer, *sf.duration)
+		if err != nil {
+			return fmt.Errorf("host %d (%s): %s", i, rh.Name, err)
+		}
+
+		name := h.nc.Details.Name
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("host %d (%s): name is used more than once", i, name)
+		}
+		names[name] = struct{}{}
+
+		for _, ip := range h.nc.Details.Ips {
+			if owner, ok := ipOwners[ip.IP.String()]; ok {
+				return fmt.Errorf("host %d (%s): ip %s is already assigned to %s", i, name, ip.IP, owner)
+			}
+			ipOwners[ip.IP.String()] = name
+		}
+
+		if err := issueCert(h.nc, caCert, caKey, policy); err != nil {
+			return fmt.Errorf("host %d (%s): %s", i, name, err)
+		}
+
+		hosts = append(hosts, h)
+	}
+
+	summaryPath := filepath.Join(*sf.outDir, "summary.json")
+	for _, p := range batchOutputPaths(*sf.outDir, hosts, summaryPath) {
+		if _, err := os.Stat(p); err == nil {
+			return fmt.Errorf("refusing to overwrite existing file: %s", p)
+		}
+	}
+
+	if err := os.MkdirAll(*sf.outDir, 0700); err != nil {
+		return fmt.Errorf("error while creating out-dir: %s", err)
+	}
+
+	summary := batchSummary{
+		CA:    batchSummaryCA{Name: caCert.Details.Name, Fingerprint: issuer},
+		Hosts: make([]batchSummaryHost, 0, len(hosts)),
+	}
+	for _, h := range hosts {
+		name := h.nc.Details.Name
+		sh := batchSummaryHost{Name: name, Groups: h.nc.Details.Groups, NotAfter: h.nc.Details.NotAfter}
+
+		if h.rawPriv != nil {
+			keyPath := filepath.Join(*sf.outDir, name+".key")
+			err = ioutil.WriteFile(keyPath, cert.MarshalPrivateKey(caCert.Details.Curve, h.rawPriv), 0600)
+			if err != nil {
+				return fmt.Errorf("error while writing key for %s: %s", name, err)
+			}
+			sh.Key = keyPath
+		} else {
+			sh.Pub = h.pubPath
+		}
+
+		b, err := h.nc.MarshalToPEMWithChain()
+		if err != nil {
+			return fmt.Errorf("error while marshalling certificate for %s: %s", name, err)
+		}
+
+		crtPath := filepath.Join(*sf.outDir, name+".crt")
+		err = ioutil.WriteFile(crtPath, b, 0600)
+		if err != nil {
+			return fmt.Errorf("error while writing certificate for %s: %s", name, err)
+		}
+
+		sh.Crt = crtPath
+		sh.Fingerprint, err = h.nc.Sha256Sum()
+		if err != nil {
+			return fmt.Errorf("error while getting fingerprint for %s: %s", name, err)
+		}
+
+		for _, ip := range h.nc.Details.Ips {
+			sh.Ips = append(sh.Ips, ip.String())
+		}
+
+		summary.Hosts = append(summary.Hosts, sh)
+	}
+
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error while marshalling summary: %s", err)
+	}
+
+	err = ioutil.WriteFile(summaryPath, append(b, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("error while writing summary: %s", err)
+	}
+
+	return nil
+}
+
+// newBatchHost parses a manifest entry into an unsigned certificate, generating a key pair unless pub is set
+func newBatchHost(rh rawManifestHost, manifestDir string, caCert *cert.NebulaCertificate, issuer string, defaultDuration time.Duration) (*batchHost, error) {
+	name := strings.TrimSpace(rh.Name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	// The name is used for the output file names
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, fmt.Errorf("name can not be used as a file name")
+	}
+
+	if strings.TrimSpace(rh.Ip) == "" {
+		return nil, fmt.Errorf("ip is required")
+	}
+
+	ips, err := parseIps(rh.Ip)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets, err := parseSubnets(strings.Join(rh.Subnets, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	groups := parseGroups(strings.Join(rh.Groups, ","))
+
+	for k := range rh.Extensions {
+		if strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("extensions can not have an empty key")
+		}
+	}
+
+	duration := defaultDuration
+	if rh.Duration != "" {
+		duration, err = time.ParseDuration(rh.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %s", err)
+		}
+	}
+	// if no duration is given, expire one second before the root expires
+	if duration <= 0 {
+		duration = time.Until(caCert.Details.NotAfter) - time.Second*1
+	}
+
+	h := &batchHost{}
+	var pub []byte
+	if rh.Pub != "" {
+		h.pubPath = rh.Pub
+		if !filepath.IsAbs(h.pubPath) {
+			h.pubPath = filepath.Join(manifestDir, h.pubPath)
+		}
+
+		rawPub, err := ioutil.ReadFile(h.pubPath)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading pub: %s", err)
+		}
+
+		var curve cert.Curve
+		pub, _, curve, err = cert.UnmarshalPublicKey(rawPub)
+		if err != nil {
+			return nil, fmt.Errorf("error while parsing pub: %s", err)
+		}
+		if curve != caCert.Details.Curve {
+			return nil, fmt.Errorf("pub curve %s does not match the ca-crt curve %s", curve, caCert.Details.Curve)
+		}
+	} else {
+		pub, h.rawPriv = newKeypair(caCert.Details.Curve)
+	}
+
+	now := time.Now()
+	h.nc = &cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:       name,
+			Ips:        ips,
+			Groups:     groups,
+			Subnets:    subnets,
+			NotBefore:  now,
+			NotAfter:   now.Add(duration),
+			PublicKey:  pub,
+			IsCA:       false,
+			Issuer:     issuer,
+			Curve:      caCert.Details.Curve,
+			Extensions: rh.Extensions,
+		},
+		Chain: issuedChain(caCert),
+	}
+
+	if len(h.nc.Details.Extensions) == 0 {
+		h.nc.Details.Extensions = nil
+	}
+
+	return h, nil
+}
+
+// batchOutputPaths lists every file sign-batch will write
+func batchOutputPaths(outDir string, hosts []*batchHost, summaryPath string) []string {
+	paths := []string{summaryPath}
+	for _, h := range hosts {
+		paths = append(paths, filepath.Join(outDir, h.nc.Details.Name+".crt"))
+		if h.rawPriv != nil {
+			paths = append(paths, filepath.Join(outDir, h.nc.Details.Name+".key"))
+		}
+	}
+
+	return paths
+}
+
+func signBatchSummary() string {
+	return "sign-batch <flags>: create and sign a certificate for every host in a manifest file"
+}
+
+func signBatchHelp(out io.Writer) {
+	sf := newSignBatchFlags()
+	out.Write([]byte("Usage of " + os.Args[0] + " " + signBatchSummary() + "\n"))
+	sf.set.SetOutput(out)
+	sf.set.PrintDefaults()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_signBatchSummary(t *testing.T) {
+	assert.Equal(t, "sign-batch <flags>: create and sign a certificate for every host in a manifest file", signBatchSummary())
+}
+
+func Test_signBatchHelp(t *testing.T) {
+	ob := &bytes.Buffer{}
+	signBatchHelp(ob)
+	assert.Equal(
+		t,
+		"Usage of "+os.Args[0]+" sign-batch <flags>: create and sign a certificate for every host in a manifest file\n"+
+			"  -ca-crt string\n"+
+			"    \tOptional: path to the signing CA cert (default \"ca.crt\")\n"+
+			"  -ca-key string\n"+
+			"    \tOptional: path to the signing CA key (default \"ca.key\")\n"+
+			"  -ca-passphrase-file string\n"+
+			"    \tOptional: path to a file holding the passphrase of an encrypted ca-key. The "+caPassphraseEnv+" environment variable is used if not set, otherwise the passphrase is prompted for\n"+
+			"  -duration duration\n"+
+			"    \tOptional: how long the certs should be valid for unless a host sets its own. The default is 1 second before the signing cert expires. Valid time units are seconds: \"s\", minutes: \"m\", hours: \"h\"\n"+
+			"  -manifest string\n"+
+			"    \tRequired: path to a yaml file listing the hosts to sign, see examples/manifest.yml\n"+
+			"  -out-dir string\n"+
+			"    \tRequired: directory to write the certificates, keys and summary.json to. It is created if it does not exist\n"+
+			"  -policy string\n"+
+			"    \tOptional: path to a yaml policy file every certificate must satisfy, see examples/policy.yml\n",
+		ob.String(),
+	)
+}
+
+func Test_signBatch(t *testing.T) {
+	nopw := &StubPasswordReader{}
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	dir, err := ioutil.TempDir("", "nebula-cert-sign-batch")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// required args
+	assertHelpError(t, signBatch([]string{"-out-dir", p("out")}, ob, eb, nopw), "-manifest is required")
+	assertHelpError(t, signBatch([]string{"-manifest", p("hosts.yml")}, ob, eb, nopw), "-out-dir is required")
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	args := []string{"-name", "ca", "-duration", "10h", "-ips", "10.0.0.0/16,fd00::/64", "-out-crt", p("ca.crt"), "-out-key", p("ca.key")}
+	assert.Nil(t, ca(args, ob, eb, nopw))
+
+	batch := func(manifest string, extra ...string) error {
+		assert.Nil(t, ioutil.WriteFile(p("hosts.yml"), []byte(manifest), 0600))
+		args := append([]string{"-ca-crt", p("ca.crt"), "-ca-key", p("ca.key"), "-manifest", p("hosts.yml"), "-out-dir", p("out")}, extra...)
+		return signBatch(args, ob, eb, nopw)
+	}
+
+	// missing manifest
+	args = []string{"-ca-crt", p("ca.crt"), "-ca-key", p("ca.key"), "-manifest", p("nope.yml"), "-out-dir", p("out")}
+	assert.EqualError(t, signBatch(args, ob, eb, nopw), "error while reading manifest: open "+p("nope.yml")+": "+NoSuchFileError)
+
+	// manifests are parsed strictly
+	assert.EqualError(t, batch("hosts:\n  - name: a\n    ips: 10.0.0.1/16\n"), "error while parsing manifest: yaml: unmarshal errors:\n  line 3: field ips not found in type main.rawManifestHost")
+	assert.EqualError(t, batch("hosts: []\n"), "manifest did not contain any hosts")
+
+	// bad entries
+	assert.EqualError(t, batch("hosts:\n  - ip: 10.0.0.1/16\n"), "host 0 (): name is required")
+	assert.EqualError(t, batch("hosts:\n  - name: ../a\n    ip: 10.0.0.1/16\n"), "host 0 (../a): name can not be used as a file name")
+	assert.EqualError(t, batch("hosts:\n  - name: a\n"), "host 0 (a): ip is required")
+	assert.EqualError(t, batch("hosts:\n  - name: a\n    ip: nope\n"), "host 0 (a): invalid ip definition: invalid CIDR address: nope")
+	assert.EqualError(t, batch("hosts:\n  - name: a\n    ip: 10.0.0.1/16\n    duration: nope\n"), "host 0 (a): invalid duration: time: invalid duration \"nope\"")
+	assert.EqualError(t, batch("hosts:\n  - name: a\n    ip: 10.0.0.1/16\n    pub: nope.pub\n"), "host 0 (a): error while reading pub: open "+p("nope.pub")+": "+NoSuchFileError)
+
+	// collisions within the batch
+	assert.EqualError(t, batch("hosts:\n  - name: a\n    ip: 10.0.0.1/16\n  - name: a\n    ip: 10.0.0.2/16\n"), "host 1 (a): name is used more than once")
+	assert.EqualError(t, batch("hosts:\n  - name: a\n    ip: 10.0.0.1/16\n  - name: b\n    ip: 10.0.0.1/24\n"), "host 1 (b): ip 10.0.0.1 is already assigned to a")
+
+	// ca constraints and policies apply to every host
+	assert.EqualError(t, batch("hosts:\n  - name: a\n    ip: 10.0.0.1/16\n  - name: b\n    ip: 10.1.0.1/16\n"), "host 1 (b): refusing to sign, root certificate constraints violated: certificate contained an ip assignment outside the limitations of the signing ca: 10.1.0.1/16")
+	assert.Nil(t, ioutil.WriteFile(p("policy.yml"), []byte("names: [\"[a-z]\"]\n"), 0600))
+	assert.EqualError(t, batch("hosts:\n  - name: a\n    ip: 10.0.0.1/16\n  - name: bb\n    ip: 10.0.0.2/16\n", "-policy", p("policy.yml")), "host 1 (bb): refusing to sign, policy violated: names: bb does not match any allowed pattern")
+
+	// nothing was written by the failed batches
+	_, err = os.Stat(p("out"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a good batch
+	assert.Nil(t, keygen([]string{"-out-pub", p("c.pub"), "-out-key", p("c.key")}, ob, eb))
+	manifest := `hosts:
+  - name: a
+    ip: 10.0.0.1/16
+    groups: [servers, web]
+    extensions:
+      team: ops
+  - name: b
+    ip: 10.0.0.2/16, fd00::2/64
+    subnets: [192.168.0.0/24]
+    duration: 1h
+  - name: c
+    ip: 10.0.0.3/16
+    pub: c.pub
+`
+	assert.Nil(t, batch(manifest, "-duration", "2h"))
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+
+	rb, _ := ioutil.ReadFile(p("ca.crt"))
+	caCert, _, err := cert.UnmarshalNebulaCertificateFromPEM(rb)
+	assert.Nil(t, err)
+	caPool := cert.NewCAPool()
+	_, err = caPool.AddCACertificate(rb)
+	assert.Nil(t, err)
+
+	readCert := func(name string) *cert.NebulaCertificate {
+		rb, err := ioutil.ReadFile(p(filepath.Join("out", name+".crt")))
+		assert.Nil(t, err)
+		c, _, err := cert.UnmarshalNebulaCertificateFromPEM(rb)
+		assert.Nil(t, err)
+		v, err := c.Verify(time.Now(), caPool)
+		assert.True(t, v)
+		assert.Nil(t, err)
+		return c
+	}
+
+	a := readCert("a")
+	assert.Equal(t, []string{"servers", "web"}, a.Details.Groups)
+	assert.Equal(t, map[string]string{"team": "ops"}, a.Details.Extensions)
+	assert.Equal(t, 2*time.Hour, a.Details.NotAfter.Sub(a.Details.NotBefore))
+	rb, err = ioutil.ReadFile(p("out/a.key"))
+	assert.Nil(t, err)
+	aKey, _, err := cert.UnmarshalX25519PrivateKey(rb)
+	assert.Nil(t, err)
+	assert.Nil(t, a.VerifyPrivateKey(aKey))
+
+	b := readCert("b")
+	assert.Len(t, b.Details.Ips, 2)
+	assert.Equal(t, "192.168.0.0/24", b.Details.Subnets[0].String())
+	assert.Equal(t, time.Hour, b.Details.NotAfter.Sub(b.Details.NotBefore))
+
+	c := readCert("c")
+	rb, _ = ioutil.ReadFile(p("c.key"))
+	cKey, _, err := cert.UnmarshalX25519PrivateKey(rb)
+	assert.Nil(t, err)
+	assert.Nil(t, c.VerifyPrivateKey(cKey))
+	_, err = os.Stat(p("out/c.key"))
+	assert.True(t, os.IsNotExist(err))
+
+	var summary batchSummary
+	rb, err = ioutil.ReadFile(p("out/summary.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(rb, &summary))
+	caFp, _ := caCert.Sha256Sum()
+	assert.Equal(t, batchSummaryCA{Name: "ca", Fingerprint: caFp}, summary.CA)
+	assert.Len(t, summary.Hosts, 3)
+	aFp, _ := a.Sha256Sum()
+	assert.Equal(t, "a", summary.Hosts[0].Name)
+	assert.Equal(t, aFp, summary.Hosts[0].Fingerprint)
+	assert.Equal(t, []string{"10.0.0.1/16"}, summary.Hosts[0].Ips)
+	assert.Equal(t, p("out/a.crt"), summary.Hosts[0].Crt)
+	assert.Equal(t, p("out/a.key"), summary.Hosts[0].Key)
+	assert.Equal(t, []string{"10.0.0.2/16", "fd00::2/64"}, summary.Hosts[1].Ips)
+	assert.Equal(t, "", summary.Hosts[2].Key)
+	assert.Equal(t, p("c.pub"), summary.Hosts[2].Pub)
+
+	// existing files are never overwritten
+	assert.EqualError(t, batch(manifest), "refusing to overwrite existing file: "+p("out/summary.json"))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+)
+
+type unbundleFlags struct {
+	set            *flag.FlagSet
+	caPath         *string
+	inJSONPath     *string
+	passphrasePath *string
+	outDir         *string
+}
+
+func newUnbundleFlags() *unbundleFlags {
+	uf := unbundleFlags{set: flag.NewFlagSet("unbundle", flag.ContinueOnError)}
+	uf.set.Usage = func() {}
+	uf.caPath = uf.set.String("ca", "", "Required: path to a file containing the ca certificates the bundle must be signed by")
+	uf.inJSONPath = uf.set.String("in-json", "", "Required: path to the bundle")
+	uf.passphrasePath = uf.set.String("passphrase-file", "", "Optional: path to a file holding the passphrase of an encrypted bundle, otherwise the passphrase is prompted for")
+	uf.outDir = uf.set.String("out-dir", "", "Required: directory to write ca.crt, host.crt, host.key and config.yml to")
+	return &uf
+}
+
+func unbundle(args []string, out io.Writer, errOut io.Writer, pr PasswordReader) error {
+	uf := newUnbundleFlags()
+	err := uf.set.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if err := mustFlagString("ca", uf.caPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("in-json", uf.inJSONPath); err != nil {
+		return err
+	}
+	if err := mustFlagString("out-dir", uf.outDir); err != nil {
+		return err
+	}
+
+	caPool, err := loadCAPool(*uf.caPath)
+	if err != nil {
+		return err
+	}
+
+	rb, err := ioutil.ReadFile(*uf.inJSONPath)
+	if err != nil {
+		return fmt.Errorf("error while reading in-json: %s", err)
+	}
+
+	var bf bundleFile
+	if err := json.Unmarshal(rb, &bf); err != nil {
+		return fmt.Errorf("error while parsing in-json: %s", err)
+	}
+
+	if bf.Version != bundleVersion {
+		return fmt.Errorf("unsupported bundle version: %d", bf.Version)
+	}
+
+	// Nothing in the bundle is looked at until it is known to come from a trusted ca
+	if err := verifyBundle(&bf, caPool); err != nil {
+		return err
+	}
+
+	b := []byte(bf.Bundle)
+	if bf.Encrypted != nil {
+		passphrase, err := readPassphrase(*uf.passphrasePath, false, errOut, pr)
+		if err != nil {
+			return err
+		}
+
+		b, err = cert.UnmarshalAndDecryptData(passphrase, bf.Encrypted)
+		if err != nil {
+			return fmt.Errorf("error while decrypting bundle: %s", err)
+		}
+	}
+
+	var contents bundleContents
+	if err := json.Unmarshal(b, &contents); err != nil {
+		return fmt.Errorf("error while parsing bundle: %s", err)
+	}
+
+	c, err := cert.UnmarshalNebulaCertificateWithChainFromPEM([]byte(contents.Crt))
+	if err != nil {
+		return fmt.Errorf("error while parsing bundle crt: %s", err)
+	}
+
+	if _, err := c.Verify(time.Now(), caPool); err != nil {
+		return fmt.Errorf("bundle crt does not verify against the ca: %s", err)
+	}
+
+	files := []struct {
+		name string
+		data string
+	}{
+		{"ca.crt", contents.CA},
+		{"host.crt", contents.Crt},
+		{"host.key", contents.Key},
+		{"config.yml", contents.Config},
+	}
+
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(*uf.outDir, f.name)); err == nil {
+			return fmt.Errorf("refusing to overwrite existing file: %s", filepath.Join(*uf.outDir, f.name))
+		}
+	}
+
+	err = os.MkdirAll(*uf.outDir, 0700)
+	if err != nil {
+		return fmt.Errorf("error while creating out-dir: %s", err)
+	}
+
+	for _, f := range files {
+		if f.data == "" {
+			continue
+		}
+
+		err = ioutil.WriteFile(filepath.Join(*uf.outDir, f.name), []byte(f.data), 0600)
+		if err != nil {
+			return fmt.Errorf("error while writing %s: %s", f.name, err)
+		}
+	}
+
+	return nil
+}
+
+// verifyBundle checks the signer of the bundle chains to a ca in the pool and that it signed the payload
+func verifyBundle(bf *bundleFile, caPool *cert.NebulaCAPool) error {
+	if len(bf.payload()) == 0 {
+		return fmt.Errorf("bundle is empty")
+	}
+
+	signer, err := cert.UnmarshalNebulaCertificateWithChainFromPEM([]byte(bf.Signer))
+	if err != nil {
+		return fmt.Errorf("error while parsing bundle signer: %s", err)
+	}
+
+	if !signer.Details.IsCA {
+		return fmt.Errorf("bundle signer %s is not a ca", signer.Details.Name)
+	}
+
+	fp, err := signer.Sha256Sum()
+	if err != nil {
+		return fmt.Errorf("error while getting bundle signer fingerprint: %s", err)
+	}
+
+	// A root is trusted by being in the pool, an intermediate must chain to one
+	if _, ok := caPool.CAs[fp]; !ok {
+		if signer.Details.Issuer == "" {
+			return fmt.Errorf("bundle signer %s is not trusted: root certificate is not in the ca pool", signer.Details.Name)
+		}
+
+		if _, err := signer.Verify(time.Now(), caPool); err != nil {
+			return fmt.Errorf("bundle signer %s is not trusted: %s", signer.Details.Name, err)
+		}
+	}
+
+	if !signer.CheckDataSignature(bf.payload(), bf.Signature) {
+		return fmt.Errorf("bundle signature did not match")
+	}
+
+	return nil
+}
+
+func unbundleSummary() string {
+	return "unbundle <flags>: verify the signature of a bundle and extract its certificate, key, ca and config"
+}
+
+func unbundleHelp(out io.Writer) {
+	uf := newUnbundleFlags()
+	out.Write([]byte("Usage of " + os.Args[0] + " " + unbundleSummary() + "\n"))
+	uf.set.SetOutput(out)
+	uf.set.PrintDefaults()
+}
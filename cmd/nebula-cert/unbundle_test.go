@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_unbundleSummary(t *testing.T) {
+	assert.Equal(t, "unbundle <flags>: verify the signature of a bundle and extract its certificate, key, ca and config", unbundleSummary())
+}
+
+func Test_unbundleHelp(t *testing.T) {
+	ob := &bytes.Buffer{}
+	unbundleHelp(ob)
+	assert.Equal(
+		t,
+		"Usage of "+os.Args[0]+" unbundle <flags>: verify the signature of a bundle and extract its certificate, key, ca and config\n"+
+			"  -ca string\n"+
+			"    \tRequired: path to a file containing the ca certificates the bundle must be signed by\n"+
+			"  -in-json string\n"+
+			"    \tRequired: path to the bundle\n"+
+			"  -out-dir string\n"+
+			"    \tRequired: directory to write ca.crt, host.crt, host.key and config.yml to\n"+
+			"  -passphrase-file string\n"+
+			"    \tOptional: path to a file holding the passphrase of an encrypted bundle, otherwise the passphrase is prompted for\n",
+		ob.String(),
+	)
+}
+
+func Test_unbundle(t *testing.T) {
+	nopw := &StubPasswordReader{}
+	ob := &bytes.Buffer{}
+	eb := &bytes.Buffer{}
+
+	dir, err := ioutil.TempDir("", "nebula-cert-unbundle")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	// required args
+	assertHelpError(t, unbundle([]string{"-in-json", p("b.json"), "-out-dir", p("out")}, ob, eb, nopw), "-ca is required")
+	assertHelpError(t, unbundle([]string{"-ca", p("ca.crt"), "-out-dir", p("out")}, ob, eb, nopw), "-in-json is required")
+	assertHelpError(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("b.json")}, ob, eb, nopw), "-out-dir is required")
+
+	assert.Nil(t, ca([]string{"-name", "ca", "-duration", "10h", "-out-crt", p("ca.crt"), "-out-key", p("ca.key")}, ob, eb, nopw))
+	assert.Nil(t, ca([]string{"-name", "other", "-duration", "10h", "-out-crt", p("other.crt"), "-out-key", p("other.key")}, ob, eb, nopw))
+	assert.Nil(t, ioutil.WriteFile(p("config.yml"), []byte("listen:\n  port: 0\n"), 0600))
+	assert.Nil(t, bundle([]string{"-ca-crt", p("ca.crt"), "-ca-key", p("ca.key"), "-name", "phone", "-ip", "10.0.0.2/24", "-config", p("config.yml"), "-out-json", p("b.json")}, ob, eb, nopw))
+
+	rb, err := ioutil.ReadFile(p("b.json"))
+	assert.Nil(t, err)
+	var bf bundleFile
+	assert.Nil(t, json.Unmarshal(rb, &bf))
+	var contents bundleContents
+	assert.Nil(t, json.Unmarshal(bf.Bundle, &contents))
+
+	writeBundle := func(name string, bf bundleFile) {
+		b, err := json.Marshal(bf)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(p(name), b, 0600))
+	}
+
+	// a tampered bundle is rejected before anything is written
+	tampered := bf
+	tampered.Bundle = bytes.Replace(bf.Bundle, []byte("port: 0"), []byte("port: 1"), 1)
+	assert.NotEqual(t, bf.Bundle, tampered.Bundle)
+	writeBundle("tampered.json", tampered)
+	assert.EqualError(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("tampered.json"), "-out-dir", p("out")}, ob, eb, nopw), "bundle signature did not match")
+	_, err = os.Stat(p("out"))
+	assert.True(t, os.IsNotExist(err))
+
+	// so is a bundle signed by a ca that is not trusted
+	assert.EqualError(t, unbundle([]string{"-ca", p("other.crt"), "-in-json", p("b.json"), "-out-dir", p("out")}, ob, eb, nopw), "bundle signer ca is not trusted: root certificate is not in the ca pool")
+
+	otherPEM, err := ioutil.ReadFile(p("other.crt"))
+	assert.Nil(t, err)
+	tampered = bf
+	tampered.Signer = string(otherPEM)
+	writeBundle("tampered.json", tampered)
+	assert.EqualError(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("tampered.json"), "-out-dir", p("out")}, ob, eb, nopw), "bundle signer other is not trusted: root certificate is not in the ca pool")
+
+	tampered = bf
+	tampered.Signature = nil
+	writeBundle("tampered.json", tampered)
+	assert.EqualError(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("tampered.json"), "-out-dir", p("out")}, ob, eb, nopw), "bundle signature did not match")
+
+	tampered = bf
+	tampered.Version = 1
+	writeBundle("tampered.json", tampered)
+	assert.EqualError(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("tampered.json"), "-out-dir", p("out")}, ob, eb, nopw), "unsupported bundle version: 1")
+
+	// a good bundle is extracted
+	assert.Nil(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("b.json"), "-out-dir", p("out")}, ob, eb, nopw))
+	assert.Empty(t, ob.String())
+	assert.Empty(t, eb.String())
+	for name, expected := range map[string]string{"ca.crt": contents.CA, "host.crt": contents.Crt, "host.key": contents.Key, "config.yml": contents.Config} {
+		b, err := ioutil.ReadFile(filepath.Join(p("out"), name))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(b))
+	}
+
+	// never overwrite
+	assert.EqualError(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("b.json"), "-out-dir", p("out")}, ob, eb, nopw), "refusing to overwrite existing file: "+filepath.Join(p("out"), "ca.crt"))
+
+	// an encrypted bundle signed by an intermediate, the signature is checked before the passphrase is asked for
+	assert.Nil(t, ca([]string{"-name", "inter", "-ca-crt", p("ca.crt"), "-ca-key", p("ca.key"), "-out-crt", p("inter.crt"), "-out-key", p("inter.key")}, ob, eb, nopw))
+	assert.Nil(t, ioutil.WriteFile(p("pw"), []byte("DO NOT USE\n"), 0600))
+	assert.Nil(t, bundle([]string{"-ca-crt", p("inter.crt"), "-ca-key", p("inter.key"), "-ca", p("ca.crt"), "-name", "phone", "-ip", "10.0.0.3/24", "-encrypt", "-passphrase-file", p("pw"), "-out-json", p("e.json")}, ob, eb, nopw))
+
+	rb, err = ioutil.ReadFile(p("e.json"))
+	assert.Nil(t, err)
+	bf = bundleFile{}
+	assert.Nil(t, json.Unmarshal(rb, &bf))
+	tampered = bf
+	tampered.Encrypted = append([]byte{}, bf.Encrypted...)
+	tampered.Encrypted[len(tampered.Encrypted)-1] ^= 1
+	writeBundle("tampered.json", tampered)
+	assert.EqualError(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("tampered.json"), "-out-dir", p("out2")}, ob, eb, nopw), "bundle signature did not match")
+	assert.Empty(t, eb.String())
+
+	assert.Nil(t, ioutil.WriteFile(p("badpw"), []byte("nope\n"), 0600))
+	assert.EqualError(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("e.json"), "-passphrase-file", p("badpw"), "-out-dir", p("out2")}, ob, eb, nopw), "error while decrypting bundle: invalid passphrase or corrupt private key")
+
+	assert.Nil(t, unbundle([]string{"-ca", p("ca.crt"), "-in-json", p("e.json"), "-passphrase-file", p("pw"), "-out-dir", p("out2")}, ob, eb, nopw))
+	_, err = os.Stat(filepath.Join(p("out2"), "host.key"))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(p("out2"), "config.yml"))
+	assert.True(t, os.IsNotExist(err))
+}
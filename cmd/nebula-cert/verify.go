@@ -22,7 +22,7 @@ func newVerifyFlags() *verifyFlags {
 	vf := verifyFlags{set: flag.NewFlagSet("verify", flag.ContinueOnError)}
 	vf.set.Usage = func() {}
 	vf.caPath = vf.set.String("ca", "", "Required: path to a file containing one or more ca certificates")
-	vf.certPath = vf.set.String("crt", "", "Required: path to a file containing a single certificate")
+	vf.certPath = vf.set.String("crt", "", "Required: path to a file containing a single certificate, followed by its intermediate CA certificates if any")
 	return &vf
 }
 
@@ -40,21 +40,9 @@ func verify(args []string, out io.Writer, errOut io.Writer) error {
 		return err
 	}
 
-	rawCACert, err := ioutil.ReadFile(*vf.caPath)
+	caPool, err := loadCAPool(*vf.caPath)
 	if err != nil {
-		return fmt.Errorf("error while reading ca: %s", err)
-	}
-
-	caPool := cert.NewCAPool()
-	for {
-		rawCACert, err = caPool.AddCACertificate(rawCACert)
-		if err != nil {
-			return fmt.Errorf("error while adding ca cert to pool: %s", err)
-		}
-
-		if rawCACert == nil || len(rawCACert) == 0 || strings.TrimSpace(string(rawCACert)) == "" {
-			break
-		}
+		return err
 	}
 
 	rawCert, err := ioutil.ReadFile(*vf.certPath)
@@ -62,7 +50,7 @@ func verify(args []string, out io.Writer, errOut io.Writer) error {
 		return fmt.Errorf("unable to read crt; %s", err)
 	}
 
-	c, _, err := cert.UnmarshalNebulaCertificateFromPEM(rawCert)
+	c, err := cert.UnmarshalNebulaCertificateWithChainFromPEM(rawCert)
 	if err != nil {
 		return fmt.Errorf("error while parsing crt: %s", err)
 	}
@@ -75,6 +63,28 @@ func verify(args []string, out io.Writer, errOut io.Writer) error {
 	return nil
 }
 
+// loadCAPool reads every ca certificate in the file at path into a new pool
+func loadCAPool(path string) (*cert.NebulaCAPool, error) {
+	rawCACert, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading ca: %s", err)
+	}
+
+	caPool := cert.NewCAPool()
+	for {
+		rawCACert, err = caPool.AddCACertificate(rawCACert)
+		if err != nil {
+			return nil, fmt.Errorf("error while adding ca cert to pool: %s", err)
+		}
+
+		if rawCACert == nil || len(rawCACert) == 0 || strings.TrimSpace(string(rawCACert)) == "" {
+			break
+		}
+	}
+
+	return caPool, nil
+}
+
 func verifySummary() string {
 	return "verify <flags>: verifies a certificate isn't expired and was signed by a trusted authority."
 }
@@ -21,6 +21,8 @@ var Build string
 func main() {
 	configPath := flag.String("config", "", "Path to either a file or directory to load configuration from")
 	configTest := flag.Bool("test", false, "Test the config and print the end result. Non zero exit indicates a faulty config")
+	testFirewall := flag.String("test-firewall", "", "Path to a file of packets to evaluate against the firewall of the config, prints whether each is allowed and by which rule")
+	testFirewallAgainst := flag.String("test-firewall-against", "", "Path to a second config for -test-firewall, only the packets one firewall allows and the other drops are printed")
 	printVersion := flag.Bool("version", false, "Print version")
 	printUsage := flag.Bool("help", false, "Print command line usage")
 
@@ -52,6 +54,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *testFirewall != "" {
+		os.Exit(runTestFirewall(l, c, *testFirewall, *testFirewallAgainst))
+	}
+
 	ctrl, err := nebula.Main(c, *configTest, Build, l, nil)
 
 	switch v := err.(type) {
@@ -70,3 +76,25 @@ func main() {
 
 	os.Exit(0)
 }
+
+func runTestFirewall(l *logrus.Logger, c *config.C, testPath string, againstPath string) int {
+	// Only warnings about the rules are of interest, the verdicts go to stdout
+	l.Out = os.Stderr
+	l.SetLevel(logrus.WarnLevel)
+
+	var against *config.C
+	if againstPath != "" {
+		against = config.NewC(l)
+		if err := against.Load(againstPath); err != nil {
+			fmt.Printf("failed to load config: %s\n", err)
+			return 1
+		}
+	}
+
+	if err := nebula.SimulateFirewall(l, c, against, testPath, os.Stdout); err != nil {
+		fmt.Printf("failed to test firewall: %s\n", err)
+		return 1
+	}
+
+	return 0
+}
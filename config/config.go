@@ -236,6 +236,17 @@ func (c *C) GetInt(k string, d int) int {
 	return v
 }
 
+// GetFloat will get the float64 for k or return the default d if not found or invalid
+func (c *C) GetFloat(k string, d float64) float64 {
+	r := c.GetString(k, strconv.FormatFloat(d, 'f', -1, 64))
+	v, err := strconv.ParseFloat(r, 64)
+	if err != nil {
+		return d
+	}
+
+	return v
+}
+
 // GetBool will get the bool for k or return the default d if not found or invalid
 func (c *C) GetBool(k string, d bool) bool {
 	r := strings.ToLower(c.GetString(k, fmt.Sprintf("%v", d)))
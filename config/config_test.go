@@ -64,6 +64,23 @@ func TestConfig_GetStringSlice(t *testing.T) {
 	assert.Equal(t, []string{"one", "two"}, c.GetStringSlice("slice", []string{}))
 }
 
+func TestConfig_GetFloat(t *testing.T) {
+	l := test.NewLogger()
+	c := NewC(l)
+	c.Settings["float"] = 0.75
+	assert.Equal(t, 0.75, c.GetFloat("float", 0.5))
+
+	c.Settings["float"] = "0.25"
+	assert.Equal(t, 0.25, c.GetFloat("float", 0.5))
+
+	c.Settings["float"] = 1
+	assert.Equal(t, float64(1), c.GetFloat("float", 0.5))
+
+	c.Settings["float"] = "nope"
+	assert.Equal(t, 0.5, c.GetFloat("float", 0.5))
+	assert.Equal(t, 0.5, c.GetFloat("missing", 0.5))
+}
+
 func TestConfig_GetBool(t *testing.T) {
 	l := test.NewLogger()
 	c := NewC(l)
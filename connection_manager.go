@@ -1,6 +1,7 @@
 package nebula
 
 import (
+	"bytes"
 	"context"
 	"sync"
 	"time"
@@ -188,6 +189,8 @@ func (n *connectionManager) HandleMonitorTick(now time.Time, p, nb, out []byte)
 			continue
 		}
 
+		n.tryRehandshake(hostinfo)
+
 		// If we saw an incoming packets from this ip and peer's certificate is not
 		// expired, just ignore.
 		if traf {
@@ -278,13 +281,14 @@ func (n *connectionManager) HandleDeletionTick(now time.Time) {
 }
 
 // handleInvalidCertificates will destroy a tunnel if pki.disconnect_invalid is true and the certificate is no longer valid
+// or if the certificate has been revoked by a revocation list
 func (n *connectionManager) handleInvalidCertificate(now time.Time, vpnIp iputil.VpnIp, hostinfo *HostInfo) bool {
-	if !n.intf.disconnectInvalid {
+	remoteCert := hostinfo.GetCert()
+	if remoteCert == nil {
 		return false
 	}
 
-	remoteCert := hostinfo.GetCert()
-	if remoteCert == nil {
+	if !n.intf.disconnectInvalid && !n.intf.caPool.IsRevoked(remoteCert) {
 		return false
 	}
 
@@ -307,3 +311,42 @@ func (n *connectionManager) handleInvalidCertificate(now time.Time, vpnIp iputil
 	n.ClearPendingDeletion(vpnIp)
 	return true
 }
+
+// tryRehandshake starts a new handshake with the remote if the tunnel was built with a certificate we no longer use,
+// otherwise the remote would keep seeing our old certificate until it expires. The new tunnel replaces the old one
+// once the handshake completes.
+func (n *connectionManager) tryRehandshake(hostinfo *HostInfo) {
+	cs := n.intf.certState
+	if hostinfo.ConnectionState == nil || hostinfo.ConnectionState.certState == nil || cs == nil {
+		return
+	}
+
+	if bytes.Equal(hostinfo.ConnectionState.certState.certificate.Signature, cs.certificate.Signature) {
+		return
+	}
+
+	vpnIp := hostinfo.vpnIp
+	if _, err := n.intf.handshakeManager.pendingHostMap.QueryVpnIp(vpnIp); err == nil {
+		// A handshake is already in flight
+		return
+	}
+
+	hostinfo.logger(n.l).
+		WithField("reason", "local certificate is not current").
+		Info("Re-handshaking with remote")
+
+	newHostinfo := n.intf.handshakeManager.AddVpnIp(vpnIp, n.intf.initHostInfo)
+	newHostinfo.Lock()
+	defer newHostinfo.Unlock()
+
+	if !newHostinfo.HandshakeReady {
+		ixHandshakeStage0(n.intf, vpnIp, newHostinfo)
+
+		if _, ok := n.intf.lightHouse.GetStaticHostList()[vpnIp]; ok {
+			select {
+			case n.intf.handshakeManager.trigger <- vpnIp:
+			default:
+			}
+		}
+	}
+}
@@ -253,3 +253,175 @@ func Test_NewConnectionManagerTest_DisconnectInvalid(t *testing.T) {
 	destroyed = nc.handleInvalidCertificate(nextTick, vpnIp, hostinfo)
 	assert.True(t, destroyed)
 }
+
+// Check that a peer is disconnected when its certificate has been revoked,
+// even if disconnectInvalid: true is not set.
+func Test_NewConnectionManagerTest_DisconnectRevoked(t *testing.T) {
+	now := time.Now()
+	l := test.NewLogger()
+	ipNet := net.IPNet{
+		IP:   net.IPv4(172, 1, 1, 2),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+	_, vpncidr, _ := net.ParseCIDR("172.1.1.1/24")
+	_, localrange, _ := net.ParseCIDR("10.1.1.1/24")
+	preferredRanges := []*net.IPNet{localrange}
+	hostMap := NewHostMap(l, "test", vpncidr, preferredRanges)
+
+	// Generate keys for CA and peer's cert.
+	pubCA, privCA, _ := ed25519.GenerateKey(rand.Reader)
+	caCert := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      "ca",
+			NotBefore: now,
+			NotAfter:  now.Add(1 * time.Hour),
+			IsCA:      true,
+			PublicKey: pubCA,
+		},
+	}
+	caCert.Sign(privCA)
+	caPem, _ := caCert.MarshalToPEM()
+	ncp, err := cert.NewCAPoolFromBytes(caPem)
+	assert.Nil(t, err)
+	issuer, _ := caCert.Sha256Sum()
+
+	pubCrt, _, _ := ed25519.GenerateKey(rand.Reader)
+	peerCert := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      "host",
+			Ips:       []*net.IPNet{&ipNet},
+			Subnets:   []*net.IPNet{},
+			NotBefore: now,
+			NotAfter:  now.Add(60 * time.Second),
+			PublicKey: pubCrt,
+			IsCA:      false,
+			Issuer:    issuer,
+		},
+	}
+	peerCert.Sign(privCA)
+
+	cs := &CertState{
+		rawCertificate:      []byte{},
+		privateKey:          []byte{},
+		certificate:         &cert.NebulaCertificate{},
+		rawCertificateNoKey: []byte{},
+	}
+
+	lh := &LightHouse{l: l, atomicStaticList: make(map[iputil.VpnIp]struct{}), atomicLighthouses: make(map[iputil.VpnIp]struct{})}
+	ifce := &Interface{
+		hostMap:          hostMap,
+		inside:           &test.NoopTun{},
+		outside:          &udp.Conn{},
+		certState:        cs,
+		firewall:         &Firewall{},
+		lightHouse:       lh,
+		handshakeManager: NewHandshakeManager(l, vpncidr, preferredRanges, hostMap, lh, &udp.Conn{}, defaultHandshakeConfig),
+		l:                l,
+		caPool:           ncp,
+	}
+
+	// Create manager
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := newConnectionManager(ctx, l, ifce, 5, 10)
+	ifce.connectionManager = nc
+	hostinfo, _ := nc.hostMap.AddVpnIp(vpnIp, nil)
+	hostinfo.ConnectionState = &ConnectionState{
+		certState: cs,
+		peerCert:  &peerCert,
+		H:         &noise.HandshakeState{},
+	}
+
+	// Not revoked yet, should be alive.
+	destroyed := nc.handleInvalidCertificate(now, vpnIp, hostinfo)
+	assert.False(t, destroyed)
+
+	// Revoke the peer's cert.
+	// Should be disconnected.
+	fp, _ := peerCert.Sha256Sum()
+	crl := &cert.NebulaRevocationList{
+		Details: cert.NebulaRevocationListDetails{
+			Issuer:   issuer,
+			IssuedAt: now,
+		},
+	}
+	assert.Nil(t, crl.Revoke(fp))
+	assert.Nil(t, crl.Sign(privCA))
+	updated, err := ncp.AddRevocationList(crl)
+	assert.True(t, updated)
+	assert.Nil(t, err)
+
+	destroyed = nc.handleInvalidCertificate(now, vpnIp, hostinfo)
+	assert.True(t, destroyed)
+}
+
+// Check that a tunnel built with a certificate we no longer use gets re-handshaked
+func Test_NewConnectionManagerTest_Rehandshake(t *testing.T) {
+	l := test.NewLogger()
+	_, vpncidr, _ := net.ParseCIDR("172.1.1.1/24")
+	_, localrange, _ := net.ParseCIDR("10.1.1.1/24")
+	vpnIp := iputil.Ip2VpnIp(net.ParseIP("172.1.1.2"))
+	preferredRanges := []*net.IPNet{localrange}
+	hostMap := NewHostMap(l, "test", vpncidr, preferredRanges)
+
+	oldCs := &CertState{
+		rawCertificate:      []byte{},
+		privateKey:          []byte{},
+		certificate:         &cert.NebulaCertificate{Signature: []byte("old")},
+		rawCertificateNoKey: []byte{},
+	}
+
+	kp, err := noise.DH25519.GenerateKeypair(rand.Reader)
+	assert.Nil(t, err)
+	newCs := &CertState{
+		rawCertificate:      []byte{},
+		privateKey:          kp.Private,
+		publicKey:           kp.Public,
+		certificate:         &cert.NebulaCertificate{Signature: []byte("new")},
+		rawCertificateNoKey: []byte{},
+	}
+
+	lh := &LightHouse{l: l, atomicStaticList: make(map[iputil.VpnIp]struct{}), atomicLighthouses: make(map[iputil.VpnIp]struct{})}
+	ifce := &Interface{
+		hostMap:          hostMap,
+		inside:           &test.NoopTun{},
+		outside:          &udp.Conn{},
+		certState:        newCs,
+		firewall:         &Firewall{},
+		lightHouse:       lh,
+		handshakeManager: NewHandshakeManager(l, vpncidr, preferredRanges, hostMap, lh, &udp.Conn{}, defaultHandshakeConfig),
+		l:                l,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nc := newConnectionManager(ctx, l, ifce, 5, 10)
+
+	hostinfo, _ := nc.hostMap.AddVpnIp(vpnIp, nil)
+	hostinfo.ConnectionState = &ConnectionState{
+		certState: newCs,
+		H:         &noise.HandshakeState{},
+	}
+
+	// A tunnel using our current certificate is left alone
+	nc.tryRehandshake(hostinfo)
+	_, err = ifce.handshakeManager.pendingHostMap.QueryVpnIp(vpnIp)
+	assert.NotNil(t, err)
+
+	// Our certificate changed since the tunnel was built
+	hostinfo.ConnectionState.certState = oldCs
+	nc.tryRehandshake(hostinfo)
+	pending, err := ifce.handshakeManager.pendingHostMap.QueryVpnIp(vpnIp)
+	assert.Nil(t, err)
+	assert.True(t, pending.HandshakeReady)
+	assert.Equal(t, newCs, pending.ConnectionState.certState)
+
+	// Only one handshake is started at a time
+	nc.tryRehandshake(hostinfo)
+	again, err := ifce.handshakeManager.pendingHostMap.QueryVpnIp(vpnIp)
+	assert.Nil(t, err)
+	assert.Equal(t, pending, again)
+
+	// The existing tunnel stays up until the handshake completes
+	assert.Contains(t, nc.hostMap.Hosts, vpnIp)
+}
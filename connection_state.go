@@ -3,12 +3,14 @@ package nebula
 import (
 	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"sync"
 	"sync/atomic"
 
 	"github.com/flynn/noise"
 	"github.com/sirupsen/logrus"
 	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/noiseutil"
 )
 
 const ReplayWindow = 1024
@@ -28,12 +30,24 @@ type ConnectionState struct {
 }
 
 func (f *Interface) newConnectionState(l *logrus.Logger, initiator bool, pattern noise.HandshakePattern, psk []byte, pskStage int) *ConnectionState {
-	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherAESGCM, noise.HashSHA256)
+	curCertState := f.certState
+
+	var dhFunc noise.DHFunc
+	switch curCertState.certificate.Details.Curve {
+	case cert.Curve_CURVE25519:
+		dhFunc = noise.DH25519
+	case cert.Curve_P256:
+		dhFunc = noiseutil.DHP256
+	default:
+		// NewCertState refuses certificates with any other curve, so this is a bug
+		panic(fmt.Sprintf("invalid curve: %s", curCertState.certificate.Details.Curve))
+	}
+
+	cs := noise.NewCipherSuite(dhFunc, noise.CipherAESGCM, noise.HashSHA256)
 	if f.cipher == "chachapoly" {
-		cs = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+		cs = noise.NewCipherSuite(dhFunc, noise.CipherChaChaPoly, noise.HashSHA256)
 	}
 
-	curCertState := f.certState
 	static := noise.DHKey{Private: curCertState.privateKey, Public: curCertState.publicKey}
 
 	b := NewBits(ReplayWindow)
@@ -50,7 +64,8 @@ func (f *Interface) newConnectionState(l *logrus.Logger, initiator bool, pattern
 		PresharedKeyPlacement: pskStage,
 	})
 	if err != nil {
-		return nil
+		// The config above is fixed, so a failure here is a bug rather than something a caller could recover from
+		panic(fmt.Sprintf("failed to create noise handshake state: %s", err))
 	}
 
 	// The queue and ready params prevent a counter race that would happen when
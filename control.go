@@ -5,11 +5,14 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"sort"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/firewall"
 	"github.com/slackhq/nebula/header"
 	"github.com/slackhq/nebula/iputil"
 	"github.com/slackhq/nebula/udp"
@@ -40,6 +43,64 @@ type ControlHostInfo struct {
 	CurrentRelaysThroughMe []iputil.VpnIp          `json:"currentRelaysThroughMe"`
 }
 
+// ControlTunnelCA describes the CA that issued the certificate of the host on the other end of a tunnel
+type ControlTunnelCA struct {
+	VpnIp      net.IP `json:"vpnIp"`
+	CertName   string `json:"certName"`
+	Issuer     string `json:"issuer"`
+	IssuerName string `json:"issuerName"`
+	// DeprecatedCA is the fingerprint of the deprecated CA in the chain of the certificate, if any
+	DeprecatedCA string `json:"deprecatedCa,omitempty"`
+}
+
+// ControlFirewallRule describes a firewall rule and the number of flows it has matched
+type ControlFirewallRule struct {
+	Name      string `json:"name"`
+	Direction string `json:"direction"`
+	Action    string `json:"action"`
+	Hits      int64  `json:"hits"`
+	Rule      string `json:"rule"`
+}
+
+// ControlConntrackEntry describes a flow tracked by the firewall. Direction is the direction of the packet that
+// started the flow, RulesVersion is the version of the rules that allowed it.
+type ControlConntrackEntry struct {
+	VpnIp        net.IP    `json:"vpnIp"`
+	Direction    string    `json:"direction"`
+	Protocol     string    `json:"protocol"`
+	LocalIP      net.IP    `json:"localIp"`
+	LocalPort    uint16    `json:"localPort"`
+	RemoteIP     net.IP    `json:"remoteIp"`
+	RemotePort   uint16    `json:"remotePort"`
+	ICMPType     uint8     `json:"icmpType,omitempty"`
+	ICMPCode     uint8     `json:"icmpCode,omitempty"`
+	ICMPID       uint16    `json:"icmpId,omitempty"`
+	Fragment     bool      `json:"fragment,omitempty"`
+	TCPState     string    `json:"tcpState,omitempty"`
+	Expires      time.Time `json:"expires"`
+	RulesVersion uint16    `json:"rulesVersion"`
+}
+
+// ControlConntrackFilter selects conntrack entries, the zero value selects every entry
+type ControlConntrackFilter struct {
+	// VpnIp selects the flows with a single peer
+	VpnIp iputil.VpnIp
+	// Port selects the flows using Port on either side
+	Port uint16
+}
+
+func (cf ControlConntrackFilter) match(fp firewall.Packet, c *conn) bool {
+	if cf.VpnIp.IsValid() && cf.VpnIp != c.vpnIp {
+		return false
+	}
+
+	if cf.Port != 0 && cf.Port != fp.LocalPort && cf.Port != fp.RemotePort {
+		return false
+	}
+
+	return true
+}
+
 // Start actually runs nebula, this is a nonblocking call. To block use Control.ShutdownBlock()
 func (c *Control) Start() {
 	// Activate the interface
@@ -123,6 +184,30 @@ func (c *Control) GetHostInfoByVpnIp(vpnIp iputil.VpnIp, pending bool) *ControlH
 	return &ch
 }
 
+// ListTunnelCAs returns the CA that issued the certificate of every established tunnel, useful to track the progress
+// of a CA rotation
+func (c *Control) ListTunnelCAs() []ControlTunnelCA {
+	return listTunnelCAs(c.f.hostMap, c.f.caPool)
+}
+
+// ListFirewallRules returns every rule of the running firewall, in the order they were configured, with the number of
+// flows each has matched. Rules that never match are good candidates for removal.
+func (c *Control) ListFirewallRules() []ControlFirewallRule {
+	return listFirewallRules(c.f.firewall)
+}
+
+// ListConntrack returns the flows tracked by the firewall that match filter, ordered by peer
+func (c *Control) ListConntrack(filter ControlConntrackFilter) []ControlConntrackEntry {
+	return listConntrack(c.f.firewall, filter)
+}
+
+// FlushConntrack forgets the flows tracked by the firewall that match filter and returns how many were flushed. The next
+// packet of a flushed flow is checked against the rules as if it started a new flow, routines may keep passing it for
+// up to firewall.conntrack.routine_cache_timeout.
+func (c *Control) FlushConntrack(filter ControlConntrackFilter) int {
+	return flushConntrack(c.f.firewall, filter)
+}
+
 // SetRemoteForTunnel forces a tunnel to use a specific remote
 func (c *Control) SetRemoteForTunnel(vpnIp iputil.VpnIp, addr udp.Addr) *ControlHostInfo {
 	hostInfo, err := c.f.hostMap.QueryVpnIp(vpnIp)
@@ -245,3 +330,147 @@ func listHostMap(hm *HostMap) []ControlHostInfo {
 
 	return hosts
 }
+
+func listTunnelCAs(hm *HostMap, caPool *cert.NebulaCAPool) []ControlTunnelCA {
+	hm.RLock()
+	hostInfos := make([]*HostInfo, 0, len(hm.Hosts))
+	for _, v := range hm.Hosts {
+		hostInfos = append(hostInfos, v)
+	}
+	hm.RUnlock()
+
+	tunnels := make([]ControlTunnelCA, 0, len(hostInfos))
+	for _, h := range hostInfos {
+		c := h.GetCert()
+		if c == nil {
+			continue
+		}
+
+		t := ControlTunnelCA{
+			VpnIp:        h.vpnIp.ToIP(),
+			CertName:     c.Details.Name,
+			Issuer:       c.Details.Issuer,
+			DeprecatedCA: caPool.GetDeprecatedCA(c),
+		}
+
+		// The issuer may have been removed from the pool since the tunnel was established
+		if signer, err := caPool.GetCAForCert(c); err == nil {
+			t.IssuerName = signer.Details.Name
+		}
+
+		tunnels = append(tunnels, t)
+	}
+
+	return tunnels
+}
+
+func listFirewallRules(fw *Firewall) []ControlFirewallRule {
+	rules := make([]ControlFirewallRule, 0, len(fw.ruleStats))
+	for _, s := range fw.ruleStats {
+		r := ControlFirewallRule{
+			Name:      s.Name,
+			Direction: "incoming",
+			Action:    ruleAction(s.Deny),
+			Hits:      s.Hits(),
+			Rule:      s.Rule,
+		}
+
+		if !s.Incoming {
+			r.Direction = "outgoing"
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules
+}
+
+func listConntrack(fw *Firewall, filter ControlConntrackFilter) []ControlConntrackEntry {
+	conntrack := fw.Conntrack
+	conntrack.Lock()
+	entries := make([]ControlConntrackEntry, 0, len(conntrack.Conns))
+	vpnIps := make([]iputil.VpnIp, 0, len(conntrack.Conns))
+	for fp, c := range conntrack.Conns {
+		if !filter.match(fp, c) {
+			continue
+		}
+
+		e := ControlConntrackEntry{
+			VpnIp:        c.vpnIp.ToIP(),
+			Direction:    "incoming",
+			Protocol:     protoName(fp.Protocol),
+			LocalIP:      net.IP(fp.LocalIP.AsSlice()),
+			LocalPort:    fp.LocalPort,
+			RemoteIP:     net.IP(fp.RemoteIP.AsSlice()),
+			RemotePort:   fp.RemotePort,
+			Fragment:     fp.Fragment,
+			Expires:      c.Expires,
+			RulesVersion: c.rulesVersion,
+		}
+
+		if !c.incoming {
+			e.Direction = "outgoing"
+		}
+
+		switch fp.Protocol {
+		case firewall.ProtoTCP:
+			e.TCPState = tcpStateName(c.tcpState)
+		case firewall.ProtoICMP, firewall.ProtoICMPv6:
+			e.ICMPType = fp.ICMPType
+			e.ICMPCode = fp.ICMPCode
+			e.ICMPID = fp.ICMPID
+		}
+
+		entries = append(entries, e)
+		vpnIps = append(vpnIps, c.vpnIp)
+	}
+	conntrack.Unlock()
+
+	// Map order is random, keep the output stable so it can be compared between runs
+	sort.Sort(conntrackEntrySorter{entries: entries, vpnIps: vpnIps})
+	return entries
+}
+
+type conntrackEntrySorter struct {
+	entries []ControlConntrackEntry
+	vpnIps  []iputil.VpnIp
+}
+
+func (s conntrackEntrySorter) Len() int { return len(s.entries) }
+
+func (s conntrackEntrySorter) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.vpnIps[i], s.vpnIps[j] = s.vpnIps[j], s.vpnIps[i]
+}
+
+func (s conntrackEntrySorter) Less(i, j int) bool {
+	a, b := s.entries[i], s.entries[j]
+	switch {
+	case s.vpnIps[i] != s.vpnIps[j]:
+		return s.vpnIps[i].Less(s.vpnIps[j])
+	case a.Protocol != b.Protocol:
+		return a.Protocol < b.Protocol
+	case a.LocalPort != b.LocalPort:
+		return a.LocalPort < b.LocalPort
+	case a.RemotePort != b.RemotePort:
+		return a.RemotePort < b.RemotePort
+	default:
+		return a.Expires.Before(b.Expires)
+	}
+}
+
+func flushConntrack(fw *Firewall, filter ControlConntrackFilter) int {
+	conntrack := fw.Conntrack
+	conntrack.Lock()
+	defer conntrack.Unlock()
+
+	flushed := 0
+	for fp, c := range conntrack.Conns {
+		if filter.match(fp, c) {
+			conntrack.delete(fp)
+			flushed++
+		}
+	}
+
+	return flushed
+}
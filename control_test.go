@@ -2,12 +2,14 @@ package nebula
 
 import (
 	"net"
+	"net/netip"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/firewall"
 	"github.com/slackhq/nebula/iputil"
 	"github.com/slackhq/nebula/test"
 	"github.com/slackhq/nebula/udp"
@@ -42,14 +44,15 @@ func TestControl_GetHostInfoByVpnIp(t *testing.T) {
 			PublicKey:      []byte{5, 6, 7, 8},
 			IsCA:           false,
 			Issuer:         "the-issuer",
+			Extensions:     map[string]string{"team": "ops"},
 			InvertedGroups: map[string]struct{}{"default-group": {}},
 		},
 		Signature: []byte{1, 2, 1, 2, 1, 3},
 	}
 
 	remotes := NewRemoteList()
-	remotes.unlockedPrependV4(0, NewIp4AndPort(remote1.IP, uint32(remote1.Port)))
-	remotes.unlockedPrependV6(0, NewIp6AndPort(remote2.IP, uint32(remote2.Port)))
+	remotes.unlockedPrependV4(iputil.VpnIp{}, NewIp4AndPort(remote1.IP, uint32(remote1.Port)))
+	remotes.unlockedPrependV6(iputil.VpnIp{}, NewIp6AndPort(remote2.IP, uint32(remote2.Port)))
 	hm.Add(iputil.Ip2VpnIp(ipNet.IP), &HostInfo{
 		remote:  remote1,
 		remotes: remotes,
@@ -114,6 +117,112 @@ func TestControl_GetHostInfoByVpnIp(t *testing.T) {
 	})
 }
 
+func TestControl_ListTunnelCAs(t *testing.T) {
+	l := test.NewLogger()
+	hm := NewHostMap(l, "test", &net.IPNet{}, make([]*net.IPNet, 0))
+	crt := &cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:   "test",
+			Issuer: "the-issuer",
+		},
+	}
+
+	hm.Add(iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)), &HostInfo{
+		ConnectionState: &ConnectionState{peerCert: crt},
+		vpnIp:           iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
+	})
+
+	// Hosts without a cert yet are skipped
+	hm.Add(iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 5)), &HostInfo{
+		ConnectionState: &ConnectionState{},
+		vpnIp:           iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 5)),
+	})
+
+	caPool := cert.NewCAPool()
+	c := Control{
+		f: &Interface{
+			hostMap: hm,
+			caPool:  caPool,
+		},
+		l: logrus.New(),
+	}
+
+	// The issuer is not in the pool so it has no name
+	assert.Equal(t, []ControlTunnelCA{{VpnIp: net.IPv4(1, 2, 3, 4).To4(), CertName: "test", Issuer: "the-issuer"}}, c.ListTunnelCAs())
+
+	caPool.DeprecateCA("the-issuer")
+	assert.Equal(t, []ControlTunnelCA{{VpnIp: net.IPv4(1, 2, 3, 4).To4(), CertName: "test", Issuer: "the-issuer", DeprecatedCA: "the-issuer"}}, c.ListTunnelCAs())
+}
+
+func TestControl_ListConntrack(t *testing.T) {
+	l := test.NewLogger()
+	newHost := func(ip net.IP) *HostInfo {
+		c := &cert.NebulaCertificate{
+			Details: cert.NebulaCertificateDetails{
+				Name:   ip.String(),
+				Ips:    []*net.IPNet{{IP: ip, Mask: net.IPMask{255, 255, 255, 0}}},
+				Groups: []string{"default-group"},
+			},
+		}
+		h := &HostInfo{
+			ConnectionState: &ConnectionState{peerCert: c},
+			vpnIp:           iputil.Ip2VpnIp(ip),
+		}
+		h.CreateRemoteCIDR(c)
+		return h
+	}
+	h1 := newHost(net.IPv4(10, 1, 1, 2).To4())
+	h2 := newHost(net.IPv4(10, 1, 1, 3).To4())
+
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, newHost(net.IPv4(10, 1, 1, 1).To4()).GetCert())
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}}, 0, 0))
+	cp := cert.NewCAPool()
+
+	local := netip.AddrFrom4([4]byte{10, 1, 1, 1})
+	ssh := firewall.Packet{LocalIP: local, RemoteIP: netip.AddrFrom4([4]byte{10, 1, 1, 2}), LocalPort: 22, RemotePort: 40000, Protocol: firewall.ProtoTCP, TCPFlags: tcpSYN}
+	dns := firewall.Packet{LocalIP: local, RemoteIP: netip.AddrFrom4([4]byte{10, 1, 1, 2}), LocalPort: 53, RemotePort: 40001, Protocol: firewall.ProtoUDP}
+	ping := firewall.Packet{LocalIP: local, RemoteIP: netip.AddrFrom4([4]byte{10, 1, 1, 3}), Protocol: firewall.ProtoICMP, ICMPType: firewall.ICMPEchoRequest, ICMPID: 7}
+	assert.NoError(t, fw.Drop([]byte{0x60}, ssh, true, h1, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, dns, true, h1, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, ping, true, h2, cp, nil))
+
+	c := Control{
+		f: &Interface{firewall: fw},
+		l: logrus.New(),
+	}
+
+	entries := c.ListConntrack(ControlConntrackFilter{})
+	assert.Len(t, entries, 3)
+	for i := range entries {
+		assert.WithinDuration(t, time.Now(), entries[i].Expires, time.Hour)
+		entries[i].Expires = time.Time{}
+	}
+	assert.Equal(t, []ControlConntrackEntry{
+		{VpnIp: net.IP{10, 1, 1, 2}, Direction: "incoming", Protocol: "tcp", LocalIP: net.IP{10, 1, 1, 1}, LocalPort: 22, RemoteIP: net.IP{10, 1, 1, 2}, RemotePort: 40000, TCPState: "syn_sent"},
+		{VpnIp: net.IP{10, 1, 1, 2}, Direction: "incoming", Protocol: "udp", LocalIP: net.IP{10, 1, 1, 1}, LocalPort: 53, RemoteIP: net.IP{10, 1, 1, 2}, RemotePort: 40001},
+		{VpnIp: net.IP{10, 1, 1, 3}, Direction: "incoming", Protocol: "icmp", LocalIP: net.IP{10, 1, 1, 1}, RemoteIP: net.IP{10, 1, 1, 3}, ICMPType: firewall.ICMPEchoRequest, ICMPID: 7},
+	}, entries)
+
+	// Filters combine, the port can be on either side
+	entries = c.ListConntrack(ControlConntrackFilter{VpnIp: h1.vpnIp})
+	assert.Len(t, entries, 2)
+	entries = c.ListConntrack(ControlConntrackFilter{Port: 40001})
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "udp", entries[0].Protocol)
+	assert.Empty(t, c.ListConntrack(ControlConntrackFilter{VpnIp: h2.vpnIp, Port: 22}))
+
+	// A flushed flow is checked against the rules again, which do not allow outgoing packets
+	reply := firewall.Packet{LocalIP: local, RemoteIP: ssh.RemoteIP, LocalPort: 22, RemotePort: 40000, Protocol: firewall.ProtoTCP}
+	assert.NoError(t, fw.Drop([]byte{0x60}, reply, false, h1, cp, nil))
+	assert.Equal(t, 1, c.FlushConntrack(ControlConntrackFilter{VpnIp: h1.vpnIp, Port: 22}))
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{0x60}, reply, false, h1, cp, nil))
+	assert.Equal(t, 1, fw.Conntrack.hostEntries[h1.vpnIp])
+
+	assert.Equal(t, 2, c.FlushConntrack(ControlConntrackFilter{}))
+	assert.Empty(t, c.ListConntrack(ControlConntrackFilter{}))
+	assert.Empty(t, fw.Conntrack.hostEntries)
+}
+
 func assertFields(t *testing.T, expected []string, actualStruct interface{}) {
 	val := reflect.ValueOf(actualStruct).Elem()
 	fields := make([]string, val.NumField())
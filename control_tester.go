@@ -73,9 +73,9 @@ func (c *Control) InjectRelays(vpnIp net.IP, relayVpnIps []net.IP) {
 	c.f.lightHouse.Unlock()
 
 	iVpnIp := iputil.Ip2VpnIp(vpnIp)
-	uVpnIp := []uint32{}
+	uVpnIp := []iputil.VpnIp{}
 	for _, rVPnIp := range relayVpnIps {
-		uVpnIp = append(uVpnIp, uint32(iputil.Ip2VpnIp(rVPnIp)))
+		uVpnIp = append(uVpnIp, iputil.Ip2VpnIp(rVPnIp))
 	}
 
 	remoteList.unlockedSetRelay(iVpnIp, iVpnIp, uVpnIp)
@@ -106,19 +106,43 @@ func (c *Control) InjectUDPPacket(p *udp.Packet) {
 
 // InjectTunUDPPacket puts a udp packet on the tun interface. Using UDP here because it's a simpler protocol
 func (c *Control) InjectTunUDPPacket(toIp net.IP, toPort uint16, fromPort uint16, data []byte) {
-	ip := layers.IPv4{
-		Version:  4,
-		TTL:      64,
-		Protocol: layers.IPProtocolUDP,
-		SrcIP:    c.f.inside.Cidr().IP,
-		DstIP:    toIp,
+	// Send from our vpn ip of the same family as toIp
+	fromIp := c.f.inside.Cidr().IP
+	for _, p := range c.f.myVpnNets {
+		if p.Addr().Is4() == (toIp.To4() != nil) {
+			fromIp = p.Addr().AsSlice()
+			break
+		}
 	}
 
+	var ip gopacket.SerializableLayer
 	udp := layers.UDP{
 		SrcPort: layers.UDPPort(fromPort),
 		DstPort: layers.UDPPort(toPort),
 	}
-	err := udp.SetNetworkLayerForChecksum(&ip)
+
+	var err error
+	if toIp.To4() != nil {
+		ip4 := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    fromIp,
+			DstIP:    toIp,
+		}
+		err = udp.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	} else {
+		ip6 := &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolUDP,
+			SrcIP:      fromIp,
+			DstIP:      toIp,
+		}
+		err = udp.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -128,7 +152,7 @@ func (c *Control) InjectTunUDPPacket(toIp net.IP, toPort uint16, fromPort uint16
 		ComputeChecksums: true,
 		FixLengths:       true,
 	}
-	err = gopacket.SerializeLayers(buffer, opt, &ip, &udp, gopacket.Payload(data))
+	err = gopacket.SerializeLayers(buffer, opt, ip, &udp, gopacket.Payload(data))
 	if err != nil {
 		panic(err)
 	}
@@ -66,6 +66,90 @@ func TestGoodHandshake(t *testing.T) {
 	//TODO: assert hostmaps
 }
 
+func TestGoodHandshakeP256(t *testing.T) {
+	ca, _, caKey, _ := newTestCaCertP256(time.Now(), time.Now().Add(10*time.Minute))
+	myControl, myVpnIp, myUdpAddr := newSimpleServer(ca, caKey, "me", net.IP{10, 0, 0, 1}, nil)
+	theirControl, theirVpnIp, theirUdpAddr := newSimpleServer(ca, caKey, "them", net.IP{10, 0, 0, 2}, nil)
+
+	// Put their info in our lighthouse
+	myControl.InjectLightHouseAddr(theirVpnIp, theirUdpAddr)
+
+	// Start the servers
+	myControl.Start()
+	theirControl.Start()
+
+	t.Log("Send a udp packet through to begin standing up the tunnel, this should come out the other side")
+	myControl.InjectTunUDPPacket(theirVpnIp, 80, 80, []byte("Hi from me"))
+
+	t.Log("Have them consume my stage 0 packet. They have a tunnel now")
+	theirControl.InjectUDPPacket(myControl.GetFromUDP(true))
+
+	t.Log("Have me consume their stage 1 packet. I have a tunnel now")
+	myControl.InjectUDPPacket(theirControl.GetFromUDP(true))
+
+	t.Log("Wait until we see my cached packet come through")
+	myControl.WaitForType(1, 0, theirControl)
+
+	t.Log("Make sure our host infos are correct")
+	assertHostInfoPair(t, myUdpAddr, theirUdpAddr, myVpnIp, theirVpnIp, myControl, theirControl)
+
+	t.Log("Get that cached packet and make sure it looks right")
+	myCachedPacket := theirControl.GetFromTun(true)
+	assertUdpPacket(t, []byte("Hi from me"), myCachedPacket, myVpnIp, theirVpnIp, 80, 80)
+
+	t.Log("Do a bidirectional tunnel test")
+	r := router.NewR(t, myControl, theirControl)
+	defer r.RenderFlow()
+	assertTunnel(t, myVpnIp, theirVpnIp, myControl, theirControl, r)
+
+	myControl.Stop()
+	theirControl.Stop()
+}
+
+func TestCARotation(t *testing.T) {
+	oldCa, _, oldCaKey, oldCaPEM := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	newCa, _, newCaKey, newCaPEM := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	oldFp, _ := oldCa.Sha256Sum()
+	newFp, _ := newCa.Sha256Sum()
+
+	// Both trust both CAs while the rotation is in progress, they have deprecated the old one
+	caPool := string(oldCaPEM) + string(newCaPEM)
+	myControl, myVpnIp, myUdpAddr := newSimpleServer(oldCa, oldCaKey, "me", net.IP{10, 0, 0, 1}, m{"pki": m{"ca": caPool}})
+	theirControl, theirVpnIp, theirUdpAddr := newSimpleServer(newCa, newCaKey, "them", net.IP{10, 0, 0, 2}, m{"pki": m{"ca": caPool, "ca_deprecated": []string{oldFp}}})
+
+	myControl.InjectLightHouseAddr(theirVpnIp, theirUdpAddr)
+	myControl.Start()
+	theirControl.Start()
+
+	t.Log("Stand up the tunnel, both sides accept the others ca")
+	myControl.InjectTunUDPPacket(theirVpnIp, 80, 80, []byte("Hi from me"))
+	theirControl.InjectUDPPacket(myControl.GetFromUDP(true))
+	myControl.InjectUDPPacket(theirControl.GetFromUDP(true))
+	myControl.WaitForType(1, 0, theirControl)
+	assertHostInfoPair(t, myUdpAddr, theirUdpAddr, myVpnIp, theirVpnIp, myControl, theirControl)
+	assertUdpPacket(t, []byte("Hi from me"), theirControl.GetFromTun(true), myVpnIp, theirVpnIp, 80, 80)
+
+	t.Log("I see their cert was issued by the new ca")
+	assert.Equal(t, []nebula.ControlTunnelCA{{
+		VpnIp:      theirVpnIp,
+		CertName:   "them",
+		Issuer:     newFp,
+		IssuerName: "test ca",
+	}}, myControl.ListTunnelCAs())
+
+	t.Log("They see my cert was issued by the deprecated ca")
+	assert.Equal(t, []nebula.ControlTunnelCA{{
+		VpnIp:        myVpnIp,
+		CertName:     "me",
+		Issuer:       oldFp,
+		IssuerName:   "test ca",
+		DeprecatedCA: oldFp,
+	}}, theirControl.ListTunnelCAs())
+
+	myControl.Stop()
+	theirControl.Stop()
+}
+
 func TestWrongResponderHandshake(t *testing.T) {
 	ca, _, caKey, _ := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
 
@@ -215,3 +299,91 @@ func TestRelays(t *testing.T) {
 }
 
 //TODO: add a test with many lies
+
+func TestGoodHandshakeIpv6Only(t *testing.T) {
+	ca, _, caKey, _ := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	myControl, myVpnIp, myUdpAddr := newSimpleServer(ca, caKey, "me", net.ParseIP("fd00::1"), nil)
+	theirControl, theirVpnIp, theirUdpAddr := newSimpleServer(ca, caKey, "them", net.ParseIP("fd00::2"), nil)
+
+	// Put their info in our lighthouse
+	myControl.InjectLightHouseAddr(theirVpnIp, theirUdpAddr)
+
+	// Start the servers
+	myControl.Start()
+	theirControl.Start()
+
+	t.Log("Send a udp packet through to begin standing up the tunnel, this should come out the other side")
+	myControl.InjectTunUDPPacket(theirVpnIp, 80, 80, []byte("Hi from me"))
+
+	t.Log("Have them consume my stage 0 packet. They have a tunnel now")
+	theirControl.InjectUDPPacket(myControl.GetFromUDP(true))
+
+	t.Log("Have me consume their stage 1 packet. I have a tunnel now")
+	myControl.InjectUDPPacket(theirControl.GetFromUDP(true))
+
+	t.Log("Wait until we see my cached packet come through")
+	myControl.WaitForType(1, 0, theirControl)
+
+	t.Log("Make sure our host infos are correct")
+	assertHostInfoPair(t, myUdpAddr, theirUdpAddr, myVpnIp, theirVpnIp, myControl, theirControl)
+
+	t.Log("Get that cached packet and make sure it looks right")
+	myCachedPacket := theirControl.GetFromTun(true)
+	assertUdpPacket(t, []byte("Hi from me"), myCachedPacket, myVpnIp, theirVpnIp, 80, 80)
+
+	t.Log("Do a bidirectional tunnel test")
+	r := router.NewR(t, myControl, theirControl)
+	defer r.RenderFlow()
+	assertTunnel(t, myVpnIp, theirVpnIp, myControl, theirControl, r)
+
+	myControl.Stop()
+	theirControl.Stop()
+}
+
+func TestHandshakeWithSecondVpnIp(t *testing.T) {
+	ca, _, caKey, _ := newTestCaCert(time.Now(), time.Now().Add(10*time.Minute), []*net.IPNet{}, []*net.IPNet{}, []string{})
+	_, myVpnNet6, _ := net.ParseCIDR("fd80::1/64")
+	myVpnNet6.IP = net.ParseIP("fd80::1")
+	_, theirVpnNet6, _ := net.ParseCIDR("fd80::2/64")
+	theirVpnNet6.IP = net.ParseIP("fd80::2")
+
+	myControl, myVpnIp, myUdpAddr := newServerWithVpnIps(ca, caKey, "me", net.IP{10, 0, 0, 1}, []*net.IPNet{{IP: net.IP{10, 128, 0, 1}, Mask: net.IPMask{255, 255, 255, 0}}, myVpnNet6}, nil)
+	theirControl, theirVpnIp, theirUdpAddr := newServerWithVpnIps(ca, caKey, "them", net.IP{10, 0, 0, 2}, []*net.IPNet{{IP: net.IP{10, 128, 0, 2}, Mask: net.IPMask{255, 255, 255, 0}}, theirVpnNet6}, nil)
+
+	// Put their info in our lighthouse under their second vpn ip
+	myControl.InjectLightHouseAddr(theirVpnNet6.IP, theirUdpAddr)
+
+	// Start the servers
+	myControl.Start()
+	theirControl.Start()
+
+	t.Log("Send a udp packet to their second vpn ip to begin standing up the tunnel")
+	myControl.InjectTunUDPPacket(theirVpnNet6.IP, 80, 80, []byte("Hi from me"))
+
+	t.Log("Have them consume my stage 0 packet. They have a tunnel now")
+	theirControl.InjectUDPPacket(myControl.GetFromUDP(true))
+
+	t.Log("Have me consume their stage 1 packet. I have a tunnel now")
+	myControl.InjectUDPPacket(theirControl.GetFromUDP(true))
+
+	t.Log("Wait until we see my cached packet come through")
+	myControl.WaitForType(1, 0, theirControl)
+
+	t.Log("Make sure our host infos are stored under the first vpn ip and found by either")
+	assertHostInfoPair(t, myUdpAddr, theirUdpAddr, myVpnIp, theirVpnIp, myControl, theirControl)
+	assert.NotNil(t, myControl.GetHostInfoByVpnIp(iputil.Ip2VpnIp(theirVpnNet6.IP), false))
+	assert.Nil(t, myControl.GetHostInfoByVpnIp(iputil.Ip2VpnIp(theirVpnNet6.IP), true), "The pending handshake was not cleaned up")
+
+	t.Log("Get that cached packet and make sure it looks right")
+	myCachedPacket := theirControl.GetFromTun(true)
+	assertUdpPacket(t, []byte("Hi from me"), myCachedPacket, myVpnNet6.IP, theirVpnNet6.IP, 80, 80)
+
+	t.Log("Do a bidirectional tunnel test over both vpn ips")
+	r := router.NewR(t, myControl, theirControl)
+	defer r.RenderFlow()
+	assertTunnel(t, myVpnIp, theirVpnIp, myControl, theirControl, r)
+	assertTunnel(t, myVpnNet6.IP, theirVpnNet6.IP, myControl, theirControl, r)
+
+	myControl.Stop()
+	theirControl.Stop()
+}
@@ -4,6 +4,8 @@
 package e2e
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"fmt"
 	"io"
@@ -32,16 +34,25 @@ type m map[string]interface{}
 
 // newSimpleServer creates a nebula instance with many assumptions
 func newSimpleServer(caCrt *cert.NebulaCertificate, caKey []byte, name string, udpIp net.IP, overrides m) (*nebula.Control, net.IP, *net.UDPAddr) {
-	l := NewTestLogger()
-
 	vpnIpNet := &net.IPNet{IP: make([]byte, len(udpIp)), Mask: net.IPMask{255, 255, 255, 0}}
+	if udpIp.To4() == nil {
+		vpnIpNet.Mask = net.CIDRMask(64, 128)
+	}
 	copy(vpnIpNet.IP, udpIp)
 	vpnIpNet.IP[1] += 128
+
+	return newServerWithVpnIps(caCrt, caKey, name, udpIp, []*net.IPNet{vpnIpNet}, overrides)
+}
+
+// newServerWithVpnIps is newSimpleServer with the ips to put in the certificate, the first is returned
+func newServerWithVpnIps(caCrt *cert.NebulaCertificate, caKey []byte, name string, udpIp net.IP, vpnIps []*net.IPNet, overrides m) (*nebula.Control, net.IP, *net.UDPAddr) {
+	l := NewTestLogger()
+
 	udpAddr := net.UDPAddr{
 		IP:   udpIp,
 		Port: 4242,
 	}
-	_, _, myPrivKey, myPEM := newTestCert(caCrt, caKey, name, time.Now(), time.Now().Add(5*time.Minute), vpnIpNet, nil, []string{})
+	_, _, myPrivKey, myPEM := newTestCert(caCrt, caKey, name, time.Now(), time.Now().Add(5*time.Minute), vpnIps, nil, []string{})
 
 	caB, err := caCrt.MarshalToPEM()
 	if err != nil {
@@ -102,7 +113,7 @@ func newSimpleServer(caCrt *cert.NebulaCertificate, caKey []byte, name string, u
 		panic(err)
 	}
 
-	return control, vpnIpNet.IP, &udpAddr
+	return control, vpnIps[0].IP, &udpAddr
 }
 
 // newTestCaCert will generate a CA cert
@@ -151,9 +162,43 @@ func newTestCaCert(before, after time.Time, ips, subnets []*net.IPNet, groups []
 	return nc, pub, priv, pem
 }
 
+// newTestCaCertP256 will generate a CA cert that signs with ECDSA P256
+func newTestCaCertP256(before, after time.Time) (*cert.NebulaCertificate, []byte, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	pub := elliptic.Marshal(key.Curve, key.X, key.Y)
+	priv := key.D.FillBytes(make([]byte, 32))
+
+	nc := &cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "test ca p256",
+			NotBefore:      time.Unix(before.Unix(), 0),
+			NotAfter:       time.Unix(after.Unix(), 0),
+			PublicKey:      pub,
+			IsCA:           true,
+			Curve:          cert.Curve_P256,
+			InvertedGroups: make(map[string]struct{}),
+		},
+	}
+
+	err = nc.Sign(priv)
+	if err != nil {
+		panic(err)
+	}
+
+	pem, err := nc.MarshalToPEM()
+	if err != nil {
+		panic(err)
+	}
+
+	return nc, pub, priv, pem
+}
+
 // newTestCert will generate a signed certificate with the provided details.
 // Expiry times are defaulted if you do not pass them in
-func newTestCert(ca *cert.NebulaCertificate, key []byte, name string, before, after time.Time, ip *net.IPNet, subnets []*net.IPNet, groups []string) (*cert.NebulaCertificate, []byte, []byte, []byte) {
+func newTestCert(ca *cert.NebulaCertificate, key []byte, name string, before, after time.Time, ips []*net.IPNet, subnets []*net.IPNet, groups []string) (*cert.NebulaCertificate, []byte, []byte, []byte) {
 	issuer, err := ca.Sha256Sum()
 	if err != nil {
 		panic(err)
@@ -168,11 +213,14 @@ func newTestCert(ca *cert.NebulaCertificate, key []byte, name string, before, af
 	}
 
 	pub, rawPriv := x25519Keypair()
+	if ca.Details.Curve == cert.Curve_P256 {
+		pub, rawPriv = p256Keypair()
+	}
 
 	nc := &cert.NebulaCertificate{
 		Details: cert.NebulaCertificateDetails{
 			Name:           name,
-			Ips:            []*net.IPNet{ip},
+			Ips:            ips,
 			Subnets:        subnets,
 			Groups:         groups,
 			NotBefore:      time.Unix(before.Unix(), 0),
@@ -180,6 +228,7 @@ func newTestCert(ca *cert.NebulaCertificate, key []byte, name string, before, af
 			PublicKey:      pub,
 			IsCA:           false,
 			Issuer:         issuer,
+			Curve:          ca.Details.Curve,
 			InvertedGroups: make(map[string]struct{}),
 		},
 	}
@@ -194,7 +243,7 @@ func newTestCert(ca *cert.NebulaCertificate, key []byte, name string, before, af
 		panic(err)
 	}
 
-	return nc, pub, cert.MarshalX25519PrivateKey(rawPriv), pem
+	return nc, pub, cert.MarshalPrivateKey(ca.Details.Curve, rawPriv), pem
 }
 
 func x25519Keypair() ([]byte, []byte) {
@@ -211,6 +260,15 @@ func x25519Keypair() ([]byte, []byte) {
 	return pubkey, privkey
 }
 
+func p256Keypair() ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return elliptic.Marshal(key.Curve, key.X, key.Y), key.D.FillBytes(make([]byte, 32))
+}
+
 type doneCb func()
 
 func deadline(t *testing.T, seconds time.Duration) doneCb {
@@ -281,12 +339,17 @@ func assertHostInfoPair(t *testing.T, addrA, addrB *net.UDPAddr, vpnIpA, vpnIpB
 }
 
 func assertUdpPacket(t *testing.T, expected, b []byte, fromIp, toIp net.IP, fromPort, toPort uint16) {
-	packet := gopacket.NewPacket(b, layers.LayerTypeIPv4, gopacket.Lazy)
-	v4 := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
-	assert.NotNil(t, v4, "No ipv4 data found")
+	layerType := layers.LayerTypeIPv4
+	if b[0]>>4 == 6 {
+		layerType = layers.LayerTypeIPv6
+	}
+
+	packet := gopacket.NewPacket(b, layerType, gopacket.Lazy)
+	ip := packet.NetworkLayer()
+	assert.NotNil(t, ip, "No ip data found")
 
-	assert.Equal(t, fromIp, v4.SrcIP, "Source ip was incorrect")
-	assert.Equal(t, toIp, v4.DstIP, "Dest ip was incorrect")
+	assert.Equal(t, fromIp, net.IP(ip.NetworkFlow().Src().Raw()), "Source ip was incorrect")
+	assert.Equal(t, toIp, net.IP(ip.NetworkFlow().Dst().Raw()), "Dest ip was incorrect")
 
 	udp := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
 	assert.NotNil(t, udp, "No udp data found")
@@ -594,14 +594,19 @@ func (r *R) getControl(fromAddr, toAddr string, p *udp.Packet) *nebula.Control {
 }
 
 func (r *R) formatUdpPacket(p *packet) string {
-	packet := gopacket.NewPacket(p.packet.Data, layers.LayerTypeIPv4, gopacket.Lazy)
-	v4 := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
-	if v4 == nil {
-		panic("not an ipv4 packet")
+	layerType := layers.LayerTypeIPv4
+	if p.packet.Data[0]>>4 == 6 {
+		layerType = layers.LayerTypeIPv6
+	}
+
+	packet := gopacket.NewPacket(p.packet.Data, layerType, gopacket.Lazy)
+	ip := packet.NetworkLayer()
+	if ip == nil {
+		panic("not an ip packet")
 	}
 
 	from := "unknown"
-	if c, ok := r.vpnControls[iputil.Ip2VpnIp(v4.SrcIP)]; ok {
+	if c, ok := r.vpnControls[iputil.Ip2VpnIp(ip.NetworkFlow().Src().Raw())]; ok {
 		from = c.GetUDPAddr()
 	}
 
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"reflect"
 	"strconv"
 	"strings"
@@ -19,13 +20,54 @@ import (
 	"github.com/slackhq/nebula/cidr"
 	"github.com/slackhq/nebula/config"
 	"github.com/slackhq/nebula/firewall"
+	"github.com/slackhq/nebula/iputil"
 )
 
 const tcpACK = 0x10
 const tcpFIN = 0x01
+const tcpSYN = 0x02
+const tcpRST = 0x04
+
+// The states of a tracked tcp connection, each has its own timeout
+const (
+	tcpEstablished uint8 = iota
+	tcpSynSent
+	tcpFinWait
+	tcpClose
+)
+
+// Which sides of a tcp connection have sent a FIN
+const (
+	tcpFinOriginator uint8 = 1 << iota
+	tcpFinResponder
+)
+
+const (
+	defaultTCPSynSentTimeout = 2 * time.Minute
+	defaultTCPFinWaitTimeout = 2 * time.Minute
+	defaultTCPCloseTimeout   = 10 * time.Second
+)
 
 type FirewallInterface interface {
-	AddRule(incoming bool, proto uint8, startPort int32, endPort int32, groups []string, host string, ip *net.IPNet, caName string, caSha string) error
+	AddRule(r FirewallRuleSpec, startPort int32, endPort int32) error
+	AddICMPRule(r FirewallRuleSpec, startType int32, endType int32, startCode int32, endCode int32) error
+}
+
+// FirewallRuleSpec holds everything about a rule except the ports, or icmp types and codes, it matches
+type FirewallRuleSpec struct {
+	Incoming   bool
+	Deny       bool
+	Proto      uint8
+	Groups     []string
+	Host       string
+	Ip         *net.IPNet
+	LocalIp    *net.IPNet
+	Extensions map[string]string
+	CAName     string
+	CASha      string
+
+	// Name is what the hits of the rule are counted under, an empty name uses the index of the rule within its direction
+	Name string
 }
 
 type conn struct {
@@ -38,39 +80,162 @@ type conn struct {
 	// fields pack for free after the uint32 above
 	incoming     bool
 	rulesVersion uint16
+
+	// The host the connection is with, used to enforce the per host conntrack limit
+	vpnIp iputil.VpnIp
+
+	// Only used for tcp, tcpFin records which sides have closed their half of the connection
+	tcpState uint8
+	tcpFin   uint8
+}
+
+// startTCP sets the state of a new tcp connection from the flags of its first packet. A connection picked up without
+// seeing its SYN, after a restart or an eviction, is assumed to be established.
+func (c *conn) startTCP(flags uint8) {
+	if flags&(tcpSYN|tcpACK) == tcpSYN {
+		c.tcpState = tcpSynSent
+	} else {
+		c.tcpState = tcpEstablished
+	}
+
+	c.updateTCP(flags, c.incoming)
+}
+
+// updateTCP moves a tcp connection through its states from the flags of a packet, incoming is the direction of the
+// packet and not of the connection
+func (c *conn) updateTCP(flags uint8, incoming bool) {
+	fromOriginator := incoming == c.incoming
+
+	switch {
+	case flags&tcpRST != 0:
+		c.tcpState = tcpClose
+
+	case flags&tcpFIN != 0:
+		if fromOriginator {
+			c.tcpFin |= tcpFinOriginator
+		} else {
+			c.tcpFin |= tcpFinResponder
+		}
+
+		if c.tcpFin == tcpFinOriginator|tcpFinResponder {
+			c.tcpState = tcpClose
+		} else {
+			c.tcpState = tcpFinWait
+		}
+
+	case flags&(tcpSYN|tcpACK) == tcpSYN:
+		// The same ports are being reused for a new connection
+		if fromOriginator && c.tcpState != tcpSynSent && c.tcpState != tcpEstablished {
+			c.tcpState = tcpSynSent
+			c.tcpFin = 0
+		}
+
+	case c.tcpState == tcpSynSent && !fromOriginator:
+		c.tcpState = tcpEstablished
+	}
 }
 
-// TODO: need conntrack max tracked connections handling
 type Firewall struct {
 	Conntrack *FirewallConntrack
 
 	InRules  *FirewallTable
 	OutRules *FirewallTable
 
+	// Deny rules take precedence, a packet matching one is dropped even if an allow rule above matches it as well
+	InDenyRules  *FirewallTable
+	OutDenyRules *FirewallTable
+
 	//TODO: we should have many more options for TCP, an option for ICMP, and mimic the kernel a bit better
 	// https://www.kernel.org/doc/Documentation/networking/nf_conntrack-sysctl.txt
 	TCPTimeout     time.Duration //linux: 5 days max
 	UDPTimeout     time.Duration //linux: 180s max
 	DefaultTimeout time.Duration //linux: 600s
 
+	// Timeouts of tcp connections that are not established, TCPTimeout applies once established. None are ever longer
+	// than TCPTimeout.
+	TCPSynSentTimeout time.Duration //linux: 120s
+	TCPFinWaitTimeout time.Duration //linux: 120s
+	TCPCloseTimeout   time.Duration //linux: 10s
+
+	// Limits on the number of conntrack entries in total and per host, 0 is unlimited. When the table is full the
+	// entry closest to expiring is evicted, when a host is at its limit new connections with it are dropped.
+	MaxConntrackEntries        int
+	MaxConntrackEntriesPerHost int
+
 	// Used to ensure we don't emit local packets for ips we don't own
-	localIps *cidr.Tree4
+	localIps  *cidr.Tree4
+	localIps6 *cidr.Tree6
 
 	rules        string
 	rulesVersion uint16
 
+	// rulesPath is the directory of rule files this firewall was built with, rulesPathHash covers the files it read
+	rulesPath     string
+	rulesPathHash string
+
+	// ruleStats holds the hit counters of every rule in the order they were added
+	ruleStats []*firewallRuleStats
+
 	trackTCPRTT     bool
 	metricTCPRTT    metrics.Histogram
 	incomingMetrics firewallMetrics
 	outgoingMetrics firewallMetrics
 
+	// dropLog is nil unless firewall.log_drops is enabled
+	dropLog *firewallDropLog
+
 	l *logrus.Logger
 }
 
 type firewallMetrics struct {
-	droppedLocalIP  metrics.Counter
-	droppedRemoteIP metrics.Counter
-	droppedNoRule   metrics.Counter
+	droppedLocalIP   metrics.Counter
+	droppedRemoteIP  metrics.Counter
+	droppedNoRule    metrics.Counter
+	droppedDenyRule  metrics.Counter
+	droppedConntrack metrics.Counter
+}
+
+// dropped returns the counter of packets dropped for err
+func (m firewallMetrics) dropped(err error) metrics.Counter {
+	switch err {
+	case ErrInvalidLocalIP:
+		return m.droppedLocalIP
+	case ErrInvalidRemoteIP:
+		return m.droppedRemoteIP
+	case ErrDenyRule:
+		return m.droppedDenyRule
+	case ErrConntrackLimit:
+		return m.droppedConntrack
+	default:
+		return m.droppedNoRule
+	}
+}
+
+// firewallRuleStats counts the flows a single configured rule has matched. Only the first packet of a flow is
+// counted, everything after that is let through by conntrack. When several rules match a flow only the first one found
+// is counted.
+type firewallRuleStats struct {
+	Name     string
+	Incoming bool
+	Deny     bool
+	Rule     string
+
+	metricName string
+	hits       metrics.Counter
+}
+
+func (s *firewallRuleStats) hit() {
+	if s.hits != nil {
+		s.hits.Inc(1)
+	}
+}
+
+// Hits returns the number of flows counted against the rule
+func (s *firewallRuleStats) Hits() int64 {
+	if s.hits == nil {
+		return 0
+	}
+	return s.hits.Count()
 }
 
 type FirewallConntrack struct {
@@ -78,21 +243,53 @@ type FirewallConntrack struct {
 
 	Conns      map[firewall.Packet]*conn
 	TimerWheel *TimerWheel
+
+	// hostEntries counts the entries in Conns for every host
+	hostEntries map[iputil.VpnIp]int
+	evicted     metrics.Counter
+}
+
+// add stores a new entry or replaces an existing one, the caller must hold the lock
+func (ct *FirewallConntrack) add(fp firewall.Packet, c *conn) {
+	if old, ok := ct.Conns[fp]; ok {
+		ct.hostEntries[old.vpnIp]--
+	}
+	ct.Conns[fp] = c
+	ct.hostEntries[c.vpnIp]++
+}
+
+// delete removes an entry, the caller must hold the lock
+func (ct *FirewallConntrack) delete(fp firewall.Packet) {
+	c, ok := ct.Conns[fp]
+	if !ok {
+		return
+	}
+
+	delete(ct.Conns, fp)
+	if ct.hostEntries[c.vpnIp] <= 1 {
+		delete(ct.hostEntries, c.vpnIp)
+	} else {
+		ct.hostEntries[c.vpnIp]--
+	}
 }
 
 type FirewallTable struct {
 	TCP      firewallPort
 	UDP      firewallPort
-	ICMP     firewallPort
 	AnyProto firewallPort
+
+	// The icmp tables are keyed by icmpKey instead of port
+	ICMP   firewallPort
+	ICMPv6 firewallPort
 }
 
 func newFirewallTable() *FirewallTable {
 	return &FirewallTable{
 		TCP:      firewallPort{},
 		UDP:      firewallPort{},
-		ICMP:     firewallPort{},
 		AnyProto: firewallPort{},
+		ICMP:     firewallPort{},
+		ICMPv6:   firewallPort{},
 	}
 }
 
@@ -103,11 +300,86 @@ type FirewallCA struct {
 }
 
 type FirewallRule struct {
-	// Any makes Hosts, Groups, and CIDR irrelevant
+	// Any makes Hosts, Groups, Extensions, and CIDR irrelevant
 	Any    bool
-	Hosts  map[string]struct{}
+	Hosts  map[string]firewallLocalRules
 	Groups [][]string
 	CIDR   *cidr.Tree4
+	CIDR6  *cidr.Tree6
+
+	// Extensions holds sets of certificate extensions, every key and value in a set must match
+	Extensions []map[string]string
+
+	// The rules that match any peer, each entry of Groups and each entry of Extensions. Any is only set once a rule
+	// matches any peer sending to any local ip. Hosts and the cidr trees hold theirs as values.
+	anyRules       firewallLocalRules
+	groupRules     []firewallLocalRule
+	extensionRules []firewallLocalRule
+
+	// cidrRules finds the rules already stored in the cidr trees, so rules sharing a cidr are all kept
+	cidrRules map[string]*firewallLocalRules
+}
+
+// firewallLocalRule is a rule that only matches packets whose local ip is within local, a local that is not valid
+// matches every local ip
+type firewallLocalRule struct {
+	local netip.Prefix
+	stats *firewallRuleStats
+}
+
+// firewallLocalRules holds every rule that matched the same peer, in the order they were added
+type firewallLocalRules []firewallLocalRule
+
+func newFirewallLocalRule(localIp *net.IPNet, stats *firewallRuleStats) firewallLocalRule {
+	lr := firewallLocalRule{stats: stats}
+	if localIp == nil {
+		return lr
+	}
+
+	ones, bits := localIp.Mask.Size()
+	ip, _ := netip.AddrFromSlice(localIp.IP)
+	if ip.Is4In6() {
+		ip = ip.Unmap()
+		if bits == 128 {
+			ones -= 96
+		}
+	}
+
+	// A local cidr covering everything is the same as not having one
+	if ones > 0 {
+		lr.local = netip.PrefixFrom(ip, ones).Masked()
+	}
+
+	return lr
+}
+
+func (lr firewallLocalRule) match(ip netip.Addr) *firewallRuleStats {
+	if lr.local.IsValid() && !lr.local.Contains(ip) {
+		return nil
+	}
+
+	return lr.stats
+}
+
+func (lrs firewallLocalRules) match(ip netip.Addr) *firewallRuleStats {
+	for _, lr := range lrs {
+		if rs := lr.match(ip); rs != nil {
+			return rs
+		}
+	}
+
+	return nil
+}
+
+// add appends a rule unless one that matches every local ip is already present, it would shadow the new rule
+func (lrs firewallLocalRules) add(lr firewallLocalRule) firewallLocalRules {
+	for _, e := range lrs {
+		if !e.local.IsValid() {
+			return lrs
+		}
+	}
+
+	return append(lrs, lr)
 }
 
 // Even though ports are uint16, int32 maps are faster for lookup
@@ -117,54 +389,61 @@ type firewallPort map[int32]*FirewallCA
 // NewFirewall creates a new Firewall object. A TimerWheel is created for you from the provided timeouts.
 func NewFirewall(l *logrus.Logger, tcpTimeout, UDPTimeout, defaultTimeout time.Duration, c *cert.NebulaCertificate) *Firewall {
 	//TODO: error on 0 duration
-	var min, max time.Duration
-
-	if tcpTimeout < UDPTimeout {
-		min = tcpTimeout
-		max = UDPTimeout
-	} else {
-		min = UDPTimeout
-		max = tcpTimeout
-	}
-
-	if defaultTimeout < min {
-		min = defaultTimeout
-	} else if defaultTimeout > max {
-		max = defaultTimeout
-	}
-
 	localIps := cidr.NewTree4()
+	localIps6 := cidr.NewTree6()
 	for _, ip := range c.Details.Ips {
-		localIps.AddCIDR(&net.IPNet{IP: ip.IP, Mask: net.IPMask{255, 255, 255, 255}}, struct{}{})
+		if ip.IP.To4() != nil {
+			localIps.AddCIDR(&net.IPNet{IP: ip.IP, Mask: net.IPMask{255, 255, 255, 255}}, struct{}{})
+		} else {
+			localIps6.AddCIDR(&net.IPNet{IP: ip.IP, Mask: net.CIDRMask(128, 128)}, struct{}{})
+		}
 	}
 
 	for _, n := range c.Details.Subnets {
-		localIps.AddCIDR(n, struct{}{})
+		if n.IP.To4() != nil {
+			localIps.AddCIDR(n, struct{}{})
+		} else {
+			localIps6.AddCIDR(n, struct{}{})
+		}
 	}
 
 	return &Firewall{
 		Conntrack: &FirewallConntrack{
-			Conns:      make(map[firewall.Packet]*conn),
-			TimerWheel: NewTimerWheel(min, max),
+			Conns:       make(map[firewall.Packet]*conn),
+			TimerWheel:  newConntrackTimerWheel(tcpTimeout, UDPTimeout, defaultTimeout),
+			hostEntries: make(map[iputil.VpnIp]int),
+			evicted:     metrics.GetOrRegisterCounter("firewall.conntrack.evicted", nil),
 		},
 		InRules:        newFirewallTable(),
 		OutRules:       newFirewallTable(),
+		InDenyRules:    newFirewallTable(),
+		OutDenyRules:   newFirewallTable(),
 		TCPTimeout:     tcpTimeout,
 		UDPTimeout:     UDPTimeout,
 		DefaultTimeout: defaultTimeout,
-		localIps:       localIps,
-		l:              l,
+
+		TCPSynSentTimeout: defaultTCPSynSentTimeout,
+		TCPFinWaitTimeout: defaultTCPFinWaitTimeout,
+		TCPCloseTimeout:   defaultTCPCloseTimeout,
+
+		localIps:  localIps,
+		localIps6: localIps6,
+		l:         l,
 
 		metricTCPRTT: metrics.GetOrRegisterHistogram("network.tcp.rtt", nil, metrics.NewExpDecaySample(1028, 0.015)),
 		incomingMetrics: firewallMetrics{
-			droppedLocalIP:  metrics.GetOrRegisterCounter("firewall.incoming.dropped.local_ip", nil),
-			droppedRemoteIP: metrics.GetOrRegisterCounter("firewall.incoming.dropped.remote_ip", nil),
-			droppedNoRule:   metrics.GetOrRegisterCounter("firewall.incoming.dropped.no_rule", nil),
+			droppedLocalIP:   metrics.GetOrRegisterCounter("firewall.incoming.dropped.local_ip", nil),
+			droppedRemoteIP:  metrics.GetOrRegisterCounter("firewall.incoming.dropped.remote_ip", nil),
+			droppedNoRule:    metrics.GetOrRegisterCounter("firewall.incoming.dropped.no_rule", nil),
+			droppedDenyRule:  metrics.GetOrRegisterCounter("firewall.incoming.dropped.deny_rule", nil),
+			droppedConntrack: metrics.GetOrRegisterCounter("firewall.incoming.dropped.conntrack_limit", nil),
 		},
 		outgoingMetrics: firewallMetrics{
-			droppedLocalIP:  metrics.GetOrRegisterCounter("firewall.outgoing.dropped.local_ip", nil),
-			droppedRemoteIP: metrics.GetOrRegisterCounter("firewall.outgoing.dropped.remote_ip", nil),
-			droppedNoRule:   metrics.GetOrRegisterCounter("firewall.outgoing.dropped.no_rule", nil),
+			droppedLocalIP:   metrics.GetOrRegisterCounter("firewall.outgoing.dropped.local_ip", nil),
+			droppedRemoteIP:  metrics.GetOrRegisterCounter("firewall.outgoing.dropped.remote_ip", nil),
+			droppedNoRule:    metrics.GetOrRegisterCounter("firewall.outgoing.dropped.no_rule", nil),
+			droppedDenyRule:  metrics.GetOrRegisterCounter("firewall.outgoing.dropped.deny_rule", nil),
+			droppedConntrack: metrics.GetOrRegisterCounter("firewall.outgoing.dropped.conntrack_limit", nil),
 		},
 	}
 }
@@ -176,9 +455,27 @@ func NewFirewallFromConfig(l *logrus.Logger, nc *cert.NebulaCertificate, c *conf
 		c.GetDuration("firewall.conntrack.udp_timeout", time.Minute*3),
 		c.GetDuration("firewall.conntrack.default_timeout", time.Minute*10),
 		nc,
-		//TODO: max_connections
 	)
 
+	fw.TCPSynSentTimeout = c.GetDuration("firewall.conntrack.tcp_syn_sent_timeout", defaultTCPSynSentTimeout)
+	fw.TCPFinWaitTimeout = c.GetDuration("firewall.conntrack.tcp_fin_wait_timeout", defaultTCPFinWaitTimeout)
+	fw.TCPCloseTimeout = c.GetDuration("firewall.conntrack.tcp_close_timeout", defaultTCPCloseTimeout)
+	if fw.TCPSynSentTimeout < time.Second || fw.TCPFinWaitTimeout < time.Second || fw.TCPCloseTimeout < time.Second {
+		return nil, fmt.Errorf("firewall.conntrack.tcp_syn_sent_timeout, tcp_fin_wait_timeout and tcp_close_timeout must be at least 1s")
+	}
+
+	// The wheel needs to tick often enough for the tcp state timeouts as well
+	fw.Conntrack.TimerWheel = newConntrackTimerWheel(
+		fw.TCPTimeout, fw.UDPTimeout, fw.DefaultTimeout,
+		fw.tcpTimeout(tcpSynSent), fw.tcpTimeout(tcpFinWait), fw.tcpTimeout(tcpClose),
+	)
+
+	fw.MaxConntrackEntries = c.GetInt("firewall.conntrack.max_entries", 0)
+	fw.MaxConntrackEntriesPerHost = c.GetInt("firewall.conntrack.max_entries_per_host", 0)
+	if fw.MaxConntrackEntries < 0 || fw.MaxConntrackEntriesPerHost < 0 {
+		return nil, fmt.Errorf("firewall.conntrack.max_entries and max_entries_per_host can not be negative")
+	}
+
 	err := AddFirewallRulesFromConfig(l, false, c, fw)
 	if err != nil {
 		return nil, err
@@ -189,57 +486,299 @@ func NewFirewallFromConfig(l *logrus.Logger, nc *cert.NebulaCertificate, c *conf
 		return nil, err
 	}
 
+	// Rules managed outside of the main config come after the ones in it
+	fw.rulesPath = c.GetString("firewall.rules_path", "")
+	if fw.rulesPath != "" {
+		fw.rulesPathHash, err = addFirewallRulesFromPath(l, fw.rulesPath, fw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fw.dropLog, err = newFirewallDropLogFromConfig(l, c)
+	if err != nil {
+		return nil, err
+	}
+
 	return fw, nil
 }
 
-// AddRule properly creates the in memory rule structure for a firewall table.
-func (f *Firewall) AddRule(incoming bool, proto uint8, startPort int32, endPort int32, groups []string, host string, ip *net.IPNet, caName string, caSha string) error {
+// AddRule properly creates the in memory rule structure for a firewall table
+func (f *Firewall) AddRule(r FirewallRuleSpec, startPort int32, endPort int32) error {
+	stats, err := f.prepareRule(r, fmt.Sprintf("startPort: %v, endPort: %v", startPort, endPort), m{"startPort": startPort, "endPort": endPort})
+	if err != nil {
+		return err
+	}
+
+	var fp firewallPort
+	ft := f.table(r.Incoming, r.Deny)
+
+	switch r.Proto {
+	case firewall.ProtoTCP:
+		fp = ft.TCP
+	case firewall.ProtoUDP:
+		fp = ft.UDP
+	case firewall.ProtoICMP:
+		fp = ft.ICMP
+	case firewall.ProtoICMPv6:
+		fp = ft.ICMPv6
+	case firewall.ProtoAny:
+		fp = ft.AnyProto
+	default:
+		return fmt.Errorf("unknown protocol %v", r.Proto)
+	}
+
+	if (r.Proto == firewall.ProtoICMP || r.Proto == firewall.ProtoICMPv6) && startPort != firewall.PortAny && startPort != firewall.PortFragment {
+		// A port range on an icmp rule is a range of types with any code
+		err = fp.addICMPRule(startPort, endPort, firewall.ICMPAny, firewall.ICMPAny, r.Groups, r.Host, r.Ip, r.LocalIp, r.Extensions, r.CAName, r.CASha, stats)
+	} else {
+		err = fp.addRule(startPort, endPort, r.Groups, r.Host, r.Ip, r.LocalIp, r.Extensions, r.CAName, r.CASha, stats)
+	}
+	if err != nil {
+		return err
+	}
+
+	f.trackRuleStats(stats)
+	return nil
+}
+
+// AddICMPRule creates the in memory rule structure for an icmp or icmpv6 rule matching ranges of types and codes.
+// firewall.ICMPAny matches every type or code.
+func (f *Firewall) AddICMPRule(r FirewallRuleSpec, startType int32, endType int32, startCode int32, endCode int32) error {
+	ranges := fmt.Sprintf("startType: %v, endType: %v, startCode: %v, endCode: %v", startType, endType, startCode, endCode)
+	stats, err := f.prepareRule(r, ranges, m{"startType": startType, "endType": endType, "startCode": startCode, "endCode": endCode})
+	if err != nil {
+		return err
+	}
+
+	ft := f.table(r.Incoming, r.Deny)
+
+	switch r.Proto {
+	case firewall.ProtoICMP:
+		err = ft.ICMP.addICMPRule(startType, endType, startCode, endCode, r.Groups, r.Host, r.Ip, r.LocalIp, r.Extensions, r.CAName, r.CASha, stats)
+	case firewall.ProtoICMPv6:
+		err = ft.ICMPv6.addICMPRule(startType, endType, startCode, endCode, r.Groups, r.Host, r.Ip, r.LocalIp, r.Extensions, r.CAName, r.CASha, stats)
+	default:
+		err = fmt.Errorf("protocol %v does not have icmp types", r.Proto)
+	}
+	if err != nil {
+		return err
+	}
+
+	f.trackRuleStats(stats)
+	return nil
+}
+
+// prepareRule adds the rule to the rule string the firewall hash is made from, logs it, and creates its hit counter.
+// ranges and rangeFields are the ports, or icmp types and codes, of the rule for the rule string and the log.
+func (f *Firewall) prepareRule(r FirewallRuleSpec, ranges string, rangeFields m) (*firewallRuleStats, error) {
 	// Under gomobile, stringing a nil pointer with fmt causes an abort in debug mode for iOS
 	// https://github.com/golang/go/issues/14131
 	sIp := ""
-	if ip != nil {
-		sIp = ip.String()
+	if r.Ip != nil {
+		sIp = r.Ip.String()
+	}
+
+	sLocalIp := ""
+	if r.LocalIp != nil {
+		sLocalIp = r.LocalIp.String()
 	}
 
 	// We need this rule string because we generate a hash. Removing this will break firewall reload.
 	ruleString := fmt.Sprintf(
-		"incoming: %v, proto: %v, startPort: %v, endPort: %v, groups: %v, host: %v, ip: %v, caName: %v, caSha: %s",
-		incoming, proto, startPort, endPort, groups, host, sIp, caName, caSha,
+		"incoming: %v, proto: %v, %s, groups: %v, host: %v, ip: %v, extensions: %v, caName: %v, caSha: %s",
+		r.Incoming, r.Proto, ranges, r.Groups, r.Host, sIp, r.Extensions, r.CAName, r.CASha,
 	)
+	// Only rules using a local ip, deny rules, and named rules carry them, the hash of an older rule set is unchanged
+	if r.LocalIp != nil {
+		ruleString += ", localIp: " + sLocalIp
+	}
+	if r.Deny {
+		ruleString += ", action: deny"
+	}
+	if r.Name != "" {
+		ruleString += ", name: " + r.Name
+	}
 	f.rules += ruleString + "\n"
 
 	direction := "incoming"
-	if !incoming {
+	if !r.Incoming {
 		direction = "outgoing"
 	}
-	f.l.WithField("firewallRule", m{"direction": direction, "proto": proto, "startPort": startPort, "endPort": endPort, "groups": groups, "host": host, "ip": sIp, "caName": caName, "caSha": caSha}).
-		Info("Firewall rule added")
+	fields := m{"direction": direction, "action": ruleAction(r.Deny), "proto": r.Proto, "groups": r.Groups, "host": r.Host, "ip": sIp, "localIp": sLocalIp, "extensions": r.Extensions, "caName": r.CAName, "caSha": r.CASha, "name": r.Name}
+	for k, v := range rangeFields {
+		fields[k] = v
+	}
+	f.l.WithField("firewallRule", fields).Info("Firewall rule added")
 
-	var (
-		ft *FirewallTable
-		fp firewallPort
-	)
+	return f.newRuleStats(r.Incoming, r.Deny, r.Name, ruleString)
+}
 
-	if incoming {
-		ft = f.InRules
+// newRuleStats validates the name of a new rule and creates its hit counter, an empty name is replaced with the index
+// of the rule within its direction
+func (f *Firewall) newRuleStats(incoming bool, deny bool, name string, ruleString string) (*firewallRuleStats, error) {
+	count := 0
+	for _, s := range f.ruleStats {
+		if s.Incoming == incoming {
+			count++
+		}
+	}
+
+	if name == "" {
+		name = strconv.Itoa(count)
 	} else {
-		ft = f.OutRules
+		if err := validateRuleName(name); err != nil {
+			return nil, err
+		}
+
+		for _, s := range f.ruleStats {
+			if s.Incoming == incoming && s.Name == name {
+				return nil, fmt.Errorf("rule name `%s` is used more than once", name)
+			}
+		}
+	}
+
+	direction := "incoming"
+	if !incoming {
+		direction = "outgoing"
+	}
+
+	return &firewallRuleStats{
+		Name:       name,
+		Incoming:   incoming,
+		Deny:       deny,
+		Rule:       ruleString,
+		metricName: "firewall." + direction + ".rules." + name + ".hits",
+	}, nil
+}
+
+// validateRuleName makes sure a rule name is usable as part of a metric name and can not be confused with the index
+// of an unnamed rule
+func validateRuleName(name string) error {
+	numeric := true
+	for _, c := range name {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '-', c == '_':
+			numeric = false
+		default:
+			return fmt.Errorf("rule name `%s` may only contain letters, numbers, `-`, and `_`", name)
+		}
 	}
 
+	if numeric {
+		return fmt.Errorf("rule name `%s` can not be a number, numbers are used for unnamed rules", name)
+	}
+
+	return nil
+}
+
+// trackRuleStats registers the hit counter of a rule that was added successfully. A reloaded rule keeps its name and
+// continues counting where the previous firewall stopped.
+func (f *Firewall) trackRuleStats(s *firewallRuleStats) {
+	s.hits = metrics.GetOrRegisterCounter(s.metricName, nil)
+	f.ruleStats = append(f.ruleStats, s)
+}
+
+// unregisterRuleStats removes the hit counters of rules that do not exist in next, so removed rules stop being
+// reported
+func (f *Firewall) unregisterRuleStats(next *Firewall) {
+	keep := make(map[string]struct{}, len(next.ruleStats))
+	for _, s := range next.ruleStats {
+		keep[s.metricName] = struct{}{}
+	}
+
+	for _, s := range f.ruleStats {
+		if _, ok := keep[s.metricName]; !ok {
+			metrics.Unregister(s.metricName)
+		}
+	}
+}
+
+// table returns the rule table for the direction and action of a rule
+func (f *Firewall) table(incoming bool, deny bool) *FirewallTable {
+	switch {
+	case incoming && deny:
+		return f.InDenyRules
+	case incoming:
+		return f.InRules
+	case deny:
+		return f.OutDenyRules
+	default:
+		return f.OutRules
+	}
+}
+
+func ruleAction(deny bool) string {
+	if deny {
+		return "deny"
+	}
+	return "allow"
+}
+
+// protoName returns the name rules use for proto, or its number if rules have no name for it
+func protoName(proto uint8) string {
 	switch proto {
 	case firewall.ProtoTCP:
-		fp = ft.TCP
+		return "tcp"
 	case firewall.ProtoUDP:
-		fp = ft.UDP
+		return "udp"
 	case firewall.ProtoICMP:
-		fp = ft.ICMP
-	case firewall.ProtoAny:
-		fp = ft.AnyProto
+		return "icmp"
+	case firewall.ProtoICMPv6:
+		return "icmpv6"
 	default:
-		return fmt.Errorf("unknown protocol %v", proto)
+		return strconv.Itoa(int(proto))
 	}
+}
 
-	return fp.addRule(startPort, endPort, groups, host, ip, caName, caSha)
+func tcpStateName(state uint8) string {
+	switch state {
+	case tcpEstablished:
+		return "established"
+	case tcpSynSent:
+		return "syn_sent"
+	case tcpFinWait:
+		return "fin_wait"
+	case tcpClose:
+		return "close"
+	default:
+		return strconv.Itoa(int(state))
+	}
+}
+
+// newConntrackTimerWheel creates a timer wheel that ticks as often as the shortest timeout and spans the longest
+func newConntrackTimerWheel(timeouts ...time.Duration) *TimerWheel {
+	min, max := timeouts[0], timeouts[0]
+	for _, t := range timeouts[1:] {
+		if t < min {
+			min = t
+		} else if t > max {
+			max = t
+		}
+	}
+
+	return NewTimerWheel(min, max)
+}
+
+// tcpTimeout returns how long a tcp connection in the provided state is tracked without seeing a packet
+func (f *Firewall) tcpTimeout(state uint8) time.Duration {
+	var t time.Duration
+	switch state {
+	case tcpSynSent:
+		t = f.TCPSynSentTimeout
+	case tcpFinWait:
+		t = f.TCPFinWaitTimeout
+	case tcpClose:
+		t = f.TCPCloseTimeout
+	default:
+		return f.TCPTimeout
+	}
+
+	if t > f.TCPTimeout {
+		return f.TCPTimeout
+	}
+	return t
 }
 
 // GetRuleHash returns a hash representation of all inbound and outbound rules
@@ -256,7 +795,11 @@ func AddFirewallRulesFromConfig(l *logrus.Logger, inbound bool, c *config.C, fw
 		table = "firewall.outbound"
 	}
 
-	r := c.Get(table)
+	return addFirewallRules(l, inbound, table, c.Get(table), fw)
+}
+
+// addFirewallRules adds the rules of a single direction, table names where they came from in errors
+func addFirewallRules(l *logrus.Logger, inbound bool, table string, r interface{}, fw FirewallInterface) error {
 	if r == nil {
 		return nil
 	}
@@ -277,8 +820,21 @@ func AddFirewallRulesFromConfig(l *logrus.Logger, inbound bool, c *config.C, fw
 			return fmt.Errorf("%s rule #%v; only one of port or code should be provided", table, i)
 		}
 
-		if r.Host == "" && len(r.Groups) == 0 && r.Group == "" && r.Cidr == "" && r.CAName == "" && r.CASha == "" {
-			return fmt.Errorf("%s rule #%v; at least one of host, group, cidr, ca_name, or ca_sha must be provided", table, i)
+		if r.Type != "" && r.Port != "" {
+			return fmt.Errorf("%s rule #%v; only one of port or type should be provided", table, i)
+		}
+
+		if r.Host == "" && len(r.Groups) == 0 && r.Group == "" && r.Cidr == "" && r.LocalCidr == "" && len(r.Extensions) == 0 && r.CAName == "" && r.CASha == "" {
+			return fmt.Errorf("%s rule #%v; at least one of host, group, cidr, local_cidr, extensions, ca_name, or ca_sha must be provided", table, i)
+		}
+
+		var deny bool
+		switch r.Action {
+		case "", "allow":
+		case "deny":
+			deny = true
+		default:
+			return fmt.Errorf("%s rule #%v; action was not understood; `%s`", table, i, r.Action)
 		}
 
 		if len(r.Groups) > 0 {
@@ -294,21 +850,24 @@ func AddFirewallRulesFromConfig(l *logrus.Logger, inbound bool, c *config.C, fw
 			groups = []string{r.Group}
 		}
 
-		var sPort, errPort string
-		if r.Code != "" {
-			errPort = "code"
-			sPort = r.Code
-		} else {
-			errPort = "port"
-			sPort = r.Port
-		}
+		// Rules with an icmp type or code match those instead of a port
+		icmpRule := r.Type != "" || r.Code != ""
 
-		startPort, endPort, err := parsePort(sPort)
-		if err != nil {
-			return fmt.Errorf("%s rule #%v; %s %s", table, i, errPort, err)
+		var startPort, endPort, startCode, endCode int32
+		if icmpRule {
+			startCode, endCode, err = parseICMP(r.Code, nil)
+			if err != nil {
+				return fmt.Errorf("%s rule #%v; code %s", table, i, err)
+			}
+		} else {
+			startPort, endPort, err = parsePort(r.Port)
+			if err != nil {
+				return fmt.Errorf("%s rule #%v; port %s", table, i, err)
+			}
 		}
 
 		var proto uint8
+		var icmpTypes map[string]int32
 		switch r.Proto {
 		case "any":
 			proto = firewall.ProtoAny
@@ -318,10 +877,22 @@ func AddFirewallRulesFromConfig(l *logrus.Logger, inbound bool, c *config.C, fw
 			proto = firewall.ProtoUDP
 		case "icmp":
 			proto = firewall.ProtoICMP
+			icmpTypes = icmpTypeNames
+		case "icmpv6":
+			proto = firewall.ProtoICMPv6
+			icmpTypes = icmpv6TypeNames
 		default:
 			return fmt.Errorf("%s rule #%v; proto was not understood; `%s`", table, i, r.Proto)
 		}
 
+		var startType, endType int32
+		if icmpRule {
+			startType, endType, err = parseICMP(r.Type, icmpTypes)
+			if err != nil {
+				return fmt.Errorf("%s rule #%v; type %s", table, i, err)
+			}
+		}
+
 		var cidr *net.IPNet
 		if r.Cidr != "" {
 			_, cidr, err = net.ParseCIDR(r.Cidr)
@@ -330,7 +901,36 @@ func AddFirewallRulesFromConfig(l *logrus.Logger, inbound bool, c *config.C, fw
 			}
 		}
 
-		err = fw.AddRule(inbound, proto, startPort, endPort, groups, r.Host, cidr, r.CAName, r.CASha)
+		var localCidr *net.IPNet
+		if r.LocalCidr != "" {
+			_, localCidr, err = net.ParseCIDR(r.LocalCidr)
+			if err != nil {
+				return fmt.Errorf("%s rule #%v; local_cidr did not parse; %s", table, i, err)
+			}
+		}
+
+		spec := FirewallRuleSpec{
+			Incoming:   inbound,
+			Deny:       deny,
+			Proto:      proto,
+			Groups:     groups,
+			Host:       r.Host,
+			Ip:         cidr,
+			LocalIp:    localCidr,
+			Extensions: r.Extensions,
+			CAName:     r.CAName,
+			CASha:      r.CASha,
+			Name:       r.Name,
+		}
+
+		if icmpRule {
+			if icmpTypes == nil {
+				return fmt.Errorf("%s rule #%v; type and code can only be used with proto icmp or icmpv6", table, i)
+			}
+			err = fw.AddICMPRule(spec, startType, endType, startCode, endCode)
+		} else {
+			err = fw.AddRule(spec, startPort, endPort)
+		}
 		if err != nil {
 			return fmt.Errorf("%s rule #%v; `%s`", table, i, err)
 		}
@@ -342,6 +942,8 @@ func AddFirewallRulesFromConfig(l *logrus.Logger, inbound bool, c *config.C, fw
 var ErrInvalidRemoteIP = errors.New("remote IP is not in remote certificate subnets")
 var ErrInvalidLocalIP = errors.New("local IP is not in list of handled local IPs")
 var ErrNoMatchingRule = errors.New("no matching rule in firewall table")
+var ErrDenyRule = errors.New("matched a deny rule in firewall table")
+var ErrConntrackLimit = errors.New("host has reached its conntrack entry limit")
 
 // Drop returns an error if the packet should be dropped, explaining why. It
 // returns nil if the packet should not be dropped.
@@ -351,41 +953,69 @@ func (f *Firewall) Drop(packet []byte, fp firewall.Packet, incoming bool, h *Hos
 		return nil
 	}
 
+	rs, err := f.checkRules(fp, incoming, h, caPool)
+	if rs != nil {
+		rs.hit()
+	}
+
+	if err != nil {
+		f.metrics(incoming).dropped(err).Inc(1)
+		f.logDrop(fp, incoming, h, err)
+		return err
+	}
+
+	// We always want to conntrack since it is a faster operation
+	if !f.addConn(packet, fp, incoming, h.vpnIp) {
+		f.metrics(incoming).droppedConntrack.Inc(1)
+		f.logDrop(fp, incoming, h, ErrConntrackLimit)
+		return ErrConntrackLimit
+	}
+
+	return nil
+}
+
+// isLocalIP returns true if the address is one of ours, either directly or via an unsafe route subnet
+func (f *Firewall) isLocalIP(ip netip.Addr) bool {
+	if ip.Is4() {
+		return f.localIps.Contains(iputil.AddrToVpnIp(ip)) != nil
+	}
+
+	hi, lo := iputil.AddrToHiLo(ip)
+	return f.localIps6.MostSpecificContainsIpV6(hi, lo) != nil
+}
+
+// logDrop logs a dropped packet if firewall.log_drops is enabled
+func (f *Firewall) logDrop(fp firewall.Packet, incoming bool, h *HostInfo, reason error) {
+	if f.dropLog != nil {
+		f.dropLog.log(fp, incoming, h, reason)
+	}
+}
+
+// checkRules decides if a packet that is not part of a tracked connection is allowed. The rule that allowed or denied
+// the packet is returned if there was one.
+func (f *Firewall) checkRules(fp firewall.Packet, incoming bool, h *HostInfo, caPool *cert.NebulaCAPool) (*firewallRuleStats, error) {
 	// Make sure remote address matches nebula certificate
-	if remoteCidr := h.remoteCidr; remoteCidr != nil {
-		if remoteCidr.Contains(fp.RemoteIP) == nil {
-			f.metrics(incoming).droppedRemoteIP.Inc(1)
-			return ErrInvalidRemoteIP
-		}
-	} else {
-		// Simple case: Certificate has one IP and no subnets
-		if fp.RemoteIP != h.vpnIp {
-			f.metrics(incoming).droppedRemoteIP.Inc(1)
-			return ErrInvalidRemoteIP
-		}
+	if !h.allowsRemoteIP(fp.RemoteIP) {
+		return nil, ErrInvalidRemoteIP
 	}
 
 	// Make sure we are supposed to be handling this local ip address
-	if f.localIps.Contains(fp.LocalIP) == nil {
-		f.metrics(incoming).droppedLocalIP.Inc(1)
-		return ErrInvalidLocalIP
+	if !f.isLocalIP(fp.LocalIP) {
+		return nil, ErrInvalidLocalIP
 	}
 
-	table := f.OutRules
-	if incoming {
-		table = f.InRules
+	// Deny rules are checked first, they win over any allow rule
+	if rs := f.table(incoming, true).match(fp, incoming, h.ConnectionState.peerCert, caPool); rs != nil {
+		return rs, ErrDenyRule
 	}
 
 	// We now know which firewall table to check against
-	if !table.match(fp, incoming, h.ConnectionState.peerCert, caPool) {
-		f.metrics(incoming).droppedNoRule.Inc(1)
-		return ErrNoMatchingRule
+	rs := f.table(incoming, false).match(fp, incoming, h.ConnectionState.peerCert, caPool)
+	if rs == nil {
+		return nil, ErrNoMatchingRule
 	}
 
-	// We always want to conntrack since it is a faster operation
-	f.addConn(packet, fp, incoming)
-
-	return nil
+	return rs, nil
 }
 
 func (f *Firewall) metrics(incoming bool) firewallMetrics {
@@ -400,6 +1030,11 @@ func (f *Firewall) metrics(incoming bool) firewallMetrics {
 // firewall object is created
 func (f *Firewall) Destroy() {
 	//TODO: clean references if/when needed
+	if f.dropLog != nil {
+		if err := f.dropLog.Close(); err != nil {
+			f.l.WithError(err).Error("Failed to close the firewall drop log")
+		}
+	}
 }
 
 func (f *Firewall) EmitStats() {
@@ -412,7 +1047,12 @@ func (f *Firewall) EmitStats() {
 }
 
 func (f *Firewall) inConns(packet []byte, fp firewall.Packet, incoming bool, h *HostInfo, caPool *cert.NebulaCAPool, localCache firewall.ConntrackCache) bool {
-	if localCache != nil {
+	// icmp replies are tracked under the key of their request
+	tcpFlags := fp.TCPFlags
+	fp = fp.ConntrackKey()
+
+	// Packets that change the state of a tcp connection always go to the conntrack table
+	if localCache != nil && tcpFlags&(tcpSYN|tcpFIN|tcpRST) == 0 {
 		if _, ok := localCache[fp]; ok {
 			return true
 		}
@@ -436,13 +1076,9 @@ func (f *Firewall) inConns(packet []byte, fp firewall.Packet, incoming bool, h *
 	if c.rulesVersion != f.rulesVersion {
 		// This conntrack entry was for an older rule set, validate
 		// it still passes with the current rule set
-		table := f.OutRules
-		if c.incoming {
-			table = f.InRules
-		}
-
-		// We now know which firewall table to check against
-		if !table.match(fp, c.incoming, h.ConnectionState.peerCert, caPool) {
+		peerCert := h.ConnectionState.peerCert
+		denied := f.table(c.incoming, true).match(fp, c.incoming, peerCert, caPool) != nil
+		if denied || f.table(c.incoming, false).match(fp, c.incoming, peerCert, caPool) == nil {
 			if f.l.Level >= logrus.DebugLevel {
 				h.logger(f.l).
 					WithField("fwPacket", fp).
@@ -451,7 +1087,7 @@ func (f *Firewall) inConns(packet []byte, fp firewall.Packet, incoming bool, h *
 					WithField("oldRulesVersion", c.rulesVersion).
 					Debugln("dropping old conntrack entry, does not match new ruleset")
 			}
-			delete(conntrack.Conns, fp)
+			conntrack.delete(fp)
 			conntrack.Unlock()
 			return false
 		}
@@ -470,7 +1106,15 @@ func (f *Firewall) inConns(packet []byte, fp firewall.Packet, incoming bool, h *
 
 	switch fp.Protocol {
 	case firewall.ProtoTCP:
-		c.Expires = time.Now().Add(f.TCPTimeout)
+		oldTimeout := f.tcpTimeout(c.tcpState)
+		c.updateTCP(tcpFlags, incoming)
+		timeout := f.tcpTimeout(c.tcpState)
+		if timeout < oldTimeout {
+			// The timer already running for this connection would go off too late for its new state
+			conntrack.TimerWheel.Add(fp, timeout)
+		}
+
+		c.Expires = time.Now().Add(timeout)
 		if incoming {
 			f.checkTCPRTT(c, packet)
 		} else {
@@ -491,13 +1135,17 @@ func (f *Firewall) inConns(packet []byte, fp firewall.Packet, incoming bool, h *
 	return true
 }
 
-func (f *Firewall) addConn(packet []byte, fp firewall.Packet, incoming bool) {
+// addConn tracks a new connection, it returns false if the host is at its conntrack limit
+func (f *Firewall) addConn(packet []byte, fp firewall.Packet, incoming bool, vpnIp iputil.VpnIp) bool {
+	tcpFlags := fp.TCPFlags
+	fp = fp.ConntrackKey()
 	var timeout time.Duration
-	c := &conn{}
+	c := &conn{incoming: incoming}
 
 	switch fp.Protocol {
 	case firewall.ProtoTCP:
-		timeout = f.TCPTimeout
+		c.startTCP(tcpFlags)
+		timeout = f.tcpTimeout(c.tcpState)
 		if !incoming {
 			setTCPRTTTracking(c, packet)
 		}
@@ -510,16 +1158,77 @@ func (f *Firewall) addConn(packet []byte, fp firewall.Packet, incoming bool) {
 	conntrack := f.Conntrack
 	conntrack.Lock()
 	if _, ok := conntrack.Conns[fp]; !ok {
+		if f.MaxConntrackEntriesPerHost > 0 && conntrack.hostEntries[vpnIp] >= f.MaxConntrackEntriesPerHost {
+			conntrack.Unlock()
+			return false
+		}
+
+		for f.MaxConntrackEntries > 0 && len(conntrack.Conns) >= f.MaxConntrackEntries {
+			if !f.evictOldest() {
+				break
+			}
+		}
+
 		conntrack.TimerWheel.Add(fp, timeout)
 	}
 
 	// Record which rulesVersion allowed this connection, so we can retest after
 	// firewall reload
-	c.incoming = incoming
 	c.rulesVersion = f.rulesVersion
+	c.vpnIp = vpnIp
 	c.Expires = time.Now().Add(timeout)
-	conntrack.Conns[fp] = c
+	conntrack.add(fp, c)
 	conntrack.Unlock()
+	return true
+}
+
+// How many conntrack entries are compared to find the one to evict when the table is full
+const conntrackEvictSamples = 5
+
+// evictOldest makes room in a full conntrack table. The entries closest to timing out are taken from the timer wheel
+// and the one that will expire first is evicted, the rest go back on the wheel. Entries whose timer went off long
+// before their traffic stopped are not necessarily the oldest, sampling a few keeps an active connection from being
+// evicted in favor of an idle one. Returns false if there was nothing to evict.
+// Caller must own the connMutex lock!
+func (f *Firewall) evictOldest() bool {
+	conntrack := f.Conntrack
+	now := time.Now()
+
+	var samples [conntrackEvictSamples]firewall.Packet
+	n := 0
+	oldest := -1
+	for n < conntrackEvictSamples {
+		p, ok := conntrack.TimerWheel.PopNext()
+		if !ok {
+			break
+		}
+
+		c, ok := conntrack.Conns[p]
+		if !ok {
+			// The entry was already removed, only its timer was left
+			continue
+		}
+
+		samples[n] = p
+		if oldest == -1 || c.Expires.Before(conntrack.Conns[samples[oldest]].Expires) {
+			oldest = n
+		}
+		n++
+	}
+
+	if oldest == -1 {
+		return false
+	}
+
+	for i := 0; i < n; i++ {
+		if i != oldest {
+			conntrack.TimerWheel.Add(samples[i], conntrack.Conns[samples[i]].Expires.Sub(now))
+		}
+	}
+
+	conntrack.delete(samples[oldest])
+	conntrack.evicted.Inc(1)
+	return true
 }
 
 // Evict checks if a conntrack entry has expired, if so it is removed, if not it is re-added to the wheel
@@ -542,57 +1251,99 @@ func (f *Firewall) evict(p firewall.Packet) {
 	}
 
 	// This conn is done
-	delete(conntrack.Conns, p)
+	conntrack.delete(p)
 }
 
-func (ft *FirewallTable) match(p firewall.Packet, incoming bool, c *cert.NebulaCertificate, caPool *cert.NebulaCAPool) bool {
-	if ft.AnyProto.match(p, incoming, c, caPool) {
-		return true
+// match returns the stats of the rule that matched the packet, nil if no rule did
+func (ft *FirewallTable) match(p firewall.Packet, incoming bool, c *cert.NebulaCertificate, caPool *cert.NebulaCAPool) *firewallRuleStats {
+	if rs := ft.AnyProto.match(p, incoming, c, caPool); rs != nil {
+		return rs
 	}
 
 	switch p.Protocol {
 	case firewall.ProtoTCP:
-		if ft.TCP.match(p, incoming, c, caPool) {
-			return true
-		}
+		return ft.TCP.match(p, incoming, c, caPool)
 	case firewall.ProtoUDP:
-		if ft.UDP.match(p, incoming, c, caPool) {
-			return true
-		}
+		return ft.UDP.match(p, incoming, c, caPool)
 	case firewall.ProtoICMP:
-		if ft.ICMP.match(p, incoming, c, caPool) {
-			return true
+		return ft.ICMP.matchICMP(p, c, caPool)
+	case firewall.ProtoICMPv6:
+		if rs := ft.ICMPv6.matchICMP(p, c, caPool); rs != nil {
+			return rs
 		}
+
+		// icmp rules that do not name a type or code cover icmpv6 as well
+		return ft.ICMP.match(p, incoming, c, caPool)
 	}
 
-	return false
+	return nil
 }
 
-func (fp firewallPort) addRule(startPort int32, endPort int32, groups []string, host string, ip *net.IPNet, caName string, caSha string) error {
+func (fp firewallPort) addRule(startPort int32, endPort int32, groups []string, host string, ip *net.IPNet, localIp *net.IPNet, extensions map[string]string, caName string, caSha string, stats *firewallRuleStats) error {
 	if startPort > endPort {
 		return fmt.Errorf("start port was lower than end port")
 	}
 
 	for i := startPort; i <= endPort; i++ {
-		if _, ok := fp[i]; !ok {
-			fp[i] = &FirewallCA{
-				CANames: make(map[string]*FirewallRule),
-				CAShas:  make(map[string]*FirewallRule),
-			}
+		if err := fp.addKeyRule(i, groups, host, ip, localIp, extensions, caName, caSha, stats); err != nil {
+			return err
 		}
+	}
 
-		if err := fp[i].addRule(groups, host, ip, caName, caSha); err != nil {
-			return err
+	return nil
+}
+
+// addICMPRule adds a rule for every type and code in the provided ranges, firewall.ICMPAny matches every type or code
+func (fp firewallPort) addICMPRule(startType int32, endType int32, startCode int32, endCode int32, groups []string, host string, ip *net.IPNet, localIp *net.IPNet, extensions map[string]string, caName string, caSha string, stats *firewallRuleStats) error {
+	if startType < firewall.ICMPAny || endType > 255 || startCode < firewall.ICMPAny || endCode > 255 {
+		return fmt.Errorf("icmp types and codes must be between 0 and 255")
+	}
+
+	if startType > endType {
+		return fmt.Errorf("start type was higher than end type")
+	}
+
+	if startCode > endCode {
+		return fmt.Errorf("start code was higher than end code")
+	}
+
+	for t := startType; t <= endType; t++ {
+		for c := startCode; c <= endCode; c++ {
+			if err := fp.addKeyRule(icmpKey(t, c), groups, host, ip, localIp, extensions, caName, caSha, stats); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-func (fp firewallPort) match(p firewall.Packet, incoming bool, c *cert.NebulaCertificate, caPool *cert.NebulaCAPool) bool {
+func (fp firewallPort) addKeyRule(k int32, groups []string, host string, ip *net.IPNet, localIp *net.IPNet, extensions map[string]string, caName string, caSha string, stats *firewallRuleStats) error {
+	if stats == nil {
+		// A match is reported with the stats of the rule, it needs something to report even if nothing is counted
+		stats = &firewallRuleStats{}
+	}
+
+	if _, ok := fp[k]; !ok {
+		fp[k] = &FirewallCA{
+			CANames: make(map[string]*FirewallRule),
+			CAShas:  make(map[string]*FirewallRule),
+		}
+	}
+
+	return fp[k].addRule(groups, host, ip, localIp, extensions, caName, caSha, stats)
+}
+
+// icmpKey packs an icmp type and code into a firewallPort key. Both are offset by one so that firewall.ICMPAny for
+// both lands on firewall.PortAny and rules without a type or code keep working as `port: any` rules.
+func icmpKey(icmpType int32, icmpCode int32) int32 {
+	return (icmpType+1)<<9 | (icmpCode + 1)
+}
+
+func (fp firewallPort) match(p firewall.Packet, incoming bool, c *cert.NebulaCertificate, caPool *cert.NebulaCAPool) *firewallRuleStats {
 	// We don't have any allowed ports, bail
 	if fp == nil {
-		return false
+		return nil
 	}
 
 	var port int32
@@ -605,19 +1356,45 @@ func (fp firewallPort) match(p firewall.Packet, incoming bool, c *cert.NebulaCer
 		port = int32(p.RemotePort)
 	}
 
-	if fp[port].match(p, c, caPool) {
-		return true
+	if rs := fp[port].match(p, c, caPool); rs != nil {
+		return rs
 	}
 
 	return fp[firewall.PortAny].match(p, c, caPool)
 }
 
-func (fc *FirewallCA) addRule(groups []string, host string, ip *net.IPNet, caName, caSha string) error {
+// matchICMP looks for a rule covering the type and code of an icmp packet
+func (fp firewallPort) matchICMP(p firewall.Packet, c *cert.NebulaCertificate, caPool *cert.NebulaCAPool) *firewallRuleStats {
+	if fp == nil {
+		return nil
+	}
+
+	if p.Fragment {
+		if rs := fp[firewall.PortFragment].match(p, c, caPool); rs != nil {
+			return rs
+		}
+		return fp[firewall.PortAny].match(p, c, caPool)
+	}
+
+	t := int32(p.ICMPType)
+	code := int32(p.ICMPCode)
+	for _, k := range [...]int32{icmpKey(t, code), icmpKey(t, firewall.ICMPAny), icmpKey(firewall.ICMPAny, code), firewall.PortAny} {
+		if rs := fp[k].match(p, c, caPool); rs != nil {
+			return rs
+		}
+	}
+
+	return nil
+}
+
+func (fc *FirewallCA) addRule(groups []string, host string, ip *net.IPNet, localIp *net.IPNet, extensions map[string]string, caName, caSha string, stats *firewallRuleStats) error {
 	fr := func() *FirewallRule {
 		return &FirewallRule{
-			Hosts:  make(map[string]struct{}),
-			Groups: make([][]string, 0),
-			CIDR:   cidr.NewTree4(),
+			Hosts:     make(map[string]firewallLocalRules),
+			Groups:    make([][]string, 0),
+			CIDR:      cidr.NewTree4(),
+			CIDR6:     cidr.NewTree6(),
+			cidrRules: make(map[string]*firewallLocalRules),
 		}
 	}
 
@@ -626,14 +1403,14 @@ func (fc *FirewallCA) addRule(groups []string, host string, ip *net.IPNet, caNam
 			fc.Any = fr()
 		}
 
-		return fc.Any.addRule(groups, host, ip)
+		return fc.Any.addRule(groups, host, ip, localIp, extensions, stats)
 	}
 
 	if caSha != "" {
 		if _, ok := fc.CAShas[caSha]; !ok {
 			fc.CAShas[caSha] = fr()
 		}
-		err := fc.CAShas[caSha].addRule(groups, host, ip)
+		err := fc.CAShas[caSha].addRule(groups, host, ip, localIp, extensions, stats)
 		if err != nil {
 			return err
 		}
@@ -643,7 +1420,7 @@ func (fc *FirewallCA) addRule(groups []string, host string, ip *net.IPNet, caNam
 		if _, ok := fc.CANames[caName]; !ok {
 			fc.CANames[caName] = fr()
 		}
-		err := fc.CANames[caName].addRule(groups, host, ip)
+		err := fc.CANames[caName].addRule(groups, host, ip, localIp, extensions, stats)
 		if err != nil {
 			return err
 		}
@@ -652,59 +1429,101 @@ func (fc *FirewallCA) addRule(groups []string, host string, ip *net.IPNet, caNam
 	return nil
 }
 
-func (fc *FirewallCA) match(p firewall.Packet, c *cert.NebulaCertificate, caPool *cert.NebulaCAPool) bool {
+func (fc *FirewallCA) match(p firewall.Packet, c *cert.NebulaCertificate, caPool *cert.NebulaCAPool) *firewallRuleStats {
 	if fc == nil {
-		return false
+		return nil
 	}
 
-	if fc.Any.match(p, c) {
-		return true
+	if rs := fc.Any.match(p, c); rs != nil {
+		return rs
 	}
 
 	if t, ok := fc.CAShas[c.Details.Issuer]; ok {
-		if t.match(p, c) {
-			return true
+		if rs := t.match(p, c); rs != nil {
+			return rs
 		}
 	}
 
-	s, err := caPool.GetCAForCert(c)
+	// Every CA between the certificate and its root can be matched
+	signers, err := caPool.GetCAChainForCert(c)
 	if err != nil {
-		return false
+		return nil
+	}
+
+	for _, s := range signers {
+		if rs := fc.CANames[s.Details.Name].match(p, c); rs != nil {
+			return rs
+		}
+
+		if t, ok := fc.CAShas[s.Details.Issuer]; ok && s.Details.Issuer != "" {
+			if rs := t.match(p, c); rs != nil {
+				return rs
+			}
+		}
 	}
 
-	return fc.CANames[s.Details.Name].match(p, c)
+	return nil
 }
 
-func (fr *FirewallRule) addRule(groups []string, host string, ip *net.IPNet) error {
+func (fr *FirewallRule) addRule(groups []string, host string, ip *net.IPNet, localIp *net.IPNet, extensions map[string]string, stats *firewallRuleStats) error {
 	if fr.Any {
 		return nil
 	}
 
-	if fr.isAny(groups, host, ip) {
+	lr := newFirewallLocalRule(localIp, stats)
+
+	if fr.isAny(groups, host, ip, extensions) {
+		fr.anyRules = fr.anyRules.add(lr)
+		if lr.local.IsValid() {
+			// Only packets to the local cidr are matched, the other rules are still needed
+			return nil
+		}
+
 		fr.Any = true
 		// If it's any we need to wipe out any pre-existing rules to save on memory
 		fr.Groups = make([][]string, 0)
-		fr.Hosts = make(map[string]struct{})
+		fr.Hosts = make(map[string]firewallLocalRules)
 		fr.CIDR = cidr.NewTree4()
+		fr.CIDR6 = cidr.NewTree6()
+		fr.Extensions = nil
+		fr.groupRules = nil
+		fr.extensionRules = nil
+		fr.cidrRules = make(map[string]*firewallLocalRules)
 	} else {
 		if len(groups) > 0 {
 			fr.Groups = append(fr.Groups, groups)
+			fr.groupRules = append(fr.groupRules, lr)
+		}
+
+		if len(extensions) > 0 {
+			fr.Extensions = append(fr.Extensions, extensions)
+			fr.extensionRules = append(fr.extensionRules, lr)
 		}
 
 		if host != "" {
-			fr.Hosts[host] = struct{}{}
+			fr.Hosts[host] = fr.Hosts[host].add(lr)
 		}
 
 		if ip != nil {
-			fr.CIDR.AddCIDR(ip, struct{}{})
+			lrs, ok := fr.cidrRules[ip.String()]
+			if !ok {
+				lrs = &firewallLocalRules{}
+				fr.cidrRules[ip.String()] = lrs
+				if ip.IP.To4() != nil {
+					fr.CIDR.AddCIDR(ip, lrs)
+				} else {
+					fr.CIDR6.AddCIDR(ip, lrs)
+				}
+			}
+			*lrs = lrs.add(lr)
 		}
 	}
 
 	return nil
 }
 
-func (fr *FirewallRule) isAny(groups []string, host string, ip *net.IPNet) bool {
-	if len(groups) == 0 && host == "" && ip == nil {
+func (fr *FirewallRule) isAny(groups []string, host string, ip *net.IPNet, extensions map[string]string) bool {
+	if len(groups) == 0 && host == "" && ip == nil && len(extensions) == 0 {
 		return true
 	}
 
@@ -725,18 +1544,18 @@ func (fr *FirewallRule) isAny(groups []string, host string, ip *net.IPNet) bool
 	return false
 }
 
-func (fr *FirewallRule) match(p firewall.Packet, c *cert.NebulaCertificate) bool {
+func (fr *FirewallRule) match(p firewall.Packet, c *cert.NebulaCertificate) *firewallRuleStats {
 	if fr == nil {
-		return false
+		return nil
 	}
 
 	// Shortcut path for if groups, hosts, or cidr contained an `any`
-	if fr.Any {
-		return true
+	if rs := fr.anyRules.match(p.LocalIP); rs != nil || fr.Any {
+		return rs
 	}
 
-	// Need any of group, host, or cidr to match
-	for _, sg := range fr.Groups {
+	// Need any of group, host, extensions, or cidr to match, along with the local cidr of the same rule
+	for i, sg := range fr.Groups {
 		found := false
 
 		for _, g := range sg {
@@ -749,34 +1568,69 @@ func (fr *FirewallRule) match(p firewall.Packet, c *cert.NebulaCertificate) bool
 		}
 
 		if found {
-			return true
+			if rs := fr.groupRules[i].match(p.LocalIP); rs != nil {
+				return rs
+			}
 		}
 	}
 
 	if fr.Hosts != nil {
-		if _, ok := fr.Hosts[c.Details.Name]; ok {
-			return true
+		if rs := fr.Hosts[c.Details.Name].match(p.LocalIP); rs != nil {
+			return rs
 		}
 	}
 
-	if fr.CIDR != nil && fr.CIDR.Contains(p.RemoteIP) != nil {
-		return true
+	for i, se := range fr.Extensions {
+		found := true
+		for k, v := range se {
+			if cv, ok := c.Details.Extensions[k]; !ok || cv != v {
+				found = false
+				break
+			}
+		}
+
+		if found {
+			if rs := fr.extensionRules[i].match(p.LocalIP); rs != nil {
+				return rs
+			}
+		}
 	}
 
-	// No host, group, or cidr matched, bye bye
-	return false
+	// Overlapping cidrs may be limited to different local cidrs, every cidr containing the remote ip is checked
+	var rs *firewallRuleStats
+	matchLocal := func(v interface{}) bool {
+		rs = v.(*firewallLocalRules).match(p.LocalIP)
+		return rs != nil
+	}
+
+	if p.RemoteIP.Is4() {
+		if fr.CIDR != nil {
+			fr.CIDR.EachContains(iputil.AddrToVpnIp(p.RemoteIP), matchLocal)
+		}
+	} else if fr.CIDR6 != nil {
+		hi, lo := iputil.AddrToHiLo(p.RemoteIP)
+		fr.CIDR6.EachContainsIpV6(hi, lo, matchLocal)
+	}
+
+	// No host, group, extensions, or cidr matched if rs is nil, bye bye
+	return rs
 }
 
 type rule struct {
-	Port   string
-	Code   string
-	Proto  string
-	Host   string
-	Group  string
-	Groups []string
-	Cidr   string
-	CAName string
-	CASha  string
+	Name       string
+	Action     string
+	Port       string
+	Type       string
+	Code       string
+	Proto      string
+	Host       string
+	Group      string
+	Groups     []string
+	Cidr       string
+	LocalCidr  string
+	Extensions map[string]string
+	CAName     string
+	CASha      string
 }
 
 func convertRule(l *logrus.Logger, p interface{}, table string, i int) (rule, error) {
@@ -795,11 +1649,15 @@ func convertRule(l *logrus.Logger, p interface{}, table string, i int) (rule, er
 		return fmt.Sprintf("%v", v)
 	}
 
+	r.Name = toString("name", m)
+	r.Action = toString("action", m)
 	r.Port = toString("port", m)
+	r.Type = toString("type", m)
 	r.Code = toString("code", m)
 	r.Proto = toString("proto", m)
 	r.Host = toString("host", m)
 	r.Cidr = toString("cidr", m)
+	r.LocalCidr = toString("local_cidr", m)
 	r.CAName = toString("ca_name", m)
 	r.CASha = toString("ca_sha", m)
 
@@ -829,6 +1687,18 @@ func convertRule(l *logrus.Logger, p interface{}, table string, i int) (rule, er
 		}
 	}
 
+	if re, ok := m["extensions"]; ok {
+		rm, ok := re.(map[interface{}]interface{})
+		if !ok {
+			return r, errors.New("extensions should be a map of key and value pairs")
+		}
+
+		r.Extensions = make(map[string]string, len(rm))
+		for k, v := range rm {
+			r.Extensions[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", v)
+		}
+	}
+
 	return r, nil
 }
 
@@ -879,8 +1749,82 @@ func parsePort(s string) (startPort, endPort int32, err error) {
 	return
 }
 
+// Names that can be used in place of a number for the type of an icmp or icmpv6 rule
+var icmpTypeNames = map[string]int32{
+	"echo-reply":              firewall.ICMPEchoReply,
+	"destination-unreachable": 3,
+	"redirect":                5,
+	"echo-request":            firewall.ICMPEchoRequest,
+	"time-exceeded":           11,
+}
+
+var icmpv6TypeNames = map[string]int32{
+	"destination-unreachable": 1,
+	"packet-too-big":          2,
+	"time-exceeded":           3,
+	"echo-request":            firewall.ICMPv6EchoRequest,
+	"echo-reply":              firewall.ICMPv6EchoReply,
+	"redirect":                137,
+}
+
+// parseICMP parses an icmp type or code which may be `any`, a number, a range, or one of the provided names.
+// An empty string is any.
+func parseICMP(s string, names map[string]int32) (start, end int32, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "any" {
+		return firewall.ICMPAny, firewall.ICMPAny, nil
+	}
+
+	if n, ok := names[s]; ok {
+		return n, n, nil
+	}
+
+	if strings.Contains(s, `-`) {
+		parts := strings.SplitN(s, `-`, 2)
+		parts[0] = strings.TrimSpace(parts[0])
+		parts[1] = strings.TrimSpace(parts[1])
+		if parts[0] == "" || parts[1] == "" {
+			return 0, 0, fmt.Errorf("appears to be a range but could not be parsed; `%s`", s)
+		}
+
+		rStart, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return 0, 0, fmt.Errorf("beginning range was not a number; `%s`", parts[0])
+		}
+
+		rEnd, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("ending range was not a number; `%s`", parts[1])
+		}
+
+		start, end = int32(rStart), int32(rEnd)
+
+	} else {
+		r, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("was not a number; `%s`", s)
+		}
+		start, end = int32(r), int32(r)
+	}
+
+	if start < 0 || end > 255 {
+		return 0, 0, fmt.Errorf("must be between 0 and 255; `%s`", s)
+	}
+
+	if start > end {
+		return 0, 0, fmt.Errorf("range start was higher than range end; `%s`", s)
+	}
+
+	return start, end, nil
+}
+
 //TODO: write tests for these
 func setTCPRTTTracking(c *conn, p []byte) {
+	// Only ipv4 is tracked, ipv6 headers would need their extension headers walked again
+	if p[0]>>4 == 6 {
+		return
+	}
+
 	if c.Seq != 0 {
 		return
 	}
@@ -897,6 +1841,11 @@ func setTCPRTTTracking(c *conn, p []byte) {
 }
 
 func (f *Firewall) checkTCPRTT(c *conn, p []byte) bool {
+	// Only ipv4 is tracked, ipv6 headers would need their extension headers walked again
+	if p[0]>>4 == 6 {
+		return false
+	}
+
 	if c.Seq == 0 {
 		return false
 	}
@@ -3,8 +3,7 @@ package firewall
 import (
 	"encoding/json"
 	"fmt"
-
-	"github.com/slackhq/nebula/iputil"
+	"net/netip"
 )
 
 type m map[string]interface{}
@@ -14,18 +13,35 @@ const (
 	ProtoTCP  = 6
 	ProtoUDP  = 17
 	ProtoICMP = 1
+	// ProtoICMPv6 is only ever seen on ipv6 packets, `proto: icmp` rules match it as well
+	ProtoICMPv6 = 58
 
 	PortAny      = 0  // Special value for matching `port: any`
 	PortFragment = -1 // Special value for matching `port: fragment`
+
+	ICMPAny = -1 // Special value for matching any icmp type or code
+
+	ICMPEchoReply     = 0
+	ICMPEchoRequest   = 8
+	ICMPv6EchoRequest = 128
+	ICMPv6EchoReply   = 129
 )
 
 type Packet struct {
-	LocalIP    iputil.VpnIp
-	RemoteIP   iputil.VpnIp
+	LocalIP    netip.Addr
+	RemoteIP   netip.Addr
 	LocalPort  uint16
 	RemotePort uint16
 	Protocol   uint8
 	Fragment   bool
+
+	// Only set for icmp and icmpv6 packets, ICMPID is the identifier of an echo request or reply
+	ICMPType uint8
+	ICMPCode uint8
+	ICMPID   uint16
+
+	// Only set for tcp packets, the flags drive the conntrack state of the connection and are not part of its key
+	TCPFlags uint8
 }
 
 func (fp *Packet) Copy() *Packet {
@@ -36,9 +52,49 @@ func (fp *Packet) Copy() *Packet {
 		RemotePort: fp.RemotePort,
 		Protocol:   fp.Protocol,
 		Fragment:   fp.Fragment,
+		ICMPType:   fp.ICMPType,
+		ICMPCode:   fp.ICMPCode,
+		ICMPID:     fp.ICMPID,
+		TCPFlags:   fp.TCPFlags,
 	}
 }
 
+// IsICMP returns true for icmp and icmpv6 packets
+func (fp *Packet) IsICMP() bool {
+	return fp.Protocol == ProtoICMP || fp.Protocol == ProtoICMPv6
+}
+
+// IsICMPEcho returns true for icmp and icmpv6 echo requests and replies
+func (fp *Packet) IsICMPEcho() bool {
+	switch fp.Protocol {
+	case ProtoICMP:
+		return fp.ICMPType == ICMPEchoRequest || fp.ICMPType == ICMPEchoReply
+	case ProtoICMPv6:
+		return fp.ICMPType == ICMPv6EchoRequest || fp.ICMPType == ICMPv6EchoReply
+	}
+	return false
+}
+
+// ConntrackKey returns the packet as it is tracked by conntrack. Echo replies are keyed like echo requests so a ping
+// and its reply share an entry, different pings are told apart by their identifier. Every packet of a tcp connection
+// shares an entry no matter its flags.
+func (fp Packet) ConntrackKey() Packet {
+	switch fp.Protocol {
+	case ProtoTCP:
+		fp.TCPFlags = 0
+	case ProtoICMP:
+		if fp.ICMPType == ICMPEchoReply {
+			fp.ICMPType = ICMPEchoRequest
+		}
+	case ProtoICMPv6:
+		if fp.ICMPType == ICMPv6EchoReply {
+			fp.ICMPType = ICMPv6EchoRequest
+		}
+	}
+
+	return fp
+}
+
 func (fp Packet) MarshalJSON() ([]byte, error) {
 	var proto string
 	switch fp.Protocol {
@@ -46,17 +102,31 @@ func (fp Packet) MarshalJSON() ([]byte, error) {
 		proto = "tcp"
 	case ProtoICMP:
 		proto = "icmp"
+	case ProtoICMPv6:
+		proto = "icmpv6"
 	case ProtoUDP:
 		proto = "udp"
 	default:
 		proto = fmt.Sprintf("unknown %v", fp.Protocol)
 	}
-	return json.Marshal(m{
+	jm := m{
 		"LocalIP":    fp.LocalIP.String(),
 		"RemoteIP":   fp.RemoteIP.String(),
 		"LocalPort":  fp.LocalPort,
 		"RemotePort": fp.RemotePort,
 		"Protocol":   proto,
 		"Fragment":   fp.Fragment,
-	})
+	}
+
+	if fp.IsICMP() {
+		jm["ICMPType"] = fp.ICMPType
+		jm["ICMPCode"] = fp.ICMPCode
+		jm["ICMPID"] = fp.ICMPID
+	}
+
+	if fp.Protocol == ProtoTCP {
+		jm["TCPFlags"] = fp.TCPFlags
+	}
+
+	return json.Marshal(jm)
 }
@@ -0,0 +1,159 @@
+package nebula
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/firewall"
+)
+
+// firewallDropLog logs packets dropped by the firewall at its own level, so a misbehaving policy can be debugged
+// without raising the log level of the whole process. Logging is limited to rate lines per second, drops beyond that
+// are counted and reported with the next line that is logged.
+type firewallDropLog struct {
+	sync.Mutex
+	l      *logrus.Logger
+	path   string
+	closer io.Closer
+
+	rate       float64
+	tokens     float64
+	last       time.Time
+	suppressed int64
+}
+
+// newFirewallDropLogFromConfig returns nil if firewall.log_drops is not enabled
+func newFirewallDropLogFromConfig(l *logrus.Logger, c *config.C) (*firewallDropLog, error) {
+	if !c.GetBool("firewall.log_drops.enabled", false) {
+		return nil, nil
+	}
+
+	rate := c.GetInt("firewall.log_drops.rate", 10)
+	if rate <= 0 {
+		return nil, fmt.Errorf("firewall.log_drops.rate must be greater than 0: %v", rate)
+	}
+
+	dl := newFirewallDropLog(l, l.Out, float64(rate))
+
+	if path := c.GetString("firewall.log_drops.file", ""); path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("firewall.log_drops.file could not be opened: %s", err)
+		}
+		dl.l.Out = f
+		dl.path = path
+		dl.closer = f
+	}
+
+	return dl, nil
+}
+
+// newFirewallDropLog creates a drop log writing to out in the format of l, a full second worth of lines can be logged
+// at once
+func newFirewallDropLog(l *logrus.Logger, out io.Writer, rate float64) *firewallDropLog {
+	dl := logrus.New()
+	dl.Out = out
+	dl.Formatter = l.Formatter
+	dl.SetLevel(logrus.InfoLevel)
+
+	return &firewallDropLog{
+		l:      dl,
+		rate:   rate,
+		tokens: rate,
+	}
+}
+
+// allow takes a token if one is available, otherwise the drop is counted as suppressed. When allowed the number of
+// drops suppressed since the last allowed one is returned.
+func (dl *firewallDropLog) allow(now time.Time) (bool, int64) {
+	dl.Lock()
+	defer dl.Unlock()
+
+	if !dl.last.IsZero() {
+		dl.tokens += now.Sub(dl.last).Seconds() * dl.rate
+		if dl.tokens > dl.rate {
+			dl.tokens = dl.rate
+		}
+	}
+	dl.last = now
+
+	if dl.tokens < 1 {
+		dl.suppressed++
+		return false, 0
+	}
+
+	dl.tokens--
+	suppressed := dl.suppressed
+	dl.suppressed = 0
+	return true, suppressed
+}
+
+func (dl *firewallDropLog) log(fp firewall.Packet, incoming bool, h *HostInfo, reason error) {
+	ok, suppressed := dl.allow(time.Now())
+	if !ok {
+		return
+	}
+
+	direction := "incoming"
+	if !incoming {
+		direction = "outgoing"
+	}
+
+	fields := logrus.Fields{
+		"fwPacket":  fp,
+		"direction": direction,
+		"reason":    reason,
+		"vpnIp":     h.vpnIp,
+	}
+
+	if c := h.GetCert(); c != nil {
+		fields["certName"] = c.Details.Name
+		fields["certGroups"] = c.Details.Groups
+	}
+
+	if suppressed > 0 {
+		fields["suppressed"] = suppressed
+	}
+
+	dl.l.WithFields(fields).Info("Firewall dropped packet")
+}
+
+// shareFile makes dl write to the file of old when both are configured with the same firewall.log_drops.file, so a
+// reload does not close the file out from under packets still being dropped by the old firewall. dl becomes the owner
+// of the file and closing old will leave it open.
+func (dl *firewallDropLog) shareFile(old *firewallDropLog) error {
+	if dl == nil || old == nil || dl.path == "" || dl.path != old.path {
+		return nil
+	}
+
+	old.Lock()
+	defer old.Unlock()
+	if old.closer == nil {
+		return nil
+	}
+
+	dl.Lock()
+	defer dl.Unlock()
+	err := dl.closer.Close()
+	dl.l.Out = old.l.Out
+	dl.closer = old.closer
+	old.closer = nil
+	return err
+}
+
+func (dl *firewallDropLog) Close() error {
+	dl.Lock()
+	closer := dl.closer
+	dl.closer = nil
+	dl.Unlock()
+
+	if closer == nil {
+		return nil
+	}
+	return closer.Close()
+}
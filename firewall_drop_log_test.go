@@ -0,0 +1,182 @@
+package nebula
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/firewall"
+	"github.com/slackhq/nebula/iputil"
+	"github.com/slackhq/nebula/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirewallDropLog_allow(t *testing.T) {
+	l := test.NewLogger()
+	dl := newFirewallDropLog(l, ioutil.Discard, 2)
+	now := time.Now()
+
+	// A full second worth of lines can be logged at once
+	ok, suppressed := dl.allow(now)
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), suppressed)
+	ok, _ = dl.allow(now)
+	assert.True(t, ok)
+
+	ok, _ = dl.allow(now)
+	assert.False(t, ok)
+	ok, _ = dl.allow(now.Add(100 * time.Millisecond))
+	assert.False(t, ok)
+
+	// Tokens refill at rate per second and the next line reports what was suppressed
+	ok, suppressed = dl.allow(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), suppressed)
+
+	// Tokens do not pile up past one second worth
+	ok, _ = dl.allow(now.Add(time.Hour))
+	assert.True(t, ok)
+	ok, _ = dl.allow(now.Add(time.Hour))
+	assert.True(t, ok)
+	ok, _ = dl.allow(now.Add(time.Hour))
+	assert.False(t, ok)
+}
+
+func TestFirewall_LogDrops(t *testing.T) {
+	l := test.NewLogger()
+	l.Formatter = &logrus.JSONFormatter{}
+
+	dir, err := ioutil.TempDir("", "firewall-log-drops")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ipNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "host1",
+			Ips:            []*net.IPNet{&ipNet},
+			Groups:         []string{"default-group"},
+			InvertedGroups: map[string]struct{}{"default-group": {}},
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(ipNet.IP),
+	}
+	h.CreateRemoteCIDR(&c)
+
+	p := firewall.Packet{
+		LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		LocalPort:  10,
+		RemotePort: 90,
+		Protocol:   firewall.ProtoUDP,
+	}
+
+	// Disabled by default
+	conf := config.NewC(l)
+	fw, err := NewFirewallFromConfig(l, &c, conf)
+	assert.Nil(t, err)
+	assert.Nil(t, fw.dropLog)
+
+	conf.Settings["firewall"] = map[interface{}]interface{}{"log_drops": map[interface{}]interface{}{"enabled": true, "rate": 0}}
+	_, err = NewFirewallFromConfig(l, &c, conf)
+	assert.EqualError(t, err, "firewall.log_drops.rate must be greater than 0: 0")
+
+	conf.Settings["firewall"] = map[interface{}]interface{}{"log_drops": map[interface{}]interface{}{"enabled": true, "file": filepath.Join(dir, "nope", "drops.log")}}
+	_, err = NewFirewallFromConfig(l, &c, conf)
+	assert.Error(t, err)
+
+	// Drops are logged to their own file no matter the level of the main logger
+	l.SetLevel(logrus.ErrorLevel)
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+	path := filepath.Join(dir, "drops.log")
+	conf.Settings["firewall"] = map[interface{}]interface{}{"log_drops": map[interface{}]interface{}{"enabled": true, "rate": 1, "file": path}}
+	fw, err = NewFirewallFromConfig(l, &c, conf)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, p, true, &h, cert.NewCAPool(), nil))
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, p, false, &h, cert.NewCAPool(), nil))
+	fw.Destroy()
+	assert.Empty(t, ob.String())
+
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	assert.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	assert.Nil(t, json.Unmarshal(lines[0], &entry))
+	assert.Equal(t, "Firewall dropped packet", entry["msg"])
+	assert.Equal(t, "incoming", entry["direction"])
+	assert.Equal(t, ErrNoMatchingRule.Error(), entry["reason"])
+	assert.Equal(t, "host1", entry["certName"])
+	assert.Equal(t, []interface{}{"default-group"}, entry["certGroups"])
+	assert.Equal(t, "1.2.3.4", entry["vpnIp"])
+	assert.Equal(t, float64(10), entry["fwPacket"].(map[string]interface{})["LocalPort"])
+}
+
+func TestFirewallDropLog_shareFile(t *testing.T) {
+	l := test.NewLogger()
+
+	dir, err := ioutil.TempDir("", "firewall-log-drops")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	newDropLog := func(path string) *firewallDropLog {
+		c := config.NewC(l)
+		c.Settings["firewall"] = map[interface{}]interface{}{"log_drops": map[interface{}]interface{}{"enabled": true, "file": path}}
+		dl, err := newFirewallDropLogFromConfig(l, c)
+		assert.Nil(t, err)
+		return dl
+	}
+
+	// The same file is shared and closing the old drop log leaves it open for the new one
+	path := filepath.Join(dir, "drops.log")
+	oldDl := newDropLog(path)
+	f := oldDl.closer.(*os.File)
+	dl := newDropLog(path)
+	assert.Nil(t, dl.shareFile(oldDl))
+	assert.Equal(t, f, dl.closer)
+	assert.Equal(t, f, dl.l.Out)
+
+	assert.Nil(t, oldDl.Close())
+	oldDl.l.Info("old")
+	dl.l.Info("new")
+
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "old")
+	assert.Contains(t, string(b), "new")
+
+	// A different file is not shared, each closes its own
+	otherDl := newDropLog(filepath.Join(dir, "other.log"))
+	assert.Nil(t, otherDl.shareFile(dl))
+	assert.Equal(t, f, dl.closer)
+	assert.NotEqual(t, f, otherDl.closer)
+	assert.Nil(t, otherDl.Close())
+	assert.Nil(t, dl.Close())
+	_, err = f.Write([]byte("nope"))
+	assert.Error(t, err)
+
+	// Nothing to share when either is disabled
+	var nilDl *firewallDropLog
+	assert.Nil(t, nilDl.shareFile(dl))
+	assert.Nil(t, dl.shareFile(nil))
+}
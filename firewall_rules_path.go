@@ -0,0 +1,166 @@
+package nebula
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/nebula/config"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultFirewallRulesPollInterval = 10 * time.Second
+
+// firewallRulesFile is the layout of a file in firewall.rules_path, the rules are written the same as the ones in
+// firewall.inbound and firewall.outbound
+type firewallRulesFile struct {
+	Inbound  []interface{} `yaml:"inbound"`
+	Outbound []interface{} `yaml:"outbound"`
+}
+
+type firewallRulesPathFile struct {
+	path string
+	raw  []byte
+}
+
+// readFirewallRulesPath reads the yaml files in dir in lexical order. Hidden files are skipped so a file can be written
+// under a temporary name and renamed into place. The returned hash covers the name and content of every file read, so
+// a file being changed, added, or removed changes it.
+func readFirewallRulesPath(dir string) ([]firewallRulesPathFile, string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	h := sha256.New()
+	var files []firewallRulesPathFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		switch filepath.Ext(name) {
+		case ".yml", ".yaml":
+		default:
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// The lengths keep the boundaries between names and contents unambiguous
+		fmt.Fprintf(h, "%d:%s%d:", len(name), name, len(raw))
+		h.Write(raw)
+		files = append(files, firewallRulesPathFile{path: path, raw: raw})
+	}
+
+	return files, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// addFirewallRulesFromPath adds the rules of every file in dir, in the order of the files, and returns the hash of the
+// files that were read
+func addFirewallRulesFromPath(l *logrus.Logger, dir string, fw FirewallInterface) (string, error) {
+	files, hash, err := readFirewallRulesPath(dir)
+	if err != nil {
+		return "", fmt.Errorf("firewall.rules_path could not be read; %s", err)
+	}
+
+	for _, f := range files {
+		var rf firewallRulesFile
+		err = yaml.UnmarshalStrict(f.raw, &rf)
+		if err != nil {
+			return "", fmt.Errorf("%s failed to parse; %s", f.path, err)
+		}
+
+		// Outbound first, the same as the main config
+		err = addFirewallRules(l, false, f.path+": outbound", rf.Outbound, fw)
+		if err != nil {
+			return "", err
+		}
+
+		err = addFirewallRules(l, true, f.path+": inbound", rf.Inbound, fw)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return hash, nil
+}
+
+// rulesPathChanged is true if the files in rulesPath can not be read or differ from the ones the firewall was built with
+func (f *Firewall) rulesPathChanged() bool {
+	if f.rulesPath == "" {
+		return false
+	}
+
+	_, hash, err := readFirewallRulesPath(f.rulesPath)
+	return err != nil || hash != f.rulesPathHash
+}
+
+// newFirewallConfig copies the firewall settings out of c, so the rules_path watcher can build new firewalls from them
+// while the main config is being reloaded
+func newFirewallConfig(l *logrus.Logger, c *config.C) *config.C {
+	fc := config.NewC(l)
+	if v := c.Get("firewall"); v != nil {
+		fc.Settings["firewall"] = v
+	}
+	return fc
+}
+
+// watchFirewallRules polls the files in firewall.rules_path and replaces the firewall as soon as they change, without
+// waiting for the rest of the config to be reloaded
+func (f *Interface) watchFirewallRules(ctx context.Context) {
+	// Broken files are reported once, not on every poll until they are fixed
+	var failedHash string
+
+	for {
+		f.firewallLock.Lock()
+		c := f.firewallConfig
+		fw := f.firewall
+		f.firewallLock.Unlock()
+
+		interval := c.GetDuration("firewall.rules_poll_interval", defaultFirewallRulesPollInterval)
+		if interval <= 0 {
+			interval = defaultFirewallRulesPollInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if fw.rulesPath == "" {
+			continue
+		}
+
+		_, hash, err := readFirewallRulesPath(fw.rulesPath)
+		if err != nil {
+			f.l.WithError(err).WithField("rulesPath", fw.rulesPath).Warn("Failed to check firewall.rules_path for changes")
+			continue
+		}
+
+		if hash == fw.rulesPathHash || hash == failedHash {
+			continue
+		}
+
+		f.l.WithField("rulesPath", fw.rulesPath).Info("Firewall rule files have changed")
+		err = f.replaceFirewall(nil)
+		if err != nil {
+			failedHash = hash
+			f.l.WithError(err).Error("Error while creating firewall from the changed rule files")
+			continue
+		}
+		failedHash = ""
+	}
+}
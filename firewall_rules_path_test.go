@@ -0,0 +1,133 @@
+package nebula
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFirewallFromConfig_rulesPath(t *testing.T) {
+	l := test.NewLogger()
+
+	dir, err := ioutil.TempDir("", "firewall-rules-path")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, s string) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0600))
+	}
+	ruleNames := func(fw *Firewall) []string {
+		var names []string
+		for _, r := range listFirewallRules(fw) {
+			names = append(names, r.Direction+" "+r.Name)
+		}
+		return names
+	}
+
+	c := &cert.NebulaCertificate{}
+	conf := config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{
+		"rules_path": dir,
+		"inbound":    []interface{}{map[interface{}]interface{}{"name": "icmp", "proto": "icmp", "port": "any", "host": "any"}},
+	}
+
+	// An empty directory adds no rules
+	fw, err := NewFirewallFromConfig(l, c, conf)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"incoming icmp"}, ruleNames(fw))
+	assert.False(t, fw.rulesPathChanged())
+
+	// Files are added in lexical order after the main config, hidden files and other extensions are skipped
+	write("20-db.yaml", "inbound:\n  - {name: postgres, proto: tcp, port: 5432, group: dba}\n")
+	write("10-web.yml", "inbound:\n  - {proto: tcp, port: 443, host: any}\noutbound:\n  - {proto: any, port: any, host: any}\n")
+	write(".30-new.yml", "not: rules")
+	write("README", "not: rules")
+	assert.True(t, fw.rulesPathChanged())
+
+	fw, err = NewFirewallFromConfig(l, c, conf)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"incoming icmp", "outgoing 0", "incoming 1", "incoming postgres"}, ruleNames(fw))
+	assert.False(t, fw.rulesPathChanged())
+
+	// Renaming a file changes the hash even if the order of the rules does not
+	assert.Nil(t, os.Rename(filepath.Join(dir, "20-db.yaml"), filepath.Join(dir, "21-db.yaml")))
+	assert.True(t, fw.rulesPathChanged())
+
+	// Errors point at the file
+	write("30-bad.yml", "inbound:\n  - {proto: gre, port: any, host: any}\n")
+	_, err = NewFirewallFromConfig(l, c, conf)
+	assert.EqualError(t, err, filepath.Join(dir, "30-bad.yml")+": inbound rule #0; proto was not understood; `gre`")
+
+	write("30-bad.yml", "inbond: []\n")
+	_, err = NewFirewallFromConfig(l, c, conf)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(dir, "30-bad.yml")+" failed to parse; ")
+	assert.Contains(t, err.Error(), "field inbond not found")
+
+	conf.Settings["firewall"] = map[interface{}]interface{}{"rules_path": filepath.Join(dir, "nope")}
+	_, err = NewFirewallFromConfig(l, c, conf)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "firewall.rules_path could not be read; ")
+}
+
+func TestInterface_watchFirewallRules(t *testing.T) {
+	l := test.NewLogger()
+
+	dir, err := ioutil.TempDir("", "firewall-rules-path")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.yml")
+	write := func(s string) {
+		// Written to a hidden file and renamed so the watcher never sees half a file
+		tmp := filepath.Join(dir, ".rules.yml")
+		assert.Nil(t, ioutil.WriteFile(tmp, []byte(s), 0600))
+		assert.Nil(t, os.Rename(tmp, path))
+	}
+	write("inbound:\n  - {name: ssh, proto: tcp, port: 22, host: any}\n")
+
+	c := &cert.NebulaCertificate{Details: cert.NebulaCertificateDetails{Ips: []*net.IPNet{{IP: net.IP{10, 1, 1, 1}, Mask: net.IPMask{255, 255, 255, 0}}}}}
+	conf := config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"rules_path": dir, "rules_poll_interval": "10ms"}
+
+	fw, err := NewFirewallFromConfig(l, c, conf)
+	assert.Nil(t, err)
+
+	ifce := &Interface{
+		firewall:       fw,
+		firewallConfig: newFirewallConfig(l, conf),
+		certState:      &CertState{certificate: c},
+		l:              l,
+	}
+	current := func() *Firewall {
+		ifce.firewallLock.Lock()
+		defer ifce.firewallLock.Unlock()
+		return ifce.firewall
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ifce.watchFirewallRules(ctx)
+
+	// A change is picked up on its own, the conntrack entries are kept and revalidated under the new rulesVersion
+	write("inbound:\n  - {name: https, proto: tcp, port: 443, host: any}\n")
+	assert.Eventually(t, func() bool { return current() != fw }, time.Second, 5*time.Millisecond)
+	newFw := current()
+	assert.Equal(t, "https", listFirewallRules(newFw)[0].Name)
+	assert.Equal(t, fw.rulesVersion+1, newFw.rulesVersion)
+	assert.Same(t, fw.Conntrack, newFw.Conntrack)
+
+	// A broken file leaves the running firewall alone
+	write("inbound:\n  - {name: https, proto: gre, port: 443, host: any}\n")
+	time.Sleep(50 * time.Millisecond)
+	assert.Same(t, newFw, current())
+}
@@ -0,0 +1,575 @@
+package nebula
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/firewall"
+	"github.com/slackhq/nebula/iputil"
+	"gopkg.in/yaml.v2"
+)
+
+// The port used for the side of a generated packet that is not being matched by a rule
+const firewallTestEphemeralPort = 40000
+
+var firewallTestProtos = map[string]uint8{
+	"tcp":    firewall.ProtoTCP,
+	"udp":    firewall.ProtoUDP,
+	"icmp":   firewall.ProtoICMP,
+	"icmpv6": firewall.ProtoICMPv6,
+}
+
+// FirewallTestCase is a packet exchanged with the holder of Cert, used to find out what a firewall would do with it
+type FirewallTestCase struct {
+	Incoming bool
+	Packet   firewall.Packet
+	Cert     *cert.NebulaCertificate
+}
+
+func (tc FirewallTestCase) String() string {
+	direction := "incoming"
+	fp := tc.Packet
+	from := netip.AddrPortFrom(fp.RemoteIP, fp.RemotePort)
+	to := netip.AddrPortFrom(fp.LocalIP, fp.LocalPort)
+	if !tc.Incoming {
+		direction = "outgoing"
+		from, to = to, from
+	}
+
+	proto := protoName(fp.Protocol)
+
+	var sb strings.Builder
+	switch fp.Protocol {
+	case firewall.ProtoICMP, firewall.ProtoICMPv6:
+		fmt.Fprintf(&sb, "%s %s %s -> %s type %d code %d", direction, proto, from.Addr(), to.Addr(), fp.ICMPType, fp.ICMPCode)
+	default:
+		fmt.Fprintf(&sb, "%s %s %s -> %s", direction, proto, from, to)
+	}
+
+	if fp.Fragment {
+		sb.WriteString(" fragment")
+	}
+
+	fmt.Fprintf(&sb, " peer %s", tc.Cert.Details.Name)
+	return sb.String()
+}
+
+// FirewallVerdict is what a firewall would do with a packet that is not part of a tracked connection
+type FirewallVerdict struct {
+	// Rule is the name of the rule that allowed or denied the packet, empty if no rule did
+	Rule string
+	// Err is why the packet would be dropped, nil if it would be allowed
+	Err error
+}
+
+func (v FirewallVerdict) Allowed() bool {
+	return v.Err == nil
+}
+
+func (v FirewallVerdict) String() string {
+	switch {
+	case v.Err == nil:
+		return "allow, rule " + v.Rule
+	case v.Rule != "":
+		return "deny, rule " + v.Rule
+	default:
+		return "deny, " + v.Err.Error()
+	}
+}
+
+// FirewallSimulation checks packets against the firewall rules of a config without running nebula, so a change to the
+// rules can be reviewed before it is deployed. Nothing is tracked or counted, every packet is checked as if it started
+// a new connection.
+type FirewallSimulation struct {
+	fw     *Firewall
+	cert   *cert.NebulaCertificate
+	caPool *cert.NebulaCAPool
+}
+
+// NewFirewallSimulationFromConfig loads the ca, the certificate, and the firewall rules of a config. The private key is
+// not needed.
+func NewFirewallSimulationFromConfig(l *logrus.Logger, c *config.C) (*FirewallSimulation, error) {
+	caPool, err := loadCAFromConfig(l, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ca from config: %s", err)
+	}
+
+	nc, err := loadCertFromConfig(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate from config: %s", err)
+	}
+
+	fw, err := NewFirewallFromConfig(l, nc, c)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading firewall rules: %s", err)
+	}
+
+	return &FirewallSimulation{fw: fw, cert: nc, caPool: caPool}, nil
+}
+
+// Evaluate checks a packet against the rules the same way a running firewall checks the first packet of a connection
+func (s *FirewallSimulation) Evaluate(tc FirewallTestCase) FirewallVerdict {
+	h := &HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: tc.Cert,
+		},
+	}
+	if len(tc.Cert.Details.Ips) > 0 {
+		h.vpnIp = iputil.Ip2VpnIp(tc.Cert.Details.Ips[0].IP)
+	}
+	h.CreateRemoteCIDR(tc.Cert)
+
+	rs, err := s.fw.checkRules(tc.Packet, tc.Incoming, h, s.caPool)
+	v := FirewallVerdict{Err: err}
+	if rs != nil {
+		v.Rule = rs.Name
+	}
+
+	return v
+}
+
+func (s *FirewallSimulation) Close() {
+	s.fw.Destroy()
+}
+
+// SimulateFirewall evaluates the packets in the test file at testPath against the firewall of c and writes a line with
+// the verdict of each to out. If against is not nil the packets are evaluated against its firewall as well and only
+// the ones that would be allowed by one and dropped by the other are written.
+func SimulateFirewall(l *logrus.Logger, c *config.C, against *config.C, testPath string, out io.Writer) error {
+	sim, err := NewFirewallSimulationFromConfig(l, c)
+	if err != nil {
+		return err
+	}
+	defer sim.Close()
+	sims := []*FirewallSimulation{sim}
+
+	var next *FirewallSimulation
+	if against != nil {
+		next, err = NewFirewallSimulationFromConfig(l, against)
+		if err != nil {
+			return err
+		}
+		defer next.Close()
+		sims = append(sims, next)
+	}
+
+	tcs, err := LoadFirewallTestCases(testPath, sims...)
+	if err != nil {
+		return err
+	}
+
+	allowed, changed := 0, 0
+	for _, tc := range tcs {
+		v := sim.Evaluate(tc)
+		if next == nil {
+			if v.Allowed() {
+				allowed++
+			}
+			fmt.Fprintf(out, "%s: %s\n", tc, v)
+			continue
+		}
+
+		// Only the verdict matters, not which rule made the call or why a packet is dropped. Unnamed rules are renumbered
+		// by any change before them.
+		nv := next.Evaluate(tc)
+		if v.Allowed() != nv.Allowed() {
+			changed++
+			fmt.Fprintf(out, "%s: %s => %s\n", tc, v, nv)
+		}
+	}
+
+	if next == nil {
+		fmt.Fprintf(out, "%d packets, %d allowed, %d denied\n", len(tcs), allowed, len(tcs)-allowed)
+	} else {
+		fmt.Fprintf(out, "%d packets, %d changed\n", len(tcs), changed)
+	}
+
+	return nil
+}
+
+type firewallTestFile struct {
+	// Certs are the peer certificates by name, each is a path or inline PEM data
+	Certs map[string]string `yaml:"certs"`
+	// Generate adds packets on the edges of every port, type, and code used by the rules
+	Generate bool                 `yaml:"generate"`
+	Packets  []firewallTestPacket `yaml:"packets"`
+}
+
+type firewallTestPacket struct {
+	Cert       string `yaml:"cert"`
+	Direction  string `yaml:"direction"`
+	Proto      string `yaml:"proto"`
+	LocalIP    string `yaml:"local_ip"`
+	LocalPort  uint16 `yaml:"local_port"`
+	RemoteIP   string `yaml:"remote_ip"`
+	RemotePort uint16 `yaml:"remote_port"`
+	Type       string `yaml:"type"`
+	Code       string `yaml:"code"`
+	Fragment   bool   `yaml:"fragment"`
+}
+
+// LoadFirewallTestCases reads the packets to simulate from a yaml file. Local addresses default to the first address
+// in the certificate of the first simulation, remote addresses to the first address in the peer certificate.
+// Generated packets cover the rules of every provided simulation.
+func LoadFirewallTestCases(path string, sims ...*FirewallSimulation) ([]FirewallTestCase, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read firewall test file %s: %s", path, err)
+	}
+
+	var tf firewallTestFile
+	if err = yaml.UnmarshalStrict(b, &tf); err != nil {
+		return nil, fmt.Errorf("unable to parse firewall test file %s: %s", path, err)
+	}
+
+	names := make([]string, 0, len(tf.Certs))
+	for name := range tf.Certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	certs := make(map[string]*cert.NebulaCertificate, len(names))
+	for _, name := range names {
+		certs[name], err = loadFirewallTestCert(tf.Certs[name])
+		if err != nil {
+			return nil, fmt.Errorf("certs.%s %s", name, err)
+		}
+	}
+
+	var tcs []FirewallTestCase
+	for i, p := range tf.Packets {
+		tc, err := p.testCase(certs, sims[0].cert)
+		if err != nil {
+			return nil, fmt.Errorf("packets #%v; %s", i, err)
+		}
+		tcs = append(tcs, tc)
+	}
+
+	if tf.Generate {
+		for _, name := range names {
+			tcs = append(tcs, generateFirewallTestCases(certs[name], sims)...)
+		}
+	}
+
+	return tcs, nil
+}
+
+func loadFirewallTestCert(pathOrPEM string) (*cert.NebulaCertificate, error) {
+	var b []byte
+	var err error
+
+	if strings.Contains(pathOrPEM, "-----BEGIN") {
+		b = []byte(pathOrPEM)
+	} else {
+		b, err = ioutil.ReadFile(pathOrPEM)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read certificate file %s: %s", pathOrPEM, err)
+		}
+	}
+
+	nc, _, err := cert.UnmarshalNebulaCertificateFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshaling certificate: %s", err)
+	}
+
+	return nc, nil
+}
+
+func (p firewallTestPacket) testCase(certs map[string]*cert.NebulaCertificate, local *cert.NebulaCertificate) (FirewallTestCase, error) {
+	tc := FirewallTestCase{}
+
+	var ok bool
+	tc.Cert, ok = certs[p.Cert]
+	if !ok {
+		return tc, fmt.Errorf("cert `%s` is not in certs", p.Cert)
+	}
+
+	switch p.Direction {
+	case "", "incoming":
+		tc.Incoming = true
+	case "outgoing":
+	default:
+		return tc, fmt.Errorf("direction was not understood; `%s`", p.Direction)
+	}
+
+	tc.Packet.Protocol, ok = firewallTestProtos[p.Proto]
+	if !ok {
+		return tc, fmt.Errorf("proto was not understood; `%s`", p.Proto)
+	}
+
+	var err error
+	tc.Packet.LocalIP, err = firewallTestAddr(p.LocalIP, local)
+	if err != nil {
+		return tc, fmt.Errorf("local_ip %s", err)
+	}
+
+	tc.Packet.RemoteIP, err = firewallTestAddr(p.RemoteIP, tc.Cert)
+	if err != nil {
+		return tc, fmt.Errorf("remote_ip %s", err)
+	}
+
+	tc.Packet.Fragment = p.Fragment
+
+	switch tc.Packet.Protocol {
+	case firewall.ProtoICMP, firewall.ProtoICMPv6:
+		names, echo := icmpTypeNames, int32(firewall.ICMPEchoRequest)
+		if tc.Packet.Protocol == firewall.ProtoICMPv6 {
+			names, echo = icmpv6TypeNames, firewall.ICMPv6EchoRequest
+		}
+
+		t, err := parseFirewallTestICMP(p.Type, names, echo)
+		if err != nil {
+			return tc, fmt.Errorf("type %s", err)
+		}
+
+		c, err := parseFirewallTestICMP(p.Code, nil, 0)
+		if err != nil {
+			return tc, fmt.Errorf("code %s", err)
+		}
+
+		tc.Packet.ICMPType, tc.Packet.ICMPCode = uint8(t), uint8(c)
+
+	default:
+		if p.Type != "" || p.Code != "" {
+			return tc, fmt.Errorf("type and code can only be used with proto icmp or icmpv6")
+		}
+
+		tc.Packet.LocalPort = p.LocalPort
+		tc.Packet.RemotePort = p.RemotePort
+	}
+
+	return tc, nil
+}
+
+// firewallTestAddr parses s, an empty s is the first address in c
+func firewallTestAddr(s string, c *cert.NebulaCertificate) (netip.Addr, error) {
+	if s != "" {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return addr, fmt.Errorf("did not parse; %s", err)
+		}
+		return addr.Unmap(), nil
+	}
+
+	if len(c.Details.Ips) == 0 {
+		return netip.Addr{}, fmt.Errorf("was not provided and certificate %s has no ips", c.Details.Name)
+	}
+
+	addr, err := iputil.ToNetIpAddr(c.Details.Ips[0].IP)
+	return addr.Unmap(), err
+}
+
+// parseFirewallTestICMP parses a single icmp type or code, an empty s is def
+func parseFirewallTestICMP(s string, names map[string]int32, def int32) (int32, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	start, end, err := parseICMP(s, names)
+	if err != nil {
+		return 0, err
+	}
+
+	if start != end || start == firewall.ICMPAny {
+		return 0, fmt.Errorf("must be a single value; `%s`", s)
+	}
+
+	return start, nil
+}
+
+// generateFirewallTestCases creates packets with the holder of peer in both directions, from every address in peer to
+// every address handled by the simulations. Tcp and udp packets are sent to the first and last port of every range
+// used by the rules and to a port no rule names, icmp packets use every type and code named by the rules.
+func generateFirewallTestCases(peer *cert.NebulaCertificate, sims []*FirewallSimulation) []FirewallTestCase {
+	var tcs []FirewallTestCase
+
+	var locals []netip.Addr
+	seen := map[netip.Addr]struct{}{}
+	addLocal := func(n *net.IPNet, first bool) {
+		addr, err := iputil.ToNetIpAddr(n.IP)
+		if err != nil {
+			return
+		}
+
+		addr = addr.Unmap()
+		if first {
+			// The first address of a subnet is its network address, use the one after it
+			addr = addr.Next()
+		}
+
+		if _, ok := seen[addr]; !ok {
+			seen[addr] = struct{}{}
+			locals = append(locals, addr)
+		}
+	}
+
+	for _, s := range sims {
+		for _, n := range s.cert.Details.Ips {
+			addLocal(n, false)
+		}
+		for _, n := range s.cert.Details.Subnets {
+			addLocal(&net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask}, true)
+		}
+	}
+
+	for _, incoming := range []bool{true, false} {
+		tcpPorts := firewallTestPorts(sims, incoming, firewall.ProtoTCP)
+		udpPorts := firewallTestPorts(sims, incoming, firewall.ProtoUDP)
+		icmpTypes := firewallTestICMP(sims, incoming, firewall.ProtoICMP, firewall.ICMPEchoRequest)
+		icmpv6Types := firewallTestICMP(sims, incoming, firewall.ProtoICMPv6, firewall.ICMPv6EchoRequest)
+
+		for _, n := range peer.Details.Ips {
+			remote, err := iputil.ToNetIpAddr(n.IP)
+			if err != nil {
+				continue
+			}
+			remote = remote.Unmap()
+
+			for _, local := range locals {
+				if local.Is4() != remote.Is4() {
+					continue
+				}
+
+				newCase := func(proto uint8) FirewallTestCase {
+					return FirewallTestCase{
+						Incoming: incoming,
+						Cert:     peer,
+						Packet: firewall.Packet{
+							LocalIP:  local,
+							RemoteIP: remote,
+							Protocol: proto,
+						},
+					}
+				}
+
+				addPorts := func(proto uint8, ports []uint16) {
+					for _, port := range ports {
+						tc := newCase(proto)
+						if incoming {
+							tc.Packet.LocalPort, tc.Packet.RemotePort = port, firewallTestEphemeralPort
+						} else {
+							tc.Packet.LocalPort, tc.Packet.RemotePort = firewallTestEphemeralPort, port
+						}
+						tcs = append(tcs, tc)
+					}
+				}
+
+				addPorts(firewall.ProtoTCP, tcpPorts)
+				addPorts(firewall.ProtoUDP, udpPorts)
+
+				proto, types := uint8(firewall.ProtoICMP), icmpTypes
+				if local.Is6() {
+					proto, types = firewall.ProtoICMPv6, icmpv6Types
+				}
+
+				for _, t := range types {
+					tc := newCase(proto)
+					tc.Packet.ICMPType, tc.Packet.ICMPCode = t[0], t[1]
+					tcs = append(tcs, tc)
+				}
+			}
+		}
+	}
+
+	return tcs
+}
+
+// firewallTestPorts returns the first and last port of every range of ports in the rules for proto, followed by the
+// lowest port none of them use
+func firewallTestPorts(sims []*FirewallSimulation, incoming bool, proto uint8) []uint16 {
+	used := map[int32]struct{}{}
+	for _, s := range sims {
+		for _, deny := range []bool{true, false} {
+			ft := s.fw.table(incoming, deny)
+			fps := []firewallPort{ft.AnyProto, ft.TCP}
+			if proto == firewall.ProtoUDP {
+				fps[1] = ft.UDP
+			}
+
+			for _, fp := range fps {
+				for k := range fp {
+					if k > 0 {
+						used[k] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+
+	var ports []uint16
+	unused := int32(0)
+	for k := int32(1); k <= 65535; k++ {
+		_, ok := used[k]
+		if !ok {
+			if unused == 0 {
+				unused = k
+			}
+			continue
+		}
+
+		_, prev := used[k-1]
+		_, next := used[k+1]
+		if !prev || !next {
+			ports = append(ports, uint16(k))
+		}
+	}
+
+	if unused != 0 {
+		ports = append(ports, uint16(unused))
+	}
+
+	return ports
+}
+
+// firewallTestICMP returns every type and code pair named in the icmp rules for proto, a rule with any type or code
+// uses echo for the type and 0 for the code
+func firewallTestICMP(sims []*FirewallSimulation, incoming bool, proto uint8, echo uint8) [][2]uint8 {
+	used := map[[2]uint8]struct{}{{echo, 0}: {}}
+	for _, s := range sims {
+		for _, deny := range []bool{true, false} {
+			ft := s.fw.table(incoming, deny)
+			fp := ft.ICMP
+			if proto == firewall.ProtoICMPv6 {
+				fp = ft.ICMPv6
+			}
+
+			for k := range fp {
+				if k == firewall.PortFragment {
+					continue
+				}
+
+				t, c := (k>>9)-1, (k&0x1ff)-1
+				key := [2]uint8{echo, 0}
+				if t != firewall.ICMPAny {
+					key[0] = uint8(t)
+				}
+				if c != firewall.ICMPAny {
+					key[1] = uint8(c)
+				}
+				used[key] = struct{}{}
+			}
+		}
+	}
+
+	types := make([][2]uint8, 0, len(used))
+	for k := range used {
+		types = append(types, k)
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		if types[i][0] != types[j][0] {
+			return types[i][0] < types[j][0]
+		}
+		return types[i][1] < types[j][1]
+	})
+
+	return types
+}
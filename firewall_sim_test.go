@@ -0,0 +1,179 @@
+package nebula
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/firewall"
+	"github.com/slackhq/nebula/test"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+func newFirewallSimTestCert(t *testing.T, ca *cert.NebulaCertificate, caKey ed25519.PrivateKey, name string, ip net.IP, subnets []*net.IPNet, groups []string) string {
+	priv := make([]byte, 32)
+	_, _ = rand.Read(priv)
+	pub, _ := curve25519.X25519(priv, curve25519.Basepoint)
+	issuer, _ := ca.Sha256Sum()
+
+	c := &cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      name,
+			Ips:       []*net.IPNet{{IP: ip, Mask: net.IPMask{255, 255, 255, 0}}},
+			Subnets:   subnets,
+			Groups:    groups,
+			NotBefore: time.Now().Add(-time.Hour),
+			NotAfter:  time.Now().Add(time.Hour),
+			PublicKey: pub,
+			Issuer:    issuer,
+		},
+	}
+	assert.NoError(t, c.Sign(caKey))
+
+	b, err := c.MarshalToPEM()
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestSimulateFirewall(t *testing.T) {
+	l := test.NewLogger()
+
+	dir, err := ioutil.TempDir("", "firewall-sim")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey, _ := newRenewalTestCA(t, time.Now().Add(time.Hour))
+	caPEM, err := ca.MarshalToPEM()
+	assert.NoError(t, err)
+
+	_, dbNet, _ := net.ParseCIDR("10.20.0.0/16")
+	gateway := newFirewallSimTestCert(t, ca, caKey, "gateway", net.IP{10, 1, 1, 1}, []*net.IPNet{dbNet}, nil)
+	dba := newFirewallSimTestCert(t, ca, caKey, "dba", net.IP{10, 1, 1, 2}, nil, []string{"dba"})
+	web := newFirewallSimTestCert(t, ca, caKey, "web", net.IP{10, 1, 1, 3}, nil, []string{"web"})
+
+	newConfig := func(inbound ...interface{}) *config.C {
+		c := config.NewC(l)
+		c.Settings["pki"] = map[interface{}]interface{}{"ca": string(caPEM), "cert": gateway}
+		c.Settings["firewall"] = map[interface{}]interface{}{"inbound": inbound}
+		return c
+	}
+	rule := func(kv ...string) map[interface{}]interface{} {
+		r := map[interface{}]interface{}{}
+		for i := 0; i < len(kv); i += 2 {
+			r[kv[i]] = kv[i+1]
+		}
+		return r
+	}
+
+	c := newConfig(
+		rule("name", "ssh", "port", "22", "proto", "tcp", "group", "dba"),
+		rule("port", "5432", "proto", "tcp", "group", "dba", "local_cidr", "10.20.0.0/16"),
+		rule("action", "deny", "port", "22", "proto", "tcp", "host", "web"),
+	)
+
+	testFile := filepath.Join(dir, "packets.yml")
+	write := func(s string) {
+		assert.Nil(t, ioutil.WriteFile(testFile, []byte(s), 0600))
+	}
+	indent := func(s string) string {
+		return strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n    ")
+	}
+	certs := "certs:\n  dba: |\n    " + indent(dba) + "\n  web: |\n    " + indent(web) + "\n"
+
+	write(certs + `
+packets:
+  - {cert: dba, proto: tcp, local_port: 22, remote_port: 40000}
+  - {cert: dba, proto: tcp, local_ip: 10.20.1.1, local_port: 5432}
+  - {cert: dba, proto: tcp, local_port: 5432}
+  - {cert: web, proto: tcp, local_port: 22}
+  - {cert: web, proto: icmp, type: echo-request}
+  - {cert: dba, proto: udp, remote_ip: 10.1.1.9, local_port: 53}
+  - {cert: dba, direction: outgoing, proto: tcp, remote_port: 443}
+`)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, SimulateFirewall(l, c, nil, testFile, out))
+	assert.Equal(t, `incoming tcp 10.1.1.2:40000 -> 10.1.1.1:22 peer dba: allow, rule ssh
+incoming tcp 10.1.1.2:0 -> 10.20.1.1:5432 peer dba: allow, rule 1
+incoming tcp 10.1.1.2:0 -> 10.1.1.1:5432 peer dba: deny, no matching rule in firewall table
+incoming tcp 10.1.1.3:0 -> 10.1.1.1:22 peer web: deny, rule 2
+incoming icmp 10.1.1.3 -> 10.1.1.1 type 8 code 0 peer web: deny, no matching rule in firewall table
+incoming udp 10.1.1.9:0 -> 10.1.1.1:53 peer dba: deny, remote IP is not in remote certificate subnets
+outgoing tcp 10.1.1.1:0 -> 10.1.1.2:443 peer dba: deny, no matching rule in firewall table
+7 packets, 2 allowed, 5 denied
+`, out.String())
+
+	// Only the packets the candidate treats differently are printed, renumbered rules do not count
+	candidate := newConfig(
+		rule("port", "22", "proto", "tcp", "groups", "dba"),
+		rule("name", "ssh", "port", "22", "proto", "tcp", "group", "web"),
+		rule("port", "5432", "proto", "tcp", "group", "dba", "local_cidr", "10.20.0.0/16"),
+	)
+	out.Reset()
+	assert.NoError(t, SimulateFirewall(l, c, candidate, testFile, out))
+	assert.Equal(t, `incoming tcp 10.1.1.3:0 -> 10.1.1.1:22 peer web: deny, rule 2 => allow, rule ssh
+7 packets, 1 changed
+`, out.String())
+
+	// A packet dropped for a different reason is not a change
+	out.Reset()
+	assert.NoError(t, SimulateFirewall(l, c, newConfig(rule("name", "ssh", "port", "22", "proto", "tcp", "group", "dba")), testFile, out))
+	assert.Equal(t, "incoming tcp 10.1.1.2:0 -> 10.20.1.1:5432 peer dba: allow, rule 1 => deny, no matching rule in firewall table\n7 packets, 1 changed\n", out.String())
+
+	// Generated packets cover the ports of both rule sets
+	write(certs + "generate: true\n")
+	out.Reset()
+	assert.NoError(t, SimulateFirewall(l, c, candidate, testFile, out))
+	assert.Contains(t, out.String(), "incoming tcp 10.1.1.3:40000 -> 10.1.1.1:22 peer web: deny, rule 2 => allow, rule ssh\n")
+	assert.Contains(t, out.String(), "incoming tcp 10.1.1.3:40000 -> 10.20.0.1:22 peer web: deny, rule 2 => allow, rule ssh\n")
+	assert.Contains(t, out.String(), "packets, 2 changed\n")
+
+	// Errors point at the packet
+	for s, e := range map[string]string{
+		"packets:\n  - {cert: nope, proto: tcp}":                "packets #0; cert `nope` is not in certs",
+		"packets:\n  - {cert: dba, proto: gre}":                 "packets #0; proto was not understood; `gre`",
+		"packets:\n  - {cert: dba, proto: tcp, direction: in}":  "packets #0; direction was not understood; `in`",
+		"packets:\n  - {cert: dba, proto: tcp, type: 8}":        "packets #0; type and code can only be used with proto icmp or icmpv6",
+		"packets:\n  - {cert: dba, proto: icmp, type: 0-8}":     "packets #0; type must be a single value; `0-8`",
+		"packets:\n  - {cert: dba, proto: tcp, local_ip: nope}": "packets #0; local_ip did not parse; ParseAddr(\"nope\"): unable to parse IP",
+	} {
+		write(certs + s)
+		assert.EqualError(t, SimulateFirewall(l, c, nil, testFile, out), e)
+	}
+
+	// Unknown fields are most likely typos
+	write(certs + "packets:\n  - {cert: dba, proto: tcp, local_prot: 22}")
+	err = SimulateFirewall(l, c, nil, testFile, out)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "field local_prot not found")
+}
+
+func Test_firewallTestPorts(t *testing.T) {
+	l := test.NewLogger()
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &cert.NebulaCertificate{})
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{"any"}}, 1, 2))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{"any"}}, 22, 22))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Deny: true, Proto: firewall.ProtoAny, Groups: []string{"any"}}, 200, 300))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"any"}}, 53, 53))
+	assert.Nil(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{"any"}}, 3, 3, 1, 1))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{"any"}}, 0, 0))
+	sims := []*FirewallSimulation{{fw: fw}}
+
+	// Both edges of every range and the lowest unused port
+	assert.Equal(t, []uint16{1, 2, 22, 200, 300, 3}, firewallTestPorts(sims, true, firewall.ProtoTCP))
+	assert.Equal(t, []uint16{53, 200, 300, 1}, firewallTestPorts(sims, true, firewall.ProtoUDP))
+	assert.Equal(t, []uint16{1}, firewallTestPorts(sims, false, firewall.ProtoTCP))
+
+	assert.Equal(t, [][2]uint8{{3, 1}, {8, 0}}, firewallTestICMP(sims, true, firewall.ProtoICMP, firewall.ICMPEchoRequest))
+	assert.Equal(t, [][2]uint8{{128, 0}}, firewallTestICMP(sims, true, firewall.ProtoICMPv6, firewall.ICMPv6EchoRequest))
+}
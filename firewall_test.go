@@ -6,6 +6,7 @@ import (
 	"errors"
 	"math"
 	"net"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -69,67 +70,94 @@ func TestFirewall_AddRule(t *testing.T) {
 
 	_, ti, _ := net.ParseCIDR("1.2.3.4/32")
 
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoTCP, 1, 1, []string{}, "", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{}}, 1, 1))
 	// An empty rule is any
 	assert.True(t, fw.InRules.TCP[1].Any.Any)
 	assert.Empty(t, fw.InRules.TCP[1].Any.Groups)
 	assert.Empty(t, fw.InRules.TCP[1].Any.Hosts)
 
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoUDP, 1, 1, []string{"g1"}, "", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"g1"}}, 1, 1))
 	assert.False(t, fw.InRules.UDP[1].Any.Any)
 	assert.Contains(t, fw.InRules.UDP[1].Any.Groups[0], "g1")
 	assert.Empty(t, fw.InRules.UDP[1].Any.Hosts)
 
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoICMP, 1, 1, []string{}, "h1", nil, "", ""))
-	assert.False(t, fw.InRules.ICMP[1].Any.Any)
-	assert.Empty(t, fw.InRules.ICMP[1].Any.Groups)
-	assert.Contains(t, fw.InRules.ICMP[1].Any.Hosts, "h1")
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{}, Host: "h1"}, 1, 1))
+	// A port on an icmp rule is the icmp type
+	k := icmpKey(1, firewall.ICMPAny)
+	assert.False(t, fw.InRules.ICMP[k].Any.Any)
+	assert.Empty(t, fw.InRules.ICMP[k].Any.Groups)
+	assert.Contains(t, fw.InRules.ICMP[k].Any.Hosts, "h1")
 
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
-	assert.Nil(t, fw.AddRule(false, firewall.ProtoAny, 1, 1, []string{}, "", ti, "", ""))
+	assert.Nil(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMPv6, Groups: []string{}, Host: "h1"}, 128, 129, 0, 0))
+	assert.Contains(t, fw.InRules.ICMPv6[icmpKey(128, 0)].Any.Hosts, "h1")
+	assert.Contains(t, fw.InRules.ICMPv6[icmpKey(129, 0)].Any.Hosts, "h1")
+	assert.Len(t, fw.InRules.ICMPv6, 2)
+	assert.Empty(t, fw.InRules.ICMP)
+	assert.EqualError(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{}, Host: "h1"}, 0, 0, 0, 0), "protocol 6 does not have icmp types")
+	assert.EqualError(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{}, Host: "h1"}, 0, 256, 0, 0), "icmp types and codes must be between 0 and 255")
+	assert.EqualError(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{}, Host: "h1"}, 8, 0, 0, 0), "start type was higher than end type")
+
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Proto: firewall.ProtoAny, Groups: []string{}, Ip: ti}, 1, 1))
 	assert.False(t, fw.OutRules.AnyProto[1].Any.Any)
 	assert.Empty(t, fw.OutRules.AnyProto[1].Any.Groups)
 	assert.Empty(t, fw.OutRules.AnyProto[1].Any.Hosts)
 	assert.NotNil(t, fw.OutRules.AnyProto[1].Any.CIDR.Match(iputil.Ip2VpnIp(ti.IP)))
 
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoUDP, 1, 1, []string{"g1"}, "", nil, "ca-name", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{}, Extensions: map[string]string{"env": "prod"}}, 1, 1))
+	assert.False(t, fw.InRules.UDP[1].Any.Any)
+	assert.Empty(t, fw.InRules.UDP[1].Any.Groups)
+	assert.Equal(t, []map[string]string{{"env": "prod"}}, fw.InRules.UDP[1].Any.Extensions)
+
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"g1"}, CAName: "ca-name"}, 1, 1))
 	assert.Contains(t, fw.InRules.UDP[1].CANames, "ca-name")
 
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoUDP, 1, 1, []string{"g1"}, "", nil, "", "ca-sha"))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"g1"}, CASha: "ca-sha"}, 1, 1))
 	assert.Contains(t, fw.InRules.UDP[1].CAShas, "ca-sha")
 
 	// Set any and clear fields
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
-	assert.Nil(t, fw.AddRule(false, firewall.ProtoAny, 0, 0, []string{"g1", "g2"}, "h1", ti, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Proto: firewall.ProtoAny, Groups: []string{"g1", "g2"}, Host: "h1", Ip: ti}, 0, 0))
 	assert.Equal(t, []string{"g1", "g2"}, fw.OutRules.AnyProto[0].Any.Groups[0])
 	assert.Contains(t, fw.OutRules.AnyProto[0].Any.Hosts, "h1")
 	assert.NotNil(t, fw.OutRules.AnyProto[0].Any.CIDR.Match(iputil.Ip2VpnIp(ti.IP)))
 
 	// run twice just to make sure
 	//TODO: these ANY rules should clear the CA firewall portion
-	assert.Nil(t, fw.AddRule(false, firewall.ProtoAny, 0, 0, []string{"any"}, "", nil, "", ""))
-	assert.Nil(t, fw.AddRule(false, firewall.ProtoAny, 0, 0, []string{}, "any", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Proto: firewall.ProtoAny, Groups: []string{"any"}}, 0, 0))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Proto: firewall.ProtoAny, Groups: []string{}, Host: "any"}, 0, 0))
 	assert.True(t, fw.OutRules.AnyProto[0].Any.Any)
 	assert.Empty(t, fw.OutRules.AnyProto[0].Any.Groups)
 	assert.Empty(t, fw.OutRules.AnyProto[0].Any.Hosts)
+	assert.Empty(t, fw.OutRules.AnyProto[0].Any.Extensions)
 
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
-	assert.Nil(t, fw.AddRule(false, firewall.ProtoAny, 0, 0, []string{}, "any", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Proto: firewall.ProtoAny, Groups: []string{}, Host: "any"}, 0, 0))
 	assert.True(t, fw.OutRules.AnyProto[0].Any.Any)
 
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
 	_, anyIp, _ := net.ParseCIDR("0.0.0.0/0")
-	assert.Nil(t, fw.AddRule(false, firewall.ProtoAny, 0, 0, []string{}, "", anyIp, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Proto: firewall.ProtoAny, Groups: []string{}, Ip: anyIp}, 0, 0))
 	assert.True(t, fw.OutRules.AnyProto[0].Any.Any)
 
+	// Any peer limited to a local cidr does not make the rule any
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
+	_, localIp, _ := net.ParseCIDR("10.0.0.0/8")
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}, LocalIp: localIp}, 0, 0))
+	assert.False(t, fw.InRules.AnyProto[0].Any.Any)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}, LocalIp: anyIp}, 0, 0))
+	assert.True(t, fw.InRules.AnyProto[0].Any.Any)
+
 	// Test error conditions
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
-	assert.Error(t, fw.AddRule(true, math.MaxUint8, 0, 0, []string{}, "", nil, "", ""))
-	assert.Error(t, fw.AddRule(true, firewall.ProtoAny, 10, 0, []string{}, "", nil, "", ""))
+	assert.Error(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: math.MaxUint8, Groups: []string{}}, 0, 0))
+	assert.Error(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{}}, 10, 0))
 }
 
 func TestFirewall_Drop(t *testing.T) {
@@ -138,12 +166,12 @@ func TestFirewall_Drop(t *testing.T) {
 	l.SetOutput(ob)
 
 	p := firewall.Packet{
-		iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
-		iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
-		10,
-		90,
-		firewall.ProtoUDP,
-		false,
+		LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		LocalPort:  10,
+		RemotePort: 90,
+		Protocol:   firewall.ProtoUDP,
+		Fragment:   false,
 	}
 
 	ipNet := net.IPNet{
@@ -169,7 +197,7 @@ func TestFirewall_Drop(t *testing.T) {
 	h.CreateRemoteCIDR(&c)
 
 	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"any"}, "", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}}, 0, 0))
 	cp := cert.NewCAPool()
 
 	// Drop outbound
@@ -182,35 +210,266 @@ func TestFirewall_Drop(t *testing.T) {
 
 	// test remote mismatch
 	oldRemote := p.RemoteIP
-	p.RemoteIP = iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 10))
+	p.RemoteIP = netip.AddrFrom4([4]byte{1, 2, 3, 10})
 	assert.Equal(t, fw.Drop([]byte{}, p, false, &h, cp, nil), ErrInvalidRemoteIP)
 	p.RemoteIP = oldRemote
 
 	// ensure signer doesn't get in the way of group checks
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"nope"}, "", nil, "", "signer-shasum"))
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"default-group"}, "", nil, "", "signer-shasum-bad"))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"nope"}, CASha: "signer-shasum"}, 0, 0))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"default-group"}, CASha: "signer-shasum-bad"}, 0, 0))
 	assert.Equal(t, fw.Drop([]byte{}, p, true, &h, cp, nil), ErrNoMatchingRule)
 
 	// test caSha doesn't drop on match
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"nope"}, "", nil, "", "signer-shasum-bad"))
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"default-group"}, "", nil, "", "signer-shasum"))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"nope"}, CASha: "signer-shasum-bad"}, 0, 0))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"default-group"}, CASha: "signer-shasum"}, 0, 0))
 	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
 
 	// ensure ca name doesn't get in the way of group checks
 	cp.CAs["signer-shasum"] = &cert.NebulaCertificate{Details: cert.NebulaCertificateDetails{Name: "ca-good"}}
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"nope"}, "", nil, "ca-good", ""))
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"default-group"}, "", nil, "ca-good-bad", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"nope"}, CAName: "ca-good"}, 0, 0))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"default-group"}, CAName: "ca-good-bad"}, 0, 0))
 	assert.Equal(t, fw.Drop([]byte{}, p, true, &h, cp, nil), ErrNoMatchingRule)
 
 	// test caName doesn't drop on match
 	cp.CAs["signer-shasum"] = &cert.NebulaCertificate{Details: cert.NebulaCertificateDetails{Name: "ca-good"}}
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"nope"}, "", nil, "ca-good-bad", ""))
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"default-group"}, "", nil, "ca-good", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"nope"}, CAName: "ca-good-bad"}, 0, 0))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"default-group"}, CAName: "ca-good"}, 0, 0))
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+
+	// ca name and sha rules match any CA up the chain of an intermediate signed cert
+	inter := &cert.NebulaCertificate{Details: cert.NebulaCertificateDetails{Name: "ca-intermediate", IsCA: true, Issuer: "signer-shasum"}}
+	interSha, err := inter.Sha256Sum()
+	assert.Nil(t, err)
+	c.Details.Issuer = interSha
+	c.Chain = []*cert.NebulaCertificate{inter}
+
+	for _, r := range [][2]string{{"ca-intermediate", ""}, {"ca-good", ""}, {"", interSha}, {"", "signer-shasum"}} {
+		fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+		assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"default-group"}, CAName: r[0], CASha: r[1]}, 0, 0))
+		assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil), "rule %v", r)
+	}
+
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"default-group"}, CAName: "ca-good-bad", CASha: "signer-shasum-bad"}, 0, 0))
+	assert.Equal(t, fw.Drop([]byte{}, p, true, &h, cp, nil), ErrNoMatchingRule)
+}
+
+func TestFirewall_DropExtensions(t *testing.T) {
+	l := test.NewLogger()
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+
+	p := firewall.Packet{
+		LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		LocalPort:  10,
+		RemotePort: 90,
+		Protocol:   firewall.ProtoUDP,
+		Fragment:   false,
+	}
+
+	ipNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "host1",
+			Ips:            []*net.IPNet{&ipNet},
+			Groups:         []string{"default-group"},
+			InvertedGroups: map[string]struct{}{"default-group": {}},
+			Issuer:         "signer-shasum",
+			Extensions:     map[string]string{"team": "ops", "env": "prod"},
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(ipNet.IP),
+	}
+	h.CreateRemoteCIDR(&c)
+	cp := cert.NewCAPool()
+
+	// every extension in the rule must be present with the same value
+	for _, e := range []map[string]string{{"team": "ops"}, {"team": "ops", "env": "prod"}} {
+		fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+		assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Extensions: e}, 0, 0))
+		assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil), "extensions %v", e)
+	}
+
+	for _, e := range []map[string]string{{"team": "dev"}, {"team": "ops", "env": "dev"}, {"owner": "ops"}} {
+		fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+		assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Extensions: e}, 0, 0))
+		assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, p, true, &h, cp, nil), "extensions %v", e)
+	}
+
+	// extension rules are OR'd with other rules for the same port
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"nope"}}, 0, 0))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Extensions: map[string]string{"env": "prod"}}, 0, 0))
 	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+
+	// a cert without extensions never matches
+	c.Details.Extensions = nil
+	resetConntrack(fw)
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, p, true, &h, cp, nil))
+}
+
+func TestFirewall_DropIPv6(t *testing.T) {
+	l := test.NewLogger()
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+
+	p := firewall.Packet{
+		LocalIP:    netip.MustParseAddr("fd00::1"),
+		RemoteIP:   netip.MustParseAddr("fd00::2"),
+		LocalPort:  10,
+		RemotePort: 90,
+		Protocol:   firewall.ProtoICMPv6,
+	}
+
+	_, myIp6, _ := net.ParseCIDR("fd00::1/64")
+	myIp6.IP = net.ParseIP("fd00::1")
+	myCert := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name: "me",
+			Ips:  []*net.IPNet{{IP: net.IPv4(1, 2, 3, 5), Mask: net.IPMask{255, 255, 255, 0}}, myIp6},
+		},
+	}
+
+	_, ip6, _ := net.ParseCIDR("fd00::2/64")
+	ip6.IP = net.ParseIP("fd00::2")
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "host1",
+			Ips:            []*net.IPNet{{IP: net.IPv4(1, 2, 3, 4), Mask: net.IPMask{255, 255, 255, 0}}, ip6},
+			Groups:         []string{"default-group"},
+			InvertedGroups: map[string]struct{}{"default-group": {}},
+			Issuer:         "signer-shasum",
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
+	}
+	h.CreateRemoteCIDR(&c)
+	cp := cert.NewCAPool()
+
+	// icmp rules cover icmpv6
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &myCert)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{"any"}}, 0, 0))
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+
+	// ipv4 cidr rules do not match ipv6 packets
+	_, v4Net, _ := net.ParseCIDR("1.2.3.0/24")
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &myCert)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{}, Ip: v4Net}, 0, 0))
+	assert.Equal(t, fw.Drop([]byte{}, p, true, &h, cp, nil), ErrNoMatchingRule)
+
+	// ipv6 cidr rules do
+	_, v6Net, _ := net.ParseCIDR("fd00::/64")
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &myCert)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{}, Ip: v6Net}, 0, 0))
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+
+	// remote address must be in the remote certificate
+	resetConntrack(fw)
+	p.RemoteIP = netip.MustParseAddr("fd00::3")
+	assert.Equal(t, fw.Drop([]byte{}, p, true, &h, cp, nil), ErrInvalidRemoteIP)
+
+	// local address must be ours
+	p.RemoteIP = netip.MustParseAddr("fd00::2")
+	p.LocalIP = netip.MustParseAddr("fd00::3")
+	assert.Equal(t, fw.Drop([]byte{}, p, true, &h, cp, nil), ErrInvalidLocalIP)
+}
+
+func TestFirewall_DropICMP(t *testing.T) {
+	l := test.NewLogger()
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+
+	ipNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "host1",
+			Ips:            []*net.IPNet{&ipNet},
+			Groups:         []string{"default-group"},
+			InvertedGroups: map[string]struct{}{"default-group": {}},
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(ipNet.IP),
+	}
+	h.CreateRemoteCIDR(&c)
+	cp := cert.NewCAPool()
+
+	icmp := func(icmpType, icmpCode uint8, id uint16) firewall.Packet {
+		return firewall.Packet{
+			LocalIP:  netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+			RemoteIP: netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+			Protocol: firewall.ProtoICMP,
+			ICMPType: icmpType,
+			ICMPCode: icmpCode,
+			ICMPID:   id,
+		}
+	}
+
+	// Allow pings in and out, nothing else
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{"any"}}, firewall.ICMPEchoRequest, firewall.ICMPEchoRequest, firewall.ICMPAny, firewall.ICMPAny))
+	assert.Nil(t, fw.AddICMPRule(FirewallRuleSpec{Proto: firewall.ProtoICMP, Groups: []string{"any"}}, firewall.ICMPEchoRequest, firewall.ICMPEchoRequest, 0, 0))
+
+	assert.NoError(t, fw.Drop([]byte{}, icmp(firewall.ICMPEchoRequest, 0, 1), true, &h, cp, nil))
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, icmp(5, 1, 0), true, &h, cp, nil))
+
+	// The reply to our ping finds its conntrack entry, a reply to a ping we never sent does not
+	assert.NoError(t, fw.Drop([]byte{}, icmp(firewall.ICMPEchoRequest, 0, 2), false, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, icmp(firewall.ICMPEchoReply, 0, 2), true, &h, cp, nil))
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, icmp(firewall.ICMPEchoReply, 0, 3), true, &h, cp, nil))
+
+	// The outbound rule only allows code 0
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, icmp(firewall.ICMPEchoRequest, 1, 4), false, &h, cp, nil))
+
+	// Codes can be matched without a type
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{"any"}}, firewall.ICMPAny, firewall.ICMPAny, 3, 3))
+	assert.NoError(t, fw.Drop([]byte{}, icmp(11, 3, 0), true, &h, cp, nil))
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, icmp(11, 0, 0), true, &h, cp, nil))
+
+	// icmp types do not carry over to icmpv6, only a rule without a type does
+	_, myIp6, _ := net.ParseCIDR("fd00::1/64")
+	myIp6.IP = net.ParseIP("fd00::1")
+	c.Details.Ips = append(c.Details.Ips, myIp6)
+	h.CreateRemoteCIDR(&c)
+	p6 := firewall.Packet{
+		LocalIP:  netip.MustParseAddr("fd00::1"),
+		RemoteIP: netip.MustParseAddr("fd00::1"),
+		Protocol: firewall.ProtoICMPv6,
+		ICMPType: firewall.ICMPEchoRequest,
+	}
+
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMP, Groups: []string{"any"}}, firewall.ICMPEchoRequest, firewall.ICMPEchoRequest, firewall.ICMPAny, firewall.ICMPAny))
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, p6, true, &h, cp, nil))
+
+	assert.Nil(t, fw.AddICMPRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoICMPv6, Groups: []string{"any"}}, firewall.ICMPv6EchoRequest, firewall.ICMPv6EchoRequest, firewall.ICMPAny, firewall.ICMPAny))
+	p6.ICMPType = firewall.ICMPv6EchoRequest
+	assert.NoError(t, fw.Drop([]byte{}, p6, true, &h, cp, nil))
 }
 
 func BenchmarkFirewallTable_match(b *testing.B) {
@@ -219,11 +478,11 @@ func BenchmarkFirewallTable_match(b *testing.B) {
 	}
 
 	_, n, _ := net.ParseCIDR("172.1.1.1/32")
-	_ = ft.TCP.addRule(10, 10, []string{"good-group"}, "good-host", n, "", "")
-	_ = ft.TCP.addRule(10, 10, []string{"good-group2"}, "good-host", n, "", "")
-	_ = ft.TCP.addRule(10, 10, []string{"good-group3"}, "good-host", n, "", "")
-	_ = ft.TCP.addRule(10, 10, []string{"good-group4"}, "good-host", n, "", "")
-	_ = ft.TCP.addRule(10, 10, []string{"good-group, good-group1"}, "good-host", n, "", "")
+	_ = ft.TCP.addRule(10, 10, []string{"good-group"}, "good-host", n, nil, nil, "", "", nil)
+	_ = ft.TCP.addRule(10, 10, []string{"good-group2"}, "good-host", n, nil, nil, "", "", nil)
+	_ = ft.TCP.addRule(10, 10, []string{"good-group3"}, "good-host", n, nil, nil, "", "", nil)
+	_ = ft.TCP.addRule(10, 10, []string{"good-group4"}, "good-host", n, nil, nil, "", "", nil)
+	_ = ft.TCP.addRule(10, 10, []string{"good-group, good-group1"}, "good-host", n, nil, nil, "", "", nil)
 	cp := cert.NewCAPool()
 
 	b.Run("fail on proto", func(b *testing.B) {
@@ -279,7 +538,7 @@ func BenchmarkFirewallTable_match(b *testing.B) {
 	})
 
 	b.Run("pass on ip", func(b *testing.B) {
-		ip := iputil.Ip2VpnIp(net.IPv4(172, 1, 1, 1))
+		ip := netip.AddrFrom4([4]byte{172, 1, 1, 1})
 		c := &cert.NebulaCertificate{
 			Details: cert.NebulaCertificateDetails{
 				InvertedGroups: map[string]struct{}{"nope": {}},
@@ -291,10 +550,10 @@ func BenchmarkFirewallTable_match(b *testing.B) {
 		}
 	})
 
-	_ = ft.TCP.addRule(0, 0, []string{"good-group"}, "good-host", n, "", "")
+	_ = ft.TCP.addRule(0, 0, []string{"good-group"}, "good-host", n, nil, nil, "", "", nil)
 
 	b.Run("pass on ip with any port", func(b *testing.B) {
-		ip := iputil.Ip2VpnIp(net.IPv4(172, 1, 1, 1))
+		ip := netip.AddrFrom4([4]byte{172, 1, 1, 1})
 		c := &cert.NebulaCertificate{
 			Details: cert.NebulaCertificateDetails{
 				InvertedGroups: map[string]struct{}{"nope": {}},
@@ -313,12 +572,12 @@ func TestFirewall_Drop2(t *testing.T) {
 	l.SetOutput(ob)
 
 	p := firewall.Packet{
-		iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
-		iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
-		10,
-		90,
-		firewall.ProtoUDP,
-		false,
+		LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		LocalPort:  10,
+		RemotePort: 90,
+		Protocol:   firewall.ProtoUDP,
+		Fragment:   false,
 	}
 
 	ipNet := net.IPNet{
@@ -356,7 +615,7 @@ func TestFirewall_Drop2(t *testing.T) {
 	h1.CreateRemoteCIDR(&c1)
 
 	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"default-group", "test-group"}, "", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"default-group", "test-group"}}, 0, 0))
 	cp := cert.NewCAPool()
 
 	// h1/c1 lacks the proper groups
@@ -372,12 +631,12 @@ func TestFirewall_Drop3(t *testing.T) {
 	l.SetOutput(ob)
 
 	p := firewall.Packet{
-		iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
-		iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
-		1,
-		1,
-		firewall.ProtoUDP,
-		false,
+		LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		LocalPort:  1,
+		RemotePort: 1,
+		Protocol:   firewall.ProtoUDP,
+		Fragment:   false,
 	}
 
 	ipNet := net.IPNet{
@@ -438,8 +697,8 @@ func TestFirewall_Drop3(t *testing.T) {
 	h3.CreateRemoteCIDR(&c3)
 
 	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 1, 1, []string{}, "host1", nil, "", ""))
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 1, 1, []string{}, "", nil, "", "signer-sha"))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{}, Host: "host1"}, 1, 1))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{}, CASha: "signer-sha"}, 1, 1))
 	cp := cert.NewCAPool()
 
 	// c1 should pass because host match
@@ -458,12 +717,12 @@ func TestFirewall_DropConntrackReload(t *testing.T) {
 	l.SetOutput(ob)
 
 	p := firewall.Packet{
-		iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
-		iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 4)),
-		10,
-		90,
-		firewall.ProtoUDP,
-		false,
+		LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		LocalPort:  10,
+		RemotePort: 90,
+		Protocol:   firewall.ProtoUDP,
+		Fragment:   false,
 	}
 
 	ipNet := net.IPNet{
@@ -489,7 +748,7 @@ func TestFirewall_DropConntrackReload(t *testing.T) {
 	h.CreateRemoteCIDR(&c)
 
 	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 0, 0, []string{"any"}, "", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}}, 0, 0))
 	cp := cert.NewCAPool()
 
 	// Drop outbound
@@ -502,7 +761,7 @@ func TestFirewall_DropConntrackReload(t *testing.T) {
 
 	oldFw := fw
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 10, 10, []string{"any"}, "", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}}, 10, 10))
 	fw.Conntrack = oldFw.Conntrack
 	fw.rulesVersion = oldFw.rulesVersion + 1
 
@@ -511,7 +770,7 @@ func TestFirewall_DropConntrackReload(t *testing.T) {
 
 	oldFw = fw
 	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
-	assert.Nil(t, fw.AddRule(true, firewall.ProtoAny, 11, 11, []string{"any"}, "", nil, "", ""))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}}, 11, 11))
 	fw.Conntrack = oldFw.Conntrack
 	fw.rulesVersion = oldFw.rulesVersion + 1
 
@@ -519,6 +778,520 @@ func TestFirewall_DropConntrackReload(t *testing.T) {
 	assert.Equal(t, fw.Drop([]byte{}, p, false, &h, cp, nil), ErrNoMatchingRule)
 }
 
+func TestFirewall_DropDeny(t *testing.T) {
+	l := test.NewLogger()
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+
+	p := firewall.Packet{
+		LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		LocalPort:  22,
+		RemotePort: 90,
+		Protocol:   firewall.ProtoTCP,
+	}
+
+	ipNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "build-03",
+			Ips:            []*net.IPNet{&ipNet},
+			Groups:         []string{"eng"},
+			InvertedGroups: map[string]struct{}{"eng": {}},
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(ipNet.IP),
+	}
+	h.CreateRemoteCIDR(&c)
+	cp := cert.NewCAPool()
+
+	// Allow eng to port 22 except build-03, the order the rules are added in does not matter
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Deny: true, Proto: firewall.ProtoTCP, Groups: []string{}, Host: "build-03"}, 22, 22))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{"eng"}}, 22, 22))
+	assert.Equal(t, ErrDenyRule, fw.Drop([]byte{}, p, true, &h, cp, nil))
+
+	c.Details.Name = "build-04"
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+
+	// A deny rule on any protocol and port wins over a more specific allow
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{}, Host: "build-04"}, 22, 22))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Deny: true, Proto: firewall.ProtoAny, Groups: []string{"eng"}}, 0, 0))
+	assert.Equal(t, ErrDenyRule, fw.Drop([]byte{}, p, true, &h, cp, nil))
+
+	// Deny rules only apply to their direction
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, p, false, &h, cp, nil))
+
+	// A conntrack entry is dropped once a reload denies it
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{"eng"}}, 22, 22))
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+
+	oldFw := fw
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{"eng"}}, 22, 22))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Deny: true, Proto: firewall.ProtoTCP, Groups: []string{}, Host: "build-04"}, 22, 22))
+	fw.Conntrack = oldFw.Conntrack
+	fw.rulesVersion = oldFw.rulesVersion + 1
+	assert.Equal(t, ErrDenyRule, fw.Drop([]byte{}, p, true, &h, cp, nil))
+}
+
+func TestFirewall_DropLocalCIDR(t *testing.T) {
+	l := test.NewLogger()
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+
+	// A gateway routing two unsafe networks
+	myIpNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 1),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+	_, dbNet, _ := net.ParseCIDR("10.20.0.0/16")
+	_, webNet, _ := net.ParseCIDR("10.30.0.0/16")
+	myCert := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:    "gateway",
+			Ips:     []*net.IPNet{&myIpNet},
+			Subnets: []*net.IPNet{dbNet, webNet},
+		},
+	}
+
+	ipNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "host1",
+			Ips:            []*net.IPNet{&ipNet},
+			Groups:         []string{"dba"},
+			InvertedGroups: map[string]struct{}{"dba": {}},
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(ipNet.IP),
+	}
+	h.CreateRemoteCIDR(&c)
+	cp := cert.NewCAPool()
+
+	packet := func(local [4]byte, port uint16) firewall.Packet {
+		return firewall.Packet{
+			LocalIP:    netip.AddrFrom4(local),
+			RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+			LocalPort:  port,
+			RemotePort: 90,
+			Protocol:   firewall.ProtoUDP,
+		}
+	}
+
+	// dba may reach the databases but nothing else behind the gateway
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &myCert)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"dba"}, LocalIp: dbNet}, 5432, 5432))
+	assert.NoError(t, fw.Drop([]byte{}, packet([4]byte{10, 20, 1, 1}, 5432), true, &h, cp, nil))
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, packet([4]byte{10, 30, 1, 1}, 5432), true, &h, cp, nil))
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, packet([4]byte{10, 20, 1, 1}, 80), true, &h, cp, nil))
+
+	c.Details.InvertedGroups = map[string]struct{}{"eng": {}}
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, packet([4]byte{10, 20, 1, 2}, 5432), true, &h, cp, nil))
+	c.Details.InvertedGroups = map[string]struct{}{"dba": {}}
+
+	// Rules for the same host, or for overlapping cidrs, each keep their own local cidr
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &myCert)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{}, Host: "host1", LocalIp: webNet, Name: "web"}, 80, 80))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{}, Host: "host1", LocalIp: dbNet, Name: "db"}, 80, 80))
+	assert.NoError(t, fw.Drop([]byte{}, packet([4]byte{10, 30, 1, 1}, 80), true, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, packet([4]byte{10, 20, 1, 1}, 80), true, &h, cp, nil))
+
+	_, wide, _ := net.ParseCIDR("1.2.0.0/16")
+	_, narrow, _ := net.ParseCIDR("1.2.3.0/24")
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{}, Ip: wide, LocalIp: webNet, Name: "wide"}, 443, 443))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{}, Ip: narrow, LocalIp: dbNet, Name: "narrow"}, 443, 443))
+	assert.NoError(t, fw.Drop([]byte{}, packet([4]byte{10, 30, 1, 1}, 443), true, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, packet([4]byte{10, 20, 1, 1}, 443), true, &h, cp, nil))
+
+	// Hits are counted against the rule whose local cidr matched
+	assert.Equal(t, "web", fw.InRules.match(packet([4]byte{10, 30, 1, 1}, 80), true, &c, cp).Name)
+	assert.Equal(t, "db", fw.InRules.match(packet([4]byte{10, 20, 1, 1}, 80), true, &c, cp).Name)
+	assert.Equal(t, "wide", fw.InRules.match(packet([4]byte{10, 30, 1, 1}, 443), true, &c, cp).Name)
+	assert.Equal(t, "narrow", fw.InRules.match(packet([4]byte{10, 20, 1, 1}, 443), true, &c, cp).Name)
+
+	// Any peer limited to a local cidr leaves the rest of the rules in place
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &myCert)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"any"}, LocalIp: webNet}, 80, 80))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"dba"}, LocalIp: dbNet}, 80, 80))
+	assert.NoError(t, fw.Drop([]byte{}, packet([4]byte{10, 30, 1, 1}, 80), true, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, packet([4]byte{10, 20, 1, 1}, 80), true, &h, cp, nil))
+	c.Details.InvertedGroups = map[string]struct{}{"eng": {}}
+	assert.Equal(t, ErrNoMatchingRule, fw.Drop([]byte{}, packet([4]byte{10, 20, 1, 2}, 80), true, &h, cp, nil))
+	c.Details.InvertedGroups = map[string]struct{}{"dba": {}}
+
+	// Rules without a local cidr keep their hash
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &myCert)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"dba"}}, 80, 80))
+	assert.NotContains(t, fw.rules, "localIp")
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, &myCert)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"dba"}, LocalIp: dbNet}, 80, 80))
+	assert.Contains(t, fw.rules, "localIp: 10.20.0.0/16")
+}
+
+func Test_newFirewallLocalRule(t *testing.T) {
+	assert.False(t, newFirewallLocalRule(nil, nil).local.IsValid())
+
+	// A 16 byte ipv4 address keeps its 4 byte mask
+	lr := newFirewallLocalRule(&net.IPNet{IP: net.IPv4(10, 20, 1, 1), Mask: net.CIDRMask(16, 32)}, nil)
+	assert.Equal(t, netip.MustParsePrefix("10.20.0.0/16"), lr.local)
+
+	_, n, _ := net.ParseCIDR("fd00:1::/64")
+	lr = newFirewallLocalRule(n, nil)
+	assert.Equal(t, netip.MustParsePrefix("fd00:1::/64"), lr.local)
+
+	// Covering everything is the same as no local cidr
+	_, n, _ = net.ParseCIDR("0.0.0.0/0")
+	assert.False(t, newFirewallLocalRule(n, nil).local.IsValid())
+}
+
+func TestFirewall_GetRuleHash(t *testing.T) {
+	l := test.NewLogger()
+	c := &cert.NebulaCertificate{}
+
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{"eng"}}, 22, 22))
+	allow := fw.GetRuleHash()
+
+	// Allow rules hash the same as before deny rules existed
+	assert.Equal(t, "incoming: true, proto: 6, startPort: 22, endPort: 22, groups: [eng], host: , ip: , extensions: map[], caName: , caSha: \n", fw.rules)
+
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Deny: true, Proto: firewall.ProtoTCP, Groups: []string{"eng"}}, 22, 22))
+	assert.NotEqual(t, allow, fw.GetRuleHash())
+
+	fw = NewFirewall(l, time.Second, time.Minute, time.Hour, c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoTCP, Groups: []string{"eng"}}, 22, 22))
+	assert.Equal(t, allow, fw.GetRuleHash())
+}
+
+func TestFirewall_RuleStats(t *testing.T) {
+	l := test.NewLogger()
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+
+	p := firewall.Packet{
+		LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+		LocalPort:  22,
+		RemotePort: 90,
+		Protocol:   firewall.ProtoUDP,
+	}
+
+	ipNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "build-03",
+			Ips:            []*net.IPNet{&ipNet},
+			Groups:         []string{"eng"},
+			InvertedGroups: map[string]struct{}{"eng": {}},
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(ipNet.IP),
+	}
+	h.CreateRemoteCIDR(&c)
+	cp := cert.NewCAPool()
+
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"eng"}}, 53, 53))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"eng"}, Name: "stats-test-ssh"}, 22, 22))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{}, Ip: &ipNet, Name: "stats-test-cidr"}, 22, 22))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Deny: true, Proto: firewall.ProtoUDP, Groups: []string{}, Host: "build-04", Name: "stats-test-deny"}, 22, 22))
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Proto: firewall.ProtoAny, Groups: []string{"any"}}, 0, 0))
+
+	// Unnamed rules are named after their index within their direction
+	rules := listFirewallRules(fw)
+	assert.Len(t, rules, 5)
+	assert.Equal(t, "0", rules[0].Name)
+	assert.Equal(t, "incoming", rules[0].Direction)
+	assert.Equal(t, "allow", rules[0].Action)
+	assert.Equal(t, "incoming: true, proto: 17, startPort: 53, endPort: 53, groups: [eng], host: , ip: , extensions: map[], caName: , caSha: ", rules[0].Rule)
+	assert.Equal(t, "stats-test-ssh", rules[1].Name)
+	assert.Equal(t, "deny", rules[3].Action)
+	assert.Equal(t, "0", rules[4].Name)
+	assert.Equal(t, "outgoing", rules[4].Direction)
+
+	// Names are unique within a direction and can not be confused with an index
+	assert.EqualError(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"eng"}, Name: "stats-test-ssh"}, 22, 22), "rule name `stats-test-ssh` is used more than once")
+	assert.EqualError(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"eng"}, Name: "1"}, 22, 22), "rule name `1` can not be a number, numbers are used for unnamed rules")
+	assert.EqualError(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"eng"}, Name: "a.b"}, 22, 22), "rule name `a.b` may only contain letters, numbers, `-`, and `_`")
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Proto: firewall.ProtoTCP, Groups: []string{"eng"}, Name: "stats-test-ssh"}, 22, 22))
+	assert.Len(t, fw.ruleStats, 6)
+
+	ssh := fw.ruleStats[1]
+	cidrStats := fw.ruleStats[2]
+	deny := fw.ruleStats[3]
+	sshHits := ssh.Hits()
+
+	// Only the first packet of a flow is counted against the rule, conntrack handles the rest
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, firewall.ConntrackCache{}))
+	assert.Equal(t, sshHits+1, ssh.Hits())
+	assert.Equal(t, ssh.Hits(), metrics.GetOrRegisterCounter("firewall.incoming.rules.stats-test-ssh.hits", nil).Count())
+
+	// A flow is counted against the rule that matched it
+	resetConntrack(fw)
+	cidrHits := cidrStats.Hits()
+	c.Details.InvertedGroups = map[string]struct{}{}
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h, cp, nil))
+	assert.Equal(t, sshHits+1, ssh.Hits())
+	assert.Equal(t, cidrHits+1, cidrStats.Hits())
+
+	// Deny rules count the flows they block
+	resetConntrack(fw)
+	denyHits := deny.Hits()
+	c.Details.Name = "build-04"
+	assert.Equal(t, ErrDenyRule, fw.Drop([]byte{}, p, true, &h, cp, nil))
+	assert.Equal(t, ErrDenyRule, fw.Drop([]byte{}, p, true, &h, cp, nil))
+	assert.Equal(t, denyHits+2, deny.Hits())
+
+	// A reload keeps the counters of rules that still exist and stops reporting the rest
+	next := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, next.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoUDP, Groups: []string{"eng"}, Name: "stats-test-ssh"}, 22, 22))
+	assert.Equal(t, ssh.Hits(), next.ruleStats[0].Hits())
+	fw.unregisterRuleStats(next)
+	assert.NotNil(t, metrics.Get("firewall.incoming.rules.stats-test-ssh.hits"))
+	assert.Nil(t, metrics.Get("firewall.incoming.rules.stats-test-deny.hits"))
+	assert.Nil(t, metrics.Get("firewall.outgoing.rules.stats-test-ssh.hits"))
+}
+
+func TestFirewall_ConntrackLimits(t *testing.T) {
+	l := test.NewLogger()
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+
+	ipNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "host1",
+			Ips:            []*net.IPNet{&ipNet},
+			Groups:         []string{"default-group"},
+			InvertedGroups: map[string]struct{}{"default-group": {}},
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(ipNet.IP),
+	}
+	h.CreateRemoteCIDR(&c)
+	cp := cert.NewCAPool()
+
+	packet := func(port uint16) firewall.Packet {
+		return firewall.Packet{
+			LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+			RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+			LocalPort:  10,
+			RemotePort: port,
+			Protocol:   firewall.ProtoUDP,
+		}
+	}
+
+	// A full table evicts the entry closest to expiring
+	fw := NewFirewall(l, time.Second, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}}, 0, 0))
+	fw.MaxConntrackEntries = 2
+	evicted := fw.Conntrack.evicted.Count()
+
+	assert.NoError(t, fw.Drop([]byte{}, packet(1), true, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, packet(2), true, &h, cp, nil))
+
+	// Traffic on the first connection pushes its expiry out past the second
+	assert.NoError(t, fw.Drop([]byte{}, packet(1), true, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, packet(3), true, &h, cp, nil))
+	assert.Len(t, fw.Conntrack.Conns, 2)
+	assert.Contains(t, fw.Conntrack.Conns, packet(1))
+	assert.Contains(t, fw.Conntrack.Conns, packet(3))
+	assert.Equal(t, evicted+1, fw.Conntrack.evicted.Count())
+	assert.Equal(t, 2, fw.Conntrack.hostEntries[h.vpnIp])
+
+	// A host at its limit can not open more connections, existing ones keep working and other hosts are unaffected
+	fw.MaxConntrackEntries = 0
+	fw.MaxConntrackEntriesPerHost = 2
+	assert.Equal(t, ErrConntrackLimit, fw.Drop([]byte{}, packet(4), true, &h, cp, nil))
+	assert.NoError(t, fw.Drop([]byte{}, packet(1), true, &h, cp, nil))
+
+	h2 := HostInfo{
+		ConnectionState: h.ConnectionState,
+		vpnIp:           iputil.Ip2VpnIp(net.IPv4(1, 2, 3, 5)),
+	}
+	p := packet(4)
+	p.RemoteIP = netip.AddrFrom4([4]byte{1, 2, 3, 5})
+	assert.NoError(t, fw.Drop([]byte{}, p, true, &h2, cp, nil))
+	assert.Equal(t, 1, fw.Conntrack.hostEntries[h2.vpnIp])
+
+	// Expired entries give the host room again
+	fw.Conntrack.Lock()
+	fw.Conntrack.Conns[packet(1)].Expires = time.Now().Add(-time.Second)
+	fw.evict(packet(1))
+	fw.Conntrack.Unlock()
+	assert.Equal(t, 1, fw.Conntrack.hostEntries[h.vpnIp])
+	assert.NoError(t, fw.Drop([]byte{}, packet(5), true, &h, cp, nil))
+
+	// Limits come from the config
+	conf := config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"conntrack": map[interface{}]interface{}{"max_entries": 100, "max_entries_per_host": 10}}
+	fw, err := NewFirewallFromConfig(l, &c, conf)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, fw.MaxConntrackEntries)
+	assert.Equal(t, 10, fw.MaxConntrackEntriesPerHost)
+
+	conf.Settings["firewall"] = map[interface{}]interface{}{"conntrack": map[interface{}]interface{}{"max_entries": -1}}
+	_, err = NewFirewallFromConfig(l, &c, conf)
+	assert.EqualError(t, err, "firewall.conntrack.max_entries and max_entries_per_host can not be negative")
+}
+
+func TestFirewall_TCPState(t *testing.T) {
+	l := test.NewLogger()
+	ob := &bytes.Buffer{}
+	l.SetOutput(ob)
+
+	ipNet := net.IPNet{
+		IP:   net.IPv4(1, 2, 3, 4),
+		Mask: net.IPMask{255, 255, 255, 0},
+	}
+
+	c := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:           "host1",
+			Ips:            []*net.IPNet{&ipNet},
+			Groups:         []string{"default-group"},
+			InvertedGroups: map[string]struct{}{"default-group": {}},
+		},
+	}
+	h := HostInfo{
+		ConnectionState: &ConnectionState{
+			peerCert: &c,
+		},
+		vpnIp: iputil.Ip2VpnIp(ipNet.IP),
+	}
+	h.CreateRemoteCIDR(&c)
+	cp := cert.NewCAPool()
+
+	// An ipv6 version nibble keeps rtt tracking from looking at the rest of the packet
+	b := []byte{0x60}
+	packet := func(flags uint8) firewall.Packet {
+		return firewall.Packet{
+			LocalIP:    netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+			RemoteIP:   netip.AddrFrom4([4]byte{1, 2, 3, 4}),
+			LocalPort:  10,
+			RemotePort: 90,
+			Protocol:   firewall.ProtoTCP,
+			TCPFlags:   flags,
+		}
+	}
+
+	fw := NewFirewall(l, time.Hour, time.Minute, time.Hour, &c)
+	assert.Nil(t, fw.AddRule(FirewallRuleSpec{Incoming: true, Proto: firewall.ProtoAny, Groups: []string{"any"}}, 0, 0))
+	key := packet(0)
+
+	assertState := func(state uint8, timeout time.Duration) {
+		t.Helper()
+		cn := fw.Conntrack.Conns[key]
+		assert.NotNil(t, cn)
+		assert.Equal(t, state, cn.tcpState)
+		assert.WithinDuration(t, time.Now().Add(timeout), cn.Expires, time.Second)
+	}
+
+	// All packets of a connection share one entry
+	assert.NoError(t, fw.Drop(b, packet(tcpSYN), true, &h, cp, nil))
+	assert.Len(t, fw.Conntrack.Conns, 1)
+	assertState(tcpSynSent, defaultTCPSynSentTimeout)
+
+	// A retransmitted SYN does not move the connection along
+	assert.NoError(t, fw.Drop(b, packet(tcpSYN), true, &h, cp, nil))
+	assertState(tcpSynSent, defaultTCPSynSentTimeout)
+
+	// The responder answering establishes the connection
+	assert.NoError(t, fw.Drop(b, packet(tcpSYN|tcpACK), false, &h, cp, nil))
+	assertState(tcpEstablished, time.Hour)
+
+	// One side closing leaves the other half open
+	assert.NoError(t, fw.Drop(b, packet(tcpFIN|tcpACK), false, &h, cp, nil))
+	assertState(tcpFinWait, defaultTCPFinWaitTimeout)
+	assert.NoError(t, fw.Drop(b, packet(tcpACK), true, &h, cp, nil))
+	assertState(tcpFinWait, defaultTCPFinWaitTimeout)
+
+	assert.NoError(t, fw.Drop(b, packet(tcpFIN|tcpACK), true, &h, cp, nil))
+	assertState(tcpClose, defaultTCPCloseTimeout)
+
+	// The originator can reuse the ports for a new connection
+	assert.NoError(t, fw.Drop(b, packet(tcpSYN), true, &h, cp, nil))
+	assertState(tcpSynSent, defaultTCPSynSentTimeout)
+	assert.NoError(t, fw.Drop(b, packet(tcpSYN|tcpACK), false, &h, cp, nil))
+	assertState(tcpEstablished, time.Hour)
+
+	// A reset closes the connection right away, even if the packet would otherwise have hit the cache
+	cache := firewall.ConntrackCache{}
+	assert.NoError(t, fw.Drop(b, packet(tcpACK), true, &h, cp, cache))
+	assert.NoError(t, fw.Drop(b, packet(tcpRST), false, &h, cp, cache))
+	assertState(tcpClose, defaultTCPCloseTimeout)
+
+	// A connection picked up mid stream is assumed to be established
+	fw.Conntrack.Lock()
+	fw.Conntrack.delete(key)
+	fw.Conntrack.Unlock()
+	assert.NoError(t, fw.Drop(b, packet(tcpACK), true, &h, cp, nil))
+	assertState(tcpEstablished, time.Hour)
+
+	// No state is tracked longer than an established connection
+	fw.TCPTimeout = time.Second
+	assert.Equal(t, time.Second, fw.tcpTimeout(tcpSynSent))
+	assert.Equal(t, time.Second, fw.tcpTimeout(tcpClose))
+
+	// Timeouts come from the config and the timer wheel ticks often enough for the shortest
+	conf := config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"conntrack": map[interface{}]interface{}{
+		"tcp_syn_sent_timeout": "30s",
+		"tcp_fin_wait_timeout": "1m",
+		"tcp_close_timeout":    "2s",
+	}}
+	fw, err := NewFirewallFromConfig(l, &c, conf)
+	assert.Nil(t, err)
+	assert.Equal(t, 30*time.Second, fw.TCPSynSentTimeout)
+	assert.Equal(t, time.Minute, fw.TCPFinWaitTimeout)
+	assert.Equal(t, 2*time.Second, fw.TCPCloseTimeout)
+	assert.Equal(t, 2*time.Second, fw.Conntrack.TimerWheel.tickDuration)
+
+	// A zero or tiny timeout would break the timer wheel
+	for _, v := range []string{"0s", "1ns"} {
+		conf.Settings["firewall"] = map[interface{}]interface{}{"conntrack": map[interface{}]interface{}{"tcp_close_timeout": v}}
+		_, err = NewFirewallFromConfig(l, &c, conf)
+		assert.EqualError(t, err, "firewall.conntrack.tcp_syn_sent_timeout, tcp_fin_wait_timeout and tcp_close_timeout must be at least 1s")
+	}
+}
+
 func BenchmarkLookup(b *testing.B) {
 	ml := func(m map[string]struct{}, a [][]string) {
 		for n := 0; n < b.N; n++ {
@@ -653,7 +1426,7 @@ func TestNewFirewallFromConfig(t *testing.T) {
 	conf = config.NewC(l)
 	conf.Settings["firewall"] = map[interface{}]interface{}{"outbound": []interface{}{map[interface{}]interface{}{}}}
 	_, err = NewFirewallFromConfig(l, c, conf)
-	assert.EqualError(t, err, "firewall.outbound rule #0; at least one of host, group, cidr, ca_name, or ca_sha must be provided")
+	assert.EqualError(t, err, "firewall.outbound rule #0; at least one of host, group, cidr, local_cidr, extensions, ca_name, or ca_sha must be provided")
 
 	// Test code/port error
 	conf = config.NewC(l)
@@ -707,6 +1480,94 @@ func TestAddFirewallRulesFromConfig(t *testing.T) {
 	assert.Nil(t, AddFirewallRulesFromConfig(l, false, conf, mf))
 	assert.Equal(t, addRuleCall{incoming: false, proto: firewall.ProtoICMP, startPort: 1, endPort: 1, groups: nil, host: "a", ip: nil}, mf.lastCall)
 
+	// Test adding icmp type and code rules
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"outbound": []interface{}{map[interface{}]interface{}{"type": "echo-request", "code": 0, "proto": "icmp", "host": "a"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, false, conf, mf))
+	assert.Equal(t, addRuleCall{incoming: false, proto: firewall.ProtoICMP, host: "a", startType: 8, endType: 8, startCode: 0, endCode: 0}, mf.lastCall)
+
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"outbound": []interface{}{map[interface{}]interface{}{"type": "echo-request", "proto": "icmpv6", "host": "a"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, false, conf, mf))
+	assert.Equal(t, addRuleCall{incoming: false, proto: firewall.ProtoICMPv6, host: "a", startType: 128, endType: 128, startCode: firewall.ICMPAny, endCode: firewall.ICMPAny}, mf.lastCall)
+
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"outbound": []interface{}{map[interface{}]interface{}{"type": "any", "code": "1-3", "proto": "icmp", "host": "a"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, false, conf, mf))
+	assert.Equal(t, addRuleCall{incoming: false, proto: firewall.ProtoICMP, host: "a", startType: firewall.ICMPAny, endType: firewall.ICMPAny, startCode: 1, endCode: 3}, mf.lastCall)
+
+	conf = config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"outbound": []interface{}{map[interface{}]interface{}{"type": "8", "proto": "tcp", "host": "a"}}}
+	assert.EqualError(t, AddFirewallRulesFromConfig(l, false, conf, mf), "firewall.outbound rule #0; type and code can only be used with proto icmp or icmpv6")
+
+	conf = config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"outbound": []interface{}{map[interface{}]interface{}{"type": "8", "port": "any", "proto": "icmp", "host": "a"}}}
+	assert.EqualError(t, AddFirewallRulesFromConfig(l, false, conf, mf), "firewall.outbound rule #0; only one of port or type should be provided")
+
+	conf = config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"outbound": []interface{}{map[interface{}]interface{}{"type": "ping", "proto": "icmp", "host": "a"}}}
+	assert.EqualError(t, AddFirewallRulesFromConfig(l, false, conf, mf), "firewall.outbound rule #0; type was not a number; `ping`")
+
+	conf = config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"outbound": []interface{}{map[interface{}]interface{}{"type": "300", "proto": "icmp", "host": "a"}}}
+	assert.EqualError(t, AddFirewallRulesFromConfig(l, false, conf, mf), "firewall.outbound rule #0; type must be between 0 and 255; `300`")
+
+	// Test adding deny rules
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"action": "deny", "port": "22", "proto": "tcp", "host": "a"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, true, conf, mf))
+	assert.Equal(t, addRuleCall{incoming: true, deny: true, proto: firewall.ProtoTCP, startPort: 22, endPort: 22, host: "a"}, mf.lastCall)
+
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"action": "deny", "type": "redirect", "proto": "icmp", "host": "a"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, true, conf, mf))
+	assert.Equal(t, addRuleCall{incoming: true, deny: true, proto: firewall.ProtoICMP, host: "a", startType: 5, endType: 5, startCode: firewall.ICMPAny, endCode: firewall.ICMPAny}, mf.lastCall)
+
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"action": "allow", "port": "22", "proto": "tcp", "host": "a"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, true, conf, mf))
+	assert.False(t, mf.lastCall.deny)
+
+	conf = config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"action": "drop", "port": "22", "proto": "tcp", "host": "a"}}}
+	assert.EqualError(t, AddFirewallRulesFromConfig(l, true, conf, mf), "firewall.inbound rule #0; action was not understood; `drop`")
+
+	// Test adding named rules
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"name": "ssh", "port": "22", "proto": "tcp", "host": "a"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, true, conf, mf))
+	assert.Equal(t, addRuleCall{incoming: true, proto: firewall.ProtoTCP, startPort: 22, endPort: 22, host: "a", name: "ssh"}, mf.lastCall)
+
+	conf = config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"name": "ssh.v2", "port": "22", "proto": "tcp", "host": "a"}}}
+	assert.EqualError(t, AddFirewallRulesFromConfig(l, true, conf, NewFirewall(l, time.Second, time.Minute, time.Hour, &cert.NebulaCertificate{})), "firewall.inbound rule #0; `rule name `ssh.v2` may only contain letters, numbers, `-`, and `_``")
+
+	// Test adding rule with local_cidr
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"port": "5432", "proto": "tcp", "group": "dba", "local_cidr": "10.20.0.0/16"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, true, conf, mf))
+	_, localIp, _ := net.ParseCIDR("10.20.0.0/16")
+	assert.Equal(t, addRuleCall{incoming: true, proto: firewall.ProtoTCP, startPort: 5432, endPort: 5432, groups: []string{"dba"}, localIp: localIp}, mf.lastCall)
+
+	// A local_cidr alone is enough
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"port": "5432", "proto": "tcp", "local_cidr": "10.20.0.0/16"}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, true, conf, mf))
+	assert.Equal(t, localIp, mf.lastCall.localIp)
+
+	conf = config.NewC(l)
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"port": "5432", "proto": "tcp", "group": "dba", "local_cidr": "10.20.0.0"}}}
+	assert.EqualError(t, AddFirewallRulesFromConfig(l, true, conf, mf), "firewall.inbound rule #0; local_cidr did not parse; invalid CIDR address: 10.20.0.0")
+
 	// Test adding any rule
 	conf = config.NewC(l)
 	mf = &mockFirewall{}
@@ -749,6 +1610,18 @@ func TestAddFirewallRulesFromConfig(t *testing.T) {
 	assert.Nil(t, AddFirewallRulesFromConfig(l, true, conf, mf))
 	assert.Equal(t, addRuleCall{incoming: true, proto: firewall.ProtoAny, startPort: 1, endPort: 1, groups: []string{"a", "b"}, ip: nil}, mf.lastCall)
 
+	// Test extensions
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"port": "1", "proto": "any", "extensions": map[interface{}]interface{}{"env": "prod", "tier": 1}}}}
+	assert.Nil(t, AddFirewallRulesFromConfig(l, true, conf, mf))
+	assert.Equal(t, addRuleCall{incoming: true, proto: firewall.ProtoAny, startPort: 1, endPort: 1, extensions: map[string]string{"env": "prod", "tier": "1"}}, mf.lastCall)
+
+	conf = config.NewC(l)
+	mf = &mockFirewall{}
+	conf.Settings["firewall"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"port": "1", "proto": "any", "extensions": "env=prod"}}}
+	assert.EqualError(t, AddFirewallRulesFromConfig(l, true, conf, mf), "firewall.inbound rule #0; extensions should be a map of key and value pairs")
+
 	// Test Add error
 	conf = config.NewC(l)
 	mf = &mockFirewall{}
@@ -885,15 +1758,25 @@ func TestFirewall_convertRule(t *testing.T) {
 }
 
 type addRuleCall struct {
-	incoming  bool
-	proto     uint8
-	startPort int32
-	endPort   int32
-	groups    []string
-	host      string
-	ip        *net.IPNet
-	caName    string
-	caSha     string
+	incoming   bool
+	deny       bool
+	proto      uint8
+	startPort  int32
+	endPort    int32
+	groups     []string
+	host       string
+	ip         *net.IPNet
+	localIp    *net.IPNet
+	extensions map[string]string
+	caName     string
+	caSha      string
+	name       string
+
+	// Only set by AddICMPRule
+	startType int32
+	endType   int32
+	startCode int32
+	endCode   int32
 }
 
 type mockFirewall struct {
@@ -901,26 +1784,47 @@ type mockFirewall struct {
 	nextCallReturn error
 }
 
-func (mf *mockFirewall) AddRule(incoming bool, proto uint8, startPort int32, endPort int32, groups []string, host string, ip *net.IPNet, caName string, caSha string) error {
-	mf.lastCall = addRuleCall{
-		incoming:  incoming,
-		proto:     proto,
-		startPort: startPort,
-		endPort:   endPort,
-		groups:    groups,
-		host:      host,
-		ip:        ip,
-		caName:    caName,
-		caSha:     caSha,
-	}
+func (mf *mockFirewall) AddRule(r FirewallRuleSpec, startPort int32, endPort int32) error {
+	mf.lastCall = newAddRuleCall(r)
+	mf.lastCall.startPort = startPort
+	mf.lastCall.endPort = endPort
+
+	err := mf.nextCallReturn
+	mf.nextCallReturn = nil
+	return err
+}
+
+func (mf *mockFirewall) AddICMPRule(r FirewallRuleSpec, startType int32, endType int32, startCode int32, endCode int32) error {
+	mf.lastCall = newAddRuleCall(r)
+	mf.lastCall.startType = startType
+	mf.lastCall.endType = endType
+	mf.lastCall.startCode = startCode
+	mf.lastCall.endCode = endCode
 
 	err := mf.nextCallReturn
 	mf.nextCallReturn = nil
 	return err
 }
 
+func newAddRuleCall(r FirewallRuleSpec) addRuleCall {
+	return addRuleCall{
+		incoming:   r.Incoming,
+		deny:       r.Deny,
+		proto:      r.Proto,
+		groups:     r.Groups,
+		host:       r.Host,
+		ip:         r.Ip,
+		localIp:    r.LocalIp,
+		extensions: r.Extensions,
+		caName:     r.CAName,
+		caSha:      r.CASha,
+		name:       r.Name,
+	}
+}
+
 func resetConntrack(fw *Firewall) {
 	fw.Conntrack.Lock()
 	fw.Conntrack.Conns = map[firewall.Packet]*conn{}
+	fw.Conntrack.hostEntries = map[iputil.VpnIp]int{}
 	fw.Conntrack.Unlock()
 }
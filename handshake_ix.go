@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/flynn/noise"
+	"github.com/slackhq/nebula/cert"
 	"github.com/slackhq/nebula/header"
 	"github.com/slackhq/nebula/iputil"
 	"github.com/slackhq/nebula/udp"
@@ -99,6 +100,13 @@ func ixHandshakeStage1(f *Interface, addr *udp.Addr, via interface{}, packet []b
 			Info("Invalid certificate from host")
 		return
 	}
+
+	if remoteCert.Details.Curve != ci.certState.certificate.Details.Curve {
+		f.l.WithField("udpAddr", addr).WithField("cert", remoteCert).
+			WithField("handshake", m{"stage": 1, "style": "ix_psk0"}).
+			Info("Refusing to handshake with a host using a different curve")
+		return
+	}
 	vpnIp := iputil.Ip2VpnIp(remoteCert.Details.Ips[0].IP)
 	certName := remoteCert.Details.Name
 	fingerprint, _ := remoteCert.Sha256Sum()
@@ -120,6 +128,8 @@ func ixHandshakeStage1(f *Interface, addr *udp.Addr, via interface{}, packet []b
 		}
 	}
 
+	checkDeprecatedCA(f, remoteCert, vpnIp, addr, 1)
+
 	myIndex, err := generateIndex(f.l)
 	if err != nil {
 		f.l.WithError(err).WithField("vpnIp", vpnIp).WithField("udpAddr", addr).
@@ -209,7 +219,7 @@ func ixHandshakeStage1(f *Interface, addr *udp.Addr, via interface{}, packet []b
 	hostinfo.CreateRemoteCIDR(remoteCert)
 
 	// Only overwrite existing record if we should win the handshake race
-	overwrite := vpnIp > f.myVpnIp
+	overwrite := f.myVpnIp.Less(vpnIp)
 	existing, err := f.handshakeManager.CheckAndComplete(hostinfo, 0, overwrite, f)
 	if err != nil {
 		switch err {
@@ -423,11 +433,26 @@ func ixHandshakeStage2(f *Interface, addr *udp.Addr, via interface{}, hostinfo *
 		return true
 	}
 
+	if remoteCert.Details.Curve != ci.certState.certificate.Details.Curve {
+		f.l.WithField("vpnIp", hostinfo.vpnIp).WithField("udpAddr", addr).
+			WithField("cert", remoteCert).WithField("handshake", m{"stage": 2, "style": "ix_psk0"}).
+			Error("Refusing to handshake with a host using a different curve")
+
+		// The handshake state machine is complete, if things break now there is no chance to recover. Tear down and start again
+		return true
+	}
+
 	vpnIp := iputil.Ip2VpnIp(remoteCert.Details.Ips[0].IP)
 	certName := remoteCert.Details.Name
 	fingerprint, _ := remoteCert.Sha256Sum()
 	issuer := remoteCert.Details.Issuer
 
+	// A host may be handshaked with by any ip in its certificate, from now on it is tracked by the first
+	if vpnIp != hostinfo.vpnIp && certHasVpnIp(remoteCert, hostinfo.vpnIp) {
+		f.handshakeManager.pendingHostMap.DeleteVpnIp(hostinfo.vpnIp)
+		hostinfo.vpnIp = vpnIp
+	}
+
 	// Ensure the right host responded
 	if vpnIp != hostinfo.vpnIp {
 		f.l.WithField("intendedVpnIp", hostinfo.vpnIp).WithField("haveVpnIp", vpnIp).
@@ -471,6 +496,8 @@ func ixHandshakeStage2(f *Interface, addr *udp.Addr, via interface{}, hostinfo *
 	// Mark packet 2 as seen so it doesn't show up as missed
 	ci.window.Update(f.l, 2)
 
+	checkDeprecatedCA(f, remoteCert, vpnIp, addr, 2)
+
 	duration := time.Since(hostinfo.handshakeStart).Nanoseconds()
 	f.l.WithField("vpnIp", vpnIp).WithField("udpAddr", addr).
 		WithField("certName", certName).
@@ -509,3 +536,29 @@ func ixHandshakeStage2(f *Interface, addr *udp.Addr, via interface{}, hostinfo *
 
 	return false
 }
+
+// checkDeprecatedCA logs and counts handshakes with a host whose certificate was issued by a deprecated CA
+func checkDeprecatedCA(f *Interface, remoteCert *cert.NebulaCertificate, vpnIp iputil.VpnIp, addr *udp.Addr, stage int) {
+	deprecated := f.caPool.GetDeprecatedCA(remoteCert)
+	if deprecated == "" {
+		return
+	}
+
+	f.metricDeprecatedCAHandshakes.Inc(1)
+	f.l.WithField("vpnIp", vpnIp).WithField("udpAddr", addr).
+		WithField("certName", remoteCert.Details.Name).
+		WithField("issuer", remoteCert.Details.Issuer).
+		WithField("deprecatedCa", deprecated).
+		WithField("handshake", m{"stage": stage, "style": "ix_psk0"}).
+		Warn("Handshake with a host using a certificate issued by a deprecated CA")
+}
+
+// certHasVpnIp returns true if vpnIp is any of the ips in the certificate
+func certHasVpnIp(c *cert.NebulaCertificate, vpnIp iputil.VpnIp) bool {
+	for _, ip := range c.Details.Ips {
+		if iputil.Ip2VpnIp(ip.IP) == vpnIp {
+			return true
+		}
+	}
+	return false
+}
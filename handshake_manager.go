@@ -211,9 +211,8 @@ func (c *HandshakeManager) handleOutbound(vpnIp iputil.VpnIp, f udp.EncWriter, l
 					m := NebulaControl{
 						Type:                NebulaControl_CreateRelayRequest,
 						InitiatorRelayIndex: existingRelay.LocalIndex,
-						RelayFromIp:         uint32(c.lightHouse.myVpnIp),
-						RelayToIp:           uint32(vpnIp),
 					}
+					m.setRelayIps(c.lightHouse.myVpnIp, vpnIp)
 					msg, err := m.Marshal()
 					if err != nil {
 						hostinfo.logger(c.l).
@@ -240,9 +239,8 @@ func (c *HandshakeManager) handleOutbound(vpnIp iputil.VpnIp, f udp.EncWriter, l
 					m := NebulaControl{
 						Type:                NebulaControl_CreateRelayRequest,
 						InitiatorRelayIndex: idx,
-						RelayFromIp:         uint32(c.lightHouse.myVpnIp),
-						RelayToIp:           uint32(vpnIp),
 					}
+					m.setRelayIps(c.lightHouse.myVpnIp, vpnIp)
 					msg, err := m.Marshal()
 					if err != nil {
 						hostinfo.logger(c.l).
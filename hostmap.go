@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -53,6 +54,7 @@ type HostMap struct {
 	Relays          map[uint32]*HostInfo // Maps a Relay IDX to a Relay HostInfo object
 	RemoteIndexes   map[uint32]*HostInfo
 	Hosts           map[iputil.VpnIp]*HostInfo
+	altVpnIps       map[iputil.VpnIp]iputil.VpnIp // Maps the other vpn ips in a peer certificate to the vpnIp the host is stored under
 	preferredRanges []*net.IPNet
 	vpnCIDR         *net.IPNet
 	metricsEnabled  bool
@@ -121,7 +123,7 @@ func (rs *RelayState) RemoveRelay(localIdx uint32) (iputil.VpnIp, bool) {
 	defer rs.Unlock()
 	relay, ok := rs.relayForByIdx[localIdx]
 	if !ok {
-		return iputil.VpnIp(0), false
+		return iputil.VpnIp{}, false
 	}
 	delete(rs.relayForByIdx, localIdx)
 	delete(rs.relayForByIp, relay.PeerIp)
@@ -166,6 +168,7 @@ type HostInfo struct {
 	vpnIp             iputil.VpnIp
 	recvError         int
 	remoteCidr        *cidr.Tree4
+	remoteCidr6       *cidr.Tree6
 	relayState        RelayState
 
 	// lastRebindCount is the other side of Interface.rebindCount, if these values don't match then we need to ask LH
@@ -180,6 +183,9 @@ type HostInfo struct {
 
 	lastRoam       time.Time
 	lastRoamRemote *udp.Addr
+
+	// rateLimits caches the *hostRateLimits of this host so the limits are only matched against the cert once
+	rateLimits atomic.Value
 }
 
 type ViaSender struct {
@@ -213,6 +219,7 @@ func NewHostMap(l *logrus.Logger, name string, vpnCIDR *net.IPNet, preferredRang
 		Relays:          relays,
 		RemoteIndexes:   r,
 		Hosts:           h,
+		altVpnIps:       map[iputil.VpnIp]iputil.VpnIp{},
 		preferredRanges: preferredRanges,
 		vpnCIDR:         vpnCIDR,
 		l:               l,
@@ -445,6 +452,7 @@ func (hm *HostMap) unlockedDeleteHostInfo(hostinfo *HostInfo) {
 	if len(hm.Hosts) == 0 {
 		hm.Hosts = map[iputil.VpnIp]*HostInfo{}
 	}
+	hm.unlockedDeleteAltVpnIps(hostinfo)
 	delete(hm.Indexes, hostinfo.localIndexId)
 	if len(hm.Indexes) == 0 {
 		hm.Indexes = map[uint32]*HostInfo{}
@@ -507,7 +515,15 @@ func (hm *HostMap) PromoteBestQueryVpnIp(vpnIp iputil.VpnIp, ifce *Interface) (*
 
 func (hm *HostMap) queryVpnIp(vpnIp iputil.VpnIp, promoteIfce *Interface) (*HostInfo, error) {
 	hm.RLock()
-	if h, ok := hm.Hosts[vpnIp]; ok {
+	h, ok := hm.Hosts[vpnIp]
+	if !ok {
+		// The host may be stored under another ip in its certificate
+		if primary, found := hm.altVpnIps[vpnIp]; found {
+			h, ok = hm.Hosts[primary]
+		}
+	}
+
+	if ok {
 		hm.RUnlock()
 		// Do not attempt promotion if you are a lighthouse
 		if promoteIfce != nil && !promoteIfce.lightHouse.amLighthouse {
@@ -532,6 +548,7 @@ func (hm *HostMap) addHostInfo(hostinfo *HostInfo, f *Interface) {
 	hm.Hosts[hostinfo.vpnIp] = hostinfo
 	hm.Indexes[hostinfo.localIndexId] = hostinfo
 	hm.RemoteIndexes[hostinfo.remoteIndexId] = hostinfo
+	hm.unlockedAddAltVpnIps(hostinfo)
 
 	if hm.l.Level >= logrus.DebugLevel {
 		hm.l.WithField("hostMap", m{"mapName": hm.name, "vpnIp": hostinfo.vpnIp, "mapTotalSize": len(hm.Hosts),
@@ -540,6 +557,46 @@ func (hm *HostMap) addHostInfo(hostinfo *HostInfo, f *Interface) {
 	}
 }
 
+// We already have the hm Lock when this is called
+func (hm *HostMap) unlockedAddAltVpnIps(hostinfo *HostInfo) {
+	if hostinfo.ConnectionState == nil {
+		return
+	}
+
+	for _, vpnIp := range certAltVpnIps(hostinfo.ConnectionState.peerCert, hostinfo.vpnIp) {
+		hm.altVpnIps[vpnIp] = hostinfo.vpnIp
+	}
+}
+
+// We already have the hm Lock when this is called
+func (hm *HostMap) unlockedDeleteAltVpnIps(hostinfo *HostInfo) {
+	if hostinfo.ConnectionState == nil {
+		return
+	}
+
+	for _, vpnIp := range certAltVpnIps(hostinfo.ConnectionState.peerCert, hostinfo.vpnIp) {
+		if hm.altVpnIps[vpnIp] == hostinfo.vpnIp {
+			delete(hm.altVpnIps, vpnIp)
+		}
+	}
+}
+
+// certAltVpnIps returns the vpn ips in a certificate other than primary
+func certAltVpnIps(c *cert.NebulaCertificate, primary iputil.VpnIp) []iputil.VpnIp {
+	if c == nil {
+		return nil
+	}
+
+	var vpnIps []iputil.VpnIp
+	for _, ip := range c.Details.Ips {
+		vpnIp := iputil.Ip2VpnIp(ip.IP)
+		if vpnIp.IsValid() && vpnIp != primary {
+			vpnIps = append(vpnIps, vpnIp)
+		}
+	}
+	return vpnIps
+}
+
 // punchList assembles a list of all non nil RemoteList pointer entries in this hostmap
 // The caller can then do the its work outside of the read lock
 func (hm *HostMap) punchList(rl []*RemoteList) []*RemoteList {
@@ -750,14 +807,51 @@ func (i *HostInfo) CreateRemoteCIDR(c *cert.NebulaCertificate) {
 	}
 
 	remoteCidr := cidr.NewTree4()
+	var remoteCidr6 *cidr.Tree6
+	addCIDR := func(n *net.IPNet) {
+		if n.IP.To4() != nil {
+			remoteCidr.AddCIDR(n, struct{}{})
+			return
+		}
+
+		if remoteCidr6 == nil {
+			remoteCidr6 = cidr.NewTree6()
+		}
+		remoteCidr6.AddCIDR(n, struct{}{})
+	}
+
 	for _, ip := range c.Details.Ips {
-		remoteCidr.AddCIDR(&net.IPNet{IP: ip.IP, Mask: net.IPMask{255, 255, 255, 255}}, struct{}{})
+		if ip.IP.To4() != nil {
+			addCIDR(&net.IPNet{IP: ip.IP, Mask: net.IPMask{255, 255, 255, 255}})
+		} else {
+			addCIDR(&net.IPNet{IP: ip.IP, Mask: net.CIDRMask(128, 128)})
+		}
 	}
 
 	for _, n := range c.Details.Subnets {
-		remoteCidr.AddCIDR(n, struct{}{})
+		addCIDR(n)
 	}
 	i.remoteCidr = remoteCidr
+	i.remoteCidr6 = remoteCidr6
+}
+
+// allowsRemoteIP returns true if the address is covered by the ips and subnets in the remote certificate
+func (i *HostInfo) allowsRemoteIP(ip netip.Addr) bool {
+	if i.remoteCidr == nil {
+		// Simple case: Certificate has one IP and no subnets
+		return iputil.AddrToVpnIp(ip) == i.vpnIp
+	}
+
+	if ip.Is4() {
+		return i.remoteCidr.Contains(iputil.AddrToVpnIp(ip)) != nil
+	}
+
+	if i.remoteCidr6 == nil {
+		return false
+	}
+
+	hi, lo := iputil.AddrToHiLo(ip)
+	return i.remoteCidr6.MostSpecificContainsIpV6(hi, lo) != nil
 }
 
 func (i *HostInfo) logger(l *logrus.Logger) *logrus.Entry {
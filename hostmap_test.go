@@ -1 +1,110 @@
 package nebula
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/iputil"
+	"github.com/slackhq/nebula/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostMap_QueryVpnIpAlt(t *testing.T) {
+	l := test.NewLogger()
+	_, vpncidr, _ := net.ParseCIDR("172.1.1.1/24")
+	hm := NewHostMap(l, "test", vpncidr, nil)
+
+	_, ip6, _ := net.ParseCIDR("fd00::2/64")
+	ip6.IP = net.ParseIP("fd00::2")
+	c := &cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name: "host1",
+			Ips:  []*net.IPNet{{IP: net.IPv4(172, 1, 1, 2), Mask: net.IPMask{255, 255, 255, 0}}, ip6},
+		},
+	}
+
+	hostinfo := &HostInfo{
+		ConnectionState: &ConnectionState{peerCert: c},
+		vpnIp:           iputil.Ip2VpnIp(net.IPv4(172, 1, 1, 2)),
+		localIndexId:    1,
+		remoteIndexId:   2,
+	}
+
+	alt := iputil.AddrToVpnIp(netip.MustParseAddr("fd00::2"))
+	_, err := hm.QueryVpnIp(alt)
+	assert.NotNil(t, err)
+
+	hm.Lock()
+	hm.addHostInfo(hostinfo, &Interface{})
+	hm.Unlock()
+
+	// Both ips in the certificate find the host
+	h, err := hm.QueryVpnIp(hostinfo.vpnIp)
+	assert.Nil(t, err)
+	assert.Equal(t, hostinfo, h)
+
+	h, err = hm.QueryVpnIp(alt)
+	assert.Nil(t, err)
+	assert.Equal(t, hostinfo, h)
+
+	_, err = hm.QueryVpnIp(iputil.AddrToVpnIp(netip.MustParseAddr("fd00::3")))
+	assert.NotNil(t, err)
+
+	// Removing the host removes the alternate ips with it
+	hm.DeleteHostInfo(hostinfo)
+	_, err = hm.QueryVpnIp(alt)
+	assert.NotNil(t, err)
+	assert.Empty(t, hm.altVpnIps)
+}
+
+func TestHostMap_QueryVpnIpV6Only(t *testing.T) {
+	l := test.NewLogger()
+	_, vpncidr, _ := net.ParseCIDR("fd00::1/64")
+	hm := NewHostMap(l, "test", vpncidr, nil)
+
+	_, ip6, _ := net.ParseCIDR("fd00::2/64")
+	ip6.IP = net.ParseIP("fd00::2")
+	hostinfo := &HostInfo{
+		ConnectionState: &ConnectionState{peerCert: &cert.NebulaCertificate{
+			Details: cert.NebulaCertificateDetails{Name: "host1", Ips: []*net.IPNet{ip6}},
+		}},
+		vpnIp:         iputil.Ip2VpnIp(ip6.IP),
+		localIndexId:  1,
+		remoteIndexId: 2,
+	}
+
+	hm.Lock()
+	hm.addHostInfo(hostinfo, &Interface{})
+	hm.Unlock()
+
+	h, err := hm.QueryVpnIp(iputil.AddrToVpnIp(netip.MustParseAddr("fd00::2")))
+	assert.Nil(t, err)
+	assert.Equal(t, hostinfo, h)
+	assert.Empty(t, hm.altVpnIps)
+}
+
+func TestHostInfo_allowsRemoteIP(t *testing.T) {
+	_, ip6, _ := net.ParseCIDR("fd00::2/64")
+	ip6.IP = net.ParseIP("fd00::2")
+
+	// A single ipv6 address needs no tree
+	h := &HostInfo{vpnIp: iputil.Ip2VpnIp(ip6.IP)}
+	c := &cert.NebulaCertificate{Details: cert.NebulaCertificateDetails{Ips: []*net.IPNet{ip6}}}
+	h.CreateRemoteCIDR(c)
+	assert.True(t, h.allowsRemoteIP(netip.MustParseAddr("fd00::2")))
+	assert.False(t, h.allowsRemoteIP(netip.MustParseAddr("fd00::3")))
+	assert.False(t, h.allowsRemoteIP(netip.MustParseAddr("10.0.0.2")))
+
+	// Both families with an ipv4 subnet
+	h = &HostInfo{vpnIp: iputil.Ip2VpnIp(net.IPv4(10, 0, 0, 2))}
+	c.Details.Ips = []*net.IPNet{{IP: net.IPv4(10, 0, 0, 2), Mask: net.IPMask{255, 255, 255, 0}}, ip6}
+	c.Details.Subnets = []*net.IPNet{{IP: net.IPv4(192, 168, 0, 0), Mask: net.IPMask{255, 255, 0, 0}}}
+	h.CreateRemoteCIDR(c)
+	assert.True(t, h.allowsRemoteIP(netip.MustParseAddr("10.0.0.2")))
+	assert.True(t, h.allowsRemoteIP(netip.MustParseAddr("fd00::2")))
+	assert.True(t, h.allowsRemoteIP(netip.MustParseAddr("192.168.1.1")))
+	assert.False(t, h.allowsRemoteIP(netip.MustParseAddr("10.0.0.3")))
+	assert.False(t, h.allowsRemoteIP(netip.MustParseAddr("fd00::3")))
+}
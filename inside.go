@@ -18,12 +18,14 @@ func (f *Interface) consumeInsidePacket(packet []byte, fwPacket *firewall.Packet
 		return
 	}
 
+	vpnIp := iputil.AddrToVpnIp(fwPacket.RemoteIP)
+
 	// Ignore local broadcast packets
-	if f.dropLocalBroadcast && fwPacket.RemoteIP == f.localBroadcast {
+	if f.dropLocalBroadcast && vpnIp == f.localBroadcast {
 		return
 	}
 
-	if fwPacket.RemoteIP == f.myVpnIp {
+	if f.isMyVpnIp(vpnIp) {
 		// Immediately forward packets from self to self.
 		// This should only happen on Darwin-based hosts, which routes packets from
 		// the Nebula IP to the Nebula IP through the Nebula TUN device.
@@ -39,11 +41,11 @@ func (f *Interface) consumeInsidePacket(packet []byte, fwPacket *firewall.Packet
 	}
 
 	// Ignore broadcast packets
-	if f.dropMulticast && isMulticast(fwPacket.RemoteIP) {
+	if f.dropMulticast && fwPacket.RemoteIP.IsMulticast() {
 		return
 	}
 
-	hostinfo := f.getOrHandshake(fwPacket.RemoteIP)
+	hostinfo := f.getOrHandshake(vpnIp)
 	if hostinfo == nil {
 		if f.l.Level >= logrus.DebugLevel {
 			f.l.WithField("vpnIp", fwPacket.RemoteIP).
@@ -67,6 +69,9 @@ func (f *Interface) consumeInsidePacket(packet []byte, fwPacket *firewall.Packet
 	}
 
 	dropReason := f.firewall.Drop(packet, *fwPacket, false, hostinfo, f.caPool, localCache)
+	if dropReason == nil {
+		dropReason = f.rateLimiter.Drop(hostinfo, false, len(packet))
+	}
 	if dropReason == nil {
 		f.sendNoMetrics(header.Message, 0, ci, hostinfo, nil, packet, nb, out, q)
 
@@ -84,10 +89,9 @@ func (f *Interface) Handshake(vpnIp iputil.VpnIp) {
 
 // getOrHandshake returns nil if the vpnIp is not routable
 func (f *Interface) getOrHandshake(vpnIp iputil.VpnIp) *HostInfo {
-	//TODO: we can find contains without converting back to bytes
-	if f.hostMap.vpnCIDR.Contains(vpnIp.ToIP()) == false {
+	if !f.inMyVpnNets(vpnIp) {
 		vpnIp = f.inside.RouteFor(vpnIp)
-		if vpnIp == 0 {
+		if !vpnIp.IsValid() {
 			return nil
 		}
 	}
@@ -151,6 +155,9 @@ func (f *Interface) sendMessageNow(t header.MessageType, st header.MessageSubTyp
 
 	// check if packet is in outbound fw rules
 	dropReason := f.firewall.Drop(p, *fp, false, hostInfo, f.caPool, nil)
+	if dropReason == nil {
+		dropReason = f.rateLimiter.Drop(hostInfo, false, len(p))
+	}
 	if dropReason != nil {
 		if f.l.Level >= logrus.DebugLevel {
 			f.l.WithField("fwPacket", fp).
@@ -344,12 +351,3 @@ func (f *Interface) sendNoMetrics(t header.MessageType, st header.MessageSubType
 	}
 	return
 }
-
-func isMulticast(ip iputil.VpnIp) bool {
-	// Class D multicast
-	if (((ip >> 24) & 0xff) & 0xf0) == 0xe0 {
-		return true
-	}
-
-	return false
-}
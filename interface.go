@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"os"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -23,6 +25,10 @@ import (
 
 const mtu = 9001
 
+// firewallDestroyDelay is how long a replaced firewall is kept around for the packets that were already being checked
+// against it before it is destroyed
+const firewallDestroyDelay = 5 * time.Second
+
 type InterfaceConfig struct {
 	HostMap                 *HostMap
 	Outside                 *udp.Conn
@@ -30,6 +36,7 @@ type InterfaceConfig struct {
 	certState               *CertState
 	Cipher                  string
 	Firewall                *Firewall
+	RateLimiter             *RateLimiter
 	ServeDns                bool
 	HandshakeManager        *HandshakeManager
 	lightHouse              *LightHouse
@@ -55,6 +62,9 @@ type Interface struct {
 	certState          *CertState
 	cipher             string
 	firewall           *Firewall
+	firewallLock       sync.Mutex
+	firewallConfig     *config.C
+	rateLimiter        *RateLimiter
 	connectionManager  *connectionManager
 	handshakeManager   *HandshakeManager
 	serveDns           bool
@@ -62,6 +72,7 @@ type Interface struct {
 	lightHouse         *LightHouse
 	localBroadcast     iputil.VpnIp
 	myVpnIp            iputil.VpnIp
+	myVpnNets          []netip.Prefix // Every ip in our certificate with its network, myVpnIp is the first
 	dropLocalBroadcast bool
 	dropMulticast      bool
 	routines           int
@@ -81,9 +92,10 @@ type Interface struct {
 	writers []*udp.Conn
 	readers []io.ReadWriteCloser
 
-	metricHandshakes    metrics.Histogram
-	messageMetrics      *MessageMetrics
-	cachedPacketMetrics *cachedPacketMetrics
+	metricHandshakes             metrics.Histogram
+	metricDeprecatedCAHandshakes metrics.Counter
+	messageMetrics               *MessageMetrics
+	cachedPacketMetrics          *cachedPacketMetrics
 
 	l *logrus.Logger
 }
@@ -137,6 +149,26 @@ func NewInterface(ctx context.Context, c *InterfaceConfig) (*Interface, error) {
 	}
 
 	myVpnIp := iputil.Ip2VpnIp(c.certState.certificate.Details.Ips[0].IP)
+
+	var myVpnNets []netip.Prefix
+	var localBroadcast iputil.VpnIp
+	for _, ip := range c.certState.certificate.Details.Ips {
+		p, err := iputil.ToNetIpPrefix(*ip)
+		if err != nil {
+			return nil, err
+		}
+		myVpnNets = append(myVpnNets, p)
+
+		// ipv6 has no broadcast address, only the first ipv4 network has one
+		if p.Addr().Is4() && !localBroadcast.IsValid() {
+			b := p.Addr().As4()
+			for i := range b {
+				b[i] |= ^ip.Mask[len(ip.Mask)-4+i]
+			}
+			localBroadcast = iputil.AddrToVpnIp(netip.AddrFrom4(b))
+		}
+	}
+
 	ifce := &Interface{
 		hostMap:            c.HostMap,
 		outside:            c.Outside,
@@ -144,11 +176,12 @@ func NewInterface(ctx context.Context, c *InterfaceConfig) (*Interface, error) {
 		certState:          c.certState,
 		cipher:             c.Cipher,
 		firewall:           c.Firewall,
+		rateLimiter:        c.RateLimiter,
 		serveDns:           c.ServeDns,
 		handshakeManager:   c.HandshakeManager,
 		createTime:         time.Now(),
 		lightHouse:         c.lightHouse,
-		localBroadcast:     myVpnIp | ^iputil.Ip2VpnIp(c.certState.certificate.Details.Ips[0].Mask),
+		localBroadcast:     localBroadcast,
 		dropLocalBroadcast: c.DropLocalBroadcast,
 		dropMulticast:      c.DropMulticast,
 		routines:           c.routines,
@@ -158,12 +191,14 @@ func NewInterface(ctx context.Context, c *InterfaceConfig) (*Interface, error) {
 		caPool:             c.caPool,
 		disconnectInvalid:  c.disconnectInvalid,
 		myVpnIp:            myVpnIp,
+		myVpnNets:          myVpnNets,
 		relayManager:       c.relayManager,
 
 		conntrackCacheTimeout: c.ConntrackCacheTimeout,
 
-		metricHandshakes: metrics.GetOrRegisterHistogram("handshakes", nil, metrics.NewExpDecaySample(1028, 0.015)),
-		messageMetrics:   c.MessageMetrics,
+		metricHandshakes:             metrics.GetOrRegisterHistogram("handshakes", nil, metrics.NewExpDecaySample(1028, 0.015)),
+		metricDeprecatedCAHandshakes: metrics.GetOrRegisterCounter("handshakes.deprecated_ca", nil),
+		messageMetrics:               c.MessageMetrics,
 		cachedPacketMetrics: &cachedPacketMetrics{
 			sent:    metrics.GetOrRegisterCounter("hostinfo.cached_packets.sent", nil),
 			dropped: metrics.GetOrRegisterCounter("hostinfo.cached_packets.dropped", nil),
@@ -177,6 +212,30 @@ func NewInterface(ctx context.Context, c *InterfaceConfig) (*Interface, error) {
 	return ifce, nil
 }
 
+// isMyVpnIp returns true if vpnIp is one of the ips in our certificate
+func (f *Interface) isMyVpnIp(vpnIp iputil.VpnIp) bool {
+	addr := vpnIp.ToNetIpAddr()
+	for _, p := range f.myVpnNets {
+		if p.Addr() == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inMyVpnNets returns true if vpnIp is within the network of any ip in our certificate
+func (f *Interface) inMyVpnNets(vpnIp iputil.VpnIp) bool {
+	addr := vpnIp.ToNetIpAddr()
+	for _, p := range f.myVpnNets {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // activate creates the interface on the host. After the interface is created, any
 // other services that want to bind listeners to its IP may do so successfully. However,
 // the interface isn't going to process anything until run() is called.
@@ -270,6 +329,7 @@ func (f *Interface) RegisterConfigChangeCallbacks(c *config.C) {
 	c.RegisterReloadCallback(f.reloadCA)
 	c.RegisterReloadCallback(f.reloadCertKey)
 	c.RegisterReloadCallback(f.reloadFirewall)
+	c.RegisterReloadCallback(f.reloadRateLimiter)
 	c.RegisterReloadCallback(f.reloadSendRecvError)
 	for _, udpConn := range f.writers {
 		c.RegisterReloadCallback(udpConn.ReloadConfig)
@@ -285,6 +345,11 @@ func (f *Interface) reloadCA(c *config.C) {
 		return
 	}
 
+	// Keep any newer revocation lists we may have learned from the lighthouses
+	for _, crl := range f.caPool.GetRevocationLists() {
+		newCAs.AddRevocationList(crl)
+	}
+
 	f.caPool = newCAs
 	f.l.WithField("fingerprints", f.caPool.GetFingerprints()).Info("Trusted CA certificates refreshed")
 }
@@ -311,17 +376,33 @@ func (f *Interface) reloadCertKey(c *config.C) {
 
 func (f *Interface) reloadFirewall(c *config.C) {
 	//TODO: need to trigger/detect if the certificate changed too
-	if c.HasChanged("firewall") == false {
+	if c.HasChanged("firewall") == false && !f.firewall.rulesPathChanged() {
 		f.l.Debug("No firewall config change detected")
 		return
 	}
 
-	fw, err := NewFirewallFromConfig(f.l, f.certState.certificate, c)
+	err := f.replaceFirewall(newFirewallConfig(f.l, c))
 	if err != nil {
 		f.l.WithError(err).Error("Error while creating firewall during reload")
-		return
+	}
+}
+
+// replaceFirewall builds a new firewall from c and installs it, the conntrack entries are kept and revalidated against
+// the new rules. Later changes to firewall.rules_path are built from c as well, a nil c rebuilds from the last one.
+func (f *Interface) replaceFirewall(c *config.C) error {
+	f.firewallLock.Lock()
+	defer f.firewallLock.Unlock()
+
+	if c == nil {
+		c = f.firewallConfig
 	}
 
+	fw, err := NewFirewallFromConfig(f.l, f.certState.certificate, c)
+	if err != nil {
+		return err
+	}
+	f.firewallConfig = c
+
 	oldFw := f.firewall
 	conntrack := oldFw.Conntrack
 	conntrack.Lock()
@@ -339,13 +420,35 @@ func (f *Interface) reloadFirewall(c *config.C) {
 		fw.Conntrack = conntrack
 	}
 
+	if err := fw.dropLog.shareFile(oldFw.dropLog); err != nil {
+		f.l.WithError(err).Error("Failed to close the duplicate firewall drop log")
+	}
+
 	f.firewall = fw
 
-	oldFw.Destroy()
+	oldFw.unregisterRuleStats(fw)
+	time.AfterFunc(firewallDestroyDelay, oldFw.Destroy)
 	f.l.WithField("firewallHash", fw.GetRuleHash()).
 		WithField("oldFirewallHash", oldFw.GetRuleHash()).
 		WithField("rulesVersion", fw.rulesVersion).
 		Info("New firewall has been installed")
+	return nil
+}
+
+func (f *Interface) reloadRateLimiter(c *config.C) {
+	if !c.HasChanged("rate_limits") {
+		return
+	}
+
+	rl, err := NewRateLimiterFromConfig(f.l, c)
+	if err != nil {
+		f.l.WithError(err).Error("Error while loading rate limits during reload")
+		return
+	}
+
+	// Hosts notice the new limiter on their next packet and start over with full buckets
+	f.rateLimiter = rl
+	f.l.Info("New rate limits have been installed")
 }
 
 func (f *Interface) reloadSendRecvError(c *config.C) {
@@ -7,50 +7,93 @@ import (
 	"net/netip"
 )
 
-type VpnIp uint32
-
-const maxIPv4StringLen = len("255.255.255.255")
+// VpnIp is an address on the overlay network, either ipv4 or ipv6. It is always unmapped so an ipv4 address compares
+// equal no matter how it was parsed. The zero value is not a valid address.
+type VpnIp netip.Addr
 
 func (ip VpnIp) String() string {
-	b := make([]byte, maxIPv4StringLen)
-
-	n := ubtoa(b, 0, byte(ip>>24))
-	b[n] = '.'
-	n++
-
-	n += ubtoa(b, n, byte(ip>>16&255))
-	b[n] = '.'
-	n++
-
-	n += ubtoa(b, n, byte(ip>>8&255))
-	b[n] = '.'
-	n++
-
-	n += ubtoa(b, n, byte(ip&255))
-	return string(b[:n])
+	return netip.Addr(ip).String()
 }
 
 func (ip VpnIp) MarshalJSON() ([]byte, error) {
 	return []byte(fmt.Sprintf("\"%s\"", ip.String())), nil
 }
 
+// ToIP returns the address as a 4 byte net.IP for ipv4 or 16 byte net.IP for ipv6
 func (ip VpnIp) ToIP() net.IP {
-	nip := make(net.IP, 4)
-	binary.BigEndian.PutUint32(nip, uint32(ip))
-	return nip
+	return netip.Addr(ip).AsSlice()
 }
 
 func (ip VpnIp) ToNetIpAddr() netip.Addr {
-	var nip [4]byte
-	binary.BigEndian.PutUint32(nip[:], uint32(ip))
-	return netip.AddrFrom4(nip)
+	return netip.Addr(ip)
+}
+
+func (ip VpnIp) IsValid() bool {
+	return netip.Addr(ip).IsValid()
+}
+
+func (ip VpnIp) Is4() bool {
+	return netip.Addr(ip).Is4()
+}
+
+// Uint32 returns an ipv4 address as the uint32 used on the wire and by cidr.Tree4, it returns 0 for an ipv6 address
+func (ip VpnIp) Uint32() uint32 {
+	if !ip.Is4() {
+		return 0
+	}
+
+	b := netip.Addr(ip).As4()
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// HiLo returns the high and low 64 bits of the address as used on the wire for ipv6, ipv4 addresses are ipv4 mapped
+func (ip VpnIp) HiLo() (hi, lo uint64) {
+	return AddrToHiLo(netip.Addr(ip))
+}
+
+// Less orders ipv4 before ipv6, then by address
+func (ip VpnIp) Less(other VpnIp) bool {
+	return netip.Addr(ip).Less(netip.Addr(other))
 }
 
+// Ip2VpnIp converts a 4 or 16 byte ip to a VpnIp, an ipv4 mapped ipv6 address becomes the ipv4 address
 func Ip2VpnIp(ip []byte) VpnIp {
-	if len(ip) == 16 {
-		return VpnIp(binary.BigEndian.Uint32(ip[12:16]))
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return VpnIp{}
 	}
-	return VpnIp(binary.BigEndian.Uint32(ip))
+	return VpnIp(addr.Unmap())
+}
+
+// Uint32ToVpnIp is the inverse of VpnIp.Uint32
+func Uint32ToVpnIp(ip uint32) VpnIp {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], ip)
+	return VpnIp(netip.AddrFrom4(b))
+}
+
+// HiLoToVpnIp is the inverse of VpnIp.HiLo
+func HiLoToVpnIp(hi, lo uint64) VpnIp {
+	return VpnIp(HiLoToAddr(hi, lo).Unmap())
+}
+
+// AddrToVpnIp converts a netip.Addr to a VpnIp
+func AddrToVpnIp(addr netip.Addr) VpnIp {
+	return VpnIp(addr.Unmap())
+}
+
+// AddrToHiLo splits an ipv6 netip.Addr into the high and low 64 bits, as used by Ip6AndPort and cidr.Tree6
+func AddrToHiLo(addr netip.Addr) (hi, lo uint64) {
+	b := addr.As16()
+	return binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+}
+
+// HiLoToAddr is the inverse of AddrToHiLo
+func HiLoToAddr(hi, lo uint64) netip.Addr {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], hi)
+	binary.BigEndian.PutUint64(b[8:], lo)
+	return netip.AddrFrom16(b)
 }
 
 func ToNetIpAddr(ip net.IP) (netip.Addr, error) {
@@ -72,22 +115,3 @@ func ToNetIpPrefix(ipNet net.IPNet) (netip.Prefix, error) {
 	}
 	return netip.PrefixFrom(addr, ones), nil
 }
-
-// ubtoa encodes the string form of the integer v to dst[start:] and
-// returns the number of bytes written to dst. The caller must ensure
-// that dst has sufficient length.
-func ubtoa(dst []byte, start int, v byte) int {
-	if v < 10 {
-		dst[start] = v + '0'
-		return 1
-	} else if v < 100 {
-		dst[start+1] = v%10 + '0'
-		dst[start] = v/10 + '0'
-		return 2
-	}
-
-	dst[start+2] = v%10 + '0'
-	dst[start+1] = (v/10)%10 + '0'
-	dst[start] = v/100 + '0'
-	return 3
-}
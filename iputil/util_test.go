@@ -2,6 +2,7 @@ package iputil
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,3 +16,15 @@ func TestVpnIp_String(t *testing.T) {
 	assert.Equal(t, "1.1.1.1", Ip2VpnIp(net.ParseIP("1.1.1.1")).String())
 	assert.Equal(t, "0.0.0.0", Ip2VpnIp(net.ParseIP("0.0.0.0")).String())
 }
+
+func TestAddrToVpnIp(t *testing.T) {
+	assert.Equal(t, Ip2VpnIp(net.ParseIP("10.1.2.3")), AddrToVpnIp(netip.MustParseAddr("10.1.2.3")))
+	assert.Equal(t, "255.255.255.255", AddrToVpnIp(netip.MustParseAddr("255.255.255.255")).String())
+}
+
+func TestAddrToHiLo(t *testing.T) {
+	hi, lo := AddrToHiLo(netip.MustParseAddr("fd00:1:2:3:4:5:6:7"))
+	assert.Equal(t, uint64(0xfd00000100020003), hi)
+	assert.Equal(t, uint64(0x0004000500060007), lo)
+	assert.Equal(t, netip.MustParseAddr("fd00:1:2:3:4:5:6:7"), HiLoToAddr(hi, lo))
+}
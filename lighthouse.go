@@ -13,6 +13,7 @@ import (
 
 	"github.com/rcrowley/go-metrics"
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/nebula/cert"
 	"github.com/slackhq/nebula/config"
 	"github.com/slackhq/nebula/header"
 	"github.com/slackhq/nebula/iputil"
@@ -35,7 +36,6 @@ type LightHouse struct {
 	sync.RWMutex //Because we concurrently read and write to our maps
 	amLighthouse bool
 	myVpnIp      iputil.VpnIp
-	myVpnZeros   iputil.VpnIp
 	myVpnNet     *net.IPNet
 	punchConn    *udp.Conn
 	punchy       *Punchy
@@ -44,6 +44,13 @@ type LightHouse struct {
 	// map of vpn Ip to answers
 	addrMap map[iputil.VpnIp]*RemoteList
 
+	// used by a lighthouse to find the owner of an overlay address that is not the primary vpn ip of a host
+	hostMap *HostMap
+
+	// used to answer or request certificate renewals, either may be nil
+	certSigner  *CertSigner
+	certRenewer *CertRenewer
+
 	// filters remote addresses allowed for each host
 	// - When we are a lighthouse, this filters what addresses we store and
 	// respond with.
@@ -57,12 +64,16 @@ type LightHouse struct {
 	// used to trigger the HandshakeManager when we receive HostQueryReply
 	handshakeTrigger chan<- iputil.VpnIp
 
+	// used to serve and store certificate revocation lists
+	caPool func() *cert.NebulaCAPool
+
 	// atomicStaticList exists to avoid having a bool in each addrMap entry
 	// since static should be rare
 	atomicStaticList  map[iputil.VpnIp]struct{}
 	atomicLighthouses map[iputil.VpnIp]struct{}
 
 	atomicInterval  int64
+	atomicFetchCRL  int32
 	updateCancel    context.CancelFunc
 	updateParentCtx context.Context
 	updateUdp       udp.EncWriter
@@ -96,11 +107,9 @@ func NewLightHouseFromConfig(l *logrus.Logger, c *config.C, myVpnNet *net.IPNet,
 		nebulaPort = uint32(uPort.Port)
 	}
 
-	ones, _ := myVpnNet.Mask.Size()
 	h := LightHouse{
 		amLighthouse:      amLighthouse,
 		myVpnIp:           iputil.Ip2VpnIp(myVpnNet.IP),
-		myVpnZeros:        iputil.VpnIp(32 - ones),
 		myVpnNet:          myVpnNet,
 		addrMap:           make(map[iputil.VpnIp]*RemoteList),
 		nebulaPort:        nebulaPort,
@@ -164,6 +173,10 @@ func (lh *LightHouse) GetUpdateInterval() int64 {
 	return atomic.LoadInt64(&lh.atomicInterval)
 }
 
+func (lh *LightHouse) GetFetchCRL() bool {
+	return atomic.LoadInt32(&lh.atomicFetchCRL) == 1
+}
+
 func (lh *LightHouse) reload(c *config.C, initial bool) error {
 	if initial || c.HasChanged("lighthouse.advertise_addrs") {
 		rawAdvAddrs := c.GetStringSlice("lighthouse.advertise_addrs", []string{})
@@ -210,6 +223,18 @@ func (lh *LightHouse) reload(c *config.C, initial bool) error {
 		}
 	}
 
+	if initial || c.HasChanged("lighthouse.fetch_crl") {
+		if c.GetBool("lighthouse.fetch_crl", false) {
+			atomic.StoreInt32(&lh.atomicFetchCRL, 1)
+		} else {
+			atomic.StoreInt32(&lh.atomicFetchCRL, 0)
+		}
+
+		if !initial {
+			lh.l.Infof("lighthouse.fetch_crl changed to %v", lh.GetFetchCRL())
+		}
+	}
+
 	if initial || c.HasChanged("lighthouse.remote_allow_list") || c.HasChanged("lighthouse.remote_allow_ranges") {
 		ral, err := NewRemoteAllowListFromConfig(c, "lighthouse.remote_allow_list", "lighthouse.remote_allow_ranges")
 		if err != nil {
@@ -499,12 +524,12 @@ func (lh *LightHouse) unlockedGetRemoteList(vpnIp iputil.VpnIp) *RemoteList {
 
 // unlockedShouldAddV4 checks if to is allowed by our allow list
 func (lh *LightHouse) unlockedShouldAddV4(vpnIp iputil.VpnIp, to *Ip4AndPort) bool {
-	allow := lh.GetRemoteAllowList().AllowIpV4(vpnIp, iputil.VpnIp(to.Ip))
+	allow := lh.GetRemoteAllowList().AllowIpV4(vpnIp, iputil.Uint32ToVpnIp(to.Ip))
 	if lh.l.Level >= logrus.TraceLevel {
 		lh.l.WithField("remoteIp", vpnIp).WithField("allow", allow).Trace("remoteAllowList.Allow")
 	}
 
-	if !allow || ipMaskContains(lh.myVpnIp, lh.myVpnZeros, iputil.VpnIp(to.Ip)) {
+	if !allow || lh.myVpnNet.Contains(iputil.Uint32ToVpnIp(to.Ip).ToIP()) {
 		return false
 	}
 
@@ -518,8 +543,7 @@ func (lh *LightHouse) unlockedShouldAddV6(vpnIp iputil.VpnIp, to *Ip6AndPort) bo
 		lh.l.WithField("remoteIp", lhIp6ToIp(to)).WithField("allow", allow).Trace("remoteAllowList.Allow")
 	}
 
-	// We don't check our vpn network here because nebula does not support ipv6 on the inside
-	if !allow {
+	if !allow || lh.myVpnNet.Contains(lhIp6ToIp(to)) {
 		return false
 	}
 
@@ -541,17 +565,58 @@ func (lh *LightHouse) IsLighthouseIP(vpnIp iputil.VpnIp) bool {
 }
 
 func NewLhQueryByInt(VpnIp iputil.VpnIp) *NebulaMeta {
+	details := &NebulaMetaDetails{}
+	details.setVpnIp(VpnIp)
 	return &NebulaMeta{
-		Type: NebulaMeta_HostQuery,
-		Details: &NebulaMetaDetails{
-			VpnIp: uint32(VpnIp),
-		},
+		Type:    NebulaMeta_HostQuery,
+		Details: details,
 	}
 }
 
+// setVpnIp stores an ipv4 vpn ip in VpnIp and an ipv6 vpn ip in VpnIp6Hi and VpnIp6Lo
+func (m *NebulaMetaDetails) setVpnIp(vpnIp iputil.VpnIp) {
+	if vpnIp.Is4() {
+		m.VpnIp = vpnIp.Uint32()
+		return
+	}
+	m.VpnIp6Hi, m.VpnIp6Lo = vpnIp.HiLo()
+}
+
+// getVpnIp returns the vpn ip stored by setVpnIp
+func (m *NebulaMetaDetails) getVpnIp() iputil.VpnIp {
+	if m.VpnIp6Hi != 0 || m.VpnIp6Lo != 0 {
+		return iputil.HiLoToVpnIp(m.VpnIp6Hi, m.VpnIp6Lo)
+	}
+	return iputil.Uint32ToVpnIp(m.VpnIp)
+}
+
+// setRelayVpnIps stores ipv4 relays in RelayVpnIp and ipv6 relays in RelayVpnIp6
+func (m *NebulaMetaDetails) setRelayVpnIps(relays []iputil.VpnIp) {
+	for _, r := range relays {
+		if r.Is4() {
+			m.RelayVpnIp = append(m.RelayVpnIp, r.Uint32())
+		} else {
+			hi, lo := r.HiLo()
+			m.RelayVpnIp6 = append(m.RelayVpnIp6, hi, lo)
+		}
+	}
+}
+
+// getRelayVpnIps returns the relays stored by setRelayVpnIps
+func (m *NebulaMetaDetails) getRelayVpnIps() []iputil.VpnIp {
+	relays := make([]iputil.VpnIp, 0, len(m.RelayVpnIp)+len(m.RelayVpnIp6)/2)
+	for _, r := range m.RelayVpnIp {
+		relays = append(relays, iputil.Uint32ToVpnIp(r))
+	}
+	for i := 0; i+1 < len(m.RelayVpnIp6); i += 2 {
+		relays = append(relays, iputil.HiLoToVpnIp(m.RelayVpnIp6[i], m.RelayVpnIp6[i+1]))
+	}
+	return relays
+}
+
 func NewIp4AndPort(ip net.IP, port uint32) *Ip4AndPort {
 	ipp := Ip4AndPort{Port: port}
-	ipp.Ip = uint32(iputil.Ip2VpnIp(ip))
+	ipp.Ip = iputil.Ip2VpnIp(ip).Uint32()
 	return &ipp
 }
 
@@ -615,7 +680,7 @@ func (lh *LightHouse) SendUpdate(f udp.EncWriter) {
 
 	lal := lh.GetLocalAllowList()
 	for _, e := range *localIps(lh.l, lal) {
-		if ip4 := e.To4(); ip4 != nil && ipMaskContains(lh.myVpnIp, lh.myVpnZeros, iputil.Ip2VpnIp(ip4)) {
+		if lh.myVpnNet.Contains(e) {
 			continue
 		}
 
@@ -627,20 +692,15 @@ func (lh *LightHouse) SendUpdate(f udp.EncWriter) {
 		}
 	}
 
-	var relays []uint32
-	for _, r := range lh.GetRelaysForMe() {
-		relays = append(relays, (uint32)(r))
-	}
-
 	m := &NebulaMeta{
 		Type: NebulaMeta_HostUpdateNotification,
 		Details: &NebulaMetaDetails{
-			VpnIp:       uint32(lh.myVpnIp),
 			Ip4AndPorts: v4,
 			Ip6AndPorts: v6,
-			RelayVpnIp:  relays,
 		},
 	}
+	m.Details.setVpnIp(lh.myVpnIp)
+	m.Details.setRelayVpnIps(lh.GetRelaysForMe())
 
 	lighthouses := lh.GetLighthouses()
 	lh.metricTx(NebulaMeta_HostUpdateNotification, int64(len(lighthouses)))
@@ -656,6 +716,30 @@ func (lh *LightHouse) SendUpdate(f udp.EncWriter) {
 	for vpnIp := range lighthouses {
 		f.SendMessageToVpnIp(header.LightHouse, 0, vpnIp, mm, nb, out)
 	}
+
+	if lh.GetFetchCRL() {
+		lh.sendRevocationListQuery(f, lighthouses, nb, out)
+	}
+}
+
+// sendRevocationListQuery asks the lighthouses for any certificate revocation lists they have
+func (lh *LightHouse) sendRevocationListQuery(f udp.EncWriter, lighthouses map[iputil.VpnIp]struct{}, nb, out []byte) {
+	m := &NebulaMeta{
+		Type:    NebulaMeta_RevocationListQuery,
+		Details: &NebulaMetaDetails{},
+	}
+	m.Details.setVpnIp(lh.myVpnIp)
+
+	mm, err := m.Marshal()
+	if err != nil {
+		lh.l.WithError(err).Error("Error while marshaling for lighthouse revocation list query")
+		return
+	}
+
+	lh.metricTx(NebulaMeta_RevocationListQuery, int64(len(lighthouses)))
+	for vpnIp := range lighthouses {
+		f.SendMessageToVpnIp(header.LightHouse, 0, vpnIp, mm, nb, out)
+	}
 }
 
 type LightHouseHandler struct {
@@ -701,6 +785,8 @@ func (lhh *LightHouseHandler) resetMeta() *NebulaMeta {
 	details.Ip4AndPorts = details.Ip4AndPorts[:0]
 	details.Ip6AndPorts = details.Ip6AndPorts[:0]
 	details.RelayVpnIp = details.RelayVpnIp[:0]
+	details.RelayVpnIp6 = details.RelayVpnIp6[:0]
+	details.RevocationLists = details.RevocationLists[:0]
 	lhh.meta.Details = details
 
 	return lhh.meta
@@ -738,6 +824,20 @@ func (lhh *LightHouseHandler) HandleRequest(rAddr *udp.Addr, vpnIp iputil.VpnIp,
 	case NebulaMeta_HostMovedNotification:
 	case NebulaMeta_HostPunchNotification:
 		lhh.handleHostPunchNotification(n, vpnIp, w)
+
+	case NebulaMeta_RevocationListQuery:
+		lhh.handleRevocationListQuery(vpnIp, w)
+
+	case NebulaMeta_RevocationListReply:
+		lhh.handleRevocationListReply(n, vpnIp)
+
+	case NebulaMeta_CertRenewalRequest:
+		lhh.handleCertRenewalRequest(vpnIp, w)
+
+	case NebulaMeta_CertRenewalReply:
+		if lhh.lh.certRenewer != nil {
+			lhh.lh.certRenewer.handleReply(vpnIp, n.Details.Certificate)
+		}
 	}
 }
 
@@ -751,12 +851,21 @@ func (lhh *LightHouseHandler) handleHostQuery(n *NebulaMeta, vpnIp iputil.VpnIp,
 	}
 
 	//TODO: we can DRY this further
-	reqVpnIp := n.Details.VpnIp
+	reqVpnIp := n.Details.getVpnIp()
+
+	// Hosts are stored under the first ip in their certificate, any other ip is answered for its owner
+	ownerVpnIp := reqVpnIp
+	if lhh.lh.hostMap != nil {
+		if hostinfo, err := lhh.lh.hostMap.QueryVpnIp(reqVpnIp); err == nil {
+			ownerVpnIp = hostinfo.vpnIp
+		}
+	}
+
 	//TODO: Maybe instead of marshalling into n we marshal into a new `r` to not nuke our current request data
-	found, ln, err := lhh.lh.queryAndPrepMessage(iputil.VpnIp(n.Details.VpnIp), func(c *cache) (int, error) {
+	found, ln, err := lhh.lh.queryAndPrepMessage(ownerVpnIp, func(c *cache) (int, error) {
 		n = lhh.resetMeta()
 		n.Type = NebulaMeta_HostQueryReply
-		n.Details.VpnIp = reqVpnIp
+		n.Details.setVpnIp(reqVpnIp)
 
 		lhh.coalesceAnswers(c, n)
 
@@ -779,7 +888,7 @@ func (lhh *LightHouseHandler) handleHostQuery(n *NebulaMeta, vpnIp iputil.VpnIp,
 	found, ln, err = lhh.lh.queryAndPrepMessage(vpnIp, func(c *cache) (int, error) {
 		n = lhh.resetMeta()
 		n.Type = NebulaMeta_HostPunchNotification
-		n.Details.VpnIp = uint32(vpnIp)
+		n.Details.setVpnIp(vpnIp)
 
 		lhh.coalesceAnswers(c, n)
 
@@ -796,7 +905,7 @@ func (lhh *LightHouseHandler) handleHostQuery(n *NebulaMeta, vpnIp iputil.VpnIp,
 	}
 
 	lhh.lh.metricTx(NebulaMeta_HostPunchNotification, 1)
-	w.SendMessageToVpnIp(header.LightHouse, 0, iputil.VpnIp(reqVpnIp), lhh.pb[:ln], lhh.nb, lhh.out[:0])
+	w.SendMessageToVpnIp(header.LightHouse, 0, ownerVpnIp, lhh.pb[:ln], lhh.nb, lhh.out[:0])
 }
 
 func (lhh *LightHouseHandler) coalesceAnswers(c *cache, n *NebulaMeta) {
@@ -819,7 +928,7 @@ func (lhh *LightHouseHandler) coalesceAnswers(c *cache, n *NebulaMeta) {
 	}
 
 	if c.relay != nil {
-		n.Details.RelayVpnIp = append(n.Details.RelayVpnIp, c.relay.relay...)
+		n.Details.setRelayVpnIps(c.relay.relay)
 	}
 }
 
@@ -828,20 +937,20 @@ func (lhh *LightHouseHandler) handleHostQueryReply(n *NebulaMeta, vpnIp iputil.V
 		return
 	}
 
+	certVpnIp := n.Details.getVpnIp()
 	lhh.lh.Lock()
-	am := lhh.lh.unlockedGetRemoteList(iputil.VpnIp(n.Details.VpnIp))
+	am := lhh.lh.unlockedGetRemoteList(certVpnIp)
 	am.Lock()
 	lhh.lh.Unlock()
 
-	certVpnIp := iputil.VpnIp(n.Details.VpnIp)
 	am.unlockedSetV4(vpnIp, certVpnIp, n.Details.Ip4AndPorts, lhh.lh.unlockedShouldAddV4)
 	am.unlockedSetV6(vpnIp, certVpnIp, n.Details.Ip6AndPorts, lhh.lh.unlockedShouldAddV6)
-	am.unlockedSetRelay(vpnIp, certVpnIp, n.Details.RelayVpnIp)
+	am.unlockedSetRelay(vpnIp, certVpnIp, n.Details.getRelayVpnIps())
 	am.Unlock()
 
 	// Non-blocking attempt to trigger, skip if it would block
 	select {
-	case lhh.lh.handshakeTrigger <- iputil.VpnIp(n.Details.VpnIp):
+	case lhh.lh.handshakeTrigger <- certVpnIp:
 	default:
 	}
 }
@@ -855,9 +964,10 @@ func (lhh *LightHouseHandler) handleHostUpdateNotification(n *NebulaMeta, vpnIp
 	}
 
 	//Simple check that the host sent this not someone else
-	if n.Details.VpnIp != uint32(vpnIp) {
+	certVpnIp := n.Details.getVpnIp()
+	if certVpnIp != vpnIp {
 		if lhh.l.Level >= logrus.DebugLevel {
-			lhh.l.WithField("vpnIp", vpnIp).WithField("answer", iputil.VpnIp(n.Details.VpnIp)).Debugln("Host sent invalid update")
+			lhh.l.WithField("vpnIp", vpnIp).WithField("answer", certVpnIp).Debugln("Host sent invalid update")
 		}
 		return
 	}
@@ -867,10 +977,9 @@ func (lhh *LightHouseHandler) handleHostUpdateNotification(n *NebulaMeta, vpnIp
 	am.Lock()
 	lhh.lh.Unlock()
 
-	certVpnIp := iputil.VpnIp(n.Details.VpnIp)
 	am.unlockedSetV4(vpnIp, certVpnIp, n.Details.Ip4AndPorts, lhh.lh.unlockedShouldAddV4)
 	am.unlockedSetV6(vpnIp, certVpnIp, n.Details.Ip6AndPorts, lhh.lh.unlockedShouldAddV6)
-	am.unlockedSetRelay(vpnIp, certVpnIp, n.Details.RelayVpnIp)
+	am.unlockedSetRelay(vpnIp, certVpnIp, n.Details.getRelayVpnIps())
 	am.Unlock()
 }
 
@@ -893,7 +1002,7 @@ func (lhh *LightHouseHandler) handleHostPunchNotification(n *NebulaMeta, vpnIp i
 
 		if lhh.l.Level >= logrus.DebugLevel {
 			//TODO: lacking the ip we are actually punching on, old: l.Debugf("Punching %s on %d for %s", IntIp(a.Ip), a.Port, IntIp(n.Details.VpnIp))
-			lhh.l.Debugf("Punching on %d for %s", vpnPeer.Port, iputil.VpnIp(n.Details.VpnIp))
+			lhh.l.Debugf("Punching on %d for %s", vpnPeer.Port, n.Details.getVpnIp())
 		}
 	}
 
@@ -909,7 +1018,7 @@ func (lhh *LightHouseHandler) handleHostPunchNotification(n *NebulaMeta, vpnIp i
 	// of a double nat or other difficult scenario, this may help establish
 	// a tunnel.
 	if lhh.lh.punchy.GetRespond() {
-		queryVpnIp := iputil.VpnIp(n.Details.VpnIp)
+		queryVpnIp := n.Details.getVpnIp()
 		go func() {
 			time.Sleep(time.Second * 5)
 			if lhh.l.Level >= logrus.DebugLevel {
@@ -923,8 +1032,123 @@ func (lhh *LightHouseHandler) handleHostPunchNotification(n *NebulaMeta, vpnIp i
 	}
 }
 
-// ipMaskContains checks if testIp is contained by ip after applying a cidr
-// zeros is 32 - bits from net.IPMask.Size()
-func ipMaskContains(ip iputil.VpnIp, zeros iputil.VpnIp, testIp iputil.VpnIp) bool {
-	return (testIp^ip)>>zeros == 0
+func (lhh *LightHouseHandler) handleRevocationListQuery(vpnIp iputil.VpnIp, w udp.EncWriter) {
+	// Exit if we don't answer queries
+	if !lhh.lh.amLighthouse || lhh.lh.caPool == nil {
+		if lhh.l.Level >= logrus.DebugLevel {
+			lhh.l.Debugln("I don't answer revocation list queries, but received from: ", vpnIp)
+		}
+		return
+	}
+
+	n := lhh.resetMeta()
+	n.Type = NebulaMeta_RevocationListReply
+	for _, crl := range lhh.lh.caPool().GetRevocationLists() {
+		b, err := crl.Marshal()
+		if err != nil {
+			lhh.l.WithError(err).WithField("issuer", crl.Details.Issuer).Error("Failed to marshal revocation list")
+			continue
+		}
+		n.Details.RevocationLists = append(n.Details.RevocationLists, b)
+	}
+
+	if len(n.Details.RevocationLists) == 0 {
+		return
+	}
+
+	if n.Size() > len(lhh.pb) {
+		lhh.l.WithField("vpnIp", vpnIp).WithField("size", n.Size()).
+			Error("Revocation lists are too large to send in a lighthouse reply")
+		return
+	}
+
+	ln, err := n.MarshalTo(lhh.pb)
+	if err != nil {
+		lhh.l.WithError(err).WithField("vpnIp", vpnIp).Error("Failed to marshal lighthouse revocation list reply")
+		return
+	}
+
+	lhh.lh.metricTx(NebulaMeta_RevocationListReply, 1)
+	w.SendMessageToVpnIp(header.LightHouse, 0, vpnIp, lhh.pb[:ln], lhh.nb, lhh.out[:0])
+}
+
+func (lhh *LightHouseHandler) handleRevocationListReply(n *NebulaMeta, vpnIp iputil.VpnIp) {
+	if !lhh.lh.IsLighthouseIP(vpnIp) || lhh.lh.caPool == nil {
+		return
+	}
+
+	caPool := lhh.lh.caPool()
+	for _, b := range n.Details.RevocationLists {
+		crl, err := cert.UnmarshalNebulaRevocationList(b)
+		if err != nil {
+			lhh.l.WithError(err).WithField("vpnIp", vpnIp).Error("Failed to unmarshal revocation list from lighthouse")
+			continue
+		}
+
+		updated, err := caPool.AddRevocationList(crl)
+		if err != nil {
+			lhh.l.WithError(err).WithField("vpnIp", vpnIp).WithField("issuer", crl.Details.Issuer).
+				Error("Refusing revocation list from lighthouse")
+			continue
+		}
+
+		if updated {
+			lhh.l.WithField("vpnIp", vpnIp).
+				WithField("issuer", crl.Details.Issuer).
+				WithField("issuedAt", crl.Details.IssuedAt).
+				WithField("revoked", len(crl.Details.Fingerprints)).
+				Info("Updated certificate revocation list from lighthouse")
+		}
+	}
+}
+
+// handleCertRenewalRequest signs a renewal of the certificate vpnIp presented in its handshake and sends it back, if
+// this lighthouse has a certificate signer
+func (lhh *LightHouseHandler) handleCertRenewalRequest(vpnIp iputil.VpnIp, w udp.EncWriter) {
+	if lhh.lh.certSigner == nil || lhh.lh.hostMap == nil {
+		if lhh.l.Level >= logrus.DebugLevel {
+			lhh.l.Debugln("I don't renew certificates, but received a request from: ", vpnIp)
+		}
+		return
+	}
+
+	// The certificate we trust is the one presented in the handshake, not anything in the request
+	hostinfo, err := lhh.lh.hostMap.QueryVpnIp(vpnIp)
+	if err != nil || hostinfo.ConnectionState == nil || hostinfo.ConnectionState.peerCert == nil {
+		lhh.l.WithField("vpnIp", vpnIp).Error("Could not find the certificate for a certificate renewal request")
+		return
+	}
+
+	peerCert := hostinfo.ConnectionState.peerCert
+	nc, err := lhh.lh.certSigner.Renew(peerCert, lhh.lh.caPool(), time.Now())
+	if err != nil {
+		hostinfo.logger(lhh.l).WithError(err).Warn("Refusing certificate renewal")
+		return
+	}
+
+	b, err := nc.MarshalWithChain()
+	if err != nil {
+		lhh.l.WithError(err).WithField("vpnIp", vpnIp).Error("Failed to marshal renewed certificate")
+		return
+	}
+
+	n := lhh.resetMeta()
+	n.Type = NebulaMeta_CertRenewalReply
+	n.Details.Certificate = b
+
+	if n.Size() > len(lhh.pb) {
+		lhh.l.WithField("vpnIp", vpnIp).WithField("size", n.Size()).
+			Error("Renewed certificate is too large to send in a lighthouse reply")
+		return
+	}
+
+	ln, err := n.MarshalTo(lhh.pb)
+	if err != nil {
+		lhh.l.WithError(err).WithField("vpnIp", vpnIp).Error("Failed to marshal certificate renewal reply")
+		return
+	}
+
+	hostinfo.logger(lhh.l).WithField("notAfter", nc.Details.NotAfter).Info("Renewed certificate")
+	lhh.lh.metricTx(NebulaMeta_CertRenewalReply, 1)
+	w.SendMessageToVpnIp(header.LightHouse, 0, vpnIp, lhh.pb[:ln], lhh.nb, lhh.out[:0])
 }
@@ -1,10 +1,14 @@
 package nebula
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
+	"github.com/slackhq/nebula/cert"
 	"github.com/slackhq/nebula/config"
 	"github.com/slackhq/nebula/header"
 	"github.com/slackhq/nebula/iputil"
@@ -21,7 +25,7 @@ func TestOldIPv4Only(t *testing.T) {
 	var m Ip4AndPort
 	err := m.Unmarshal(b)
 	assert.NoError(t, err)
-	assert.Equal(t, "10.1.1.1", iputil.VpnIp(m.GetIp()).String())
+	assert.Equal(t, "10.1.1.1", iputil.Uint32ToVpnIp(m.GetIp()).String())
 }
 
 func TestNewLhQuery(t *testing.T) {
@@ -76,10 +80,10 @@ func BenchmarkLighthouseHandleRequest(b *testing.B) {
 
 	hAddr := udp.NewAddrFromString("4.5.6.7:12345")
 	hAddr2 := udp.NewAddrFromString("4.5.6.7:12346")
-	lh.addrMap[3] = NewRemoteList()
-	lh.addrMap[3].unlockedSetV4(
-		3,
-		3,
+	lh.addrMap[iputil.Uint32ToVpnIp(3)] = NewRemoteList()
+	lh.addrMap[iputil.Uint32ToVpnIp(3)].unlockedSetV4(
+		iputil.Uint32ToVpnIp(3),
+		iputil.Uint32ToVpnIp(3),
 		[]*Ip4AndPort{
 			NewIp4AndPort(hAddr.IP, uint32(hAddr.Port)),
 			NewIp4AndPort(hAddr2.IP, uint32(hAddr2.Port)),
@@ -89,10 +93,10 @@ func BenchmarkLighthouseHandleRequest(b *testing.B) {
 
 	rAddr := udp.NewAddrFromString("1.2.2.3:12345")
 	rAddr2 := udp.NewAddrFromString("1.2.2.3:12346")
-	lh.addrMap[2] = NewRemoteList()
-	lh.addrMap[2].unlockedSetV4(
-		3,
-		3,
+	lh.addrMap[iputil.Uint32ToVpnIp(2)] = NewRemoteList()
+	lh.addrMap[iputil.Uint32ToVpnIp(2)].unlockedSetV4(
+		iputil.Uint32ToVpnIp(3),
+		iputil.Uint32ToVpnIp(3),
 		[]*Ip4AndPort{
 			NewIp4AndPort(rAddr.IP, uint32(rAddr.Port)),
 			NewIp4AndPort(rAddr2.IP, uint32(rAddr2.Port)),
@@ -114,7 +118,7 @@ func BenchmarkLighthouseHandleRequest(b *testing.B) {
 		p, err := req.Marshal()
 		assert.NoError(b, err)
 		for n := 0; n < b.N; n++ {
-			lhh.HandleRequest(rAddr, 2, p, mw)
+			lhh.HandleRequest(rAddr, iputil.Uint32ToVpnIp(2), p, mw)
 		}
 	})
 	b.Run("found", func(b *testing.B) {
@@ -130,7 +134,7 @@ func BenchmarkLighthouseHandleRequest(b *testing.B) {
 		assert.NoError(b, err)
 
 		for n := 0; n < b.N; n++ {
-			lhh.HandleRequest(rAddr, 2, p, mw)
+			lhh.HandleRequest(rAddr, iputil.Uint32ToVpnIp(2), p, mw)
 		}
 	})
 }
@@ -245,13 +249,91 @@ func TestLighthouse_reload(t *testing.T) {
 	lh.reload(c, false)
 }
 
-func newLHHostRequest(fromAddr *udp.Addr, myVpnIp, queryVpnIp iputil.VpnIp, lhh *LightHouseHandler) testLhReply {
-	req := &NebulaMeta{
-		Type: NebulaMeta_HostQuery,
-		Details: &NebulaMetaDetails{
-			VpnIp: uint32(queryVpnIp),
+func TestLighthouse_RevocationLists(t *testing.T) {
+	l := test.NewLogger()
+	lhVpnIp := iputil.Ip2VpnIp(net.ParseIP("10.128.0.1"))
+	myVpnIp := iputil.Ip2VpnIp(net.ParseIP("10.128.0.2"))
+	myUdpAddr := &udp.Addr{IP: net.ParseIP("10.0.0.2"), Port: 4242}
+	lhUdpAddr := &udp.Addr{IP: net.ParseIP("10.0.0.1"), Port: 4242}
+
+	pubCA, privCA, _ := ed25519.GenerateKey(rand.Reader)
+	ca := cert.NebulaCertificate{
+		Details: cert.NebulaCertificateDetails{
+			Name:      "ca",
+			NotBefore: time.Now(),
+			NotAfter:  time.Now().Add(time.Hour),
+			IsCA:      true,
+			PublicKey: pubCA,
 		},
 	}
+	ca.Sign(privCA)
+	caPem, _ := ca.MarshalToPEM()
+	issuer, _ := ca.Sha256Sum()
+
+	lhPool, err := cert.NewCAPoolFromBytes(caPem)
+	assert.NoError(t, err)
+	myPool, err := cert.NewCAPoolFromBytes(caPem)
+	assert.NoError(t, err)
+
+	crl := &cert.NebulaRevocationList{Details: cert.NebulaRevocationListDetails{Issuer: issuer, IssuedAt: time.Now()}}
+	assert.NoError(t, crl.Revoke("abcd"))
+	assert.NoError(t, crl.Sign(privCA))
+	updated, err := lhPool.AddRevocationList(crl)
+	assert.True(t, updated)
+	assert.NoError(t, err)
+
+	c := config.NewC(l)
+	c.Settings["lighthouse"] = map[interface{}]interface{}{"am_lighthouse": true}
+	c.Settings["listen"] = map[interface{}]interface{}{"port": 4242}
+	lh, err := NewLightHouseFromConfig(l, c, &net.IPNet{IP: net.IP{10, 128, 0, 1}, Mask: net.IPMask{255, 255, 255, 0}}, nil, nil)
+	assert.NoError(t, err)
+	lh.caPool = func() *cert.NebulaCAPool { return lhPool }
+	lhh := lh.NewRequestHandler()
+
+	c = config.NewC(l)
+	c.Settings["lighthouse"] = map[interface{}]interface{}{"hosts": []interface{}{"10.128.0.1"}, "fetch_crl": true}
+	c.Settings["static_host_map"] = map[interface{}]interface{}{"10.128.0.1": []interface{}{"10.0.0.1:4242"}}
+	myLh, err := NewLightHouseFromConfig(l, c, &net.IPNet{IP: net.IP{10, 128, 0, 2}, Mask: net.IPMask{255, 255, 255, 0}}, nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, myLh.GetFetchCRL())
+	myLh.caPool = func() *cert.NebulaCAPool { return myPool }
+	myLhh := myLh.NewRequestHandler()
+
+	// Ask the lighthouse for revocation lists
+	req := &NebulaMeta{
+		Type:    NebulaMeta_RevocationListQuery,
+		Details: &NebulaMetaDetails{VpnIp: myVpnIp.Uint32()},
+	}
+	b, err := req.Marshal()
+	assert.NoError(t, err)
+
+	filter := NebulaMeta_RevocationListReply
+	w := &testEncWriter{metaFilter: &filter}
+	lhh.HandleRequest(myUdpAddr, myVpnIp, b, w)
+	assert.Equal(t, myVpnIp, w.lastReply.vpnIp)
+	assert.Len(t, w.lastReply.msg.Details.RevocationLists, 1)
+
+	// Replies from non lighthouses are ignored
+	b, err = w.lastReply.msg.Marshal()
+	assert.NoError(t, err)
+	myLhh.HandleRequest(lhUdpAddr, iputil.Ip2VpnIp(net.ParseIP("10.128.0.3")), b, &testEncWriter{})
+	assert.Len(t, myPool.GetRevocationLists(), 0)
+
+	// Replies from the lighthouse are installed
+	myLhh.HandleRequest(lhUdpAddr, lhVpnIp, b, &testEncWriter{})
+	assert.Len(t, myPool.GetRevocationLists(), 1)
+	assert.True(t, myPool.GetRevocationLists()[0].IsRevoked("abcd"))
+
+	// Non lighthouses don't answer queries
+	b, err = req.Marshal()
+	assert.NoError(t, err)
+	w = &testEncWriter{}
+	myLhh.HandleRequest(lhUdpAddr, lhVpnIp, b, w)
+	assert.Nil(t, w.lastReply.msg)
+}
+
+func newLHHostRequest(fromAddr *udp.Addr, myVpnIp, queryVpnIp iputil.VpnIp, lhh *LightHouseHandler) testLhReply {
+	req := NewLhQueryByInt(queryVpnIp)
 
 	b, err := req.Marshal()
 	if err != nil {
@@ -270,13 +352,13 @@ func newLHHostUpdate(fromAddr *udp.Addr, vpnIp iputil.VpnIp, addrs []*udp.Addr,
 	req := &NebulaMeta{
 		Type: NebulaMeta_HostUpdateNotification,
 		Details: &NebulaMetaDetails{
-			VpnIp:       uint32(vpnIp),
 			Ip4AndPorts: make([]*Ip4AndPort, len(addrs)),
 		},
 	}
+	req.Details.setVpnIp(vpnIp)
 
 	for k, v := range addrs {
-		req.Details.Ip4AndPorts[k] = &Ip4AndPort{Ip: uint32(iputil.Ip2VpnIp(v.IP)), Port: uint32(v.Port)}
+		req.Details.Ip4AndPorts[k] = &Ip4AndPort{Ip: iputil.Ip2VpnIp(v.IP).Uint32(), Port: uint32(v.Port)}
 	}
 
 	b, err := req.Marshal()
@@ -354,10 +436,27 @@ func newLHHostUpdate(fromAddr *udp.Addr, vpnIp iputil.VpnIp, addrs []*udp.Addr,
 //	)
 //}
 
-func Test_ipMaskContains(t *testing.T) {
-	assert.True(t, ipMaskContains(iputil.Ip2VpnIp(net.ParseIP("10.0.0.1")), 32-24, iputil.Ip2VpnIp(net.ParseIP("10.0.0.255"))))
-	assert.False(t, ipMaskContains(iputil.Ip2VpnIp(net.ParseIP("10.0.0.1")), 32-24, iputil.Ip2VpnIp(net.ParseIP("10.0.1.1"))))
-	assert.True(t, ipMaskContains(iputil.Ip2VpnIp(net.ParseIP("10.0.0.1")), 32, iputil.Ip2VpnIp(net.ParseIP("10.0.1.1"))))
+func TestNebulaMetaDetails_VpnIp(t *testing.T) {
+	d := &NebulaMetaDetails{}
+	v4 := iputil.Ip2VpnIp(net.ParseIP("10.0.0.1"))
+	d.setVpnIp(v4)
+	assert.Equal(t, v4.Uint32(), d.VpnIp)
+	assert.Zero(t, d.VpnIp6Hi)
+	assert.Zero(t, d.VpnIp6Lo)
+	assert.Equal(t, v4, d.getVpnIp())
+
+	d = &NebulaMetaDetails{}
+	v6 := iputil.Ip2VpnIp(net.ParseIP("fd00::1"))
+	d.setVpnIp(v6)
+	assert.Zero(t, d.VpnIp)
+	assert.Equal(t, v6, d.getVpnIp())
+
+	// Relays of both families survive the round trip
+	relays := []iputil.VpnIp{v4, v6, iputil.Ip2VpnIp(net.ParseIP("fd00::2"))}
+	d.setRelayVpnIps(relays)
+	assert.Equal(t, []uint32{v4.Uint32()}, d.RelayVpnIp)
+	assert.Len(t, d.RelayVpnIp6, 4)
+	assert.ElementsMatch(t, relays, d.getRelayVpnIps())
 }
 
 type testLhReply struct {
@@ -401,7 +500,7 @@ func assertIp4InArray(t *testing.T, have []*Ip4AndPort, want ...*udp.Addr) {
 	}
 
 	for k, w := range want {
-		if !(have[k].Ip == uint32(iputil.Ip2VpnIp(w.IP)) && have[k].Port == uint32(w.Port)) {
+		if !(have[k].Ip == iputil.Ip2VpnIp(w.IP).Uint32() && have[k].Port == uint32(w.Port)) {
 			assert.Fail(t, fmt.Sprintf("Response did not contain: %v:%v at %v; %v", w.IP, w.Port, k, translateV4toUdpAddr(have)))
 		}
 	}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/slackhq/nebula/cert"
 	"github.com/slackhq/nebula/config"
 	"github.com/slackhq/nebula/overlay"
 	"github.com/slackhq/nebula/sshd"
@@ -76,6 +77,11 @@ func Main(c *config.C, configTest bool, buildVersion string, logger *logrus.Logg
 	}
 	l.WithField("firewallHash", fw.GetRuleHash()).Info("Firewall started")
 
+	rateLimiter, err := NewRateLimiterFromConfig(l, c)
+	if err != nil {
+		return nil, util.NewContextualError("Error while loading rate limits", nil, err)
+	}
+
 	// TODO: make sure mask is 4 bytes
 	tunCidr := cs.certificate.Details.Ips[0]
 
@@ -144,6 +150,21 @@ func Main(c *config.C, configTest bool, buildVersion string, logger *logrus.Logg
 				tun.Close()
 			}
 		}()
+
+		var tunCidr6 []*net.IPNet
+		for _, ip := range cs.certificate.Details.Ips {
+			if ip != tunCidr && ip.IP.To4() == nil {
+				tunCidr6 = append(tunCidr6, ip)
+			}
+		}
+
+		if d, ok := tun.(overlay.Ip6Device); ok {
+			d.SetCidr6(tunCidr6)
+		} else if tunCidr.IP.To4() == nil {
+			return nil, util.NewContextualError("Certificates without an ipv4 address are not supported by this tun device", m{"network": tunCidr.String()}, nil)
+		} else if len(tunCidr6) > 0 {
+			l.WithField("ips", tunCidr6).Warn("ipv6 overlay addresses are not supported by this tun device and will not be configured")
+		}
 	}
 
 	// set up our UDP listener
@@ -243,6 +264,19 @@ func Main(c *config.C, configTest bool, buildVersion string, logger *logrus.Logg
 
 	handshakeManager := NewHandshakeManager(l, tunCidr, preferredRanges, hostMap, lightHouse, udpConns[0], handshakeConfig)
 	lightHouse.handshakeTrigger = handshakeManager.trigger
+	lightHouse.hostMap = hostMap
+
+	certSigner, err := NewCertSignerFromConfig(l, c)
+	if err != nil {
+		return nil, util.NewContextualError("Failed to load the certificate renewal signer", nil, err)
+	}
+	lightHouse.certSigner = certSigner
+
+	certRenewer, err := NewCertRenewerFromConfig(l, c, cs.certificate.Details.Ips)
+	if err != nil {
+		return nil, util.NewContextualError("Failed to configure certificate renewal", nil, err)
+	}
+	lightHouse.certRenewer = certRenewer
 
 	//TODO: These will be reused for psk
 	//handshakeMACKey := config.GetString("handshake_mac.key", "")
@@ -266,6 +300,7 @@ func Main(c *config.C, configTest bool, buildVersion string, logger *logrus.Logg
 		certState:               cs,
 		Cipher:                  c.GetString("cipher", "aes"),
 		Firewall:                fw,
+		RateLimiter:             rateLimiter,
 		ServeDns:                serveDns,
 		HandshakeManager:        handshakeManager,
 		lightHouse:              lightHouse,
@@ -303,13 +338,22 @@ func Main(c *config.C, configTest bool, buildVersion string, logger *logrus.Logg
 		// TODO: Better way to attach these, probably want a new interface in InterfaceConfig
 		// I don't want to make this initial commit too far-reaching though
 		ifce.writers = udpConns
+		ifce.firewallConfig = newFirewallConfig(l, c)
 
 		ifce.RegisterConfigChangeCallbacks(c)
 
 		ifce.reloadSendRecvError(c)
 
+		lightHouse.caPool = func() *cert.NebulaCAPool { return ifce.caPool }
+
 		go handshakeManager.Run(ctx, ifce)
 		go lightHouse.LhUpdateWorker(ctx, ifce)
+		go ifce.watchFirewallRules(ctx)
+
+		if certRenewer != nil {
+			certRenewer.f = ifce
+			go certRenewer.Run(ctx)
+		}
 	}
 
 	// TODO - stats third-party modules start uncancellable goroutines. Update those libs to accept
@@ -84,6 +84,10 @@ func newLighthouseMetrics() *MessageMetrics {
 			NebulaMeta_HostQueryReply,
 			NebulaMeta_HostUpdateNotification,
 			NebulaMeta_HostPunchNotification,
+			NebulaMeta_RevocationListQuery,
+			NebulaMeta_RevocationListReply,
+			NebulaMeta_CertRenewalRequest,
+			NebulaMeta_CertRenewalReply,
 		}
 		for _, i := range used {
 			h[i] = []metrics.Counter{metrics.GetOrRegisterCounter(fmt.Sprintf("lighthouse.%s.%s", t, i.String()), nil)}
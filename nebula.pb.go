@@ -35,19 +35,27 @@ const (
 	NebulaMeta_HostWhoamiReply        NebulaMeta_MessageType = 7
 	NebulaMeta_PathCheck              NebulaMeta_MessageType = 8
 	NebulaMeta_PathCheckReply         NebulaMeta_MessageType = 9
+	NebulaMeta_RevocationListQuery    NebulaMeta_MessageType = 10
+	NebulaMeta_RevocationListReply    NebulaMeta_MessageType = 11
+	NebulaMeta_CertRenewalRequest     NebulaMeta_MessageType = 12
+	NebulaMeta_CertRenewalReply       NebulaMeta_MessageType = 13
 )
 
 var NebulaMeta_MessageType_name = map[int32]string{
-	0: "None",
-	1: "HostQuery",
-	2: "HostQueryReply",
-	3: "HostUpdateNotification",
-	4: "HostMovedNotification",
-	5: "HostPunchNotification",
-	6: "HostWhoami",
-	7: "HostWhoamiReply",
-	8: "PathCheck",
-	9: "PathCheckReply",
+	0:  "None",
+	1:  "HostQuery",
+	2:  "HostQueryReply",
+	3:  "HostUpdateNotification",
+	4:  "HostMovedNotification",
+	5:  "HostPunchNotification",
+	6:  "HostWhoami",
+	7:  "HostWhoamiReply",
+	8:  "PathCheck",
+	9:  "PathCheckReply",
+	10: "RevocationListQuery",
+	11: "RevocationListReply",
+	12: "CertRenewalRequest",
+	13: "CertRenewalReply",
 }
 
 var NebulaMeta_MessageType_value = map[string]int32{
@@ -61,6 +69,10 @@ var NebulaMeta_MessageType_value = map[string]int32{
 	"HostWhoamiReply":        7,
 	"PathCheck":              8,
 	"PathCheckReply":         9,
+	"RevocationListQuery":    10,
+	"RevocationListReply":    11,
+	"CertRenewalRequest":     12,
+	"CertRenewalReply":       13,
 }
 
 func (x NebulaMeta_MessageType) String() string {
@@ -177,11 +189,18 @@ func (m *NebulaMeta) GetDetails() *NebulaMetaDetails {
 }
 
 type NebulaMetaDetails struct {
-	VpnIp       uint32        `protobuf:"varint,1,opt,name=VpnIp,proto3" json:"VpnIp,omitempty"`
-	Ip4AndPorts []*Ip4AndPort `protobuf:"bytes,2,rep,name=Ip4AndPorts,proto3" json:"Ip4AndPorts,omitempty"`
-	Ip6AndPorts []*Ip6AndPort `protobuf:"bytes,4,rep,name=Ip6AndPorts,proto3" json:"Ip6AndPorts,omitempty"`
-	RelayVpnIp  []uint32      `protobuf:"varint,5,rep,packed,name=RelayVpnIp,proto3" json:"RelayVpnIp,omitempty"`
-	Counter     uint32        `protobuf:"varint,3,opt,name=counter,proto3" json:"counter,omitempty"`
+	VpnIp           uint32        `protobuf:"varint,1,opt,name=VpnIp,proto3" json:"VpnIp,omitempty"`
+	Ip4AndPorts     []*Ip4AndPort `protobuf:"bytes,2,rep,name=Ip4AndPorts,proto3" json:"Ip4AndPorts,omitempty"`
+	Ip6AndPorts     []*Ip6AndPort `protobuf:"bytes,4,rep,name=Ip6AndPorts,proto3" json:"Ip6AndPorts,omitempty"`
+	RelayVpnIp      []uint32      `protobuf:"varint,5,rep,packed,name=RelayVpnIp,proto3" json:"RelayVpnIp,omitempty"`
+	Counter         uint32        `protobuf:"varint,3,opt,name=counter,proto3" json:"counter,omitempty"`
+	RevocationLists [][]byte      `protobuf:"bytes,6,rep,name=RevocationLists,proto3" json:"RevocationLists,omitempty"`
+	// VpnIp6Hi and VpnIp6Lo hold the vpn ip in place of VpnIp when it is ipv6
+	VpnIp6Hi    uint64 `protobuf:"varint,7,opt,name=VpnIp6Hi,proto3" json:"VpnIp6Hi,omitempty"`
+	VpnIp6Lo    uint64 `protobuf:"varint,8,opt,name=VpnIp6Lo,proto3" json:"VpnIp6Lo,omitempty"`
+	Certificate []byte `protobuf:"bytes,9,opt,name=Certificate,proto3" json:"Certificate,omitempty"`
+	// RelayVpnIp6 holds the ipv6 relay vpn ips as high and low 64 bit pairs
+	RelayVpnIp6 []uint64 `protobuf:"varint,10,rep,packed,name=RelayVpnIp6,proto3" json:"RelayVpnIp6,omitempty"`
 }
 
 func (m *NebulaMetaDetails) Reset()         { *m = NebulaMetaDetails{} }
@@ -252,6 +271,41 @@ func (m *NebulaMetaDetails) GetCounter() uint32 {
 	return 0
 }
 
+func (m *NebulaMetaDetails) GetRevocationLists() [][]byte {
+	if m != nil {
+		return m.RevocationLists
+	}
+	return nil
+}
+
+func (m *NebulaMetaDetails) GetVpnIp6Hi() uint64 {
+	if m != nil {
+		return m.VpnIp6Hi
+	}
+	return 0
+}
+
+func (m *NebulaMetaDetails) GetVpnIp6Lo() uint64 {
+	if m != nil {
+		return m.VpnIp6Lo
+	}
+	return 0
+}
+
+func (m *NebulaMetaDetails) GetCertificate() []byte {
+	if m != nil {
+		return m.Certificate
+	}
+	return nil
+}
+
+func (m *NebulaMetaDetails) GetRelayVpnIp6() []uint64 {
+	if m != nil {
+		return m.RelayVpnIp6
+	}
+	return nil
+}
+
 type Ip4AndPort struct {
 	Ip   uint32 `protobuf:"varint,1,opt,name=Ip,proto3" json:"Ip,omitempty"`
 	Port uint32 `protobuf:"varint,2,opt,name=Port,proto3" json:"Port,omitempty"`
@@ -550,6 +604,11 @@ type NebulaControl struct {
 	ResponderRelayIndex uint32                    `protobuf:"varint,3,opt,name=ResponderRelayIndex,proto3" json:"ResponderRelayIndex,omitempty"`
 	RelayToIp           uint32                    `protobuf:"varint,4,opt,name=RelayToIp,proto3" json:"RelayToIp,omitempty"`
 	RelayFromIp         uint32                    `protobuf:"varint,5,opt,name=RelayFromIp,proto3" json:"RelayFromIp,omitempty"`
+	// The Ip6 fields are used in place of RelayToIp and RelayFromIp for ipv6 vpn ips
+	RelayToIp6Hi   uint64 `protobuf:"varint,6,opt,name=RelayToIp6Hi,proto3" json:"RelayToIp6Hi,omitempty"`
+	RelayToIp6Lo   uint64 `protobuf:"varint,7,opt,name=RelayToIp6Lo,proto3" json:"RelayToIp6Lo,omitempty"`
+	RelayFromIp6Hi uint64 `protobuf:"varint,8,opt,name=RelayFromIp6Hi,proto3" json:"RelayFromIp6Hi,omitempty"`
+	RelayFromIp6Lo uint64 `protobuf:"varint,9,opt,name=RelayFromIp6Lo,proto3" json:"RelayFromIp6Lo,omitempty"`
 }
 
 func (m *NebulaControl) Reset()         { *m = NebulaControl{} }
@@ -620,6 +679,34 @@ func (m *NebulaControl) GetRelayFromIp() uint32 {
 	return 0
 }
 
+func (m *NebulaControl) GetRelayToIp6Hi() uint64 {
+	if m != nil {
+		return m.RelayToIp6Hi
+	}
+	return 0
+}
+
+func (m *NebulaControl) GetRelayToIp6Lo() uint64 {
+	if m != nil {
+		return m.RelayToIp6Lo
+	}
+	return 0
+}
+
+func (m *NebulaControl) GetRelayFromIp6Hi() uint64 {
+	if m != nil {
+		return m.RelayFromIp6Hi
+	}
+	return 0
+}
+
+func (m *NebulaControl) GetRelayFromIp6Lo() uint64 {
+	if m != nil {
+		return m.RelayFromIp6Lo
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("nebula.NebulaMeta_MessageType", NebulaMeta_MessageType_name, NebulaMeta_MessageType_value)
 	proto.RegisterEnum("nebula.NebulaPing_MessageType", NebulaPing_MessageType_name, NebulaPing_MessageType_value)
@@ -637,51 +724,59 @@ func init() {
 func init() { proto.RegisterFile("nebula.proto", fileDescriptor_2d65afa7693df5ef) }
 
 var fileDescriptor_2d65afa7693df5ef = []byte{
-	// 696 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0xcd, 0x6e, 0xd3, 0x4a,
-	0x14, 0x8e, 0x1d, 0xe7, 0xef, 0xa4, 0x49, 0x7d, 0x4f, 0xef, 0xcd, 0x4d, 0xaf, 0xae, 0xac, 0xe0,
-	0x05, 0xca, 0x2a, 0xad, 0xd2, 0x52, 0xb1, 0x04, 0x82, 0x50, 0x52, 0xb5, 0x55, 0x18, 0x15, 0x90,
-	0xd8, 0xa0, 0x69, 0x32, 0xd4, 0x56, 0x12, 0x8f, 0x6b, 0x4f, 0x50, 0xf3, 0x16, 0x3c, 0x4c, 0x1f,
-	0x82, 0x05, 0x12, 0x5d, 0xb0, 0x60, 0x89, 0xda, 0x17, 0x41, 0x33, 0x76, 0x6c, 0x27, 0x0d, 0xec,
-	0xce, 0xcf, 0xf7, 0xcd, 0x7c, 0xe7, 0x9b, 0x63, 0xc3, 0x96, 0xc7, 0x2e, 0xe6, 0x53, 0xda, 0xf1,
-	0x03, 0x2e, 0x38, 0x16, 0xa3, 0xcc, 0xfe, 0xaa, 0x03, 0x9c, 0xa9, 0xf0, 0x94, 0x09, 0x8a, 0x5d,
-	0x30, 0xce, 0x17, 0x3e, 0x6b, 0x6a, 0x2d, 0xad, 0x5d, 0xef, 0x5a, 0x9d, 0x98, 0x93, 0x22, 0x3a,
-	0xa7, 0x2c, 0x0c, 0xe9, 0x25, 0x93, 0x28, 0xa2, 0xb0, 0x78, 0x00, 0xa5, 0x97, 0x4c, 0x50, 0x77,
-	0x1a, 0x36, 0xf5, 0x96, 0xd6, 0xae, 0x76, 0x77, 0x1f, 0xd2, 0x62, 0x00, 0x59, 0x22, 0xed, 0xef,
-	0x1a, 0x54, 0x33, 0x47, 0x61, 0x19, 0x8c, 0x33, 0xee, 0x31, 0x33, 0x87, 0x35, 0xa8, 0xf4, 0x79,
-	0x28, 0x5e, 0xcf, 0x59, 0xb0, 0x30, 0x35, 0x44, 0xa8, 0x27, 0x29, 0x61, 0xfe, 0x74, 0x61, 0xea,
-	0xf8, 0x1f, 0x34, 0x64, 0xed, 0x8d, 0x3f, 0xa6, 0x82, 0x9d, 0x71, 0xe1, 0x7e, 0x74, 0x47, 0x54,
-	0xb8, 0xdc, 0x33, 0xf3, 0xb8, 0x0b, 0xff, 0xc8, 0xde, 0x29, 0xff, 0xc4, 0xc6, 0x2b, 0x2d, 0x63,
-	0xd9, 0x1a, 0xce, 0xbd, 0x91, 0xb3, 0xd2, 0x2a, 0x60, 0x1d, 0x40, 0xb6, 0xde, 0x39, 0x9c, 0xce,
-	0x5c, 0xb3, 0x88, 0x3b, 0xb0, 0x9d, 0xe6, 0xd1, 0xb5, 0x25, 0xa9, 0x6c, 0x48, 0x85, 0xd3, 0x73,
-	0xd8, 0x68, 0x62, 0x96, 0xa5, 0xb2, 0x24, 0x8d, 0x20, 0x15, 0xfb, 0x9b, 0x06, 0x7f, 0x3d, 0x98,
-	0x1a, 0xff, 0x86, 0xc2, 0x5b, 0xdf, 0x1b, 0xf8, 0xca, 0xd6, 0x1a, 0x89, 0x12, 0x3c, 0x84, 0xea,
-	0xc0, 0x3f, 0x7c, 0xee, 0x8d, 0x87, 0x3c, 0x10, 0xd2, 0xbb, 0x7c, 0xbb, 0xda, 0xc5, 0xa5, 0x77,
-	0x69, 0x8b, 0x64, 0x61, 0x11, 0xeb, 0x28, 0x61, 0x19, 0xeb, 0xac, 0xa3, 0x0c, 0x2b, 0x81, 0xa1,
-	0x05, 0x40, 0xd8, 0x94, 0x2e, 0x22, 0x19, 0x85, 0x56, 0xbe, 0x5d, 0x23, 0x99, 0x0a, 0x36, 0xa1,
-	0x34, 0xe2, 0x73, 0x4f, 0xb0, 0xa0, 0x99, 0x57, 0x1a, 0x97, 0xa9, 0xbd, 0x0f, 0x90, 0x5e, 0x8f,
-	0x75, 0xd0, 0x93, 0x31, 0xf4, 0x81, 0x8f, 0x08, 0x86, 0xac, 0xab, 0x87, 0xaf, 0x11, 0x15, 0xdb,
-	0xcf, 0x24, 0xe3, 0x28, 0xc3, 0xe8, 0xbb, 0x8a, 0x61, 0x10, 0xbd, 0xef, 0xca, 0xfc, 0x84, 0x2b,
-	0xbc, 0x41, 0xf4, 0x13, 0x9e, 0x9c, 0x90, 0xcf, 0x9c, 0x70, 0xbd, 0xdc, 0xc9, 0xa1, 0xeb, 0x5d,
-	0xfe, 0x79, 0x27, 0x25, 0x62, 0xc3, 0x4e, 0x22, 0x18, 0xe7, 0xee, 0x8c, 0xc5, 0xf7, 0xa8, 0xd8,
-	0xb6, 0x1f, 0x6c, 0x9c, 0x24, 0x9b, 0x39, 0xac, 0x40, 0x21, 0x7a, 0x3f, 0xcd, 0xfe, 0x00, 0xdb,
-	0xd1, 0xb9, 0x7d, 0xea, 0x8d, 0x43, 0x87, 0x4e, 0x18, 0x3e, 0x4d, 0xd7, 0x5b, 0x53, 0xeb, 0xbd,
-	0xa6, 0x20, 0x41, 0xae, 0xef, 0xb8, 0x14, 0xd1, 0x9f, 0xd1, 0x91, 0x12, 0xb1, 0x45, 0x54, 0x6c,
-	0xdf, 0x68, 0xd0, 0xd8, 0xcc, 0x93, 0xf0, 0x1e, 0x0b, 0x84, 0xba, 0x65, 0x8b, 0xa8, 0x18, 0x1f,
-	0x43, 0x7d, 0xe0, 0xb9, 0xc2, 0xa5, 0x82, 0x07, 0x03, 0x6f, 0xcc, 0xae, 0x63, 0xa7, 0xd7, 0xaa,
-	0x12, 0x47, 0x58, 0xe8, 0x73, 0x6f, 0xcc, 0x62, 0x5c, 0xe4, 0xe7, 0x5a, 0x15, 0x1b, 0x50, 0xec,
-	0x71, 0x3e, 0x71, 0x59, 0xd3, 0x50, 0xce, 0xc4, 0x59, 0xe2, 0x57, 0x21, 0xf5, 0xeb, 0xd8, 0x28,
-	0x17, 0xcd, 0xd2, 0xb1, 0x51, 0x2e, 0x99, 0x65, 0xfb, 0x46, 0x87, 0x5a, 0x24, 0xbb, 0xc7, 0x3d,
-	0x11, 0xf0, 0x29, 0x3e, 0x59, 0x79, 0x95, 0x47, 0xab, 0x9e, 0xc4, 0xa0, 0x0d, 0x0f, 0xb3, 0x0f,
-	0x3b, 0x89, 0x74, 0xb5, 0x7f, 0xd9, 0xa9, 0x36, 0xb5, 0x24, 0x23, 0x19, 0x22, 0xc3, 0x88, 0xe6,
-	0xdb, 0xd4, 0xc2, 0xff, 0xa1, 0xa2, 0xb2, 0x73, 0x3e, 0xf0, 0xd5, 0x9c, 0x35, 0x92, 0x16, 0xb0,
-	0x05, 0x55, 0x95, 0xbc, 0x0a, 0xf8, 0x4c, 0x7d, 0x0b, 0xb2, 0x9f, 0x2d, 0xd9, 0xfd, 0xdf, 0xfd,
-	0x9a, 0x1a, 0x80, 0xbd, 0x80, 0x51, 0xc1, 0x14, 0x9a, 0xb0, 0xab, 0x39, 0x0b, 0x85, 0xa9, 0xe1,
-	0xbf, 0xb0, 0xb3, 0x52, 0x97, 0x92, 0x42, 0x66, 0xea, 0x2f, 0x0e, 0xbe, 0xdc, 0x59, 0xda, 0xed,
-	0x9d, 0xa5, 0xfd, 0xbc, 0xb3, 0xb4, 0xcf, 0xf7, 0x56, 0xee, 0xf6, 0xde, 0xca, 0xfd, 0xb8, 0xb7,
-	0x72, 0xef, 0x77, 0x2f, 0x5d, 0xe1, 0xcc, 0x2f, 0x3a, 0x23, 0x3e, 0xdb, 0x0b, 0xa7, 0x74, 0x34,
-	0x71, 0xae, 0xf6, 0x22, 0x0b, 0x2f, 0x8a, 0xea, 0x0f, 0x7d, 0xf0, 0x2b, 0x00, 0x00, 0xff, 0xff,
-	0xcd, 0xd7, 0xbe, 0xd5, 0xb1, 0x05, 0x00, 0x00,
+	// 831 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x55, 0xcd, 0x72, 0xe3, 0x44,
+	0x10, 0xb6, 0x7e, 0xfc, 0xd7, 0xfe, 0x89, 0xe8, 0x2c, 0x41, 0xd9, 0xa2, 0x5c, 0x46, 0x07, 0x4a,
+	0xa7, 0xec, 0x56, 0xb2, 0xa4, 0x38, 0x02, 0xa1, 0x28, 0x7b, 0x4b, 0x49, 0x85, 0xa9, 0x00, 0x55,
+	0x5c, 0xa8, 0x89, 0x3d, 0xc4, 0xaa, 0xd8, 0x1a, 0xad, 0x34, 0x5e, 0xd6, 0x6f, 0xc1, 0x83, 0x70,
+	0xdc, 0x87, 0xe0, 0xc0, 0x21, 0x47, 0x8e, 0x54, 0xf2, 0x0c, 0xdc, 0xa9, 0x99, 0x91, 0xf5, 0x67,
+	0xb3, 0xb7, 0xe9, 0xaf, 0xbf, 0xaf, 0xd5, 0xf3, 0xb5, 0x5a, 0x82, 0x7e, 0xc4, 0x6e, 0xd7, 0x4b,
+	0x7a, 0x12, 0x27, 0x5c, 0x70, 0x6c, 0xe9, 0xc8, 0xfb, 0xc3, 0x02, 0xb8, 0x52, 0xc7, 0x4b, 0x26,
+	0x28, 0x9e, 0x82, 0x7d, 0xb3, 0x89, 0x99, 0x6b, 0x8c, 0x0d, 0x7f, 0x78, 0x3a, 0x3a, 0xc9, 0x34,
+	0x05, 0xe3, 0xe4, 0x92, 0xa5, 0x29, 0xbd, 0x63, 0x92, 0x45, 0x14, 0x17, 0xcf, 0xa0, 0xfd, 0x2d,
+	0x13, 0x34, 0x5c, 0xa6, 0xae, 0x39, 0x36, 0xfc, 0xde, 0xe9, 0xf1, 0xae, 0x2c, 0x23, 0x90, 0x2d,
+	0xd3, 0x7b, 0x6f, 0x42, 0xaf, 0x54, 0x0a, 0x3b, 0x60, 0x5f, 0xf1, 0x88, 0x39, 0x0d, 0x1c, 0x40,
+	0x77, 0xc2, 0x53, 0xf1, 0xfd, 0x9a, 0x25, 0x1b, 0xc7, 0x40, 0x84, 0x61, 0x1e, 0x12, 0x16, 0x2f,
+	0x37, 0x8e, 0x89, 0xcf, 0xe1, 0x48, 0x62, 0x3f, 0xc4, 0x73, 0x2a, 0xd8, 0x15, 0x17, 0xe1, 0xaf,
+	0xe1, 0x8c, 0x8a, 0x90, 0x47, 0x8e, 0x85, 0xc7, 0xf0, 0xb1, 0xcc, 0x5d, 0xf2, 0xb7, 0x6c, 0x5e,
+	0x49, 0xd9, 0xdb, 0xd4, 0xf5, 0x3a, 0x9a, 0x2d, 0x2a, 0xa9, 0x26, 0x0e, 0x01, 0x64, 0xea, 0xa7,
+	0x05, 0xa7, 0xab, 0xd0, 0x69, 0xe1, 0x21, 0x1c, 0x14, 0xb1, 0x7e, 0x6c, 0x5b, 0x76, 0x76, 0x4d,
+	0xc5, 0xe2, 0x62, 0xc1, 0x66, 0xf7, 0x4e, 0x47, 0x76, 0x96, 0x87, 0x9a, 0xd2, 0xc5, 0x4f, 0xe0,
+	0x90, 0xb0, 0xb7, 0x5c, 0xd7, 0x0d, 0xc2, 0xed, 0x35, 0x60, 0x37, 0xa1, 0x15, 0x3d, 0x3c, 0x02,
+	0xbc, 0x60, 0x89, 0x20, 0x2c, 0x62, 0xbf, 0xd1, 0x25, 0x61, 0x6f, 0xd6, 0x2c, 0x15, 0x4e, 0x1f,
+	0x9f, 0x81, 0x53, 0xc1, 0x25, 0x7b, 0xe0, 0xfd, 0x6b, 0xc2, 0x47, 0x3b, 0xae, 0xe2, 0x33, 0x68,
+	0xfe, 0x18, 0x47, 0xd3, 0x58, 0x8d, 0x6d, 0x40, 0x74, 0x80, 0xaf, 0xa0, 0x37, 0x8d, 0x5f, 0x7d,
+	0x1d, 0xcd, 0xaf, 0x79, 0x22, 0xe4, 0x6c, 0x2c, 0xbf, 0x77, 0x8a, 0xdb, 0xd9, 0x14, 0x29, 0x52,
+	0xa6, 0x69, 0xd5, 0x79, 0xae, 0xb2, 0xeb, 0xaa, 0xf3, 0x92, 0x2a, 0xa7, 0xe1, 0x08, 0x80, 0xb0,
+	0x25, 0xdd, 0xe8, 0x36, 0x9a, 0x63, 0xcb, 0x1f, 0x90, 0x12, 0x82, 0x2e, 0xb4, 0x67, 0x7c, 0x1d,
+	0x09, 0x96, 0xb8, 0x96, 0xea, 0x71, 0x1b, 0xa2, 0x0f, 0x07, 0x55, 0x63, 0x52, 0xb7, 0x35, 0xb6,
+	0xfc, 0x3e, 0xa9, 0xc3, 0xf8, 0x1c, 0x3a, 0xaa, 0xd8, 0xf9, 0x24, 0x74, 0xdb, 0x63, 0xc3, 0xb7,
+	0x49, 0x1e, 0x17, 0xb9, 0x80, 0xbb, 0x9d, 0x72, 0x2e, 0xe0, 0x38, 0x86, 0x9e, 0x74, 0x52, 0x8f,
+	0x9b, 0xb9, 0xdd, 0xb1, 0xe1, 0xf7, 0x49, 0x19, 0x92, 0x8c, 0xa2, 0xd7, 0x73, 0x17, 0xc6, 0x96,
+	0x6f, 0x93, 0x32, 0xe4, 0xbd, 0x04, 0x28, 0x4c, 0xc2, 0x21, 0x98, 0xb9, 0xd9, 0xe6, 0x34, 0x46,
+	0x04, 0x5b, 0xe2, 0xea, 0xf5, 0x1f, 0x10, 0x75, 0xf6, 0xbe, 0x02, 0x28, 0x0c, 0x92, 0x8a, 0x49,
+	0xa8, 0x14, 0x36, 0x31, 0x27, 0xa1, 0x8c, 0x03, 0xae, 0xf8, 0x36, 0x31, 0x03, 0x9e, 0x57, 0xb0,
+	0x4a, 0x15, 0xde, 0x6d, 0x37, 0xf3, 0x3a, 0x8c, 0xee, 0x3e, 0xbc, 0x99, 0x92, 0xb1, 0x67, 0x33,
+	0x11, 0xec, 0x9b, 0x70, 0xc5, 0xb2, 0xe7, 0xa8, 0xb3, 0xe7, 0xed, 0xec, 0x9d, 0x14, 0x3b, 0x0d,
+	0xec, 0x42, 0x53, 0xbf, 0x65, 0x86, 0xf7, 0x0b, 0x1c, 0xe8, 0xba, 0x13, 0x1a, 0xcd, 0xd3, 0x05,
+	0xbd, 0x67, 0xf8, 0x65, 0xb1, 0xe4, 0x86, 0x5a, 0xf2, 0x5a, 0x07, 0x39, 0xb3, 0xbe, 0xe9, 0xb2,
+	0x89, 0xc9, 0x8a, 0xce, 0x54, 0x13, 0x7d, 0xa2, 0xce, 0xde, 0x7b, 0x03, 0x8e, 0xf6, 0xeb, 0x24,
+	0x5d, 0x8e, 0x46, 0x3d, 0xa5, 0x4f, 0xd4, 0x19, 0x3f, 0x87, 0xe1, 0x34, 0x0a, 0x45, 0x48, 0x05,
+	0x4f, 0xa6, 0xd1, 0x9c, 0xbd, 0xcb, 0x9c, 0xae, 0xa1, 0x92, 0x47, 0x58, 0x1a, 0xf3, 0x68, 0xce,
+	0x32, 0x9e, 0xf6, 0xb3, 0x86, 0xe2, 0x11, 0xb4, 0x2e, 0x38, 0xbf, 0x0f, 0x99, 0x6b, 0x2b, 0x67,
+	0xb2, 0x28, 0xf7, 0xab, 0x59, 0xf8, 0xf5, 0xda, 0xee, 0xb4, 0x9c, 0xf6, 0x6b, 0xbb, 0xd3, 0x76,
+	0x3a, 0xde, 0x5f, 0x16, 0x0c, 0x74, 0xdb, 0x17, 0x3c, 0x12, 0x09, 0x5f, 0xe2, 0x17, 0x95, 0xa9,
+	0x7c, 0x56, 0xf5, 0x24, 0x23, 0xed, 0x19, 0xcc, 0x4b, 0x38, 0xcc, 0x5b, 0x57, 0xaf, 0x59, 0xf9,
+	0x56, 0xfb, 0x52, 0x52, 0x91, 0x5f, 0xa2, 0xa4, 0xd0, 0xf7, 0xdb, 0x97, 0xc2, 0x4f, 0xa1, 0xab,
+	0xa2, 0x1b, 0x3e, 0x8d, 0xd5, 0x3d, 0x07, 0xa4, 0x00, 0xf2, 0x57, 0xfe, 0xbb, 0x84, 0xaf, 0xd4,
+	0xc6, 0xca, 0x7c, 0x19, 0x42, 0x0f, 0xfa, 0x39, 0x5d, 0xae, 0x5c, 0x4b, 0x99, 0x52, 0xc1, 0xaa,
+	0x9c, 0x80, 0x67, 0x6b, 0x59, 0xc1, 0xf4, 0x50, 0xf2, 0xb2, 0xb2, 0x92, 0x5e, 0xd0, 0x1a, 0x5a,
+	0xe7, 0x05, 0xdc, 0xed, 0xee, 0xf2, 0x02, 0xee, 0x4d, 0xfe, 0xef, 0xc7, 0x21, 0xbf, 0xa4, 0x09,
+	0xa3, 0x82, 0x29, 0xc1, 0xf6, 0x4b, 0x6a, 0xc8, 0x4f, 0x6f, 0x05, 0x97, 0x56, 0xa5, 0xcc, 0x31,
+	0xbf, 0x39, 0xfb, 0xf9, 0xf8, 0x2e, 0x14, 0x8b, 0xf5, 0xed, 0xc9, 0x8c, 0xaf, 0x5e, 0xa4, 0x4b,
+	0x3a, 0xbb, 0x5f, 0xbc, 0x79, 0xa1, 0x47, 0xf8, 0xe7, 0xe3, 0xc8, 0x78, 0x78, 0x1c, 0x19, 0xff,
+	0x3c, 0x8e, 0x8c, 0xdf, 0x9f, 0x46, 0x8d, 0x87, 0xa7, 0x51, 0xe3, 0xef, 0xa7, 0x51, 0xe3, 0xb6,
+	0xa5, 0xfe, 0x9f, 0x67, 0xff, 0x0d, 0x00, 0xd4, 0x5e, 0x68, 0x2f, 0x4f, 0x07, 0x00, 0x00,
 }
 
 func (m *NebulaMeta) Marshal() (dAtA []byte, err error) {
@@ -744,6 +839,50 @@ func (m *NebulaMetaDetails) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.RelayVpnIp6) > 0 {
+		dAtA2 := make([]byte, len(m.RelayVpnIp6)*10)
+		var j1 int
+		for _, num := range m.RelayVpnIp6 {
+			for num >= 1<<7 {
+				dAtA2[j1] = uint8(uint64(num)&0x7f | 0x80)
+				num >>= 7
+				j1++
+			}
+			dAtA2[j1] = uint8(num)
+			j1++
+		}
+		i -= j1
+		copy(dAtA[i:], dAtA2[:j1])
+		i = encodeVarintNebula(dAtA, i, uint64(j1))
+		i--
+		dAtA[i] = 0x52
+	}
+	if len(m.Certificate) > 0 {
+		i -= len(m.Certificate)
+		copy(dAtA[i:], m.Certificate)
+		i = encodeVarintNebula(dAtA, i, uint64(len(m.Certificate)))
+		i--
+		dAtA[i] = 0x4a
+	}
+	if m.VpnIp6Lo != 0 {
+		i = encodeVarintNebula(dAtA, i, uint64(m.VpnIp6Lo))
+		i--
+		dAtA[i] = 0x40
+	}
+	if m.VpnIp6Hi != 0 {
+		i = encodeVarintNebula(dAtA, i, uint64(m.VpnIp6Hi))
+		i--
+		dAtA[i] = 0x38
+	}
+	if len(m.RevocationLists) > 0 {
+		for iNdEx := len(m.RevocationLists) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.RevocationLists[iNdEx])
+			copy(dAtA[i:], m.RevocationLists[iNdEx])
+			i = encodeVarintNebula(dAtA, i, uint64(len(m.RevocationLists[iNdEx])))
+			i--
+			dAtA[i] = 0x32
+		}
+	}
 	if len(m.RelayVpnIp) > 0 {
 		dAtA3 := make([]byte, len(m.RelayVpnIp)*10)
 		var j2 int
@@ -1019,6 +1158,26 @@ func (m *NebulaControl) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.RelayFromIp6Lo != 0 {
+		i = encodeVarintNebula(dAtA, i, uint64(m.RelayFromIp6Lo))
+		i--
+		dAtA[i] = 0x48
+	}
+	if m.RelayFromIp6Hi != 0 {
+		i = encodeVarintNebula(dAtA, i, uint64(m.RelayFromIp6Hi))
+		i--
+		dAtA[i] = 0x40
+	}
+	if m.RelayToIp6Lo != 0 {
+		i = encodeVarintNebula(dAtA, i, uint64(m.RelayToIp6Lo))
+		i--
+		dAtA[i] = 0x38
+	}
+	if m.RelayToIp6Hi != 0 {
+		i = encodeVarintNebula(dAtA, i, uint64(m.RelayToIp6Hi))
+		i--
+		dAtA[i] = 0x30
+	}
 	if m.RelayFromIp != 0 {
 		i = encodeVarintNebula(dAtA, i, uint64(m.RelayFromIp))
 		i--
@@ -1105,6 +1264,29 @@ func (m *NebulaMetaDetails) Size() (n int) {
 		}
 		n += 1 + sovNebula(uint64(l)) + l
 	}
+	if len(m.RevocationLists) > 0 {
+		for _, b := range m.RevocationLists {
+			l = len(b)
+			n += 1 + l + sovNebula(uint64(l))
+		}
+	}
+	if m.VpnIp6Hi != 0 {
+		n += 1 + sovNebula(uint64(m.VpnIp6Hi))
+	}
+	if m.VpnIp6Lo != 0 {
+		n += 1 + sovNebula(uint64(m.VpnIp6Lo))
+	}
+	l = len(m.Certificate)
+	if l > 0 {
+		n += 1 + l + sovNebula(uint64(l))
+	}
+	if len(m.RelayVpnIp6) > 0 {
+		l = 0
+		for _, e := range m.RelayVpnIp6 {
+			l += sovNebula(uint64(e))
+		}
+		n += 1 + sovNebula(uint64(l)) + l
+	}
 	return n
 }
 
@@ -1219,6 +1401,18 @@ func (m *NebulaControl) Size() (n int) {
 	if m.RelayFromIp != 0 {
 		n += 1 + sovNebula(uint64(m.RelayFromIp))
 	}
+	if m.RelayToIp6Hi != 0 {
+		n += 1 + sovNebula(uint64(m.RelayToIp6Hi))
+	}
+	if m.RelayToIp6Lo != 0 {
+		n += 1 + sovNebula(uint64(m.RelayToIp6Lo))
+	}
+	if m.RelayFromIp6Hi != 0 {
+		n += 1 + sovNebula(uint64(m.RelayFromIp6Hi))
+	}
+	if m.RelayFromIp6Lo != 0 {
+		n += 1 + sovNebula(uint64(m.RelayFromIp6Lo))
+	}
 	return n
 }
 
@@ -1544,6 +1738,186 @@ func (m *NebulaMetaDetails) Unmarshal(dAtA []byte) error {
 			} else {
 				return fmt.Errorf("proto: wrong wireType = %d for field RelayVpnIp", wireType)
 			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RevocationLists", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNebula
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthNebula
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthNebula
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.RevocationLists = append(m.RevocationLists, make([]byte, postIndex-iNdEx))
+			copy(m.RevocationLists[len(m.RevocationLists)-1], dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field VpnIp6Hi", wireType)
+			}
+			m.VpnIp6Hi = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNebula
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.VpnIp6Hi |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field VpnIp6Lo", wireType)
+			}
+			m.VpnIp6Lo = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNebula
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.VpnIp6Lo |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Certificate", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNebula
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthNebula
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthNebula
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Certificate = append(m.Certificate[:0], dAtA[iNdEx:postIndex]...)
+			if m.Certificate == nil {
+				m.Certificate = []byte{}
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType == 0 {
+				var v uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowNebula
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				m.RelayVpnIp6 = append(m.RelayVpnIp6, v)
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowNebula
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= int(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthNebula
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex < 0 {
+					return ErrInvalidLengthNebula
+				}
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				var elementCount int
+				var count int
+				for _, integer := range dAtA[iNdEx:postIndex] {
+					if integer < 128 {
+						count++
+					}
+				}
+				elementCount = count
+				if elementCount != 0 && len(m.RelayVpnIp6) == 0 {
+					m.RelayVpnIp6 = make([]uint64, 0, elementCount)
+				}
+				for iNdEx < postIndex {
+					var v uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowNebula
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					m.RelayVpnIp6 = append(m.RelayVpnIp6, v)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field RelayVpnIp6", wireType)
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipNebula(dAtA[iNdEx:])
@@ -2252,6 +2626,82 @@ func (m *NebulaControl) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RelayToIp6Hi", wireType)
+			}
+			m.RelayToIp6Hi = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNebula
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RelayToIp6Hi |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RelayToIp6Lo", wireType)
+			}
+			m.RelayToIp6Lo = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNebula
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RelayToIp6Lo |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RelayFromIp6Hi", wireType)
+			}
+			m.RelayFromIp6Hi = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNebula
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RelayFromIp6Hi |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RelayFromIp6Lo", wireType)
+			}
+			m.RelayFromIp6Lo = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNebula
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RelayFromIp6Lo |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipNebula(dAtA[iNdEx:])
@@ -0,0 +1,61 @@
+package noiseutil
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/flynn/noise"
+)
+
+// DHP256 is the NIST P-256 ECDH function. Public keys are uncompressed points and the shared secret is the x
+// coordinate of the resulting point.
+var DHP256 noise.DHFunc = nistCurve{name: "P256", curve: elliptic.P256()}
+
+type nistCurve struct {
+	name  string
+	curve elliptic.Curve
+}
+
+func (c nistCurve) GenerateKeypair(random io.Reader) (noise.DHKey, error) {
+	priv, x, y, err := elliptic.GenerateKey(c.curve, random)
+	if err != nil {
+		return noise.DHKey{}, err
+	}
+
+	return noise.DHKey{Private: priv, Public: elliptic.Marshal(c.curve, x, y)}, nil
+}
+
+func (c nistCurve) DH(privkey, pubkey []byte) ([]byte, error) {
+	if len(privkey) != c.privLen() {
+		return nil, fmt.Errorf("private key was %v bytes, expected %v", len(privkey), c.privLen())
+	}
+
+	d := new(big.Int).SetBytes(privkey)
+	if d.Sign() == 0 || d.Cmp(c.curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("private key is out of range")
+	}
+
+	// Unmarshal rejects points that are not on the curve
+	x, y := elliptic.Unmarshal(c.curve, pubkey)
+	if x == nil {
+		return nil, fmt.Errorf("public key was not a valid uncompressed point")
+	}
+
+	sx, _ := c.curve.ScalarMult(x, y, privkey)
+	return sx.FillBytes(make([]byte, c.privLen())), nil
+}
+
+// DHLen is the length of a public key, the noise state uses it to read keys from handshake messages
+func (c nistCurve) DHLen() int {
+	return 1 + 2*c.privLen()
+}
+
+func (c nistCurve) DHName() string {
+	return c.name
+}
+
+func (c nistCurve) privLen() int {
+	return (c.curve.Params().BitSize + 7) / 8
+}
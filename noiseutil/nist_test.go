@@ -0,0 +1,71 @@
+package noiseutil
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/flynn/noise"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDHP256(t *testing.T) {
+	a, err := DHP256.GenerateKeypair(rand.Reader)
+	assert.NoError(t, err)
+	assert.Len(t, a.Private, 32)
+	assert.Len(t, a.Public, DHP256.DHLen())
+
+	b, err := DHP256.GenerateKeypair(rand.Reader)
+	assert.NoError(t, err)
+
+	ab, err := DHP256.DH(a.Private, b.Public)
+	assert.NoError(t, err)
+	ba, err := DHP256.DH(b.Private, a.Public)
+	assert.NoError(t, err)
+	assert.Len(t, ab, 32)
+	assert.Equal(t, ab, ba)
+
+	// Not a point on the curve
+	bad := make([]byte, DHP256.DHLen())
+	bad[0] = 4
+	_, err = DHP256.DH(a.Private, bad)
+	assert.EqualError(t, err, "public key was not a valid uncompressed point")
+
+	_, err = DHP256.DH(a.Private[:31], b.Public)
+	assert.EqualError(t, err, "private key was 31 bytes, expected 32")
+
+	_, err = DHP256.DH(make([]byte, 32), b.Public)
+	assert.EqualError(t, err, "private key is out of range")
+}
+
+func TestDHP256_Handshake(t *testing.T) {
+	cs := noise.NewCipherSuite(DHP256, noise.CipherAESGCM, noise.HashSHA256)
+	is, err := DHP256.GenerateKeypair(rand.Reader)
+	assert.NoError(t, err)
+	rs, err := DHP256.GenerateKeypair(rand.Reader)
+	assert.NoError(t, err)
+
+	initiator, err := noise.NewHandshakeState(noise.Config{CipherSuite: cs, Random: rand.Reader, Pattern: noise.HandshakeIX, Initiator: true, StaticKeypair: is})
+	assert.NoError(t, err)
+	responder, err := noise.NewHandshakeState(noise.Config{CipherSuite: cs, Random: rand.Reader, Pattern: noise.HandshakeIX, StaticKeypair: rs})
+	assert.NoError(t, err)
+
+	msg, _, _, err := initiator.WriteMessage(nil, []byte("hi"))
+	assert.NoError(t, err)
+	out, _, _, err := responder.ReadMessage(nil, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hi"), out)
+	assert.Equal(t, is.Public, responder.PeerStatic())
+
+	msg, rRecv, _, err := responder.WriteMessage(nil, []byte("hello"))
+	assert.NoError(t, err)
+	out, iSend, _, err := initiator.ReadMessage(nil, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), out)
+	assert.Equal(t, rs.Public, initiator.PeerStatic())
+
+	ct, err := iSend.Encrypt(nil, nil, []byte("data"))
+	assert.NoError(t, err)
+	pt, err := rRecv.Decrypt(nil, nil, ct)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), pt)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"net/netip"
 	"time"
 
 	"github.com/flynn/noise"
@@ -11,14 +12,21 @@ import (
 	"github.com/slackhq/nebula/cert"
 	"github.com/slackhq/nebula/firewall"
 	"github.com/slackhq/nebula/header"
-	"github.com/slackhq/nebula/iputil"
 	"github.com/slackhq/nebula/udp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 	"google.golang.org/protobuf/proto"
 )
 
 const (
 	minFwPacketLen = 4
+
+	// ipv6 extension headers we walk past to find the upper layer protocol
+	ipv6HopByHop   = 0
+	ipv6Routing    = 43
+	ipv6Fragment   = 44
+	ipv6AuthHeader = 51
+	ipv6DestOpts   = 60
 )
 
 func (f *Interface) readOutsidePackets(addr *udp.Addr, via interface{}, out []byte, packet []byte, h *header.H, fwPacket *firewall.Packet, lhf udp.LightHouseHandlerFunc, nb []byte, q int, localCache firewall.ConntrackCache) {
@@ -112,6 +120,20 @@ func (f *Interface) readOutsidePackets(addr *udp.Addr, via interface{}, out []by
 				if targetRelay.State == Established {
 					switch targetRelay.Type {
 					case ForwardingType:
+						// Relayed packets count against the limits of both the sender and the target
+						dropReason := f.rateLimiter.Drop(hostinfo, true, len(signedPayload))
+						if dropReason == nil {
+							dropReason = f.rateLimiter.Drop(targetHI, false, len(signedPayload))
+						}
+						if dropReason != nil {
+							if f.l.Level >= logrus.DebugLevel {
+								hostinfo.logger(f.l).WithField("peerIp", relay.PeerIp).
+									WithField("reason", dropReason).
+									Debugln("dropping relayed packet")
+							}
+							return
+						}
+
 						// Forward this packet through the relay tunnel
 						// Find the target HostInfo
 						f.SendVia(targetHI, targetRelay, signedPayload, nb, out, false)
@@ -290,11 +312,17 @@ func newPacket(data []byte, incoming bool, fp *firewall.Packet) error {
 		return fmt.Errorf("packet is less than %v bytes", ipv4.HeaderLen)
 	}
 
-	// Is it an ipv4 packet?
-	if int((data[0]>>4)&0x0f) != 4 {
-		return fmt.Errorf("packet is not ipv4, type: %v", int((data[0]>>4)&0x0f))
+	switch int((data[0] >> 4) & 0x0f) {
+	case ipv4.Version:
+		return parseV4(data, incoming, fp)
+	case ipv6.Version:
+		return parseV6(data, incoming, fp)
 	}
 
+	return fmt.Errorf("packet is not ipv4 or ipv6, type: %v", int((data[0]>>4)&0x0f))
+}
+
+func parseV4(data []byte, incoming bool, fp *firewall.Packet) error {
 	// Adjust our start position based on the advertised ip header length
 	ihl := int(data[0]&0x0f) << 2
 
@@ -319,30 +347,138 @@ func newPacket(data []byte, incoming bool, fp *firewall.Packet) error {
 		return fmt.Errorf("packet is less than %v bytes, ip header len: %v", minLen, ihl)
 	}
 
+	src, _ := netip.AddrFromSlice(data[12:16])
+	dst, _ := netip.AddrFromSlice(data[16:20])
+	setPacketTuple(data, ihl, incoming, fp, src, dst, fp.Fragment || fp.Protocol == firewall.ProtoICMP)
+	setICMP(data[ihl:], fp)
+	setTCPFlags(data[ihl:], fp)
+	return nil
+}
+
+func parseV6(data []byte, incoming bool, fp *firewall.Packet) error {
+	if len(data) < ipv6.HeaderLen {
+		return fmt.Errorf("ipv6 packet is less than %v bytes", ipv6.HeaderLen)
+	}
+
+	// Walk the extension headers to find the upper layer protocol
+	fp.Fragment = false
+	next := data[6]
+	offset := ipv6.HeaderLen
+	for {
+		var hl int
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6DestOpts:
+			if len(data) < offset+2 {
+				return fmt.Errorf("ipv6 packet was truncated in extension header %v", next)
+			}
+			hl = (int(data[offset+1]) + 1) * 8
+
+		case ipv6Fragment:
+			if len(data) < offset+8 {
+				return fmt.Errorf("ipv6 packet was truncated in extension header %v", next)
+			}
+			// Only the first fragment carries the upper layer header
+			if binary.BigEndian.Uint16(data[offset+2:offset+4])&0xfff8 != 0 {
+				fp.Fragment = true
+			}
+			hl = 8
+
+		case ipv6AuthHeader:
+			if len(data) < offset+2 {
+				return fmt.Errorf("ipv6 packet was truncated in extension header %v", next)
+			}
+			hl = (int(data[offset+1]) + 2) * 4
+
+		default:
+			hl = 0
+		}
+
+		if hl == 0 {
+			break
+		}
+
+		next = data[offset]
+		offset += hl
+
+		if fp.Fragment {
+			// Nothing past here is for us
+			break
+		}
+	}
+
+	// Firewall handles protocol checks
+	fp.Protocol = next
+
+	minLen := offset
+	if !fp.Fragment && fp.Protocol != firewall.ProtoICMPv6 {
+		minLen += minFwPacketLen
+	}
+	if len(data) < minLen {
+		return fmt.Errorf("ipv6 packet is less than %v bytes, ip header len: %v", minLen, offset)
+	}
+
+	src, _ := netip.AddrFromSlice(data[8:24])
+	dst, _ := netip.AddrFromSlice(data[24:40])
+	setPacketTuple(data, offset, incoming, fp, src, dst, fp.Fragment || fp.Protocol == firewall.ProtoICMPv6)
+	setICMP(data[offset:], fp)
+	setTCPFlags(data[offset:], fp)
+	return nil
+}
+
+// setPacketTuple fills in the locally oriented addresses and ports of a firewall packet
+func setPacketTuple(data []byte, offset int, incoming bool, fp *firewall.Packet, src, dst netip.Addr, noPorts bool) {
 	// Firewall packets are locally oriented
 	if incoming {
-		fp.RemoteIP = iputil.Ip2VpnIp(data[12:16])
-		fp.LocalIP = iputil.Ip2VpnIp(data[16:20])
-		if fp.Fragment || fp.Protocol == firewall.ProtoICMP {
+		fp.RemoteIP = src
+		fp.LocalIP = dst
+		if noPorts {
 			fp.RemotePort = 0
 			fp.LocalPort = 0
 		} else {
-			fp.RemotePort = binary.BigEndian.Uint16(data[ihl : ihl+2])
-			fp.LocalPort = binary.BigEndian.Uint16(data[ihl+2 : ihl+4])
+			fp.RemotePort = binary.BigEndian.Uint16(data[offset : offset+2])
+			fp.LocalPort = binary.BigEndian.Uint16(data[offset+2 : offset+4])
 		}
 	} else {
-		fp.LocalIP = iputil.Ip2VpnIp(data[12:16])
-		fp.RemoteIP = iputil.Ip2VpnIp(data[16:20])
-		if fp.Fragment || fp.Protocol == firewall.ProtoICMP {
+		fp.LocalIP = src
+		fp.RemoteIP = dst
+		if noPorts {
 			fp.RemotePort = 0
 			fp.LocalPort = 0
 		} else {
-			fp.LocalPort = binary.BigEndian.Uint16(data[ihl : ihl+2])
-			fp.RemotePort = binary.BigEndian.Uint16(data[ihl+2 : ihl+4])
+			fp.LocalPort = binary.BigEndian.Uint16(data[offset : offset+2])
+			fp.RemotePort = binary.BigEndian.Uint16(data[offset+2 : offset+4])
 		}
 	}
+}
 
-	return nil
+// setICMP fills in the icmp type, code, and echo identifier of a firewall packet from the upper layer data
+func setICMP(data []byte, fp *firewall.Packet) {
+	fp.ICMPType = 0
+	fp.ICMPCode = 0
+	fp.ICMPID = 0
+
+	if fp.Fragment || !fp.IsICMP() || len(data) < 2 {
+		return
+	}
+
+	fp.ICMPType = data[0]
+	fp.ICMPCode = data[1]
+
+	// Echo messages carry an identifier after the checksum
+	if fp.IsICMPEcho() && len(data) >= 6 {
+		fp.ICMPID = binary.BigEndian.Uint16(data[4:6])
+	}
+}
+
+// setTCPFlags fills in the flags of a tcp firewall packet from the upper layer data, a packet too short to carry them
+// has none
+func setTCPFlags(data []byte, fp *firewall.Packet) {
+	fp.TCPFlags = 0
+	if fp.Fragment || fp.Protocol != firewall.ProtoTCP || len(data) < 14 {
+		return
+	}
+
+	fp.TCPFlags = data[13]
 }
 
 func (f *Interface) decrypt(hostinfo *HostInfo, mc uint64, out []byte, packet []byte, h *header.H, nb []byte) ([]byte, error) {
@@ -386,6 +522,9 @@ func (f *Interface) decryptToTun(hostinfo *HostInfo, messageCounter uint64, out
 	}
 
 	dropReason := f.firewall.Drop(out, *fwPacket, true, hostinfo, f.caPool, localCache)
+	if dropReason == nil {
+		dropReason = f.rateLimiter.Drop(hostinfo, true, len(out))
+	}
 	if dropReason != nil {
 		if f.l.Level >= logrus.DebugLevel {
 			hostinfo.logger(f.l).WithField("fwPacket", fwPacket).
@@ -2,12 +2,13 @@ package nebula
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 
 	"github.com/slackhq/nebula/firewall"
-	"github.com/slackhq/nebula/iputil"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 func Test_newPacket(t *testing.T) {
@@ -31,9 +32,9 @@ func Test_newPacket(t *testing.T) {
 
 	assert.EqualError(t, err, "packet is less than 28 bytes, ip header len: 24")
 
-	// not an ipv4 packet
+	// not an ipv4 or ipv6 packet
 	err = newPacket([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, true, p)
-	assert.EqualError(t, err, "packet is not ipv4, type: 0")
+	assert.EqualError(t, err, "packet is not ipv4 or ipv6, type: 0")
 
 	// invalid ihl
 	err = newPacket([]byte{4<<4 | (8 >> 2 & 0x0f), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, true, p)
@@ -55,8 +56,8 @@ func Test_newPacket(t *testing.T) {
 
 	assert.Nil(t, err)
 	assert.Equal(t, p.Protocol, uint8(firewall.ProtoTCP))
-	assert.Equal(t, p.LocalIP, iputil.Ip2VpnIp(net.IPv4(10, 0, 0, 2)))
-	assert.Equal(t, p.RemoteIP, iputil.Ip2VpnIp(net.IPv4(10, 0, 0, 1)))
+	assert.Equal(t, p.LocalIP, netip.MustParseAddr("10.0.0.2"))
+	assert.Equal(t, p.RemoteIP, netip.MustParseAddr("10.0.0.1"))
 	assert.Equal(t, p.RemotePort, uint16(3))
 	assert.Equal(t, p.LocalPort, uint16(4))
 
@@ -76,8 +77,173 @@ func Test_newPacket(t *testing.T) {
 
 	assert.Nil(t, err)
 	assert.Equal(t, p.Protocol, uint8(2))
-	assert.Equal(t, p.LocalIP, iputil.Ip2VpnIp(net.IPv4(10, 0, 0, 1)))
-	assert.Equal(t, p.RemoteIP, iputil.Ip2VpnIp(net.IPv4(10, 0, 0, 2)))
+	assert.Equal(t, p.LocalIP, netip.MustParseAddr("10.0.0.1"))
+	assert.Equal(t, p.RemoteIP, netip.MustParseAddr("10.0.0.2"))
 	assert.Equal(t, p.RemotePort, uint16(6))
 	assert.Equal(t, p.LocalPort, uint16(5))
 }
+
+func Test_newPacket_v6(t *testing.T) {
+	p := &firewall.Packet{}
+
+	v6Header := func(next byte) []byte {
+		b := make([]byte, ipv6.HeaderLen)
+		b[0] = 6 << 4
+		b[6] = next
+		copy(b[8:24], net.ParseIP("fd00::1"))
+		copy(b[24:40], net.ParseIP("fd00::2"))
+		return b
+	}
+
+	// length fail
+	err := newPacket(v6Header(firewall.ProtoTCP)[:30], true, p)
+	assert.EqualError(t, err, "ipv6 packet is less than 40 bytes")
+
+	// missing ports
+	err = newPacket(v6Header(firewall.ProtoTCP), true, p)
+	assert.EqualError(t, err, "ipv6 packet is less than 44 bytes, ip header len: 40")
+
+	// incoming
+	b := append(v6Header(firewall.ProtoUDP), 0, 3, 0, 4)
+	err = newPacket(b, true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(firewall.ProtoUDP), p.Protocol)
+	assert.Equal(t, netip.MustParseAddr("fd00::2"), p.LocalIP)
+	assert.Equal(t, netip.MustParseAddr("fd00::1"), p.RemoteIP)
+	assert.Equal(t, uint16(3), p.RemotePort)
+	assert.Equal(t, uint16(4), p.LocalPort)
+	assert.False(t, p.Fragment)
+
+	// outgoing, walking past a hop by hop extension header
+	b = append(v6Header(ipv6HopByHop), firewall.ProtoTCP, 0, 0, 0, 0, 0, 0, 0)
+	b = append(b, 0, 5, 0, 6)
+	err = newPacket(b, false, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(firewall.ProtoTCP), p.Protocol)
+	assert.Equal(t, netip.MustParseAddr("fd00::1"), p.LocalIP)
+	assert.Equal(t, netip.MustParseAddr("fd00::2"), p.RemoteIP)
+	assert.Equal(t, uint16(6), p.RemotePort)
+	assert.Equal(t, uint16(5), p.LocalPort)
+
+	// truncated extension header
+	err = newPacket(v6Header(ipv6HopByHop), false, p)
+	assert.EqualError(t, err, "ipv6 packet was truncated in extension header 0")
+
+	// the first fragment has ports
+	b = append(v6Header(ipv6Fragment), firewall.ProtoTCP, 0, 0, 1, 0, 0, 0, 1)
+	b = append(b, 0, 5, 0, 6)
+	err = newPacket(b, false, p)
+	assert.Nil(t, err)
+	assert.False(t, p.Fragment)
+	assert.Equal(t, uint16(6), p.RemotePort)
+
+	// later fragments do not
+	b = append(v6Header(ipv6Fragment), firewall.ProtoTCP, 0, 0, 8, 0, 0, 0, 1)
+	err = newPacket(b, false, p)
+	assert.Nil(t, err)
+	assert.True(t, p.Fragment)
+	assert.Equal(t, uint8(firewall.ProtoTCP), p.Protocol)
+	assert.Equal(t, uint16(0), p.RemotePort)
+	assert.Equal(t, uint16(0), p.LocalPort)
+
+	// icmpv6 has no ports
+	err = newPacket(v6Header(firewall.ProtoICMPv6), true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(firewall.ProtoICMPv6), p.Protocol)
+	assert.Equal(t, uint16(0), p.RemotePort)
+	assert.Equal(t, uint16(0), p.LocalPort)
+
+	// icmpv6 echo requests carry their type, code, and identifier
+	b = append(v6Header(firewall.ProtoICMPv6), firewall.ICMPv6EchoRequest, 0, 0, 0, 0x12, 0x34, 0, 1)
+	err = newPacket(b, true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(firewall.ICMPv6EchoRequest), p.ICMPType)
+	assert.Equal(t, uint8(0), p.ICMPCode)
+	assert.Equal(t, uint16(0x1234), p.ICMPID)
+	assert.Equal(t, uint16(0), p.LocalPort)
+}
+
+func Test_newPacket_TCPFlags(t *testing.T) {
+	p := &firewall.Packet{}
+
+	h := ipv4.Header{
+		Version:  1,
+		Len:      20,
+		Src:      net.IPv4(10, 0, 0, 1),
+		Dst:      net.IPv4(10, 0, 0, 2),
+		Protocol: firewall.ProtoTCP,
+	}
+	hb, _ := h.Marshal()
+
+	tcp := make([]byte, 20)
+	tcp[13] = tcpSYN | tcpACK
+	err := newPacket(append(hb, tcp...), true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(tcpSYN|tcpACK), p.TCPFlags)
+
+	// the flags are not part of the conntrack key
+	assert.Equal(t, uint8(0), p.ConntrackKey().TCPFlags)
+
+	// too short to hold the flags
+	err = newPacket(append(hb[:len(hb):len(hb)], tcp[:13]...), true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(0), p.TCPFlags)
+
+	// other protocols have no flags
+	h.Protocol = firewall.ProtoUDP
+	hb, _ = h.Marshal()
+	err = newPacket(append(hb, tcp...), true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(0), p.TCPFlags)
+}
+
+func Test_newPacket_ICMP(t *testing.T) {
+	p := &firewall.Packet{}
+
+	h := ipv4.Header{
+		Version:  1,
+		Len:      20,
+		Src:      net.IPv4(10, 0, 0, 1),
+		Dst:      net.IPv4(10, 0, 0, 2),
+		Protocol: firewall.ProtoICMP,
+	}
+	hb, _ := h.Marshal()
+
+	// echo request
+	b := append(hb, firewall.ICMPEchoRequest, 0, 0, 0, 0xab, 0xcd, 0, 1)
+	err := newPacket(b, false, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(firewall.ProtoICMP), p.Protocol)
+	assert.Equal(t, uint8(firewall.ICMPEchoRequest), p.ICMPType)
+	assert.Equal(t, uint8(0), p.ICMPCode)
+	assert.Equal(t, uint16(0xabcd), p.ICMPID)
+	assert.Equal(t, uint16(0), p.LocalPort)
+	assert.Equal(t, uint16(0), p.RemotePort)
+
+	// the reply shares the conntrack key of the request
+	req := *p
+	b = append(hb[:len(hb):len(hb)], firewall.ICMPEchoReply, 0, 0, 0, 0xab, 0xcd, 0, 1)
+	err = newPacket(b, true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(firewall.ICMPEchoReply), p.ICMPType)
+	assert.NotEqual(t, req.ConntrackKey(), p.ConntrackKey())
+	p.LocalIP, p.RemoteIP = p.RemoteIP, p.LocalIP
+	assert.Equal(t, req.ConntrackKey(), p.ConntrackKey())
+
+	// other messages have no identifier
+	b = append(hb[:len(hb):len(hb)], 3, 1, 0, 0, 0xab, 0xcd, 0, 1)
+	err = newPacket(b, true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(3), p.ICMPType)
+	assert.Equal(t, uint8(1), p.ICMPCode)
+	assert.Equal(t, uint16(0), p.ICMPID)
+
+	// nothing is left over from a previous packet
+	h.Protocol = firewall.ProtoUDP
+	hb, _ = h.Marshal()
+	b = append(hb, 0, 5, 0, 6)
+	err = newPacket(b, true, p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(0), p.ICMPType)
+	assert.Equal(t, uint8(0), p.ICMPCode)
+}
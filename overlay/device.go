@@ -15,3 +15,9 @@ type Device interface {
 	RouteFor(iputil.VpnIp) iputil.VpnIp
 	NewMultiQueueReader() (io.ReadWriteCloser, error)
 }
+
+// Ip6Device is implemented by devices that can carry ipv6 overlay addresses, either alongside the ipv4 vpn network
+// or as the vpn network itself. SetCidr6 must be called before Activate
+type Ip6Device interface {
+	SetCidr6([]*net.IPNet)
+}
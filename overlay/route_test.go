@@ -255,13 +255,13 @@ func Test_makeRouteTree(t *testing.T) {
 	ip := iputil.Ip2VpnIp(net.ParseIP("1.0.0.2"))
 	r := routeTree.MostSpecificContains(ip)
 	assert.NotNil(t, r)
-	assert.IsType(t, iputil.VpnIp(0), r)
+	assert.IsType(t, iputil.VpnIp{}, r)
 	assert.EqualValues(t, iputil.Ip2VpnIp(net.ParseIP("192.168.0.1")), r)
 
 	ip = iputil.Ip2VpnIp(net.ParseIP("1.0.0.1"))
 	r = routeTree.MostSpecificContains(ip)
 	assert.NotNil(t, r)
-	assert.IsType(t, iputil.VpnIp(0), r)
+	assert.IsType(t, iputil.VpnIp{}, r)
 	assert.EqualValues(t, iputil.Ip2VpnIp(net.ParseIP("192.168.0.2")), r)
 
 	ip = iputil.Ip2VpnIp(net.ParseIP("1.1.0.1"))
@@ -1,7 +1,9 @@
 package overlay
 
 import (
+	"fmt"
 	"net"
+	"strconv"
 
 	"github.com/sirupsen/logrus"
 	"github.com/slackhq/nebula/config"
@@ -10,6 +12,24 @@ import (
 
 const DefaultMTU = 1300
 
+// MaxMTUFromConfig returns the size of the largest packet the device can carry, the larger of tun.mtu and the mtu of
+// any route. Invalid route mtus are left for the route parsing to report.
+func MaxMTUFromConfig(c *config.C) int {
+	max := c.GetInt("tun.mtu", DefaultMTU)
+	for _, k := range []string{"tun.routes", "tun.unsafe_routes"} {
+		routes, _ := c.Get(k).([]interface{})
+		for _, r := range routes {
+			m, _ := r.(map[interface{}]interface{})
+			if v, ok := m["mtu"]; ok {
+				if mtu, err := strconv.Atoi(fmt.Sprint(v)); err == nil && mtu > max {
+					max = mtu
+				}
+			}
+		}
+	}
+	return max
+}
+
 func NewDeviceFromConfig(c *config.C, l *logrus.Logger, tunCidr *net.IPNet, fd *int, routines int) (Device, error) {
 	routes, err := parseRoutes(c, tunCidr)
 	if err != nil {
@@ -41,7 +41,7 @@ func newTun(_ *logrus.Logger, _ string, _ *net.IPNet, _ int, _ []Route, _ int, _
 }
 
 func (t *tun) RouteFor(iputil.VpnIp) iputil.VpnIp {
-	return 0
+	return iputil.VpnIp{}
 }
 
 func (t tun) Activate() error {
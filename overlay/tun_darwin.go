@@ -317,7 +317,7 @@ func (t *tun) RouteFor(ip iputil.VpnIp) iputil.VpnIp {
 		return r.(iputil.VpnIp)
 	}
 
-	return 0
+	return iputil.VpnIp{}
 }
 
 // Get the LinkAddr for the interface of the given name
@@ -106,7 +106,7 @@ func (t *tun) RouteFor(ip iputil.VpnIp) iputil.VpnIp {
 		return r.(iputil.VpnIp)
 	}
 
-	return 0
+	return iputil.VpnIp{}
 }
 
 func (t *tun) Cidr() *net.IPNet {
@@ -43,7 +43,7 @@ func (t *tun) Activate() error {
 }
 
 func (t *tun) RouteFor(iputil.VpnIp) iputil.VpnIp {
-	return 0
+	return iputil.VpnIp{}
 }
 
 // The following is hoisted up from water, we do this so we can inject our own fd on iOS
@@ -23,6 +23,7 @@ type tun struct {
 	fd         int
 	Device     string
 	cidr       *net.IPNet
+	cidr6      []*net.IPNet
 	MaxMTU     int
 	DefaultMTU int
 	TXQueueLen int
@@ -157,7 +158,7 @@ func (t *tun) RouteFor(ip iputil.VpnIp) iputil.VpnIp {
 		return r.(iputil.VpnIp)
 	}
 
-	return 0
+	return iputil.VpnIp{}
 }
 
 func (t *tun) Write(b []byte) (int, error) {
@@ -216,15 +217,19 @@ func (t tun) Activate() error {
 		},
 	}
 
-	// Set the device ip address
-	if err = ioctl(fd, unix.SIOCSIFADDR, uintptr(unsafe.Pointer(&ifra))); err != nil {
-		return fmt.Errorf("failed to set tun address: %s", err)
-	}
+	// An ipv6 vpn network is set with the other ipv6 addresses below
+	isV4 := t.cidr.IP.To4() != nil
+	if isV4 {
+		// Set the device ip address
+		if err = ioctl(fd, unix.SIOCSIFADDR, uintptr(unsafe.Pointer(&ifra))); err != nil {
+			return fmt.Errorf("failed to set tun address: %s", err)
+		}
 
-	// Set the device network
-	ifra.Addr.Addr = mask
-	if err = ioctl(fd, unix.SIOCSIFNETMASK, uintptr(unsafe.Pointer(&ifra))); err != nil {
-		return fmt.Errorf("failed to set tun netmask: %s", err)
+		// Set the device network
+		ifra.Addr.Addr = mask
+		if err = ioctl(fd, unix.SIOCSIFNETMASK, uintptr(unsafe.Pointer(&ifra))); err != nil {
+			return fmt.Errorf("failed to set tun netmask: %s", err)
+		}
 	}
 
 	// Set the device name
@@ -259,22 +264,36 @@ func (t tun) Activate() error {
 		return fmt.Errorf("failed to get tun device link: %s", err)
 	}
 
-	// Default route
-	dr := &net.IPNet{IP: t.cidr.IP.Mask(t.cidr.Mask), Mask: t.cidr.Mask}
-	nr := netlink.Route{
-		LinkIndex: link.Attrs().Index,
-		Dst:       dr,
-		MTU:       t.DefaultMTU,
-		AdvMSS:    t.advMSS(Route{}),
-		Scope:     unix.RT_SCOPE_LINK,
-		Src:       t.cidr.IP,
-		Protocol:  unix.RTPROT_KERNEL,
-		Table:     unix.RT_TABLE_MAIN,
-		Type:      unix.RTN_UNICAST,
+	// Add any ipv6 overlay addresses, the kernel takes care of the prefix route for us
+	cidr6 := t.cidr6
+	if !isV4 {
+		cidr6 = append([]*net.IPNet{t.cidr}, cidr6...)
 	}
-	err = netlink.RouteReplace(&nr)
-	if err != nil {
-		return fmt.Errorf("failed to set mtu %v on the default route %v; %v", t.DefaultMTU, dr, err)
+
+	for _, c := range cidr6 {
+		if err = netlink.AddrReplace(link, &netlink.Addr{IPNet: c}); err != nil {
+			return fmt.Errorf("failed to set tun ipv6 address %v: %s", c, err)
+		}
+	}
+
+	// Default route, the kernel already added one for an ipv6 vpn network
+	if isV4 {
+		dr := &net.IPNet{IP: t.cidr.IP.Mask(t.cidr.Mask), Mask: t.cidr.Mask}
+		nr := netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       dr,
+			MTU:       t.DefaultMTU,
+			AdvMSS:    t.advMSS(Route{}),
+			Scope:     unix.RT_SCOPE_LINK,
+			Src:       t.cidr.IP,
+			Protocol:  unix.RTPROT_KERNEL,
+			Table:     unix.RT_TABLE_MAIN,
+			Type:      unix.RTN_UNICAST,
+		}
+		err = netlink.RouteReplace(&nr)
+		if err != nil {
+			return fmt.Errorf("failed to set mtu %v on the default route %v; %v", t.DefaultMTU, dr, err)
+		}
 	}
 
 	// Path routes
@@ -310,6 +329,10 @@ func (t *tun) Cidr() *net.IPNet {
 	return t.cidr
 }
 
+func (t *tun) SetCidr6(cidrs []*net.IPNet) {
+	t.cidr6 = cidrs
+}
+
 func (t *tun) Name() string {
 	return t.Device
 }
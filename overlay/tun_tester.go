@@ -16,6 +16,7 @@ import (
 type TestTun struct {
 	Device    string
 	cidr      *net.IPNet
+	cidr6     []*net.IPNet
 	Routes    []Route
 	routeTree *cidr.Tree4
 	l         *logrus.Logger
@@ -79,7 +80,7 @@ func (t *TestTun) RouteFor(ip iputil.VpnIp) iputil.VpnIp {
 		return r.(iputil.VpnIp)
 	}
 
-	return 0
+	return iputil.VpnIp{}
 }
 
 func (t *TestTun) Activate() error {
@@ -90,6 +91,10 @@ func (t *TestTun) Cidr() *net.IPNet {
 	return t.cidr
 }
 
+func (t *TestTun) SetCidr6(cidrs []*net.IPNet) {
+	t.cidr6 = cidrs
+}
+
 func (t *TestTun) Name() string {
 	return t.Device
 }
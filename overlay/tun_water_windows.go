@@ -102,7 +102,7 @@ func (t *waterTun) RouteFor(ip iputil.VpnIp) iputil.VpnIp {
 		return r.(iputil.VpnIp)
 	}
 
-	return 0
+	return iputil.VpnIp{}
 }
 
 func (t *waterTun) Cidr() *net.IPNet {
@@ -144,7 +144,7 @@ func (t *winTun) RouteFor(ip iputil.VpnIp) iputil.VpnIp {
 		return r.(iputil.VpnIp)
 	}
 
-	return 0
+	return iputil.VpnIp{}
 }
 
 func (t *winTun) Cidr() *net.IPNet {
@@ -0,0 +1,237 @@
+package nebula
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/sirupsen/logrus"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/overlay"
+)
+
+var ErrRateLimited = errors.New("peer is over its rate limit")
+
+// RateLimiter caps the traffic exchanged with peers. Every peer matching a limit gets its own token bucket, limits
+// are matched in the order they are configured and only the first match applies.
+type RateLimiter struct {
+	inbound  []rateLimit
+	outbound []rateLimit
+
+	incoming rateLimitMetrics
+	outgoing rateLimitMetrics
+}
+
+type rateLimit struct {
+	host  string
+	group string
+	// rate is in bytes per second, burst is the size of the bucket in bytes
+	rate  float64
+	burst float64
+}
+
+type rateLimitMetrics struct {
+	dropped      metrics.Counter
+	droppedBytes metrics.Counter
+}
+
+// hostRateLimits holds the buckets of a host, limiter is the RateLimiter they were built for so a reload rebuilds them
+type hostRateLimits struct {
+	limiter  *RateLimiter
+	incoming *tokenBucket
+	outgoing *tokenBucket
+}
+
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiterFromConfig returns nil if no rate_limits are configured
+func NewRateLimiterFromConfig(l *logrus.Logger, c *config.C) (*RateLimiter, error) {
+	// A bucket that can not hold a full sized packet would drop every one of them
+	maxPacket := overlay.MaxMTUFromConfig(c)
+
+	inbound, err := rateLimitsFromConfig(c, "rate_limits.inbound", maxPacket)
+	if err != nil {
+		return nil, err
+	}
+
+	outbound, err := rateLimitsFromConfig(c, "rate_limits.outbound", maxPacket)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(inbound) == 0 && len(outbound) == 0 {
+		return nil, nil
+	}
+
+	l.WithField("inbound", len(inbound)).WithField("outbound", len(outbound)).Info("Rate limits loaded")
+
+	return &RateLimiter{
+		inbound:  inbound,
+		outbound: outbound,
+		incoming: rateLimitMetrics{
+			dropped:      metrics.GetOrRegisterCounter("rate_limits.incoming.dropped", nil),
+			droppedBytes: metrics.GetOrRegisterCounter("rate_limits.incoming.dropped_bytes", nil),
+		},
+		outgoing: rateLimitMetrics{
+			dropped:      metrics.GetOrRegisterCounter("rate_limits.outgoing.dropped", nil),
+			droppedBytes: metrics.GetOrRegisterCounter("rate_limits.outgoing.dropped_bytes", nil),
+		},
+	}, nil
+}
+
+func rateLimitsFromConfig(c *config.C, table string, maxPacket int) ([]rateLimit, error) {
+	r := c.Get(table)
+	if r == nil {
+		return nil, nil
+	}
+
+	rs, ok := r.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s failed to parse, should be an array of limits", table)
+	}
+
+	limits := make([]rateLimit, 0, len(rs))
+	for i, t := range rs {
+		m, ok := t.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s limit #%v; could not parse limit", table, i)
+		}
+
+		toString := func(k string) string {
+			v, ok := m[k]
+			if !ok {
+				return ""
+			}
+			return fmt.Sprintf("%v", v)
+		}
+
+		rl := rateLimit{host: toString("host"), group: toString("group")}
+		if (rl.host == "") == (rl.group == "") {
+			return nil, fmt.Errorf("%s limit #%v; exactly one of host or group must be provided", table, i)
+		}
+
+		rate, err := strconv.ParseUint(toString("rate"), 10, 64)
+		if err != nil || rate == 0 {
+			return nil, fmt.Errorf("%s limit #%v; rate must be a number of bytes per second greater than 0; `%s`", table, i, toString("rate"))
+		}
+		rl.rate = float64(rate)
+
+		// A full second worth of traffic can be sent at once unless told otherwise
+		rl.burst = rl.rate
+		if s := toString("burst"); s != "" {
+			burst, err := strconv.ParseUint(s, 10, 64)
+			if err != nil || burst == 0 {
+				return nil, fmt.Errorf("%s limit #%v; burst must be a number of bytes greater than 0; `%s`", table, i, s)
+			}
+			rl.burst = float64(burst)
+		}
+
+		if rl.burst < float64(maxPacket) {
+			return nil, fmt.Errorf("%s limit #%v; burst, which defaults to rate, must be at least the %d byte mtu of the tun device; `%d`", table, i, maxPacket, int64(rl.burst))
+		}
+
+		limits = append(limits, rl)
+	}
+
+	return limits, nil
+}
+
+// Drop returns ErrRateLimited if a packet of size bytes puts h over its limit for the direction, packets are only
+// counted once they are allowed. A nil RateLimiter never drops.
+func (rl *RateLimiter) Drop(h *HostInfo, incoming bool, size int) error {
+	if rl == nil {
+		return nil
+	}
+
+	b := rl.buckets(h)
+	m := &rl.outgoing
+	bucket := b.outgoing
+	if incoming {
+		m = &rl.incoming
+		bucket = b.incoming
+	}
+
+	if bucket == nil || bucket.allow(time.Now(), float64(size)) {
+		return nil
+	}
+
+	m.dropped.Inc(1)
+	m.droppedBytes.Inc(int64(size))
+	return ErrRateLimited
+}
+
+// buckets returns the buckets cached on h, building them if h has not been seen since rl was installed
+func (rl *RateLimiter) buckets(h *HostInfo) *hostRateLimits {
+	if b, ok := h.rateLimits.Load().(*hostRateLimits); ok && b.limiter == rl {
+		return b
+	}
+
+	b := &hostRateLimits{
+		limiter:  rl,
+		incoming: newTokenBucket(rl.inbound, h),
+		outgoing: newTokenBucket(rl.outbound, h),
+	}
+
+	// Routines racing here each build their own buckets, the last one stored wins and the others are forgotten
+	h.rateLimits.Store(b)
+	return b
+}
+
+// newTokenBucket returns a full bucket for the first limit matching h, or nil if none match
+func newTokenBucket(limits []rateLimit, h *HostInfo) *tokenBucket {
+	c := h.GetCert()
+	if c == nil {
+		return nil
+	}
+
+	for _, rl := range limits {
+		if rl.host != "" && rl.host != "any" && rl.host != c.Details.Name {
+			continue
+		}
+
+		if rl.group != "" && rl.group != "any" {
+			if _, ok := c.Details.InvertedGroups[rl.group]; !ok {
+				continue
+			}
+		}
+
+		return &tokenBucket{rate: rl.rate, burst: rl.burst, tokens: rl.burst}
+	}
+
+	return nil
+}
+
+// allow takes n tokens if they are available, tokens refill at rate per second up to burst. A packet larger than burst,
+// a relayed packet carries a little more than the mtu, takes a full bucket instead so it is not dropped forever.
+func (b *tokenBucket) allow(now time.Time, n float64) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	if n > b.burst {
+		n = b.burst
+	}
+
+	if b.tokens < n {
+		return false
+	}
+
+	b.tokens -= n
+	return true
+}
@@ -0,0 +1,137 @@
+package nebula
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slackhq/nebula/cert"
+	"github.com/slackhq/nebula/config"
+	"github.com/slackhq/nebula/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRateLimiterFromConfig(t *testing.T) {
+	l := test.NewLogger()
+	c := config.NewC(l)
+
+	// Nothing to do without limits
+	rl, err := NewRateLimiterFromConfig(l, c)
+	assert.Nil(t, err)
+	assert.Nil(t, rl)
+
+	c.Settings["rate_limits"] = map[interface{}]interface{}{
+		"inbound":  []interface{}{map[interface{}]interface{}{"group": "guests", "rate": 1500}},
+		"outbound": []interface{}{map[interface{}]interface{}{"host": "laptop", "rate": "2000", "burst": 3000}},
+	}
+	rl, err = NewRateLimiterFromConfig(l, c)
+	assert.Nil(t, err)
+	assert.Equal(t, []rateLimit{{group: "guests", rate: 1500, burst: 1500}}, rl.inbound)
+	assert.Equal(t, []rateLimit{{host: "laptop", rate: 2000, burst: 3000}}, rl.outbound)
+
+	for _, tc := range []struct {
+		limit map[interface{}]interface{}
+		err   string
+	}{
+		{map[interface{}]interface{}{"rate": 1}, "rate_limits.inbound limit #0; exactly one of host or group must be provided"},
+		{map[interface{}]interface{}{"host": "a", "group": "b", "rate": 1}, "rate_limits.inbound limit #0; exactly one of host or group must be provided"},
+		{map[interface{}]interface{}{"host": "a"}, "rate_limits.inbound limit #0; rate must be a number of bytes per second greater than 0; ``"},
+		{map[interface{}]interface{}{"host": "a", "rate": 0}, "rate_limits.inbound limit #0; rate must be a number of bytes per second greater than 0; `0`"},
+		{map[interface{}]interface{}{"host": "a", "rate": "1mbps"}, "rate_limits.inbound limit #0; rate must be a number of bytes per second greater than 0; `1mbps`"},
+		{map[interface{}]interface{}{"host": "a", "rate": 1, "burst": -1}, "rate_limits.inbound limit #0; burst must be a number of bytes greater than 0; `-1`"},
+		{map[interface{}]interface{}{"host": "a", "rate": 1000}, "rate_limits.inbound limit #0; burst, which defaults to rate, must be at least the 1300 byte mtu of the tun device; `1000`"},
+		{map[interface{}]interface{}{"host": "a", "rate": 100000, "burst": 1299}, "rate_limits.inbound limit #0; burst, which defaults to rate, must be at least the 1300 byte mtu of the tun device; `1299`"},
+	} {
+		c.Settings["rate_limits"] = map[interface{}]interface{}{"inbound": []interface{}{tc.limit}}
+		_, err = NewRateLimiterFromConfig(l, c)
+		assert.EqualError(t, err, tc.err)
+	}
+
+	// Routes can carry larger packets than tun.mtu
+	c.Settings["tun"] = map[interface{}]interface{}{
+		"mtu":           1000,
+		"unsafe_routes": []interface{}{map[interface{}]interface{}{"route": "10.0.0.0/8", "via": "10.1.1.1", "mtu": 1400}},
+	}
+	c.Settings["rate_limits"] = map[interface{}]interface{}{"inbound": []interface{}{map[interface{}]interface{}{"host": "a", "rate": 1300}}}
+	_, err = NewRateLimiterFromConfig(l, c)
+	assert.EqualError(t, err, "rate_limits.inbound limit #0; burst, which defaults to rate, must be at least the 1400 byte mtu of the tun device; `1300`")
+	delete(c.Settings, "tun")
+
+	c.Settings["rate_limits"] = map[interface{}]interface{}{"inbound": "nope"}
+	_, err = NewRateLimiterFromConfig(l, c)
+	assert.EqualError(t, err, "rate_limits.inbound failed to parse, should be an array of limits")
+}
+
+func TestRateLimiter_Drop(t *testing.T) {
+	newHost := func(name string, groups ...string) *HostInfo {
+		c := &cert.NebulaCertificate{Details: cert.NebulaCertificateDetails{Name: name, Groups: groups, InvertedGroups: map[string]struct{}{}}}
+		for _, g := range groups {
+			c.Details.InvertedGroups[g] = struct{}{}
+		}
+		return &HostInfo{ConnectionState: &ConnectionState{peerCert: c}}
+	}
+
+	l := test.NewLogger()
+	c := config.NewC(l)
+	c.Settings["tun"] = map[interface{}]interface{}{"mtu": 100}
+	c.Settings["rate_limits"] = map[interface{}]interface{}{
+		"inbound": []interface{}{
+			map[interface{}]interface{}{"host": "laptop", "rate": 1000},
+			map[interface{}]interface{}{"group": "guests", "rate": 100},
+		},
+	}
+	rl, err := NewRateLimiterFromConfig(l, c)
+	assert.Nil(t, err)
+
+	laptop := newHost("laptop", "guests")
+	guest1 := newHost("guest1", "guests")
+	guest2 := newHost("guest2", "guests")
+	server := newHost("server")
+
+	dropped := rl.incoming.dropped.Count()
+	droppedBytes := rl.incoming.droppedBytes.Count()
+
+	// The first matching limit applies and every peer has its own bucket
+	assert.Nil(t, rl.Drop(laptop, true, 1000))
+	assert.Nil(t, rl.Drop(guest1, true, 100))
+	assert.Equal(t, ErrRateLimited, rl.Drop(guest1, true, 1))
+	assert.Nil(t, rl.Drop(guest2, true, 100))
+
+	// Peers without a matching limit and directions without limits are never dropped
+	assert.Nil(t, rl.Drop(server, true, 1<<20))
+	assert.Nil(t, rl.Drop(guest1, false, 1<<20))
+
+	assert.Equal(t, dropped+1, rl.incoming.dropped.Count())
+	assert.Equal(t, droppedBytes+1, rl.incoming.droppedBytes.Count())
+
+	// A reloaded limiter starts over with full buckets
+	rl2, err := NewRateLimiterFromConfig(l, c)
+	assert.Nil(t, err)
+	assert.Nil(t, rl2.Drop(guest1, true, 100))
+	assert.Equal(t, ErrRateLimited, rl2.Drop(guest1, true, 1))
+
+	// A nil limiter never drops
+	var nilRl *RateLimiter
+	assert.Nil(t, nilRl.Drop(guest1, true, 1<<20))
+}
+
+func TestTokenBucket_allow(t *testing.T) {
+	b := &tokenBucket{rate: 100, burst: 200, tokens: 200}
+	now := time.Now()
+
+	assert.True(t, b.allow(now, 150))
+	assert.False(t, b.allow(now, 100))
+	assert.True(t, b.allow(now, 50))
+
+	// Tokens refill at rate per second
+	assert.False(t, b.allow(now.Add(500*time.Millisecond), 51))
+	assert.True(t, b.allow(now.Add(500*time.Millisecond), 50))
+
+	// Tokens do not pile up past burst
+	assert.True(t, b.allow(now.Add(time.Hour), 200))
+	assert.False(t, b.allow(now.Add(time.Hour), 1))
+
+	// A packet larger than burst needs a full bucket
+	assert.False(t, b.allow(now.Add(time.Hour+time.Second), 300))
+	assert.True(t, b.allow(now.Add(2*time.Hour), 300))
+	assert.Equal(t, float64(0), b.tokens)
+}
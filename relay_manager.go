@@ -94,8 +94,8 @@ func (rm *relayManager) EstablishRelay(relayHostInfo *HostInfo, m *NebulaControl
 	if !ok {
 		rm.l.WithFields(logrus.Fields{"relayHostInfo": relayHostInfo.vpnIp,
 			"initiatorRelayIndex": m.InitiatorRelayIndex,
-			"relayFrom":           m.RelayFromIp,
-			"relayTo":             m.RelayToIp}).Info("relayManager EstablishRelay relayForByIdx not found")
+			"relayFrom":           m.relayFromIp(),
+			"relayTo":             m.relayToIp()}).Info("relayManager EstablishRelay relayForByIdx not found")
 		return nil, fmt.Errorf("unknown relay")
 	}
 	// relay deserves some synchronization
@@ -118,13 +118,13 @@ func (rm *relayManager) HandleControlMsg(h *HostInfo, m *NebulaControl, f *Inter
 
 func (rm *relayManager) handleCreateRelayResponse(h *HostInfo, f *Interface, m *NebulaControl) {
 	rm.l.WithFields(logrus.Fields{
-		"relayFrom":    iputil.VpnIp(m.RelayFromIp),
-		"relayTarget":  iputil.VpnIp(m.RelayToIp),
+		"relayFrom":    m.relayFromIp(),
+		"relayTarget":  m.relayToIp(),
 		"initiatorIdx": m.InitiatorRelayIndex,
 		"responderIdx": m.ResponderRelayIndex,
 		"hostInfo":     h.vpnIp}).
 		Info("handleCreateRelayResponse")
-	target := iputil.VpnIp(m.RelayToIp)
+	target := m.relayToIp()
 
 	relay, err := rm.EstablishRelay(h, m)
 	if err != nil {
@@ -151,9 +151,8 @@ func (rm *relayManager) handleCreateRelayResponse(h *HostInfo, f *Interface, m *
 		Type:                NebulaControl_CreateRelayResponse,
 		ResponderRelayIndex: peerRelay.LocalIndex,
 		InitiatorRelayIndex: peerRelay.RemoteIndex,
-		RelayFromIp:         uint32(peerHostInfo.vpnIp),
-		RelayToIp:           uint32(target),
 	}
+	resp.setRelayIps(peerHostInfo.vpnIp, target)
 	msg, err := resp.Marshal()
 	if err != nil {
 		rm.l.
@@ -165,13 +164,13 @@ func (rm *relayManager) handleCreateRelayResponse(h *HostInfo, f *Interface, m *
 
 func (rm *relayManager) handleCreateRelayRequest(h *HostInfo, f *Interface, m *NebulaControl) {
 	rm.l.WithFields(logrus.Fields{
-		"relayFrom":    iputil.VpnIp(m.RelayFromIp),
-		"relayTarget":  iputil.VpnIp(m.RelayToIp),
+		"relayFrom":    m.relayFromIp(),
+		"relayTarget":  m.relayToIp(),
 		"initiatorIdx": m.InitiatorRelayIndex,
 		"hostInfo":     h.vpnIp}).
 		Info("handleCreateRelayRequest")
-	from := iputil.VpnIp(m.RelayFromIp)
-	target := iputil.VpnIp(m.RelayToIp)
+	from := m.relayFromIp()
+	target := m.relayToIp()
 	// Is the target of the relay me?
 	if target == f.myVpnIp {
 		existingRelay, ok := h.relayState.QueryRelayForByIp(from)
@@ -201,9 +200,8 @@ func (rm *relayManager) handleCreateRelayRequest(h *HostInfo, f *Interface, m *N
 			Type:                NebulaControl_CreateRelayResponse,
 			ResponderRelayIndex: relay.LocalIndex,
 			InitiatorRelayIndex: relay.RemoteIndex,
-			RelayFromIp:         uint32(from),
-			RelayToIp:           uint32(target),
 		}
+		resp.setRelayIps(from, target)
 		msg, err := resp.Marshal()
 		if err != nil {
 			rm.l.
@@ -249,9 +247,8 @@ func (rm *relayManager) handleCreateRelayRequest(h *HostInfo, f *Interface, m *N
 			req := NebulaControl{
 				Type:                NebulaControl_CreateRelayRequest,
 				InitiatorRelayIndex: index,
-				RelayFromIp:         uint32(h.vpnIp),
-				RelayToIp:           uint32(target),
 			}
+			req.setRelayIps(h.vpnIp, target)
 			msg, err := req.Marshal()
 			if err != nil {
 				rm.l.
@@ -292,9 +289,8 @@ func (rm *relayManager) handleCreateRelayRequest(h *HostInfo, f *Interface, m *N
 					Type:                NebulaControl_CreateRelayResponse,
 					ResponderRelayIndex: relay.LocalIndex,
 					InitiatorRelayIndex: relay.RemoteIndex,
-					RelayFromIp:         uint32(h.vpnIp),
-					RelayToIp:           uint32(target),
 				}
+				resp.setRelayIps(h.vpnIp, target)
 				msg, err := resp.Marshal()
 				if err != nil {
 					rm.l.
@@ -313,3 +309,32 @@ func (rm *relayManager) handleCreateRelayRequest(h *HostInfo, f *Interface, m *N
 func (rm *relayManager) RemoveRelay(localIdx uint32) {
 	rm.hostmap.RemoveRelay(localIdx)
 }
+
+// setRelayIps stores the vpn ips at either end of a relay, ipv6 vpn ips are stored in the Ip6 fields
+func (m *NebulaControl) setRelayIps(from, to iputil.VpnIp) {
+	if from.Is4() {
+		m.RelayFromIp = from.Uint32()
+	} else {
+		m.RelayFromIp6Hi, m.RelayFromIp6Lo = from.HiLo()
+	}
+
+	if to.Is4() {
+		m.RelayToIp = to.Uint32()
+	} else {
+		m.RelayToIp6Hi, m.RelayToIp6Lo = to.HiLo()
+	}
+}
+
+func (m *NebulaControl) relayFromIp() iputil.VpnIp {
+	if m.RelayFromIp6Hi != 0 || m.RelayFromIp6Lo != 0 {
+		return iputil.HiLoToVpnIp(m.RelayFromIp6Hi, m.RelayFromIp6Lo)
+	}
+	return iputil.Uint32ToVpnIp(m.RelayFromIp)
+}
+
+func (m *NebulaControl) relayToIp() iputil.VpnIp {
+	if m.RelayToIp6Hi != 0 || m.RelayToIp6Lo != 0 {
+		return iputil.HiLoToVpnIp(m.RelayToIp6Hi, m.RelayToIp6Lo)
+	}
+	return iputil.Uint32ToVpnIp(m.RelayToIp)
+}
@@ -40,7 +40,7 @@ type cache struct {
 }
 
 type cacheRelay struct {
-	relay []uint32
+	relay []iputil.VpnIp
 }
 
 // cacheV4 stores learned and reported ipv4 records under cache
@@ -186,7 +186,7 @@ func (r *RemoteList) CopyCache() *CacheMap {
 
 		if mc.relay != nil {
 			for _, a := range mc.relay.relay {
-				nip := iputil.VpnIp(a).ToIP()
+				nip := a.ToIP()
 				c.Relay = append(c.Relay, &nip)
 			}
 		}
@@ -286,7 +286,7 @@ func (r *RemoteList) unlockedSetV4(ownerVpnIp iputil.VpnIp, vpnIp iputil.VpnIp,
 	}
 }
 
-func (r *RemoteList) unlockedSetRelay(ownerVpnIp iputil.VpnIp, vpnIp iputil.VpnIp, to []uint32) {
+func (r *RemoteList) unlockedSetRelay(ownerVpnIp iputil.VpnIp, vpnIp iputil.VpnIp, to []iputil.VpnIp) {
 	r.shouldRebuild = true
 	c := r.unlockedGetOrMakeRelay(ownerVpnIp)
 
@@ -431,7 +431,7 @@ func (r *RemoteList) unlockedCollect() {
 
 		if c.relay != nil {
 			for _, v := range c.relay.relay {
-				ip := iputil.VpnIp(v)
+				ip := v
 				relays = append(relays, &ip)
 			}
 		}
@@ -11,26 +11,26 @@ import (
 func TestRemoteList_Rebuild(t *testing.T) {
 	rl := NewRemoteList()
 	rl.unlockedSetV4(
-		0,
-		0,
+		iputil.Uint32ToVpnIp(0),
+		iputil.Uint32ToVpnIp(0),
 		[]*Ip4AndPort{
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("70.199.182.92"))), Port: 1475}, // this is duped
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.0.182"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.1.1"))), Port: 10101}, // this is duped
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.18.0.1"))), Port: 10101}, // this is duped
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.18.0.1"))), Port: 10101}, // this is a dupe
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.19.0.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.31.0.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.1.1"))), Port: 10101},   // this is a dupe
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("70.199.182.92"))), Port: 1476}, // almost dupe of 0 with a diff port
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("70.199.182.92"))), Port: 1475}, // this is a dupe
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("70.199.182.92")).Uint32(), Port: 1475}, // this is duped
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.0.182")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.1.1")).Uint32(), Port: 10101}, // this is duped
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.18.0.1")).Uint32(), Port: 10101}, // this is duped
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.18.0.1")).Uint32(), Port: 10101}, // this is a dupe
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.19.0.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.31.0.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.1.1")).Uint32(), Port: 10101},   // this is a dupe
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("70.199.182.92")).Uint32(), Port: 1476}, // almost dupe of 0 with a diff port
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("70.199.182.92")).Uint32(), Port: 1475}, // this is a dupe
 		},
 		func(iputil.VpnIp, *Ip4AndPort) bool { return true },
 	)
 
 	rl.unlockedSetV6(
-		1,
-		1,
+		iputil.Uint32ToVpnIp(1),
+		iputil.Uint32ToVpnIp(1),
 		[]*Ip6AndPort{
 			NewIp6AndPort(net.ParseIP("1::1"), 1), // this is duped
 			NewIp6AndPort(net.ParseIP("1::1"), 2), // almost dupe of 0 with a diff port, also gets duped
@@ -104,24 +104,24 @@ func TestRemoteList_Rebuild(t *testing.T) {
 func BenchmarkFullRebuild(b *testing.B) {
 	rl := NewRemoteList()
 	rl.unlockedSetV4(
-		0,
-		0,
+		iputil.Uint32ToVpnIp(0),
+		iputil.Uint32ToVpnIp(0),
 		[]*Ip4AndPort{
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("70.199.182.92"))), Port: 1475},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.0.182"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.1.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.18.0.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.19.0.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.31.0.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.1.1"))), Port: 10101},   // this is a dupe
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("70.199.182.92"))), Port: 1476}, // dupe of 0 with a diff port
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("70.199.182.92")).Uint32(), Port: 1475},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.0.182")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.1.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.18.0.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.19.0.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.31.0.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.1.1")).Uint32(), Port: 10101},   // this is a dupe
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("70.199.182.92")).Uint32(), Port: 1476}, // dupe of 0 with a diff port
 		},
 		func(iputil.VpnIp, *Ip4AndPort) bool { return true },
 	)
 
 	rl.unlockedSetV6(
-		0,
-		0,
+		iputil.Uint32ToVpnIp(0),
+		iputil.Uint32ToVpnIp(0),
 		[]*Ip6AndPort{
 			NewIp6AndPort(net.ParseIP("1::1"), 1),
 			NewIp6AndPort(net.ParseIP("1::1"), 2), // dupe of 0 with a diff port
@@ -169,24 +169,24 @@ func BenchmarkFullRebuild(b *testing.B) {
 func BenchmarkSortRebuild(b *testing.B) {
 	rl := NewRemoteList()
 	rl.unlockedSetV4(
-		0,
-		0,
+		iputil.Uint32ToVpnIp(0),
+		iputil.Uint32ToVpnIp(0),
 		[]*Ip4AndPort{
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("70.199.182.92"))), Port: 1475},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.0.182"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.1.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.18.0.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.19.0.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.31.0.1"))), Port: 10101},
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("172.17.1.1"))), Port: 10101},   // this is a dupe
-			{Ip: uint32(iputil.Ip2VpnIp(net.ParseIP("70.199.182.92"))), Port: 1476}, // dupe of 0 with a diff port
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("70.199.182.92")).Uint32(), Port: 1475},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.0.182")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.1.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.18.0.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.19.0.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.31.0.1")).Uint32(), Port: 10101},
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("172.17.1.1")).Uint32(), Port: 10101},   // this is a dupe
+			{Ip: iputil.Ip2VpnIp(net.ParseIP("70.199.182.92")).Uint32(), Port: 1476}, // dupe of 0 with a diff port
 		},
 		func(iputil.VpnIp, *Ip4AndPort) bool { return true },
 	)
 
 	rl.unlockedSetV6(
-		0,
-		0,
+		iputil.Uint32ToVpnIp(0),
+		iputil.Uint32ToVpnIp(0),
 		[]*Ip6AndPort{
 			NewIp6AndPort(net.ParseIP("1::1"), 1),
 			NewIp6AndPort(net.ParseIP("1::1"), 2), // dupe of 0 with a diff port
@@ -11,8 +11,10 @@ import (
 	"reflect"
 	"runtime/pprof"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/slackhq/nebula/config"
@@ -49,6 +51,16 @@ type sshCreateTunnelFlags struct {
 	Address string
 }
 
+type sshListConntrackFlags struct {
+	Json   bool
+	Pretty bool
+	Port   uint
+}
+
+type sshFlushConntrackFlags struct {
+	Port uint
+}
+
 func wireSSHReload(l *logrus.Logger, ssh *sshd.SSHServer, c *config.C) {
 	c.RegisterReloadCallback(func(c *config.C) {
 		if c.GetBool("sshd.enabled", false) {
@@ -212,6 +224,67 @@ func attachCommands(l *logrus.Logger, ssh *sshd.SSHServer, hostMap *HostMap, pen
 		},
 	})
 
+	ssh.RegisterCommand(&sshd.Command{
+		Name:             "list-tunnel-cas",
+		ShortDescription: "List the CA that issued the certificate of every established tunnel, grouped by CA",
+		Flags: func() (*flag.FlagSet, interface{}) {
+			fl := flag.NewFlagSet("", flag.ContinueOnError)
+			s := sshListHostMapFlags{}
+			fl.BoolVar(&s.Json, "json", false, "outputs as json with more information")
+			fl.BoolVar(&s.Pretty, "pretty", false, "pretty prints json, assumes -json")
+			return fl, &s
+		},
+		Callback: func(fs interface{}, a []string, w sshd.StringWriter) error {
+			return sshListTunnelCAs(ifce, fs, w)
+		},
+	})
+
+	ssh.RegisterCommand(&sshd.Command{
+		Name:             "list-firewall-rules",
+		ShortDescription: "List every firewall rule with the number of flows it has matched",
+		Flags: func() (*flag.FlagSet, interface{}) {
+			fl := flag.NewFlagSet("", flag.ContinueOnError)
+			s := sshListHostMapFlags{}
+			fl.BoolVar(&s.Json, "json", false, "outputs as json with more information")
+			fl.BoolVar(&s.Pretty, "pretty", false, "pretty prints json, assumes -json")
+			return fl, &s
+		},
+		Callback: func(fs interface{}, a []string, w sshd.StringWriter) error {
+			return sshListFirewallRules(ifce, fs, w)
+		},
+	})
+
+	ssh.RegisterCommand(&sshd.Command{
+		Name:             "list-conntrack",
+		ShortDescription: "List the flows tracked by the firewall, optionally only those with the provided vpn ip",
+		Flags: func() (*flag.FlagSet, interface{}) {
+			fl := flag.NewFlagSet("", flag.ContinueOnError)
+			s := sshListConntrackFlags{}
+			fl.BoolVar(&s.Json, "json", false, "outputs as json with more information")
+			fl.BoolVar(&s.Pretty, "pretty", false, "pretty prints json, assumes -json")
+			fl.UintVar(&s.Port, "port", 0, "only lists flows using this port on either side")
+			return fl, &s
+		},
+		Callback: func(fs interface{}, a []string, w sshd.StringWriter) error {
+			return sshListConntrack(ifce, fs, a, w)
+		},
+	})
+
+	ssh.RegisterCommand(&sshd.Command{
+		Name:             "flush-conntrack",
+		ShortDescription: "Flushes the flows tracked by the firewall, optionally only those with the provided vpn ip",
+		Help:             "The next packet of a flushed flow is checked against the current firewall rules",
+		Flags: func() (*flag.FlagSet, interface{}) {
+			fl := flag.NewFlagSet("", flag.ContinueOnError)
+			s := sshFlushConntrackFlags{}
+			fl.UintVar(&s.Port, "port", 0, "only flushes flows using this port on either side")
+			return fl, &s
+		},
+		Callback: func(fs interface{}, a []string, w sshd.StringWriter) error {
+			return sshFlushConntrack(ifce, fs, a, w)
+		},
+	})
+
 	ssh.RegisterCommand(&sshd.Command{
 		Name:             "reload",
 		ShortDescription: "Reloads configuration from disk, same as sending HUP to the process",
@@ -396,6 +469,197 @@ func sshListHostMap(hostMap *HostMap, a interface{}, w sshd.StringWriter) error
 	return nil
 }
 
+func sshListTunnelCAs(ifce *Interface, a interface{}, w sshd.StringWriter) error {
+	fs, ok := a.(*sshListHostMapFlags)
+	if !ok {
+		//TODO: error
+		return nil
+	}
+
+	tunnels := listTunnelCAs(ifce.hostMap, ifce.caPool)
+	sort.Slice(tunnels, func(i, j int) bool {
+		if tunnels[i].Issuer != tunnels[j].Issuer {
+			return tunnels[i].Issuer < tunnels[j].Issuer
+		}
+		return bytes.Compare(tunnels[i].VpnIp, tunnels[j].VpnIp) < 0
+	})
+
+	if fs.Json || fs.Pretty {
+		js := json.NewEncoder(w.GetWriter())
+		if fs.Pretty {
+			js.SetIndent("", "    ")
+		}
+
+		err := js.Encode(tunnels)
+		if err != nil {
+			//TODO
+			return nil
+		}
+
+		return nil
+	}
+
+	for i := 0; i < len(tunnels); {
+		// Tunnels are sorted by issuer, find the end of this issuers run
+		j := i
+		for j < len(tunnels) && tunnels[j].Issuer == tunnels[i].Issuer {
+			j++
+		}
+
+		name := tunnels[i].IssuerName
+		if name == "" {
+			name = "unknown ca"
+		}
+
+		line := fmt.Sprintf("%s (%s): %d tunnels", name, tunnels[i].Issuer, j-i)
+		if tunnels[i].DeprecatedCA != "" {
+			line += ", deprecated"
+		}
+
+		if err := w.WriteLine(line); err != nil {
+			return err
+		}
+
+		for _, t := range tunnels[i:j] {
+			if err := w.WriteLine(fmt.Sprintf("    %s: %s", t.VpnIp, t.CertName)); err != nil {
+				return err
+			}
+		}
+
+		i = j
+	}
+
+	return nil
+}
+
+func sshListFirewallRules(ifce *Interface, a interface{}, w sshd.StringWriter) error {
+	fs, ok := a.(*sshListHostMapFlags)
+	if !ok {
+		//TODO: error
+		return nil
+	}
+
+	rules := listFirewallRules(ifce.firewall)
+
+	if fs.Json || fs.Pretty {
+		js := json.NewEncoder(w.GetWriter())
+		if fs.Pretty {
+			js.SetIndent("", "    ")
+		}
+
+		err := js.Encode(rules)
+		if err != nil {
+			//TODO
+			return nil
+		}
+
+		return nil
+	}
+
+	for _, r := range rules {
+		err := w.WriteLine(fmt.Sprintf("%s %s %s: %d hits", r.Direction, r.Action, r.Name, r.Hits))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sshConntrackFilter builds a filter from the optional vpn ip argument, a message for the user is returned if it is
+// not valid
+func sshConntrackFilter(port uint, a []string) (ControlConntrackFilter, string) {
+	filter := ControlConntrackFilter{}
+
+	if port > 65535 {
+		return filter, fmt.Sprintf("The provided port is out of range: %d", port)
+	}
+	filter.Port = uint16(port)
+
+	if len(a) > 0 {
+		parsedIp := net.ParseIP(a[0])
+		if parsedIp == nil {
+			return filter, fmt.Sprintf("The provided vpn ip could not be parsed: %s", a[0])
+		}
+
+		filter.VpnIp = iputil.Ip2VpnIp(parsedIp)
+		if !filter.VpnIp.IsValid() {
+			return filter, fmt.Sprintf("The provided vpn ip could not be parsed: %s", a[0])
+		}
+	}
+
+	return filter, ""
+}
+
+func sshListConntrack(ifce *Interface, fs interface{}, a []string, w sshd.StringWriter) error {
+	flags, ok := fs.(*sshListConntrackFlags)
+	if !ok {
+		//TODO: error
+		return nil
+	}
+
+	filter, msg := sshConntrackFilter(flags.Port, a)
+	if msg != "" {
+		return w.WriteLine(msg)
+	}
+
+	entries := listConntrack(ifce.firewall, filter)
+
+	if flags.Json || flags.Pretty {
+		js := json.NewEncoder(w.GetWriter())
+		if flags.Pretty {
+			js.SetIndent("", "    ")
+		}
+
+		err := js.Encode(entries)
+		if err != nil {
+			//TODO
+			return nil
+		}
+
+		return nil
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		from := net.JoinHostPort(e.RemoteIP.String(), strconv.Itoa(int(e.RemotePort)))
+		to := net.JoinHostPort(e.LocalIP.String(), strconv.Itoa(int(e.LocalPort)))
+		if e.Protocol == "icmp" || e.Protocol == "icmpv6" {
+			from, to = e.RemoteIP.String(), e.LocalIP.String()
+		}
+		if e.Direction == "outgoing" {
+			from, to = to, from
+		}
+
+		line := fmt.Sprintf("%s %s %s -> %s vpnIp: %s expires: %s rulesVersion: %d", e.Direction, e.Protocol, from, to, e.VpnIp, e.Expires.Sub(now).Round(time.Second), e.RulesVersion)
+		if e.TCPState != "" {
+			line += " tcpState: " + e.TCPState
+		}
+
+		err := w.WriteLine(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sshFlushConntrack(ifce *Interface, fs interface{}, a []string, w sshd.StringWriter) error {
+	flags, ok := fs.(*sshFlushConntrackFlags)
+	if !ok {
+		//TODO: error
+		return nil
+	}
+
+	filter, msg := sshConntrackFilter(flags.Port, a)
+	if msg != "" {
+		return w.WriteLine(msg)
+	}
+
+	return w.WriteLine(fmt.Sprintf("Flushed %d conntrack entries", flushConntrack(ifce.firewall, filter)))
+}
+
 func sshListLighthouseMap(lightHouse *LightHouse, a interface{}, w sshd.StringWriter) error {
 	fs, ok := a.(*sshListHostMapFlags)
 	if !ok {
@@ -489,7 +753,7 @@ func sshQueryLighthouse(ifce *Interface, fs interface{}, a []string, w sshd.Stri
 	}
 
 	vpnIp := iputil.Ip2VpnIp(parsedIp)
-	if vpnIp == 0 {
+	if !vpnIp.IsValid() {
 		return w.WriteLine(fmt.Sprintf("The provided vpn ip could not be parsed: %s", a[0]))
 	}
 
@@ -518,7 +782,7 @@ func sshCloseTunnel(ifce *Interface, fs interface{}, a []string, w sshd.StringWr
 	}
 
 	vpnIp := iputil.Ip2VpnIp(parsedIp)
-	if vpnIp == 0 {
+	if !vpnIp.IsValid() {
 		return w.WriteLine(fmt.Sprintf("The provided vpn ip could not be parsed: %s", a[0]))
 	}
 
@@ -560,7 +824,7 @@ func sshCreateTunnel(ifce *Interface, fs interface{}, a []string, w sshd.StringW
 	}
 
 	vpnIp := iputil.Ip2VpnIp(parsedIp)
-	if vpnIp == 0 {
+	if !vpnIp.IsValid() {
 		return w.WriteLine(fmt.Sprintf("The provided vpn ip could not be parsed: %s", a[0]))
 	}
 
@@ -617,7 +881,7 @@ func sshChangeRemote(ifce *Interface, fs interface{}, a []string, w sshd.StringW
 	}
 
 	vpnIp := iputil.Ip2VpnIp(parsedIp)
-	if vpnIp == 0 {
+	if !vpnIp.IsValid() {
 		return w.WriteLine(fmt.Sprintf("The provided vpn ip could not be parsed: %s", a[0]))
 	}
 
@@ -698,7 +962,7 @@ func sshPrintCert(ifce *Interface, fs interface{}, a []string, w sshd.StringWrit
 		}
 
 		vpnIp := iputil.Ip2VpnIp(parsedIp)
-		if vpnIp == 0 {
+		if !vpnIp.IsValid() {
 			return w.WriteLine(fmt.Sprintf("The provided vpn ip could not be parsed: %s", a[0]))
 		}
 
@@ -858,7 +1122,7 @@ func sshPrintTunnel(ifce *Interface, fs interface{}, a []string, w sshd.StringWr
 	}
 
 	vpnIp := iputil.Ip2VpnIp(parsedIp)
-	if vpnIp == 0 {
+	if !vpnIp.IsValid() {
 		return w.WriteLine(fmt.Sprintf("The provided vpn ip could not be parsed: %s", a[0]))
 	}
 
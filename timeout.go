@@ -106,15 +106,38 @@ func (tw *TimerWheel) Add(v firewall.Packet, timeout time.Duration) *TimeoutItem
 }
 
 func (tw *TimerWheel) Purge() (firewall.Packet, bool) {
-	if tw.expired.Head == nil {
+	return tw.pop(tw.expired)
+}
+
+// PopNext removes the item closest to timing out from the wheel, even if it has not timed out yet. Items that already
+// timed out come first, then the ticks in the order they will time out.
+func (tw *TimerWheel) PopNext() (firewall.Packet, bool) {
+	if tw.expired.Head != nil {
+		return tw.pop(tw.expired)
+	}
+
+	// Nothing is ever added to the current tick except the items with the longest timeout, so it is checked last
+	for i := 1; i <= tw.wheelLen; i++ {
+		l := tw.wheel[(tw.current+i)%tw.wheelLen]
+		if l.Head != nil {
+			return tw.pop(l)
+		}
+	}
+
+	return emptyFWPacket, false
+}
+
+// pop removes the first item of a list and caches it for reuse
+func (tw *TimerWheel) pop(l *TimeoutList) (firewall.Packet, bool) {
+	if l.Head == nil {
 		return emptyFWPacket, false
 	}
 
-	ti := tw.expired.Head
-	tw.expired.Head = ti.Next
+	ti := l.Head
+	l.Head = ti.Next
 
-	if tw.expired.Head == nil {
-		tw.expired.Tail = nil
+	if l.Head == nil {
+		l.Tail = nil
 	}
 
 	// Clear out the items references
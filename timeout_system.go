@@ -125,7 +125,7 @@ func (tw *SystemTimerWheel) Purge() interface{} {
 	p := ti.Item
 
 	// Clear out the items references
-	ti.Item = 0
+	ti.Item = iputil.VpnIp{}
 	ti.Next = nil
 
 	// Maybe cache it for later
@@ -86,7 +86,7 @@ func TestSystemTimerWheel_Purge(t *testing.T) {
 	assert.NotNil(t, tw.lastTick)
 	assert.Equal(t, 0, tw.current)
 
-	fps := []iputil.VpnIp{9, 10, 11, 12}
+	fps := []iputil.VpnIp{iputil.Uint32ToVpnIp(9), iputil.Uint32ToVpnIp(10), iputil.Uint32ToVpnIp(11), iputil.Uint32ToVpnIp(12)}
 
 	//fp1 := ip2int(net.ParseIP("1.2.3.4"))
 
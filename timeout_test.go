@@ -1,6 +1,7 @@
 package nebula
 
 import (
+	"net/netip"
 	"testing"
 	"time"
 
@@ -86,10 +87,10 @@ func TestTimerWheel_Purge(t *testing.T) {
 	assert.Equal(t, 0, tw.current)
 
 	fps := []firewall.Packet{
-		{LocalIP: 1},
-		{LocalIP: 2},
-		{LocalIP: 3},
-		{LocalIP: 4},
+		{LocalIP: netip.AddrFrom4([4]byte{0, 0, 0, 1})},
+		{LocalIP: netip.AddrFrom4([4]byte{0, 0, 0, 2})},
+		{LocalIP: netip.AddrFrom4([4]byte{0, 0, 0, 3})},
+		{LocalIP: netip.AddrFrom4([4]byte{0, 0, 0, 4})},
 	}
 
 	tw.Add(fps[0], time.Second*1)
@@ -138,3 +139,39 @@ func TestTimerWheel_Purge(t *testing.T) {
 	tw.advance(ta)
 	assert.Equal(t, 0, tw.current)
 }
+
+func TestTimerWheel_PopNext(t *testing.T) {
+	tw := NewTimerWheel(time.Second, time.Second*10)
+	tw.advance(time.Now())
+
+	fps := []firewall.Packet{
+		{LocalIP: netip.AddrFrom4([4]byte{0, 0, 0, 1})},
+		{LocalIP: netip.AddrFrom4([4]byte{0, 0, 0, 2})},
+		{LocalIP: netip.AddrFrom4([4]byte{0, 0, 0, 3})},
+		{LocalIP: netip.AddrFrom4([4]byte{0, 0, 0, 4})},
+	}
+
+	// Items come back in the order they time out, not the order they were added
+	tw.Add(fps[2], time.Second*10)
+	tw.Add(fps[1], time.Second*5)
+	tw.Add(fps[0], time.Second*1)
+	for i := 0; i < 3; i++ {
+		p, ok := tw.PopNext()
+		assert.True(t, ok)
+		assert.Equal(t, fps[i], p)
+	}
+
+	_, ok := tw.PopNext()
+	assert.False(t, ok)
+
+	// Items that already timed out come first
+	tw.Add(fps[3], time.Second*1)
+	tw.advance(time.Now().Add(time.Second * 3))
+	tw.Add(fps[0], time.Second*1)
+	p, ok := tw.PopNext()
+	assert.True(t, ok)
+	assert.Equal(t, fps[3], p)
+	p, ok = tw.PopNext()
+	assert.True(t, ok)
+	assert.Equal(t, fps[0], p)
+}